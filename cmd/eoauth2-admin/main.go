@@ -0,0 +1,114 @@
+// eoauth2-admin eoauth2的管理命令
+//
+//	eoauth2-admin migrate up      -driver=mysql -dsn="user:pass@tcp(127.0.0.1:3306)/oauth2" [-to=版本号]
+//	eoauth2-admin migrate down    -driver=mysql -dsn="..." [-steps=1]
+//	eoauth2-admin migrate status  -driver=postgres -dsn="host=127.0.0.1 user=oauth2 dbname=oauth2"
+//	eoauth2-admin migrate version -driver=sqlite -dsn="oauth2.db"
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/ego-component/eoauth2/storage/migrate"
+	"github.com/glebarez/sqlite"
+	"gorm.io/driver/mysql"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+const usage = `usage: eoauth2-admin migrate <up|down|status|version> -driver=<mysql|postgres|sqlite> -dsn=<dsn> [flags]`
+
+func main() {
+	if err := run(os.Args[1:]); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func run(args []string) error {
+	if len(args) < 2 || args[0] != "migrate" {
+		return fmt.Errorf(usage)
+	}
+	action := args[1]
+	flags := flag.NewFlagSet("migrate "+action, flag.ContinueOnError)
+	driver := flags.String("driver", "mysql", "数据库类型，mysql、postgres、sqlite")
+	dsn := flags.String("dsn", "", "数据库连接地址")
+	to := flags.Int64("to", 0, "up执行到的版本号，0表示最新版本")
+	steps := flags.Int("steps", 1, "down回滚的版本数量")
+	timeout := flags.Duration("timeout", 10*time.Minute, "超时时间")
+	if err := flags.Parse(args[2:]); err != nil {
+		return err
+	}
+	if *dsn == "" {
+		return fmt.Errorf("dsn is empty\n%s", usage)
+	}
+
+	db, err := open(*driver, *dsn)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	migrator := migrate.NewMigrator(db)
+
+	switch action {
+	case "up":
+		done, err := migrator.UpTo(ctx, *to)
+		for _, migration := range done {
+			fmt.Printf("up   %d %s\n", migration.Version, migration.Name)
+		}
+		return err
+	case "down":
+		done, err := migrator.Down(ctx, *steps)
+		for _, migration := range done {
+			fmt.Printf("down %d %s\n", migration.Version, migration.Name)
+		}
+		return err
+	case "status":
+		list, err := migrator.Status(ctx)
+		if err != nil {
+			return err
+		}
+		for _, status := range list {
+			appliedAt := "pending"
+			if status.Applied {
+				appliedAt = time.Unix(status.AppliedAt, 0).Format(time.RFC3339)
+			}
+			fmt.Printf("%d\t%s\t%s\n", status.Version, status.Name, appliedAt)
+		}
+		return nil
+	case "version":
+		version, err := migrator.Version(ctx)
+		if err != nil {
+			return err
+		}
+		fmt.Println(version)
+		return nil
+	default:
+		return fmt.Errorf("unknown migrate action: %s\n%s", action, usage)
+	}
+}
+
+func open(driver string, dsn string) (*gorm.DB, error) {
+	var dialector gorm.Dialector
+	switch driver {
+	case "mysql":
+		dialector = mysql.Open(dsn)
+	case "postgres":
+		dialector = postgres.Open(dsn)
+	case "sqlite":
+		dialector = sqlite.Open(dsn)
+	default:
+		return nil, fmt.Errorf("unknown driver: %s", driver)
+	}
+	db, err := gorm.Open(dialector, &gorm.Config{Logger: logger.Default.LogMode(logger.Warn)})
+	if err != nil {
+		return nil, fmt.Errorf("open %s failed, err: %w", driver, err)
+	}
+	return db, nil
+}
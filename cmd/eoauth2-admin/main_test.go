@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/ego-component/eoauth2/storage/migrate"
+)
+
+// TestMigrateUpTwice 第二次执行migrate up不会重复执行，记录的版本为最新版本
+func TestMigrateUpTwice(t *testing.T) {
+	dsn := filepath.Join(t.TempDir(), "oauth2.db")
+	for i := 0; i < 2; i++ {
+		if err := run([]string{"migrate", "up", "-driver=sqlite", "-dsn=" + dsn}); err != nil {
+			t.Fatalf("migrate up %d failed, err: %v", i+1, err)
+		}
+	}
+
+	db, err := open("sqlite", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = sqlDB.Close() })
+	list, err := migrate.NewMigrator(db).Status(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, status := range list {
+		if !status.Applied {
+			t.Errorf("version %d should be applied", status.Version)
+		}
+	}
+	var cnt int64
+	if err = db.Model(&migrate.SchemaVersion{}).Count(&cnt).Error; err != nil {
+		t.Fatal(err)
+	}
+	if cnt != int64(len(list)) {
+		t.Errorf("schema_version rows, want: %d, got: %d", len(list), cnt)
+	}
+	version, err := migrate.NewMigrator(db).Version(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := list[len(list)-1].Version; version != want {
+		t.Errorf("version, want: %d, got: %d", want, version)
+	}
+}
+
+func TestRunInvalidArgs(t *testing.T) {
+	for name, args := range map[string][]string{
+		"no action":      {"migrate"},
+		"unknown action": {"migrate", "reset", "-driver=sqlite", "-dsn=" + filepath.Join(t.TempDir(), "oauth2.db")},
+		"unknown driver": {"migrate", "up", "-driver=oracle", "-dsn=oauth2"},
+		"empty dsn":      {"migrate", "up", "-driver=sqlite"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			if err := run(args); err == nil {
+				t.Error("run should fail")
+			}
+		})
+	}
+}
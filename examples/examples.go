@@ -7,6 +7,8 @@ import (
 	"github.com/ego-component/eoauth2/server"
 )
 
+// TestStorage 示例使用的存储
+// Deprecated: 没有加锁，数据不会过期，请使用 storage/memstorage
 type TestStorage struct {
 	clients   map[string]server.Client
 	authorize map[string]*server.AuthorizeData
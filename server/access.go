@@ -44,8 +44,12 @@ type AccessRequest struct {
 	userData              interface{} // Data to be passed to storage. Not used by the library.
 	TokenExpiration       int64       // Token expiration in seconds. Change if different from default
 	ParentTokenExpiration int64
+	// Refresh token expiration in seconds, 0 means never expire
+	RefreshTokenExpiration int64
 	// Set if a refresh token should be generated
 	GenerateRefresh bool
+	// Set if the previous access and refresh token should be retained after refresh
+	RetainTokenAfterRefresh bool
 
 	// Optional code_verifier as described in rfc7636
 	CodeVerifier string
@@ -53,6 +57,7 @@ type AccessRequest struct {
 	config       *Config
 	authUA       string
 	authClientIP string
+	ssoUid       int64 // 多账号下token所属的账号
 }
 
 // ResponseData for response output
@@ -70,7 +75,7 @@ func (ar *AccessRequest) handleAuthorizationCodeRequest(ctx context.Context, par
 	// get client authentication
 	auth := ar.getClientAuth(param.ClientAuthParam, ar.config.AllowClientSecretInParams)
 	if auth == nil {
-		ar.setError(E_INVALID_GRANT, nil, "handleAuthorizationCodeRequest", "getClientAuth is required")
+		// getClientAuth has set the error
 		return ar
 	}
 
@@ -82,6 +87,8 @@ func (ar *AccessRequest) handleAuthorizationCodeRequest(ctx context.Context, par
 	ar.GenerateRefresh = true
 	ar.TokenExpiration = ar.config.TokenExpiration
 	ar.ParentTokenExpiration = ar.config.ParentTokenExpiration
+	ar.RefreshTokenExpiration = ar.config.RefreshTokenExpiration
+	ar.RetainTokenAfterRefresh = ar.config.RetainTokenAfterRefresh
 
 	// "code" is required
 	if ar.Code == "" {
@@ -91,9 +98,10 @@ func (ar *AccessRequest) handleAuthorizationCodeRequest(ctx context.Context, par
 
 	// must have a valid client
 	if ar.Client = ar.getClient(ctx, auth); ar.Client == nil {
-		ar.setError(E_UNAUTHORIZED_CLIENT, nil, "handleAuthorizationCodeRequest", "client is nil")
+		// getClient has set the error
 		return ar
 	}
+	ar.applyTokenPolicy()
 
 	// must be a valid authorization code
 	var err error
@@ -176,7 +184,8 @@ func (ar *AccessRequest) handleRefreshTokenRequest(ctx context.Context, param Ac
 	// get client authentication
 	auth := ar.getClientAuth(param.ClientAuthParam, ar.config.AllowClientSecretInParams)
 	if auth == nil {
-		return nil
+		// getClientAuth has set the error
+		return ar
 	}
 
 	// generate access token
@@ -185,6 +194,8 @@ func (ar *AccessRequest) handleRefreshTokenRequest(ctx context.Context, param Ac
 	ar.Scope = param.Scope
 	ar.GenerateRefresh = true
 	ar.TokenExpiration = ar.config.TokenExpiration
+	ar.RefreshTokenExpiration = ar.config.RefreshTokenExpiration
+	ar.RetainTokenAfterRefresh = ar.config.RetainTokenAfterRefresh
 
 	// "refresh_token" is required
 	if ar.Code == "" {
@@ -194,7 +205,14 @@ func (ar *AccessRequest) handleRefreshTokenRequest(ctx context.Context, param Ac
 
 	// must have a valid client
 	if ar.Client = ar.getClient(ctx, auth); ar.Client == nil {
-		ar.setError(E_UNAUTHORIZED_CLIENT, nil, "handleRefreshTokenRequest", "client is nil")
+		// getClient has set the error
+		return ar
+	}
+	ar.applyTokenPolicy()
+	// 客户端的token策略不下发refresh token时，也不允许使用refresh token换取token
+	// ssostorage的refresh token就是access token，只隐藏响应里的refresh_token不够
+	if !ar.GenerateRefresh {
+		ar.setError(E_UNAUTHORIZED_CLIENT, nil, "handleRefreshTokenRequest", "refresh token is not allowed for the client")
 		return ar
 	}
 
@@ -217,12 +235,17 @@ func (ar *AccessRequest) handleRefreshTokenRequest(ctx context.Context, param Ac
 		ar.setError(E_UNAUTHORIZED_CLIENT, nil, "handleRefreshTokenRequest", "access data client redirect uri is empty")
 		return ar
 	}
+	if ar.AccessData.IsRefreshExpiredAt(time.Now()) {
+		ar.setError(E_INVALID_GRANT, nil, "handleRefreshTokenRequest", "refresh token is expired")
+		return ar
+	}
 
 	// client must be the same as the previous token
 	if ar.AccessData.Client.GetId() != ar.Client.GetId() {
-		ar.setError(E_INVALID_CLIENT, errors.New("Client id must be the same from previous token"), "handleRefreshTokenRequest", "client mismatch,refresh_token="+ar.Code+", current="+ar.Client.GetId()+", previous="+ar.AccessData.Client.GetId())
-		return nil
-
+		// the refresh token was issued to another client, https://tools.ietf.org/html/rfc6749#section-5.2
+		// client ids are only logged, not exposed in the response
+		ar.setError(E_INVALID_GRANT, fmt.Errorf("Client id must be the same from previous token, current=%s, previous=%s", ar.Client.GetId(), ar.AccessData.Client.GetId()), "handleRefreshTokenRequest", "refresh token was issued to another client")
+		return ar
 	}
 
 	// set rest of data
@@ -243,12 +266,26 @@ func (ar *AccessRequest) handleRefreshTokenRequest(ctx context.Context, param Ac
 
 // Helper Functions
 
+// applyTokenPolicy 使用客户端的token策略覆盖默认配置
+func (ar *AccessRequest) applyTokenPolicy() {
+	policy := ar.config.tokenPolicy(ar.Client)
+	ar.TokenExpiration = policy.accessTokenExpiration
+	ar.ParentTokenExpiration = policy.parentTokenExpiration
+	ar.RefreshTokenExpiration = policy.refreshTokenExpiration
+	ar.GenerateRefresh = ar.GenerateRefresh && policy.issueRefreshToken
+	ar.RetainTokenAfterRefresh = policy.retainTokenAfterRefresh
+}
+
 // getClient looks up and authenticates the basic auth using the given
 // storage. Sets an error on the response if auth fails or a server error occurs.
 func (ar *AccessRequest) getClient(ctx context.Context, auth *BasicAuth) Client {
 	client, err := ar.config.storage.GetClient(ctx, auth.Username)
 	if errors.Is(err, ErrNotFound) {
-		ar.setError(E_UNAUTHORIZED_CLIENT, nil, "getClient", "not found")
+		ar.setClientAuthError(fmt.Errorf("client not found, client_id=%s, err: %w", auth.Username, err), "getClient", "client authentication failed")
+		return nil
+	}
+	if errors.Is(err, ErrClientSuspended) {
+		ar.setError(E_UNAUTHORIZED_CLIENT, err, "getClient", "client suspended")
 		return nil
 	}
 	if err != nil {
@@ -256,12 +293,12 @@ func (ar *AccessRequest) getClient(ctx context.Context, auth *BasicAuth) Client
 		return nil
 	}
 	if client == nil {
-		ar.setError(E_UNAUTHORIZED_CLIENT, nil, "getClient", "client is nil")
+		ar.setClientAuthError(fmt.Errorf("client is nil, client_id=%s", auth.Username), "getClient", "client authentication failed")
 		return nil
 	}
 
 	if !CheckClientSecret(client, auth.Password) {
-		ar.setError(E_UNAUTHORIZED_CLIENT, nil, "getClient", "client check failed, client_id="+client.GetId())
+		ar.setClientAuthError(fmt.Errorf("client secret mismatch, client_id=%s", client.GetId()), "getClient", "client authentication failed")
 		return nil
 	}
 
@@ -272,6 +309,15 @@ func (ar *AccessRequest) getClient(ctx context.Context, auth *BasicAuth) Client
 	return client
 }
 
+// setClientAuthError sets invalid_client with a WWW-Authenticate challenge,
+// https://tools.ietf.org/html/rfc6749#section-5.2
+// The description is the same for unknown clients and wrong secrets, details such as
+// the client id go to internalError and are only logged.
+func (ar *AccessRequest) setClientAuthError(internalError error, method string, description string) {
+	ar.setError(E_INVALID_CLIENT, internalError, method, description)
+	ar.err.WWWAuthenticate = `Basic realm="oauth2"`
+}
+
 type ClientAuthParam struct {
 	ClientId      string
 	ClientSecret  string
@@ -303,7 +349,7 @@ func (ar *AccessRequest) getClientAuth(param ClientAuthParam, allowQueryParams b
 		return nil
 	}
 	if auth == nil {
-		ar.setError(E_INVALID_REQUEST, errors.New("Client authentication not sent"), "get_client_auth", "client authentication not sent")
+		ar.setClientAuthError(errors.New("Client authentication not sent"), "get_client_auth", "client authentication not sent")
 		return nil
 	}
 	return auth
@@ -330,6 +376,8 @@ type AccessData struct {
 	TokenExpiresIn int64
 	// Token expiration in seconds
 	ParentTokenExpiresIn int64
+	// Refresh token expiration in seconds, 0 means never expire
+	RefreshTokenExpiresIn int64
 
 	// Requested scope
 	Scope string
@@ -362,6 +410,14 @@ func (d *AccessData) ExpireAt() time.Time {
 	return d.CreatedAt.Add(time.Duration(d.TokenExpiresIn) * time.Second)
 }
 
+// IsRefreshExpiredAt returns true if refresh token expires at time 't'
+func (d *AccessData) IsRefreshExpiredAt(t time.Time) bool {
+	if d.RefreshTokenExpiresIn <= 0 {
+		return false
+	}
+	return d.CreatedAt.Add(time.Duration(d.RefreshTokenExpiresIn) * time.Second).Before(t)
+}
+
 // AccessTokenGen generates access tokens
 //type AccessTokenGen interface {
 //	GenerateAccessToken(data *AccessData, generaterefresh bool) (accesstoken string, refreshtoken string, err error)
@@ -411,12 +467,14 @@ func (ar *AccessRequest) Build(options ...AccessRequestOption) error {
 			StoreData: model.SubTokenData{
 				UA:       ar.authUA,
 				ClientIP: ar.authClientIP,
+				Uid:      ar.ssoUid,
 			},
 		}
 
 		ret.AccessToken = ret.TokenData.Token.Token
 		if ar.GenerateRefresh {
-			ret.RefreshToken = model.NewToken(ar.TokenExpiration).Token
+			ret.RefreshToken = model.NewToken(ar.RefreshTokenExpiration).Token
+			ret.RefreshTokenExpiresIn = ar.RefreshTokenExpiration
 		}
 
 		//ret.AccessToken, ret.RefreshToken, err = ar.config.accessTokenGen.GenerateAccessToken(ret, ar.GenerateRefresh)
@@ -440,7 +498,7 @@ func (ar *AccessRequest) Build(options ...AccessRequestOption) error {
 	}
 
 	// remove previous access token
-	if ret.AccessData != nil && !ar.config.RetainTokenAfterRefresh {
+	if ret.AccessData != nil && !ar.RetainTokenAfterRefresh {
 		if ret.AccessData.RefreshToken != "" {
 			ar.config.storage.RemoveRefresh(ar.Ctx, ret.AccessData.RefreshToken)
 		}
@@ -21,3 +21,10 @@ func WithAccessAuthClientIP(clientIP string) AccessRequestOption {
 		c.authClientIP = clientIP
 	}
 }
+
+// WithAccessSsoUid 多账号下，下发的token属于parent token里的哪个账号
+func WithAccessSsoUid(uid int64) AccessRequestOption {
+	return func(c *AccessRequest) {
+		c.ssoUid = uid
+	}
+}
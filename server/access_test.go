@@ -0,0 +1,154 @@
+package server_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ego-component/eoauth2/server"
+	"github.com/ego-component/eoauth2/storage/memstorage"
+)
+
+// newTestServer 使用内存存储创建server，注册了client-a、client-b两个客户端
+func newTestServer(t *testing.T) *server.Component {
+	t.Helper()
+	mem := memstorage.NewStorage(memstorage.WithCleanupInterval(0), memstorage.WithClients(
+		&server.DefaultClient{Id: "client-a", Secret: "secret-a", RedirectUri: "http://localhost/a"},
+		&server.DefaultClient{Id: "client-b", Secret: "secret-b", RedirectUri: "http://localhost/b"},
+	))
+	t.Cleanup(mem.Close)
+	return server.DefaultContainer().Build(server.WithStorage(mem))
+}
+
+// issueToken 客户端通过authorization code获取token，返回refresh token
+func issueToken(t *testing.T, srv *server.Component, clientId string, secret string) string {
+	t.Helper()
+	return issueAccess(t, srv, clientId, secret).GetOutput("refresh_token").(string)
+}
+
+// issueAccess 客户端通过authorization code获取token
+func issueAccess(t *testing.T, srv *server.Component, clientId string, secret string) *server.AccessRequest {
+	t.Helper()
+	ctx := context.Background()
+	authorize := srv.HandleAuthorizeRequest(ctx, server.AuthorizeRequestParam{
+		ClientId:     clientId,
+		ResponseType: string(server.CODE),
+	})
+	if err := authorize.Build(server.WithAuthorizeRequestAuthorized(true)); err != nil {
+		t.Fatalf("authorize failed, err: %v", err)
+	}
+	access := srv.HandleAccessRequest(ctx, server.ParamAccessRequest{
+		Method:    "POST",
+		GrantType: string(server.AUTHORIZATION_CODE),
+		AccessRequestParam: server.AccessRequestParam{
+			Code:            authorize.GetOutput("code").(string),
+			ClientAuthParam: server.ClientAuthParam{ClientId: clientId, ClientSecret: secret},
+		},
+	})
+	if err := access.Build(server.WithAccessRequestAuthorized(true)); err != nil {
+		t.Fatalf("access failed, err: %v", err)
+	}
+	return access
+}
+
+func refresh(srv *server.Component, refreshToken string, clientId string, secret string) *server.AccessRequest {
+	return srv.HandleAccessRequest(context.Background(), server.ParamAccessRequest{
+		Method:    "POST",
+		GrantType: string(server.REFRESH_TOKEN),
+		AccessRequestParam: server.AccessRequestParam{
+			Code:            refreshToken,
+			ClientAuthParam: server.ClientAuthParam{ClientId: clientId, ClientSecret: secret},
+		},
+	})
+}
+
+func TestRefreshClientMismatch(t *testing.T) {
+	srv := newTestServer(t)
+	refreshToken := issueToken(t, srv, "client-a", "secret-a")
+
+	ar := refresh(srv, refreshToken, "client-b", "secret-b")
+	if ar == nil || !ar.IsError() {
+		t.Fatal("refresh with another client should fail")
+	}
+	oauthErr := ar.GetError()
+	if oauthErr.Code != server.E_INVALID_GRANT {
+		t.Errorf("error code, want: %s, got: %s", server.E_INVALID_GRANT, oauthErr.Code)
+	}
+	if strings.Contains(oauthErr.Description, "client-a") || strings.Contains(oauthErr.Description, "client-b") {
+		t.Errorf("description should not contain client ids, got: %s", oauthErr.Description)
+	}
+	if oauthErr.WWWAuthenticate != "" {
+		t.Errorf("invalid_grant should not set WWW-Authenticate, got: %s", oauthErr.WWWAuthenticate)
+	}
+}
+
+func TestClientAuthFailed(t *testing.T) {
+	srv := newTestServer(t)
+	refreshToken := issueToken(t, srv, "client-a", "secret-a")
+
+	// 客户端不存在和密钥错误返回相同的描述，不暴露client id
+	var descriptions []string
+	for _, auth := range [][2]string{{"client-a", "wrong"}, {"client-missing", "secret"}} {
+		ar := refresh(srv, refreshToken, auth[0], auth[1])
+		if !ar.IsError() {
+			t.Fatalf("client %s with wrong secret should fail", auth[0])
+		}
+		oauthErr := ar.GetError()
+		if oauthErr.Code != server.E_INVALID_CLIENT {
+			t.Errorf("error code, want: %s, got: %s", server.E_INVALID_CLIENT, oauthErr.Code)
+		}
+		if oauthErr.StatusCode != 401 || oauthErr.WWWAuthenticate == "" {
+			t.Errorf("invalid_client should be 401 with WWW-Authenticate, got: %d %q", oauthErr.StatusCode, oauthErr.WWWAuthenticate)
+		}
+		if strings.Contains(oauthErr.Description, "client_id") || strings.Contains(oauthErr.Description, auth[0]) {
+			t.Errorf("description should not contain client id, got: %s", oauthErr.Description)
+		}
+		descriptions = append(descriptions, oauthErr.Description)
+	}
+	if descriptions[0] != descriptions[1] {
+		t.Errorf("descriptions should be the same, got: %v", descriptions)
+	}
+}
+
+// TestRefreshNotAllowed 客户端的token策略不下发refresh token时，不能用access token换取新的token
+func TestRefreshNotAllowed(t *testing.T) {
+	issueRefreshToken := false
+	mem := memstorage.NewStorage(memstorage.WithCleanupInterval(0), memstorage.WithClients(
+		&server.DefaultClient{Id: "client-a", Secret: "secret-a", RedirectUri: "http://localhost/a", TokenPolicy: &server.TokenPolicy{IssueRefreshToken: &issueRefreshToken}},
+	))
+	t.Cleanup(mem.Close)
+	srv := server.DefaultContainer().Build(server.WithStorage(mem))
+
+	access := issueAccess(t, srv, "client-a", "secret-a")
+	if refreshToken := access.GetOutput("refresh_token"); refreshToken != nil {
+		t.Fatalf("refresh token should not be issued, got: %v", refreshToken)
+	}
+	ar := refresh(srv, access.GetOutput("access_token").(string), "client-a", "secret-a")
+	if !ar.IsError() {
+		t.Fatal("refresh should fail when the client policy disables refresh tokens")
+	}
+	if code := ar.GetError().Code; code != server.E_UNAUTHORIZED_CLIENT {
+		t.Errorf("error code, want: %s, got: %s", server.E_UNAUTHORIZED_CLIENT, code)
+	}
+}
+
+func TestIsRefreshExpiredAt(t *testing.T) {
+	now := time.Now()
+	for name, c := range map[string]struct {
+		expiresIn int64
+		createdAt time.Time
+		want      bool
+	}{
+		"never expire": {expiresIn: 0, createdAt: now.Add(-24 * time.Hour), want: false},
+		"not expired":  {expiresIn: 3600, createdAt: now.Add(-time.Minute), want: false},
+		"expired":      {expiresIn: 60, createdAt: now.Add(-time.Hour), want: true},
+	} {
+		t.Run(name, func(t *testing.T) {
+			data := &server.AccessData{RefreshTokenExpiresIn: c.expiresIn, CreatedAt: c.createdAt}
+			if got := data.IsRefreshExpiredAt(now); got != c.want {
+				t.Errorf("IsRefreshExpiredAt, want: %v, got: %v", c.want, got)
+			}
+		})
+	}
+}
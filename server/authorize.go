@@ -32,6 +32,8 @@ type AuthorizeRequest struct {
 	userData    interface{} // Data to be passed to storage. Not used by the library.
 	authorized  bool        // Set if request is authorized
 	redirectUri string
+	// redirectUri 已经和客户端注册的地址校验过，出错时可以跳转回客户端
+	redirectUriVerified bool
 
 	// Token expiration in seconds. Change if different from default.
 	// If type = TOKEN, this expiration will be for the ACCESS token.
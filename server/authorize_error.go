@@ -0,0 +1,49 @@
+package server
+
+import (
+	"errors"
+)
+
+// ErrRedirectUriNotVerified is the error returned by AuthorizeRequest.GetErrorRedirectUrl when the
+// client or the redirect uri is invalid. Per https://tools.ietf.org/html/rfc6749#section-4.1.2.1
+// the authorization server MUST NOT redirect, the error should be shown with AuthorizeRequest.GetErrorPage.
+var ErrRedirectUriNotVerified = errors.New("Redirect uri not verified")
+
+// ErrorPage 无法跳转回客户端时，授权服务器自己展示的错误页面数据
+type ErrorPage struct {
+	Code        string // 错误码
+	Description string // 错误描述，HideErrorDetails 为true时为通用描述
+	URI         string // 错误说明页面，可以为空
+	StatusCode  int    // 建议返回的 HTTP 状态码
+}
+
+// IsRedirectUriVerified 跳转地址是否已经和客户端注册的地址校验过，校验过之后出错才可以跳转回客户端
+func (r *AuthorizeRequest) IsRedirectUriVerified() bool {
+	return r.redirectUriVerified
+}
+
+// GetErrorRedirectUrl 返回带有错误信息的跳转地址，包含error、error_description、error_uri和state
+// response_type为token时错误信息在fragment里，否则在query里，https://tools.ietf.org/html/rfc6749#section-4.1.2.1
+// 跳转地址没有校验过时返回 ErrRedirectUriNotVerified，需要使用 GetErrorPage 展示错误
+func (r *AuthorizeRequest) GetErrorRedirectUrl() (string, error) {
+	if !r.IsError() {
+		return "", errors.New("Not an error response")
+	}
+	if !r.redirectUriVerified {
+		return "", ErrRedirectUriNotVerified
+	}
+	return r.buildRedirectUrl(r.redirectUri, r.Type == TOKEN)
+}
+
+// GetErrorPage 返回本地错误页面数据，没有错误时返回nil，不包含state和跳转地址
+func (r *AuthorizeRequest) GetErrorPage() *ErrorPage {
+	if !r.IsError() {
+		return nil
+	}
+	return &ErrorPage{
+		Code:        r.err.Code,
+		Description: r.err.Description,
+		URI:         r.err.URI,
+		StatusCode:  r.err.StatusCode,
+	}
+}
@@ -0,0 +1,146 @@
+package server_test
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/ego-component/eoauth2/server"
+	"github.com/ego-component/eoauth2/storage/memstorage"
+	"github.com/gotomicro/ego/core/econf"
+)
+
+// newImplicitTestServer 允许response_type为token的server
+func newImplicitTestServer(t *testing.T) *server.Component {
+	t.Helper()
+	econf.Set("oauth2implicit", map[string]interface{}{
+		"AllowedAuthorizeTypes": []string{string(server.CODE), string(server.TOKEN)},
+	})
+	mem := memstorage.NewStorage(memstorage.WithCleanupInterval(0), memstorage.WithClients(
+		&server.DefaultClient{Id: "client-a", Secret: "secret-a", RedirectUri: "http://localhost/a"},
+	))
+	t.Cleanup(mem.Close)
+	return server.Load("oauth2implicit").Build(server.WithStorage(mem))
+}
+
+// TestAuthorizeErrorNotVerified 客户端不存在、跳转地址不匹配时不能跳转，使用本地错误页面
+func TestAuthorizeErrorNotVerified(t *testing.T) {
+	srv := newTestServer(t)
+	for name, c := range map[string]struct {
+		param server.AuthorizeRequestParam
+		code  string
+	}{
+		"unknown client": {
+			param: server.AuthorizeRequestParam{ClientId: "client-missing", RedirectUri: "http://localhost/a", ResponseType: string(server.CODE), State: "xyz"},
+			code:  server.E_UNAUTHORIZED_CLIENT,
+		},
+		"redirect uri mismatch": {
+			param: server.AuthorizeRequestParam{ClientId: "client-a", RedirectUri: "http://attacker.example/cb", ResponseType: string(server.CODE), State: "xyz"},
+			code:  server.E_INVALID_REQUEST,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			ar := srv.HandleAuthorizeRequest(context.Background(), c.param)
+			if !ar.IsError() {
+				t.Fatal("authorize request should fail")
+			}
+			if ar.IsRedirectUriVerified() {
+				t.Error("redirect uri should not be verified")
+			}
+			if _, err := ar.GetErrorRedirectUrl(); !errors.Is(err, server.ErrRedirectUriNotVerified) {
+				t.Errorf("GetErrorRedirectUrl, want ErrRedirectUriNotVerified, got: %v", err)
+			}
+			page := ar.GetErrorPage()
+			if page == nil {
+				t.Fatal("error page should not be nil")
+			}
+			if page.Code != c.code {
+				t.Errorf("error page code, want: %s, got: %s", c.code, page.Code)
+			}
+			if page.StatusCode == 0 {
+				t.Error("error page status code should be set")
+			}
+		})
+	}
+}
+
+// TestAuthorizeErrorRedirectQuery 跳转地址校验之后的错误，跳转回客户端，错误信息在query里并保留state
+func TestAuthorizeErrorRedirectQuery(t *testing.T) {
+	srv := newTestServer(t)
+	ar := srv.HandleAuthorizeRequest(context.Background(), server.AuthorizeRequestParam{
+		ClientId:            "client-a",
+		RedirectUri:         "http://localhost/a",
+		ResponseType:        string(server.CODE),
+		State:               "xyz",
+		CodeChallenge:       strings.Repeat("a", 43),
+		CodeChallengeMethod: "S512",
+	})
+	if !ar.IsError() {
+		t.Fatal("invalid code_challenge_method should fail")
+	}
+	if !ar.IsRedirectUriVerified() {
+		t.Fatal("redirect uri should be verified")
+	}
+	redirectUrl, err := ar.GetErrorRedirectUrl()
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := url.Parse(redirectUrl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Host != "localhost" || u.Path != "/a" || u.Fragment != "" {
+		t.Errorf("redirect url, got: %s", redirectUrl)
+	}
+	query := u.Query()
+	if query.Get("error") != server.E_INVALID_REQUEST {
+		t.Errorf("error, want: %s, got: %s", server.E_INVALID_REQUEST, query.Get("error"))
+	}
+	if query.Get("error_description") == "" {
+		t.Error("error_description should be set")
+	}
+	if query.Get("state") != "xyz" {
+		t.Errorf("state, want: xyz, got: %s", query.Get("state"))
+	}
+}
+
+// TestAuthorizeErrorRedirectFragment response_type为token时错误信息在fragment里
+func TestAuthorizeErrorRedirectFragment(t *testing.T) {
+	srv := newImplicitTestServer(t)
+	ar := srv.HandleAuthorizeRequest(context.Background(), server.AuthorizeRequestParam{
+		ClientId:     "client-a",
+		RedirectUri:  "http://localhost/a",
+		ResponseType: string(server.TOKEN),
+		State:        "xyz",
+	})
+	if ar.IsError() {
+		t.Fatalf("authorize request failed, err: %v", ar.GetError())
+	}
+	// 用户拒绝授权
+	if err := ar.Build(server.WithAuthorizeRequestAuthorized(false)); err == nil {
+		t.Fatal("unauthorized request should fail")
+	}
+	redirectUrl, err := ar.GetErrorRedirectUrl()
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := url.Parse(redirectUrl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.RawQuery != "" {
+		t.Errorf("error should not be in query, got: %s", redirectUrl)
+	}
+	fragment, err := url.ParseQuery(u.Fragment)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fragment.Get("error") != server.E_ACCESS_DENIED {
+		t.Errorf("error, want: %s, got: %s", server.E_ACCESS_DENIED, fragment.Get("error"))
+	}
+	if fragment.Get("state") != "xyz" {
+		t.Errorf("state, want: xyz, got: %s", fragment.Get("state"))
+	}
+}
@@ -1,5 +1,12 @@
 package server
 
+import (
+	"crypto/subtle"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
 // Client information
 type Client interface {
 	// Client id
@@ -23,12 +30,51 @@ type ClientSecretMatcher interface {
 	ClientSecretMatches(secret string) bool
 }
 
+// TokenPolicy is per-client token policy, zero values fall back to Config
+type TokenPolicy struct {
+	AccessTokenExpiration   int64 `json:"accessTokenExpiration,omitempty" msgpack:"a"`    // Sub Token expiration in seconds
+	RefreshTokenExpiration  int64 `json:"refreshTokenExpiration,omitempty" msgpack:"r"`   // Refresh token expiration in seconds
+	ParentTokenExpiration   int64 `json:"parentTokenExpiration,omitempty" msgpack:"p"`    // Parent Token expiration in seconds
+	IssueRefreshToken       *bool `json:"issueRefreshToken,omitempty" msgpack:"ir"`       // Whether refresh tokens are issued
+	RetainTokenAfterRefresh *bool `json:"retainTokenAfterRefresh,omitempty" msgpack:"rt"` // Whether previous tokens are kept after refresh
+}
+
+// ClientTokenPolicy is an optional interface clients can implement
+// to override the token lifetimes of Config.
+type ClientTokenPolicy interface {
+	// GetTokenPolicy returns the token policy, nil means use Config
+	GetTokenPolicy() *TokenPolicy
+}
+
+// ClientSecret is a hashed client secret, a client can hold several active secrets for rotation
+type ClientSecret struct {
+	Hash      string // bcrypt hash of the secret
+	ExpiresAt int64  // unix time, 0 means never expire
+}
+
+// IsExpiredAt is true if the secret expires at time 't'
+func (s ClientSecret) IsExpiredAt(t time.Time) bool {
+	return s.ExpiresAt > 0 && s.ExpiresAt <= t.Unix()
+}
+
+// HashClientSecret returns the bcrypt hash of a client secret
+func HashClientSecret(secret string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(secret), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
 // DefaultClient stores all data in struct variables
 type DefaultClient struct {
 	Id          string
-	Secret      string
+	Secret      string         // plain text secret, only used if Secrets is empty
+	Secrets     []ClientSecret // hashed secrets, any active secret matches
+	Public      bool           // public client without secret, only matches an empty secret
 	RedirectUri string
 	UserData    interface{}
+	TokenPolicy *TokenPolicy // optional token policy, nil means use Config
 }
 
 func (d *DefaultClient) GetId() string {
@@ -47,14 +93,48 @@ func (d *DefaultClient) GetUserData() interface{} {
 	return d.UserData
 }
 
+// Implement the ClientTokenPolicy interface
+func (d *DefaultClient) GetTokenPolicy() *TokenPolicy {
+	return d.TokenPolicy
+}
+
 // Implement the ClientSecretMatcher interface
 func (d *DefaultClient) ClientSecretMatches(secret string) bool {
-	return d.Secret == secret
+	if d.Public {
+		return secret == ""
+	}
+	// 公共客户端才能使用空密钥，没有任何密钥的机密客户端不能通过认证
+	if secret == "" {
+		return false
+	}
+	if len(d.Secrets) == 0 {
+		if d.Secret == "" {
+			return false
+		}
+		return subtle.ConstantTimeCompare([]byte(d.Secret), []byte(secret)) == 1
+	}
+	nowTime := time.Now()
+	for _, value := range d.Secrets {
+		if value.IsExpiredAt(nowTime) {
+			continue
+		}
+		if bcrypt.CompareHashAndPassword([]byte(value.Hash), []byte(secret)) == nil {
+			return true
+		}
+	}
+	return false
 }
 
 func (d *DefaultClient) CopyFrom(client Client) {
 	d.Id = client.GetId()
 	d.Secret = client.GetSecret()
+	if c, ok := client.(*DefaultClient); ok {
+		d.Secrets = c.Secrets
+		d.Public = c.Public
+	}
 	d.RedirectUri = client.GetRedirectUri()
 	d.UserData = client.GetUserData()
+	if c, ok := client.(ClientTokenPolicy); ok {
+		d.TokenPolicy = c.GetTokenPolicy()
+	}
 }
@@ -0,0 +1,46 @@
+package server_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ego-component/eoauth2/server"
+)
+
+func TestClientSecretMatches(t *testing.T) {
+	hash, err := server.HashClientSecret("hashed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expiredHash, err := server.HashClientSecret("expired")
+	if err != nil {
+		t.Fatal(err)
+	}
+	hashed := &server.DefaultClient{Id: "hashed", Secrets: []server.ClientSecret{
+		{Hash: hash, ExpiresAt: time.Now().Add(time.Hour).Unix()},
+		{Hash: expiredHash, ExpiresAt: time.Now().Add(-time.Second).Unix()},
+	}}
+	for name, c := range map[string]struct {
+		client *server.DefaultClient
+		secret string
+		want   bool
+	}{
+		"bcrypt":                   {client: hashed, secret: "hashed", want: true},
+		"bcrypt wrong":             {client: hashed, secret: "wrong", want: false},
+		"bcrypt expired":           {client: hashed, secret: "expired", want: false},
+		"bcrypt empty":             {client: hashed, secret: "", want: false},
+		"plain text":               {client: &server.DefaultClient{Id: "plain", Secret: "plain"}, secret: "plain", want: true},
+		"plain text wrong":         {client: &server.DefaultClient{Id: "plain", Secret: "plain"}, secret: "wrong", want: false},
+		"plain text empty":         {client: &server.DefaultClient{Id: "plain", Secret: "plain"}, secret: "", want: false},
+		"no secret empty":          {client: &server.DefaultClient{Id: "retired"}, secret: "", want: false},
+		"public empty":             {client: &server.DefaultClient{Id: "public", Public: true}, secret: "", want: true},
+		"public with secret":       {client: &server.DefaultClient{Id: "public", Public: true}, secret: "secret", want: false},
+		"legacy secret and hashed": {client: &server.DefaultClient{Id: "both", Secret: "plain", Secrets: hashed.Secrets}, secret: "plain", want: false},
+	} {
+		t.Run(name, func(t *testing.T) {
+			if got := server.CheckClientSecret(c.client, c.secret); got != c.want {
+				t.Errorf("CheckClientSecret, want: %v, got: %v", c.want, got)
+			}
+		})
+	}
+}
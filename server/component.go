@@ -48,6 +48,7 @@ func (c *Component) HandleAuthorizeRequest(ctx context.Context, param AuthorizeR
 			Ctx:    ctx,
 			logger: c.logger,
 			output: make(ResponseData),
+			config: c.config,
 		},
 		storage:               c.config.storage,
 		config:                c.config,
@@ -95,6 +96,10 @@ func (c *Component) HandleAuthorizeRequest(ctx context.Context, param AuthorizeR
 		ret.setError(E_UNAUTHORIZED_CLIENT, err, "HandleAuthorizeRequest", "client not found")
 		return ret
 	}
+	if errors.Is(err, ErrClientSuspended) {
+		ret.setError(E_UNAUTHORIZED_CLIENT, err, "HandleAuthorizeRequest", "client suspended")
+		return ret
+	}
 	if err != nil {
 		ret.setError(E_SERVER_ERROR, err, "HandleAuthorizeRequest", "get client error")
 		return ret
@@ -108,6 +113,10 @@ func (c *Component) HandleAuthorizeRequest(ctx context.Context, param AuthorizeR
 		return ret
 	}
 
+	// 客户端可以覆盖token有效期
+	policy := c.config.tokenPolicy(ret.Client)
+	ret.ParentTokenExpiration = policy.parentTokenExpiration
+
 	// check redirect uri, if there are multiple client redirect uri's
 	// don't set the uri
 	if ret.redirectUri == "" && FirstUri(ret.Client.GetRedirectUri(), c.config.RedirectUriSeparator) == ret.Client.GetRedirectUri() {
@@ -119,6 +128,7 @@ func (c *Component) HandleAuthorizeRequest(ctx context.Context, param AuthorizeR
 		return ret
 	} else {
 		ret.redirectUri = realRedirectUri
+		ret.redirectUriVerified = true
 	}
 
 	switch requestType {
@@ -157,7 +167,7 @@ func (c *Component) HandleAuthorizeRequest(ctx context.Context, param AuthorizeR
 		}
 	case TOKEN:
 		ret.Type = TOKEN
-		ret.Expiration = c.config.TokenExpiration
+		ret.Expiration = policy.accessTokenExpiration
 	}
 	return ret
 
@@ -231,8 +241,7 @@ func (r *AuthorizeRequest) Build(options ...AuthorizeRequestOption) error {
 
 		// save authorization token
 		if err = ret.storage.SaveAuthorize(r.Ctx, ret); err != nil {
-			ret.setError(E_SERVER_ERROR, err, "AuthorizeRequestBuild", "SaveAuthorize error")
-			return fmt.Errorf("build error4, err: %w", r.responseErr)
+			return r.setSaveAuthorizeError(err)
 		}
 
 		r.setParentToken(ret.SsoData.Token)
@@ -260,8 +269,7 @@ func (r *AuthorizeRequest) Build(options ...AuthorizeRequestOption) error {
 		var err error
 		// save authorization token
 		if err = ret.storage.SaveAuthorize(r.Ctx, ret); err != nil {
-			ret.setError(E_SERVER_ERROR, err, "AuthorizeRequestBuild", "SaveAuthorize error")
-			return fmt.Errorf("build error4, err: %w", r.responseErr)
+			return r.setSaveAuthorizeError(err)
 		}
 		r.setParentToken(ret.SsoData.Token)
 		return nil
@@ -269,6 +277,16 @@ func (r *AuthorizeRequest) Build(options ...AuthorizeRequestOption) error {
 	return fmt.Errorf("not exist type")
 }
 
+// setSaveAuthorizeError 超过会话个数限制时拒绝登录，返回的错误可以用 errors.As 取出 *Error，用 errors.Is 判断 ErrSessionLimitExceeded
+func (r *AuthorizeRequest) setSaveAuthorizeError(err error) error {
+	if errors.Is(err, ErrSessionLimitExceeded) {
+		r.setError(E_ACCESS_DENIED, err, "AuthorizeRequestBuild", "session limit exceeded")
+	} else {
+		r.setError(E_SERVER_ERROR, err, "AuthorizeRequestBuild", "SaveAuthorize error")
+	}
+	return fmt.Errorf("build error4, err: %w", r.responseErr)
+}
+
 func (r *AuthorizeRequest) generateSsoData() {
 	ssoParentToken := model.NewToken(r.ParentTokenExpiration)
 	// 如果自己设置了sso ptoken，那么使用用户定义的，因为可能是多账号登录
@@ -301,6 +319,7 @@ func (c *Component) HandleAccessRequest(ctx context.Context, param ParamAccessRe
 			Ctx:    ctx,
 			logger: c.logger,
 			output: make(ResponseData),
+			config: c.config,
 		},
 		config: c.config,
 	}
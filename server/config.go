@@ -10,11 +10,18 @@ type Config struct {
 	TokenExpiration         int64                 // Sub Token expiration in seconds (default 1 day)
 	TokenType               string                // Token type to return
 	ParentTokenExpiration   int64                 // Parent Token expiration
+	RefreshTokenExpiration  int64                 // Refresh token expiration in seconds, 0 means never expire (default)
 	AllowedAuthorizeTypes   AllowedAuthorizeTypes // List of allowed authorize types (only CODE by default)
 	AllowedAccessTypes      AllowedAccessTypes    // List of allowed access types (only AUTHORIZATION_CODE by default)
-	// HTTP status code to return for errors - default 200
-	// Only used if response was created from server
+	// HTTP status code to return for errors, 0 means the status of each error code,
+	// e.g. 400 for invalid_grant, 401 for invalid_client - default 0
 	ErrorStatusCode int
+	// If true error_description is the generic description of the error code,
+	// the specific reason is only logged - default false
+	HideErrorDetails bool
+	// Base uri of the error documentation page, error_uri is {ErrorUri}#{error}.
+	// If blank (the default), error_uri is not returned
+	ErrorUri string
 	// If true allows client secret also in params, else only in
 	// Authorization header - default true
 	AllowClientSecretInParams bool
@@ -28,7 +35,12 @@ type Config struct {
 	// RetainTokenAfter Refresh allows the server to retain the access and
 	// refresh token for re-use - default false
 	RetainTokenAfterRefresh bool
-	storage                 Storage
+	// If true allows dynamic client registration without initial access token - default false
+	AllowOpenRegistration bool
+	// Base uri of client configuration endpoint, registration_client_uri is {RegistrationClientUri}/{client_id}
+	RegistrationClientUri string
+	storage               Storage
+	registrationStorage   RegistrationStorage
 }
 
 // DefaultConfig ...
@@ -37,18 +49,64 @@ func DefaultConfig() *Config {
 		AuthorizationExpiration:     300,
 		TokenExpiration:             3600 * 24,      // 默认一天
 		ParentTokenExpiration:       3600 * 24 * 30, // 默认30天
+		RefreshTokenExpiration:      0,
 		TokenType:                   "Bearer",
 		AllowedAuthorizeTypes:       AllowedAuthorizeTypes{CODE, LOGIN},
 		AllowedAccessTypes:          AllowedAccessTypes{AUTHORIZATION_CODE, REFRESH_TOKEN},
-		ErrorStatusCode:             200,
+		ErrorStatusCode:             0,
 		AllowClientSecretInParams:   true,
 		AllowGetAccessRequest:       false,
 		RequirePKCEForPublicClients: false,
 		RedirectUriSeparator:        "",
 		RetainTokenAfterRefresh:     false,
+		AllowOpenRegistration:       false,
 	}
 }
 
+// tokenPolicy is the effective token policy of a client
+type tokenPolicy struct {
+	accessTokenExpiration   int64
+	refreshTokenExpiration  int64
+	parentTokenExpiration   int64
+	issueRefreshToken       bool
+	retainTokenAfterRefresh bool
+}
+
+// tokenPolicy merges the client token policy with config, client overrides win
+func (c *Config) tokenPolicy(client Client) tokenPolicy {
+	ret := tokenPolicy{
+		accessTokenExpiration:   c.TokenExpiration,
+		refreshTokenExpiration:  c.RefreshTokenExpiration,
+		parentTokenExpiration:   c.ParentTokenExpiration,
+		issueRefreshToken:       true,
+		retainTokenAfterRefresh: c.RetainTokenAfterRefresh,
+	}
+	policyClient, ok := client.(ClientTokenPolicy)
+	if !ok {
+		return ret
+	}
+	policy := policyClient.GetTokenPolicy()
+	if policy == nil {
+		return ret
+	}
+	if policy.AccessTokenExpiration > 0 {
+		ret.accessTokenExpiration = policy.AccessTokenExpiration
+	}
+	if policy.RefreshTokenExpiration > 0 {
+		ret.refreshTokenExpiration = policy.RefreshTokenExpiration
+	}
+	if policy.ParentTokenExpiration > 0 {
+		ret.parentTokenExpiration = policy.ParentTokenExpiration
+	}
+	if policy.IssueRefreshToken != nil {
+		ret.issueRefreshToken = *policy.IssueRefreshToken
+	}
+	if policy.RetainTokenAfterRefresh != nil {
+		ret.retainTokenAfterRefresh = *policy.RetainTokenAfterRefresh
+	}
+	return ret
+}
+
 // AllowedAuthorizeTypes is a collection of allowed auth request types
 type AllowedAuthorizeTypes []AuthorizeRequestType
 
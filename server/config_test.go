@@ -0,0 +1,48 @@
+package server
+
+import (
+	"testing"
+)
+
+func TestConfigTokenPolicy(t *testing.T) {
+	config := DefaultConfig()
+	config.TokenExpiration = 100
+	config.RefreshTokenExpiration = 200
+	config.ParentTokenExpiration = 300
+	config.RetainTokenAfterRefresh = true
+	disable := false
+
+	for name, c := range map[string]struct {
+		client Client
+		want   tokenPolicy
+	}{
+		"no policy": {
+			client: &DefaultClient{Id: "a"},
+			want:   tokenPolicy{accessTokenExpiration: 100, refreshTokenExpiration: 200, parentTokenExpiration: 300, issueRefreshToken: true, retainTokenAfterRefresh: true},
+		},
+		"zero values fall back to config": {
+			client: &DefaultClient{Id: "a", TokenPolicy: &TokenPolicy{}},
+			want:   tokenPolicy{accessTokenExpiration: 100, refreshTokenExpiration: 200, parentTokenExpiration: 300, issueRefreshToken: true, retainTokenAfterRefresh: true},
+		},
+		"client overrides": {
+			client: &DefaultClient{Id: "a", TokenPolicy: &TokenPolicy{
+				AccessTokenExpiration:   10,
+				RefreshTokenExpiration:  20,
+				ParentTokenExpiration:   30,
+				IssueRefreshToken:       &disable,
+				RetainTokenAfterRefresh: &disable,
+			}},
+			want: tokenPolicy{accessTokenExpiration: 10, refreshTokenExpiration: 20, parentTokenExpiration: 30, issueRefreshToken: false, retainTokenAfterRefresh: false},
+		},
+		"partial override": {
+			client: &DefaultClient{Id: "a", TokenPolicy: &TokenPolicy{AccessTokenExpiration: 10}},
+			want:   tokenPolicy{accessTokenExpiration: 10, refreshTokenExpiration: 200, parentTokenExpiration: 300, issueRefreshToken: true, retainTokenAfterRefresh: true},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			if got := config.tokenPolicy(c.client); got != c.want {
+				t.Errorf("tokenPolicy, want: %+v, got: %+v", c.want, got)
+			}
+		})
+	}
+}
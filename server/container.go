@@ -38,6 +38,13 @@ func WithStorage(storage Storage) Option {
 	}
 }
 
+// WithRegistrationStorage 注入动态注册客户端的存储
+func WithRegistrationStorage(storage RegistrationStorage) Option {
+	return func(c *Container) {
+		c.config.registrationStorage = storage
+	}
+}
+
 // Build ...
 func (c *Container) Build(options ...Option) *Component {
 	for _, option := range options {
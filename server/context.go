@@ -26,10 +26,12 @@ type Context struct {
 	responseErr        error  // 用户响应错误
 	internalErr        error  // 用户内部错粗
 	isError            bool
+	err                *Error
 	redirectInFragment bool
 	logger             *elog.Component
 	output             ResponseData
 	parentToken        model.Token // output会被设置到URL，自动生成的parent token，只能单独存储
+	config             *Config
 }
 
 // setRedirect changes the response to redirect to the given redirectUrl
@@ -44,14 +46,18 @@ func (c *Context) GetRedirectUrl() (string, error) {
 	if c.responseType != REDIRECT {
 		return "", errors.New("Not a redirect response")
 	}
+	return c.buildRedirectUrl(c.redirectUrl, c.redirectInFragment)
+}
 
-	u, err := url.Parse(c.redirectUrl)
+// buildRedirectUrl adds the output to the query or fragment of redirectUrl
+func (c *Context) buildRedirectUrl(redirectUrl string, inFragment bool) (string, error) {
+	u, err := url.Parse(redirectUrl)
 	if err != nil {
 		return "", err
 	}
 
 	var q url.Values
-	if c.redirectInFragment {
+	if inFragment {
 		// start with empty set for fragment
 		q = url.Values{}
 	} else {
@@ -67,7 +73,7 @@ func (c *Context) GetRedirectUrl() (string, error) {
 	// https://tools.ietf.org/html/rfc6749#section-4.2.2
 	// Fragment should be encoded as application/x-www-form-urlencoded (%-escaped, spaces are represented as '+')
 	// The stdlib redirectUrl#String() doesn't make that easy to accomplish, so build this ourselves
-	if c.redirectInFragment {
+	if inFragment {
 		u.Fragment = ""
 		redirectURI := u.String() + "#" + q.Encode()
 		return redirectURI, nil
@@ -103,12 +109,30 @@ func (c *Context) IsError() bool {
 	return c.isError
 }
 
-// setError sets an error id, description, and state on the Response
-// uri is left blank
+// GetError returns the OAuth error of the response, nil if there is no error
+func (c *Context) GetError() *Error {
+	return c.err
+}
+
+// setError sets an error id, description, uri and state on the Response
 func (c *Context) setError(responseError string, internalError error, method string, description string) {
 	// set error parameters
 	c.isError = true
-	c.responseErr = fmt.Errorf(responseError)
+	c.err = &Error{
+		Code:        responseError,
+		Description: c.errorDescription(responseError, description),
+		StatusCode:  errorStatusCode(responseError),
+		Internal:    internalError,
+	}
+	if c.config != nil {
+		if c.config.ErrorStatusCode != 0 {
+			c.err.StatusCode = c.config.ErrorStatusCode
+		}
+		if c.config.ErrorUri != "" {
+			c.err.URI = c.config.ErrorUri + "#" + responseError
+		}
+	}
+	c.responseErr = c.err
 	if internalError != nil {
 		// wrap error
 		c.internalErr = fmt.Errorf(responseError+", %w", internalError)
@@ -118,11 +142,27 @@ func (c *Context) setError(responseError string, internalError error, method str
 	// 先取出state信息
 	state := c.output["state"]
 	c.output = make(ResponseData) // clear output
-	c.output["error"] = c.responseErr.Error()
-	c.output["state"] = state
+	c.output["error"] = responseError
+	if c.err.Description != "" {
+		c.output["error_description"] = c.err.Description
+	}
+	if c.err.URI != "" {
+		c.output["error_uri"] = c.err.URI
+	}
+	if state != nil {
+		c.output["state"] = state
+	}
 	c.logger.Error("eoauth2_error", elog.FieldErr(c.internalErr), elog.FieldMethod(method), zap.String("description", description))
 }
 
+// errorDescription 输出的错误描述，服务端错误的原因属于内部细节，总是使用错误码的通用描述
+func (c *Context) errorDescription(responseError string, description string) string {
+	if responseError == E_SERVER_ERROR || (c.config != nil && c.config.HideErrorDetails) {
+		return errorDescriptions[responseError]
+	}
+	return description
+}
+
 func (c *Context) setRedirectFragment(f bool) {
 	c.redirectInFragment = f
 }
@@ -14,3 +14,68 @@ const (
 	E_INVALID_GRANT                    = "invalid_grant"
 	E_INVALID_CLIENT                   = "invalid_client"
 )
+
+// Dynamic client registration errors, https://tools.ietf.org/html/rfc7591#section-3.2.2
+const (
+	E_INVALID_REDIRECT_URI    = "invalid_redirect_uri"
+	E_INVALID_CLIENT_METADATA = "invalid_client_metadata"
+	E_INVALID_TOKEN           = "invalid_token"
+)
+
+// errorDescriptions 错误码的通用描述，隐藏具体原因时使用，https://tools.ietf.org/html/rfc6749#section-5.2
+var errorDescriptions = map[string]string{
+	E_INVALID_REQUEST:           "The request is missing a required parameter, includes an invalid parameter value, or is otherwise malformed.",
+	E_UNAUTHORIZED_CLIENT:       "The client is not authorized to request an authorization code or access token using this method.",
+	E_ACCESS_DENIED:             "The resource owner or authorization server denied the request.",
+	E_UNSUPPORTED_RESPONSE_TYPE: "The authorization server does not support obtaining an authorization code using this method.",
+	E_INVALID_SCOPE:             "The requested scope is invalid, unknown, or malformed.",
+	E_SERVER_ERROR:              "The authorization server encountered an unexpected condition that prevented it from fulfilling the request.",
+	E_TEMPORARILY_UNAVAILABLE:   "The authorization server is currently unable to handle the request due to a temporary overloading or maintenance of the server.",
+	E_UNSUPPORTED_GRANT_TYPE:    "The authorization grant type is not supported by the authorization server.",
+	E_INVALID_GRANT:             "The provided authorization grant or refresh token is invalid, expired, revoked, or was issued to another client.",
+	E_INVALID_CLIENT:            "Client authentication failed.",
+	E_INVALID_REDIRECT_URI:      "The value of one or more redirection URIs is invalid.",
+	E_INVALID_CLIENT_METADATA:   "The value of one of the client metadata fields is invalid.",
+	E_INVALID_TOKEN:             "The access token provided is expired, revoked, malformed, or invalid.",
+}
+
+// Error OAuth2的错误响应，可以通过 Context.GetError 获取，Build 返回的错误可以用 errors.As 匹配
+type Error struct {
+	Code        string // 错误码，输出为error
+	Description string // 错误描述，输出为error_description
+	URI         string // 错误说明页面，输出为error_uri，为空时不输出
+	StatusCode  int    // 建议返回的 HTTP 状态码
+	// WWWAuthenticate 需要设置的 WWW-Authenticate 响应头，token端点客户端认证失败、注册接口token无效时不为空
+	WWWAuthenticate string
+	// Internal 内部错误，只用于日志和 errors.Is 判断，不会输出
+	Internal error
+}
+
+// Error ...
+func (e *Error) Error() string {
+	if e.Description == "" {
+		return e.Code
+	}
+	return e.Code + ": " + e.Description
+}
+
+// Unwrap 返回内部错误
+func (e *Error) Unwrap() error {
+	return e.Internal
+}
+
+// errorStatusCode 错误码对应的 HTTP 状态码，https://tools.ietf.org/html/rfc6749#section-5.2
+func errorStatusCode(code string) int {
+	switch code {
+	case E_INVALID_CLIENT, E_INVALID_TOKEN:
+		return 401
+	case E_ACCESS_DENIED:
+		return 403
+	case E_SERVER_ERROR:
+		return 500
+	case E_TEMPORARILY_UNAVAILABLE:
+		return 503
+	default:
+		return 400
+	}
+}
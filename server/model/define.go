@@ -34,6 +34,7 @@ func (u *ParentTokenData) Unmarshal(content []byte) error {
 type SubTokenData struct {
 	UA       string `msgpack:"ua" json:"ua"`
 	ClientIP string `msgpack:"ip" json:"clientIP"`
+	Uid      int64  `msgpack:"u" json:"uid"` // 多账号下token所属的账号，0表示不指定
 }
 
 func (u SubTokenData) Marshal() []byte {
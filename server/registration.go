@@ -0,0 +1,421 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/ego-component/eoauth2/server/model"
+	"github.com/gotomicro/ego/core/elog"
+)
+
+// Token endpoint authentication methods, https://tools.ietf.org/html/rfc7591#section-2
+const (
+	AUTH_METHOD_NONE                = "none"
+	AUTH_METHOD_CLIENT_SECRET_BASIC = "client_secret_basic"
+	AUTH_METHOD_CLIENT_SECRET_POST  = "client_secret_post"
+
+	GRANT_TYPE_IMPLICIT = "implicit"
+)
+
+// ClientMetadata 客户端元数据，https://tools.ietf.org/html/rfc7591#section-2
+type ClientMetadata struct {
+	RedirectUris            []string        `json:"redirect_uris,omitempty"`
+	TokenEndpointAuthMethod string          `json:"token_endpoint_auth_method,omitempty"`
+	GrantTypes              []string        `json:"grant_types,omitempty"`
+	ResponseTypes           []string        `json:"response_types,omitempty"`
+	ClientName              string          `json:"client_name,omitempty"`
+	ClientUri               string          `json:"client_uri,omitempty"`
+	LogoUri                 string          `json:"logo_uri,omitempty"`
+	Scope                   string          `json:"scope,omitempty"`
+	Contacts                []string        `json:"contacts,omitempty"`
+	TosUri                  string          `json:"tos_uri,omitempty"`
+	PolicyUri               string          `json:"policy_uri,omitempty"`
+	JwksUri                 string          `json:"jwks_uri,omitempty"`
+	Jwks                    json.RawMessage `json:"jwks,omitempty"`
+	SoftwareId              string          `json:"software_id,omitempty"`
+	SoftwareVersion         string          `json:"software_version,omitempty"`
+}
+
+// RegisteredClient 动态注册的客户端信息
+type RegisteredClient struct {
+	ClientId              string
+	ClientSecret          string // 明文密钥，只在注册时返回一次，存储只保存hash
+	ClientIdIssuedAt      int64
+	ClientSecretExpiresAt int64  // 0 表示不过期
+	RedirectUri           string // 按照 RedirectUriSeparator 拼接后的跳转地址，与 Client.GetRedirectUri 一致
+	RegistrationTokenHash string // registration access token 的 sha256，不存储明文
+	Metadata              ClientMetadata
+}
+
+// RegistrationStorage is an optional storage used by dynamic client registration (RFC 7591/7592)
+type RegistrationStorage interface {
+	// ValidateInitialAccessToken returns ErrNotFound if the initial access token is unknown or expired.
+	ValidateInitialAccessToken(ctx context.Context, token string) error
+
+	// CreateRegisteredClient saves a new registered client.
+	CreateRegisteredClient(ctx context.Context, client *RegisteredClient) error
+
+	// GetRegisteredClient loads a registered client by id, returns ErrNotFound if it does not exist.
+	GetRegisteredClient(ctx context.Context, clientId string) (*RegisteredClient, error)
+
+	// UpdateRegisteredClient replaces the metadata and the registration token hash of a registered client.
+	// Secrets must be retired if TokenEndpointAuthMethod becomes "none".
+	UpdateRegisteredClient(ctx context.Context, client *RegisteredClient) error
+
+	// DeleteRegisteredClient deletes a registered client.
+	DeleteRegisteredClient(ctx context.Context, clientId string) error
+}
+
+// ClientRegistrationParam 客户端注册、客户端配置接口的请求参数
+type ClientRegistrationParam struct {
+	Authorization    string // Authorization header，注册时为 initial access token，管理时为 registration access token
+	ClientId         string // client configuration endpoint 中的 client_id
+	BodyClientId     string // 更新请求 body 里的 client_id，必须与 ClientId 一致
+	BodyClientSecret string // 更新请求 body 里的 client_secret，可选，存在时必须与当前密钥一致
+	Metadata         ClientMetadata
+}
+
+// ClientRegistrationRequest 客户端注册、客户端配置接口的处理结果
+type ClientRegistrationRequest struct {
+	Client     *RegisteredClient
+	StatusCode int // 建议返回的 HTTP 状态码
+	*Context
+	config *Config
+}
+
+func (c *Component) newClientRegistrationRequest(ctx context.Context) *ClientRegistrationRequest {
+	return &ClientRegistrationRequest{
+		StatusCode: 200,
+		Context: &Context{
+			Ctx:    ctx,
+			logger: c.logger,
+			output: make(ResponseData),
+			config: c.config,
+		},
+		config: c.config,
+	}
+}
+
+// HandleClientRegistration 处理客户端注册请求，https://tools.ietf.org/html/rfc7591#section-3
+func (c *Component) HandleClientRegistration(ctx context.Context, param ClientRegistrationParam) *ClientRegistrationRequest {
+	ret := c.newClientRegistrationRequest(ctx)
+	if c.config.EnableAccessInterceptor {
+		c.logger.Info("HandleClientRegistration access", elog.FieldCtxTid(ctx), elog.FieldValueAny(param.Metadata))
+	}
+	storage := c.config.registrationStorage
+	if storage == nil {
+		ret.setRegistrationError(500, E_SERVER_ERROR, nil, "HandleClientRegistration", "registration storage not configured")
+		return ret
+	}
+
+	// initial access token 用于限制谁可以注册客户端
+	bearer := CheckBearerAuth(BearerAuthParam{Authorization: param.Authorization})
+	if bearer == nil && !c.config.AllowOpenRegistration {
+		ret.setRegistrationError(401, E_INVALID_TOKEN, nil, "HandleClientRegistration", "initial access token is required")
+		return ret
+	}
+	if bearer != nil {
+		err := storage.ValidateInitialAccessToken(ctx, bearer.Code)
+		if errors.Is(err, ErrNotFound) {
+			ret.setRegistrationError(401, E_INVALID_TOKEN, err, "HandleClientRegistration", "initial access token is invalid")
+			return ret
+		}
+		if err != nil {
+			ret.setRegistrationError(500, E_SERVER_ERROR, err, "HandleClientRegistration", "validate initial access token error")
+			return ret
+		}
+	}
+
+	metadata := param.Metadata
+	if code, err := c.validateClientMetadata(&metadata); err != nil {
+		ret.setRegistrationError(400, code, err, "HandleClientRegistration", err.Error())
+		return ret
+	}
+
+	nowTime := time.Now()
+	client := &RegisteredClient{
+		ClientId:         model.NewToken(0).Token,
+		ClientIdIssuedAt: nowTime.Unix(),
+		RedirectUri:      strings.Join(metadata.RedirectUris, c.config.RedirectUriSeparator),
+		Metadata:         metadata,
+	}
+	// 公共客户端不下发密钥
+	if metadata.TokenEndpointAuthMethod != AUTH_METHOD_NONE {
+		client.ClientSecret = model.NewToken(0).Token + model.NewToken(0).Token
+	}
+	registrationToken := model.NewToken(0).Token
+	client.RegistrationTokenHash = hashRegistrationToken(registrationToken)
+
+	if err := storage.CreateRegisteredClient(ctx, client); err != nil {
+		ret.setRegistrationError(500, E_SERVER_ERROR, err, "HandleClientRegistration", "create registered client error")
+		return ret
+	}
+	ret.Client = client
+	ret.StatusCode = 201
+	ret.setClientOutput(client, registrationToken)
+	return ret
+}
+
+// HandleClientConfigurationRead 读取客户端配置，https://tools.ietf.org/html/rfc7592#section-2.1
+func (c *Component) HandleClientConfigurationRead(ctx context.Context, param ClientRegistrationParam) *ClientRegistrationRequest {
+	ret := c.newClientRegistrationRequest(ctx)
+	client := ret.loadRegisteredClient(ctx, param, "HandleClientConfigurationRead")
+	if client == nil {
+		return ret
+	}
+	ret.Client = client
+	ret.setClientOutput(client, "")
+	return ret
+}
+
+// HandleClientConfigurationUpdate 更新客户端配置，请求中的元数据会整体替换原有元数据，https://tools.ietf.org/html/rfc7592#section-2.2
+func (c *Component) HandleClientConfigurationUpdate(ctx context.Context, param ClientRegistrationParam) *ClientRegistrationRequest {
+	ret := c.newClientRegistrationRequest(ctx)
+	client := ret.loadRegisteredClient(ctx, param, "HandleClientConfigurationUpdate")
+	if client == nil {
+		return ret
+	}
+	if param.BodyClientId != client.ClientId {
+		ret.setRegistrationError(400, E_INVALID_CLIENT_METADATA, nil, "HandleClientConfigurationUpdate", "client_id does not match")
+		return ret
+	}
+	if param.BodyClientSecret != "" {
+		// 密钥只存储了hash，需要通过client校验
+		oauthClient, err := c.config.storage.GetClient(ctx, client.ClientId)
+		if err != nil {
+			ret.setRegistrationError(500, E_SERVER_ERROR, err, "HandleClientConfigurationUpdate", "get client error")
+			return ret
+		}
+		if !CheckClientSecret(oauthClient, param.BodyClientSecret) {
+			ret.setRegistrationError(400, E_INVALID_CLIENT_METADATA, nil, "HandleClientConfigurationUpdate", "client_secret does not match")
+			return ret
+		}
+	}
+
+	metadata := param.Metadata
+	if code, err := c.validateClientMetadata(&metadata); err != nil {
+		ret.setRegistrationError(400, code, err, "HandleClientConfigurationUpdate", err.Error())
+		return ret
+	}
+	// 公共客户端变为机密客户端，需要下发新密钥
+	if client.Metadata.TokenEndpointAuthMethod == AUTH_METHOD_NONE && metadata.TokenEndpointAuthMethod != AUTH_METHOD_NONE {
+		client.ClientSecret = model.NewToken(0).Token + model.NewToken(0).Token
+	}
+	client.Metadata = metadata
+	client.RedirectUri = strings.Join(metadata.RedirectUris, c.config.RedirectUriSeparator)
+	// 每次更新都轮换 registration access token，老 token 立即失效，https://tools.ietf.org/html/rfc7592#section-2.2
+	registrationToken := model.NewToken(0).Token
+	client.RegistrationTokenHash = hashRegistrationToken(registrationToken)
+
+	if err := c.config.registrationStorage.UpdateRegisteredClient(ctx, client); err != nil {
+		ret.setRegistrationError(500, E_SERVER_ERROR, err, "HandleClientConfigurationUpdate", "update registered client error")
+		return ret
+	}
+	ret.Client = client
+	ret.setClientOutput(client, registrationToken)
+	return ret
+}
+
+// HandleClientConfigurationDelete 删除客户端，成功时 StatusCode 为 204，https://tools.ietf.org/html/rfc7592#section-2.3
+func (c *Component) HandleClientConfigurationDelete(ctx context.Context, param ClientRegistrationParam) *ClientRegistrationRequest {
+	ret := c.newClientRegistrationRequest(ctx)
+	client := ret.loadRegisteredClient(ctx, param, "HandleClientConfigurationDelete")
+	if client == nil {
+		return ret
+	}
+	if err := c.config.registrationStorage.DeleteRegisteredClient(ctx, client.ClientId); err != nil {
+		ret.setRegistrationError(500, E_SERVER_ERROR, err, "HandleClientConfigurationDelete", "delete registered client error")
+		return ret
+	}
+	ret.Client = client
+	ret.StatusCode = 204
+	return ret
+}
+
+// loadRegisteredClient 通过 registration access token 校验并加载客户端
+// 客户端不存在或者 token 不匹配，都返回 invalid_token，避免泄露客户端是否存在
+func (r *ClientRegistrationRequest) loadRegisteredClient(ctx context.Context, param ClientRegistrationParam, method string) *RegisteredClient {
+	storage := r.config.registrationStorage
+	if storage == nil {
+		r.setRegistrationError(500, E_SERVER_ERROR, nil, method, "registration storage not configured")
+		return nil
+	}
+	bearer := CheckBearerAuth(BearerAuthParam{Authorization: param.Authorization})
+	if bearer == nil {
+		r.setRegistrationError(401, E_INVALID_TOKEN, nil, method, "registration access token is required")
+		return nil
+	}
+	client, err := storage.GetRegisteredClient(ctx, param.ClientId)
+	if errors.Is(err, ErrNotFound) {
+		r.setRegistrationError(401, E_INVALID_TOKEN, err, method, "client not found")
+		return nil
+	}
+	if err != nil {
+		r.setRegistrationError(500, E_SERVER_ERROR, err, method, "get registered client error")
+		return nil
+	}
+	if client == nil || client.RegistrationTokenHash == "" ||
+		subtle.ConstantTimeCompare([]byte(hashRegistrationToken(bearer.Code)), []byte(client.RegistrationTokenHash)) != 1 {
+		r.setRegistrationError(401, E_INVALID_TOKEN, nil, method, "registration access token is invalid")
+		return nil
+	}
+	return client
+}
+
+func (r *ClientRegistrationRequest) setRegistrationError(statusCode int, responseError string, internalError error, method string, description string) {
+	r.setError(responseError, internalError, method, description)
+	delete(r.output, "state")
+	r.err.StatusCode = statusCode
+	if statusCode == 401 {
+		// https://tools.ietf.org/html/rfc6750#section-3
+		r.err.WWWAuthenticate = `Bearer error="` + responseError + `"`
+	}
+	r.StatusCode = statusCode
+}
+
+// setClientOutput 输出客户端信息，https://tools.ietf.org/html/rfc7591#section-3.2.1
+func (r *ClientRegistrationRequest) setClientOutput(client *RegisteredClient, registrationToken string) {
+	metadataBytes, _ := json.Marshal(client.Metadata)
+	_ = json.Unmarshal(metadataBytes, &r.output)
+
+	r.SetOutput("client_id", client.ClientId)
+	r.SetOutput("client_id_issued_at", client.ClientIdIssuedAt)
+	if client.ClientSecret != "" {
+		r.SetOutput("client_secret", client.ClientSecret)
+		r.SetOutput("client_secret_expires_at", client.ClientSecretExpiresAt)
+	}
+	if registrationToken != "" {
+		r.SetOutput("registration_access_token", registrationToken)
+	}
+	if r.config.RegistrationClientUri != "" {
+		r.SetOutput("registration_client_uri", strings.TrimRight(r.config.RegistrationClientUri, "/")+"/"+url.PathEscape(client.ClientId))
+	}
+}
+
+// validateClientMetadata 校验客户端元数据，并补全默认值，返回错误码和错误
+func (c *Component) validateClientMetadata(metadata *ClientMetadata) (string, error) {
+	// 默认值，https://tools.ietf.org/html/rfc7591#section-2
+	if metadata.TokenEndpointAuthMethod == "" {
+		metadata.TokenEndpointAuthMethod = AUTH_METHOD_CLIENT_SECRET_BASIC
+	}
+	if len(metadata.GrantTypes) == 0 {
+		metadata.GrantTypes = []string{string(AUTHORIZATION_CODE)}
+	}
+	if len(metadata.ResponseTypes) == 0 {
+		metadata.ResponseTypes = []string{string(CODE)}
+	}
+
+	switch metadata.TokenEndpointAuthMethod {
+	case AUTH_METHOD_NONE, AUTH_METHOD_CLIENT_SECRET_BASIC, AUTH_METHOD_CLIENT_SECRET_POST:
+	default:
+		return E_INVALID_CLIENT_METADATA, fmt.Errorf("token_endpoint_auth_method %q not supported", metadata.TokenEndpointAuthMethod)
+	}
+	if metadata.TokenEndpointAuthMethod == AUTH_METHOD_CLIENT_SECRET_POST && !c.config.AllowClientSecretInParams {
+		return E_INVALID_CLIENT_METADATA, fmt.Errorf("token_endpoint_auth_method %q not allowed", metadata.TokenEndpointAuthMethod)
+	}
+
+	grantTypes := make(map[string]bool)
+	for _, grantType := range metadata.GrantTypes {
+		if grantType == GRANT_TYPE_IMPLICIT {
+			if !c.config.AllowedAuthorizeTypes.Exists(TOKEN) {
+				return E_INVALID_CLIENT_METADATA, fmt.Errorf("grant_type %q not allowed", grantType)
+			}
+		} else if !c.config.AllowedAccessTypes.Exists(AccessRequestType(grantType)) {
+			return E_INVALID_CLIENT_METADATA, fmt.Errorf("grant_type %q not allowed", grantType)
+		}
+		grantTypes[grantType] = true
+	}
+
+	// response_types 和 grant_types 必须一致，https://tools.ietf.org/html/rfc7591#section-2.1
+	for _, responseType := range metadata.ResponseTypes {
+		if !c.config.AllowedAuthorizeTypes.Exists(AuthorizeRequestType(responseType)) {
+			return E_INVALID_CLIENT_METADATA, fmt.Errorf("response_type %q not allowed", responseType)
+		}
+		if responseType == string(CODE) && !grantTypes[string(AUTHORIZATION_CODE)] {
+			return E_INVALID_CLIENT_METADATA, errors.New("response_type code requires grant_type authorization_code")
+		}
+		if responseType == string(TOKEN) && !grantTypes[GRANT_TYPE_IMPLICIT] {
+			return E_INVALID_CLIENT_METADATA, errors.New("response_type token requires grant_type implicit")
+		}
+	}
+
+	if grantTypes[string(AUTHORIZATION_CODE)] || grantTypes[GRANT_TYPE_IMPLICIT] {
+		if err := c.validateRedirectUris(metadata.RedirectUris); err != nil {
+			return E_INVALID_REDIRECT_URI, err
+		}
+	}
+
+	if err := validateJwks(metadata); err != nil {
+		return E_INVALID_CLIENT_METADATA, err
+	}
+	return "", nil
+}
+
+func (c *Component) validateRedirectUris(redirectUris []string) error {
+	if len(redirectUris) == 0 {
+		return errors.New("redirect_uris is required")
+	}
+	if len(redirectUris) > 1 && c.config.RedirectUriSeparator == "" {
+		return errors.New("multiple redirect_uris are not allowed")
+	}
+	for _, redirectUri := range redirectUris {
+		u, err := url.Parse(redirectUri)
+		if err != nil {
+			return fmt.Errorf("redirect_uri %q invalid, err: %w", redirectUri, err)
+		}
+		if u.Scheme == "" || u.Host == "" {
+			return fmt.Errorf("redirect_uri %q must be absolute", redirectUri)
+		}
+		if u.Fragment != "" {
+			return fmt.Errorf("redirect_uri %q must not include fragment", redirectUri)
+		}
+		if c.config.RedirectUriSeparator != "" && strings.Contains(redirectUri, c.config.RedirectUriSeparator) {
+			return fmt.Errorf("redirect_uri %q must not include separator", redirectUri)
+		}
+	}
+	return nil
+}
+
+// validateJwks jwks 和 jwks_uri 不能同时存在，https://tools.ietf.org/html/rfc7591#section-2
+func validateJwks(metadata *ClientMetadata) error {
+	if metadata.JwksUri != "" && len(metadata.Jwks) > 0 {
+		return errors.New("jwks and jwks_uri must not both be present")
+	}
+	if metadata.JwksUri != "" {
+		u, err := url.Parse(metadata.JwksUri)
+		if err != nil || u.Scheme != "https" || u.Host == "" {
+			return fmt.Errorf("jwks_uri %q must be an absolute https uri", metadata.JwksUri)
+		}
+	}
+	if len(metadata.Jwks) == 0 {
+		return nil
+	}
+	jwks := struct {
+		Keys []map[string]interface{} `json:"keys"`
+	}{}
+	if err := json.Unmarshal(metadata.Jwks, &jwks); err != nil {
+		return fmt.Errorf("jwks invalid, err: %w", err)
+	}
+	if len(jwks.Keys) == 0 {
+		return errors.New("jwks must contain at least one key")
+	}
+	for _, key := range jwks.Keys {
+		if kty, _ := key["kty"].(string); kty == "" {
+			return errors.New("jwks key must have kty")
+		}
+	}
+	return nil
+}
+
+func hashRegistrationToken(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
+}
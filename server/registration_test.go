@@ -0,0 +1,259 @@
+package server_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ego-component/eoauth2/server"
+	"github.com/ego-component/eoauth2/storage/memstorage"
+	"github.com/gotomicro/ego/core/econf"
+)
+
+// newRegistrationTestServer 使用内存存储创建支持动态注册客户端的server
+func newRegistrationTestServer(t *testing.T, name string, config map[string]interface{}) (*server.Component, *memstorage.Storage) {
+	t.Helper()
+	econf.Set(name, config)
+	mem := memstorage.NewStorage(memstorage.WithCleanupInterval(0))
+	t.Cleanup(mem.Close)
+	return server.Load(name).Build(server.WithStorage(mem), server.WithRegistrationStorage(mem)), mem
+}
+
+// registerClient 开放注册一个客户端，返回注册结果
+func registerClient(t *testing.T, srv *server.Component, metadata server.ClientMetadata) *server.ClientRegistrationRequest {
+	t.Helper()
+	rr := srv.HandleClientRegistration(context.Background(), server.ClientRegistrationParam{Metadata: metadata})
+	if rr.IsError() {
+		t.Fatalf("register client failed, err: %v", rr.GetError())
+	}
+	if rr.StatusCode != 201 {
+		t.Fatalf("register status code, want: 201, got: %d", rr.StatusCode)
+	}
+	return rr
+}
+
+func bearer(token string) string {
+	return "Bearer " + token
+}
+
+// assertRegistrationError 校验注册接口返回的错误码和状态码
+func assertRegistrationError(t *testing.T, rr *server.ClientRegistrationRequest, statusCode int, code string) {
+	t.Helper()
+	if !rr.IsError() {
+		t.Fatalf("request should fail with %s", code)
+	}
+	if rr.StatusCode != statusCode || rr.GetError().StatusCode != statusCode {
+		t.Errorf("status code, want: %d, got: %d %d", statusCode, rr.StatusCode, rr.GetError().StatusCode)
+	}
+	if rr.GetError().Code != code {
+		t.Errorf("error code, want: %s, got: %s", code, rr.GetError().Code)
+	}
+	if statusCode == 401 && rr.GetError().WWWAuthenticate == "" {
+		t.Error("401 should set WWW-Authenticate")
+	}
+}
+
+// TestClientRegistrationInvalidMetadata 跳转地址、授权类型、认证方式不合法时拒绝注册
+func TestClientRegistrationInvalidMetadata(t *testing.T) {
+	srv, _ := newRegistrationTestServer(t, "oauth2registrationsecretpost", map[string]interface{}{
+		"AllowOpenRegistration":     true,
+		"AllowClientSecretInParams": false,
+	})
+	for name, c := range map[string]struct {
+		metadata server.ClientMetadata
+		code     string
+	}{
+		"missing redirect_uris": {
+			metadata: server.ClientMetadata{},
+			code:     server.E_INVALID_REDIRECT_URI,
+		},
+		"relative redirect_uri": {
+			metadata: server.ClientMetadata{RedirectUris: []string{"/callback"}},
+			code:     server.E_INVALID_REDIRECT_URI,
+		},
+		"redirect_uri with fragment": {
+			metadata: server.ClientMetadata{RedirectUris: []string{"https://client.example/cb#frag"}},
+			code:     server.E_INVALID_REDIRECT_URI,
+		},
+		"multiple redirect_uris without separator": {
+			metadata: server.ClientMetadata{RedirectUris: []string{"https://client.example/a", "https://client.example/b"}},
+			code:     server.E_INVALID_REDIRECT_URI,
+		},
+		"grant_type not allowed": {
+			metadata: server.ClientMetadata{RedirectUris: []string{"https://client.example/cb"}, GrantTypes: []string{string(server.AUTHORIZATION_CODE), string(server.CLIENT_CREDENTIALS)}},
+			code:     server.E_INVALID_CLIENT_METADATA,
+		},
+		"implicit not allowed": {
+			metadata: server.ClientMetadata{RedirectUris: []string{"https://client.example/cb"}, GrantTypes: []string{"implicit"}, ResponseTypes: []string{string(server.TOKEN)}},
+			code:     server.E_INVALID_CLIENT_METADATA,
+		},
+		"response_type without grant_type": {
+			metadata: server.ClientMetadata{RedirectUris: []string{"https://client.example/cb"}, GrantTypes: []string{string(server.REFRESH_TOKEN)}, ResponseTypes: []string{string(server.CODE)}},
+			code:     server.E_INVALID_CLIENT_METADATA,
+		},
+		"auth method not supported": {
+			metadata: server.ClientMetadata{RedirectUris: []string{"https://client.example/cb"}, TokenEndpointAuthMethod: "private_key_jwt"},
+			code:     server.E_INVALID_CLIENT_METADATA,
+		},
+		"client_secret_post not allowed": {
+			metadata: server.ClientMetadata{RedirectUris: []string{"https://client.example/cb"}, TokenEndpointAuthMethod: server.AUTH_METHOD_CLIENT_SECRET_POST},
+			code:     server.E_INVALID_CLIENT_METADATA,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			rr := srv.HandleClientRegistration(context.Background(), server.ClientRegistrationParam{Metadata: c.metadata})
+			assertRegistrationError(t, rr, 400, c.code)
+			if rr.Client != nil {
+				t.Error("client should not be registered")
+			}
+		})
+	}
+}
+
+// TestClientRegistrationInitialAccessToken 关闭开放注册时，必须持有有效的initial access token
+func TestClientRegistrationInitialAccessToken(t *testing.T) {
+	srv, mem := newRegistrationTestServer(t, "oauth2registrationiat", map[string]interface{}{})
+	ctx := context.Background()
+	metadata := server.ClientMetadata{RedirectUris: []string{"https://client.example/cb"}}
+
+	for name, authorization := range map[string]string{
+		"missing": "",
+		"wrong":   bearer("wrong-token"),
+		"basic":   "Basic d3Jvbmc6d3Jvbmc=",
+	} {
+		t.Run(name, func(t *testing.T) {
+			rr := srv.HandleClientRegistration(ctx, server.ClientRegistrationParam{Authorization: authorization, Metadata: metadata})
+			assertRegistrationError(t, rr, 401, server.E_INVALID_TOKEN)
+		})
+	}
+
+	token, err := mem.CreateInitialAccessToken(ctx, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := srv.HandleClientRegistration(ctx, server.ClientRegistrationParam{Authorization: bearer(token), Metadata: metadata})
+	if rr.IsError() || rr.StatusCode != 201 {
+		t.Fatalf("register with initial access token failed, status: %d, err: %v", rr.StatusCode, rr.GetError())
+	}
+}
+
+// TestClientConfigurationToken registration access token缺失、错误、属于其他客户端时返回401
+func TestClientConfigurationToken(t *testing.T) {
+	srv, _ := newRegistrationTestServer(t, "oauth2registration", map[string]interface{}{"AllowOpenRegistration": true})
+	ctx := context.Background()
+	rrA := registerClient(t, srv, server.ClientMetadata{RedirectUris: []string{"https://a.example/cb"}})
+	rrB := registerClient(t, srv, server.ClientMetadata{RedirectUris: []string{"https://b.example/cb"}})
+	clientId := rrA.GetOutput("client_id").(string)
+	tokenA := rrA.GetOutput("registration_access_token").(string)
+	tokenB := rrB.GetOutput("registration_access_token").(string)
+
+	for name, param := range map[string]server.ClientRegistrationParam{
+		"missing":        {ClientId: clientId},
+		"wrong":          {ClientId: clientId, Authorization: bearer("wrong-token")},
+		"other client":   {ClientId: clientId, Authorization: bearer(tokenB)},
+		"unknown client": {ClientId: "client-missing", Authorization: bearer(tokenA)},
+	} {
+		t.Run(name, func(t *testing.T) {
+			assertRegistrationError(t, srv.HandleClientConfigurationRead(ctx, param), 401, server.E_INVALID_TOKEN)
+			param.BodyClientId = param.ClientId
+			param.Metadata = server.ClientMetadata{RedirectUris: []string{"https://attacker.example/cb"}}
+			assertRegistrationError(t, srv.HandleClientConfigurationUpdate(ctx, param), 401, server.E_INVALID_TOKEN)
+			assertRegistrationError(t, srv.HandleClientConfigurationDelete(ctx, param), 401, server.E_INVALID_TOKEN)
+		})
+	}
+
+	rr := srv.HandleClientConfigurationRead(ctx, server.ClientRegistrationParam{ClientId: clientId, Authorization: bearer(tokenA)})
+	if rr.IsError() || rr.StatusCode != 200 {
+		t.Fatalf("read client failed, status: %d, err: %v", rr.StatusCode, rr.GetError())
+	}
+	if rr.GetOutput("client_id") != clientId {
+		t.Errorf("client_id, want: %s, got: %v", clientId, rr.GetOutput("client_id"))
+	}
+	if rr.GetOutput("client_secret") != nil || rr.GetOutput("registration_access_token") != nil {
+		t.Error("read should not return client_secret or registration_access_token")
+	}
+	if got := rr.Client.RedirectUri; got != "https://a.example/cb" {
+		t.Errorf("redirect uri should not be changed, got: %s", got)
+	}
+}
+
+// TestClientConfigurationUpdate 更新后轮换registration access token，老token失效，客户端密钥保持不变
+func TestClientConfigurationUpdate(t *testing.T) {
+	srv, _ := newRegistrationTestServer(t, "oauth2registration", map[string]interface{}{"AllowOpenRegistration": true})
+	ctx := context.Background()
+	registered := registerClient(t, srv, server.ClientMetadata{RedirectUris: []string{"https://a.example/cb"}, ClientName: "a"})
+	clientId := registered.GetOutput("client_id").(string)
+	secret := registered.GetOutput("client_secret").(string)
+	oldToken := registered.GetOutput("registration_access_token").(string)
+
+	rr := srv.HandleClientConfigurationUpdate(ctx, server.ClientRegistrationParam{
+		ClientId:      clientId,
+		Authorization: bearer(oldToken),
+		BodyClientId:  "client-other",
+		Metadata:      server.ClientMetadata{RedirectUris: []string{"https://a.example/new"}},
+	})
+	assertRegistrationError(t, rr, 400, server.E_INVALID_CLIENT_METADATA)
+
+	rr = srv.HandleClientConfigurationUpdate(ctx, server.ClientRegistrationParam{
+		ClientId:         clientId,
+		Authorization:    bearer(oldToken),
+		BodyClientId:     clientId,
+		BodyClientSecret: "wrong-secret",
+		Metadata:         server.ClientMetadata{RedirectUris: []string{"https://a.example/new"}},
+	})
+	assertRegistrationError(t, rr, 400, server.E_INVALID_CLIENT_METADATA)
+
+	rr = srv.HandleClientConfigurationUpdate(ctx, server.ClientRegistrationParam{
+		ClientId:         clientId,
+		Authorization:    bearer(oldToken),
+		BodyClientId:     clientId,
+		BodyClientSecret: secret,
+		Metadata:         server.ClientMetadata{RedirectUris: []string{"https://a.example/new"}, ClientName: "a2"},
+	})
+	if rr.IsError() || rr.StatusCode != 200 {
+		t.Fatalf("update client failed, status: %d, err: %v", rr.StatusCode, rr.GetError())
+	}
+	newToken, _ := rr.GetOutput("registration_access_token").(string)
+	if newToken == "" || newToken == oldToken {
+		t.Fatalf("registration access token should be rotated, old: %s, new: %s", oldToken, newToken)
+	}
+
+	rr = srv.HandleClientConfigurationRead(ctx, server.ClientRegistrationParam{ClientId: clientId, Authorization: bearer(oldToken)})
+	assertRegistrationError(t, rr, 401, server.E_INVALID_TOKEN)
+	rr = srv.HandleClientConfigurationRead(ctx, server.ClientRegistrationParam{ClientId: clientId, Authorization: bearer(newToken)})
+	if rr.IsError() {
+		t.Fatalf("read with new token failed, err: %v", rr.GetError())
+	}
+	if rr.Client.RedirectUri != "https://a.example/new" || rr.Client.Metadata.ClientName != "a2" {
+		t.Errorf("metadata not updated, got: %s %s", rr.Client.RedirectUri, rr.Client.Metadata.ClientName)
+	}
+	// 更新元数据不影响客户端密钥
+	issueAccess(t, srv, clientId, secret)
+}
+
+// TestClientConfigurationDelete 删除后客户端不能再登录，registration access token失效
+func TestClientConfigurationDelete(t *testing.T) {
+	srv, mem := newRegistrationTestServer(t, "oauth2registration", map[string]interface{}{"AllowOpenRegistration": true})
+	ctx := context.Background()
+	registered := registerClient(t, srv, server.ClientMetadata{RedirectUris: []string{"https://a.example/cb"}})
+	clientId := registered.GetOutput("client_id").(string)
+	secret := registered.GetOutput("client_secret").(string)
+	token := registered.GetOutput("registration_access_token").(string)
+	issueAccess(t, srv, clientId, secret)
+
+	rr := srv.HandleClientConfigurationDelete(ctx, server.ClientRegistrationParam{ClientId: clientId, Authorization: bearer(token)})
+	if rr.IsError() || rr.StatusCode != 204 {
+		t.Fatalf("delete client failed, status: %d, err: %v", rr.StatusCode, rr.GetError())
+	}
+
+	if _, err := mem.GetClient(ctx, clientId); !errors.Is(err, server.ErrNotFound) {
+		t.Errorf("GetClient after delete, want ErrNotFound, got: %v", err)
+	}
+	rr = srv.HandleClientConfigurationRead(ctx, server.ClientRegistrationParam{ClientId: clientId, Authorization: bearer(token)})
+	assertRegistrationError(t, rr, 401, server.E_INVALID_TOKEN)
+	ar := srv.HandleAuthorizeRequest(ctx, server.AuthorizeRequestParam{ClientId: clientId, ResponseType: string(server.CODE)})
+	if !ar.IsError() || ar.GetError().Code != server.E_UNAUTHORIZED_CLIENT {
+		t.Errorf("authorize after delete should fail with %s, got: %v", server.E_UNAUTHORIZED_CLIENT, ar.GetError())
+	}
+}
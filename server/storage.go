@@ -11,6 +11,14 @@ var (
 	// client is not found. All other returned errors must be treated as storage-specific errors,
 	// like "connection lost", "connection refused", etc.
 	ErrNotFound = errors.New("Entity not found")
+	// ErrClientSuspended is the error returned by Storage.GetClient when the client exists
+	// but has been suspended by an administrator. Requests of the client are rejected
+	// as unauthorized_client.
+	ErrClientSuspended = errors.New("Client suspended")
+	// ErrSessionLimitExceeded is the error returned by Storage.SaveAuthorize when the user
+	// already has the maximum number of sessions allowed by the storage's session policy.
+	// The login is rejected as access_denied.
+	ErrSessionLimitExceeded = errors.New("Session limit exceeded")
 )
 
 // Storage interface
@@ -25,6 +33,7 @@ type Storage interface {
 	Close()
 
 	// GetClient loads the client by id (client_id)
+	// Returns ErrNotFound if the client does not exist or is deleted, ErrClientSuspended if it is suspended.
 	GetClient(ctx context.Context, id string) (Client, error)
 
 	// SaveAuthorize saves authorize data.
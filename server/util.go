@@ -1,6 +1,7 @@
 package server
 
 import (
+	"crypto/subtle"
 	"encoding/base64"
 	"errors"
 	"net/url"
@@ -27,7 +28,7 @@ func CheckClientSecret(client Client, secret string) bool {
 		return client.ClientSecretMatches(secret)
 	default:
 		// Fallback to the less secure method of extracting the plain text secret from the client for comparison
-		return client.GetSecret() == secret
+		return subtle.ConstantTimeCompare([]byte(client.GetSecret()), []byte(secret)) == 1
 	}
 }
 
@@ -70,3 +71,19 @@ func CheckBasicAuth(param BasicAuthParam) (*BasicAuth, error) {
 
 	return &BasicAuth{Username: username, Password: password}, nil
 }
+
+type BearerAuthParam struct {
+	Authorization string
+}
+
+// CheckBearerAuth returns bearer authorization header data
+func CheckBearerAuth(param BearerAuthParam) *BearerAuth {
+	if param.Authorization == "" {
+		return nil
+	}
+	s := strings.SplitN(param.Authorization, " ", 2)
+	if len(s) != 2 || !strings.EqualFold(s[0], "Bearer") || s[1] == "" {
+		return nil
+	}
+	return &BearerAuth{Code: s[1]}
+}
@@ -0,0 +1,83 @@
+package admin
+
+import (
+	"context"
+
+	"github.com/ego-component/eoauth2/storage/dao"
+)
+
+// ClientManager 客户端管理接口，ssostorage.API 和 sqlstorage 都实现了该接口，用于后台管理
+type ClientManager interface {
+	// CreateClient 创建客户端，app.Secret 为明文密钥，只保存hash
+	CreateClient(ctx context.Context, app *dao.App) error
+	// UpdateClient 更新客户端，updates 里有 secret 时会替换所有密钥
+	UpdateClient(ctx context.Context, clientId string, updates map[string]interface{}) error
+	// DeleteClient 删除客户端
+	DeleteClient(ctx context.Context, clientId string) error
+	// SuspendClient 暂停客户端，revokeTokens 为 true 时吊销客户端所有 token，返回吊销的个数
+	SuspendClient(ctx context.Context, clientId string, revokeTokens bool) (int, error)
+	// ResumeClient 恢复被暂停的客户端
+	ResumeClient(ctx context.Context, clientId string) error
+	// GenerateClientSecret 生成新密钥，返回的明文只有这一次可以拿到，老密钥在退役前仍然有效
+	GenerateClientSecret(ctx context.Context, clientId string, expiresAt int64) (string, *dao.AppSecret, error)
+	// RetireClientSecret 退役某个密钥，不能退役最后一个有效密钥，返回 ErrLastClientSecret
+	RetireClientSecret(ctx context.Context, clientId string, secretId int) error
+	// ListClientSecrets 查询未退役的密钥，不包含hash
+	ListClientSecrets(ctx context.Context, clientId string) ([]dao.AppSecret, error)
+	// GetClientDetail 查询客户端详情，客户端不存在返回 server.ErrNotFound
+	GetClientDetail(ctx context.Context, clientId string) (*Client, error)
+	// ListClients 按条件查询客户端列表，使用游标分页
+	ListClients(ctx context.Context, req ListClientsRequest) (*ListClientsResponse, error)
+}
+
+// Client 后台展示的客户端信息，不包含密钥
+type Client struct {
+	Aid         int    `json:"aid"`
+	Name        string `json:"name"`
+	ClientId    string `json:"clientId"`
+	RedirectUri string `json:"redirectUri"` // 跳转地址，多个地址按照 RedirectUriSeparator 拼接
+	Url         string `json:"url"`
+	CntCall     int    `json:"cntCall"` // 调用次数
+	Status      int    `json:"status"`
+	Ctime       int64  `json:"ctime"`
+	Utime       int64  `json:"utime"`
+	Dtime       int64  `json:"dtime"`
+}
+
+func newClient(app dao.App) *Client {
+	status := app.Status
+	// 老数据的状态为0，按照active展示
+	if status == 0 {
+		status = dao.AppStatusActive
+	}
+	return &Client{
+		Aid:         app.Aid,
+		Name:        app.Name,
+		ClientId:    app.ClientId,
+		RedirectUri: app.RedirectUri,
+		Url:         app.Url,
+		CntCall:     app.CntCall,
+		Status:      status,
+		Ctime:       app.Ctime,
+		Utime:       app.Utime,
+		Dtime:       app.Dtime,
+	}
+}
+
+// ListClientsRequest 客户端列表查询条件，空值表示不过滤
+type ListClientsRequest struct {
+	Name           string // 名称，模糊匹配
+	ClientId       string // 客户端ID，前缀匹配
+	Statuses       []int  // 状态
+	CtimeStart     int64  // 创建时间，大于等于
+	CtimeEnd       int64  // 创建时间，小于
+	IncludeDeleted bool   // 是否包含已删除的客户端
+	Cursor         string // 上一页返回的 NextCursor，第一页为空
+	Limit          int    // 每页个数，默认20，最大100
+}
+
+// ListClientsResponse 客户端列表
+type ListClientsResponse struct {
+	List       []*Client `json:"list"`
+	NextCursor string    `json:"nextCursor"` // 为空表示没有下一页
+}
@@ -0,0 +1,255 @@
+package admin
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/ego-component/eoauth2/server"
+	"github.com/ego-component/eoauth2/storage/dao"
+	"github.com/pborman/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultListLimit = 20
+	maxListLimit     = 100
+)
+
+// CreateClient 创建客户端，app.Secret 为明文密钥，只保存hash到app_secret表
+func CreateClient(db *gorm.DB, app *dao.App) (err error) {
+	if app.Status == 0 {
+		app.Status = dao.AppStatusActive
+	}
+	secret := app.Secret
+	app.Secret = ""
+	defer func() {
+		app.Secret = secret
+	}()
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := dao.CreateApp(tx, app); err != nil {
+			return err
+		}
+		if secret == "" {
+			return nil
+		}
+		_, err := CreateClientSecret(tx, app.ClientId, secret, 0)
+		return err
+	})
+}
+
+// UpdateClient 更新客户端
+// 如果updates里有secret，那么会退役所有老密钥，并保存新密钥的hash；secret为空表示变为公共客户端
+func UpdateClient(db *gorm.DB, clientId string, updates map[string]interface{}) (err error) {
+	secret, hasSecret := updates["secret"]
+	if hasSecret {
+		delete(updates, "secret")
+	}
+	return db.Transaction(func(tx *gorm.DB) error {
+		if len(updates) > 0 {
+			if err := dao.UpdateApp(tx, clientId, updates); err != nil {
+				return err
+			}
+		}
+		if !hasSecret {
+			return nil
+		}
+		if err := dao.RetireAppSecretsByClientId(tx, clientId); err != nil {
+			return err
+		}
+		if err := dao.UpdateApp(tx, clientId, map[string]interface{}{"secret": ""}); err != nil {
+			return err
+		}
+		if secretStr, _ := secret.(string); secretStr != "" {
+			_, err := CreateClientSecret(tx, clientId, secretStr, 0)
+			return err
+		}
+		return nil
+	})
+}
+
+// CreateClientSecret 保存客户端密钥的hash
+func CreateClientSecret(db *gorm.DB, clientId string, secret string, expiresAt int64) (info *dao.AppSecret, err error) {
+	hash, err := server.HashClientSecret(secret)
+	if err != nil {
+		return nil, fmt.Errorf("hash client secret failed, err: %w", err)
+	}
+	info = &dao.AppSecret{
+		ClientId:  clientId,
+		Hash:      hash,
+		ExpiresAt: expiresAt,
+	}
+	err = dao.CreateAppSecret(db, info)
+	if err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+// ErrLastClientSecret 退役客户端最后一个有效密钥时返回，退役之后机密客户端没有密钥可用
+var ErrLastClientSecret = errors.New("cannot retire the last active client secret")
+
+// GenerateClientSecret 为客户端生成一个新密钥，返回的明文只有这一次可以拿到
+func GenerateClientSecret(db *gorm.DB, clientId string, expiresAt int64) (secret string, info *dao.AppSecret, err error) {
+	_, err = dao.GetAppInfoByClientId(db, clientId)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return "", nil, fmt.Errorf("client not found, err: %w", server.ErrNotFound)
+	}
+	if err != nil {
+		return "", nil, err
+	}
+	secret = base64.RawURLEncoding.EncodeToString(append(uuid.NewRandom(), uuid.NewRandom()...))
+	info, err = CreateClientSecret(db, clientId, secret, expiresAt)
+	if err != nil {
+		return "", nil, err
+	}
+	return secret, info, nil
+}
+
+// RetireClientSecret 退役客户端的某个密钥，退役后立即失效
+// 退役之后没有其他未过期的密钥、也没有明文密钥时返回 ErrLastClientSecret，需要先生成新密钥
+func RetireClientSecret(db *gorm.DB, clientId string, secretId int) (err error) {
+	return db.Transaction(func(tx *gorm.DB) error {
+		app, err := dao.GetAppInfoByClientId(tx, clientId)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return fmt.Errorf("client not found, err: %w", server.ErrNotFound)
+		}
+		if err != nil {
+			return err
+		}
+		secrets, err := dao.ListAppSecretsByClientId(tx, clientId)
+		if err != nil {
+			return err
+		}
+		found := false
+		active := 0
+		nowTime := time.Now().Unix()
+		for _, value := range secrets {
+			if value.Id == secretId {
+				found = true
+				continue
+			}
+			if value.ExpiresAt == 0 || value.ExpiresAt > nowTime {
+				active++
+			}
+		}
+		if !found {
+			return fmt.Errorf("client secret not found, err: %w", server.ErrNotFound)
+		}
+		if active == 0 && app.Secret == "" {
+			return ErrLastClientSecret
+		}
+		return dao.RetireAppSecret(tx, clientId, secretId)
+	})
+}
+
+// GetClientDetail 查询客户端详情
+func GetClientDetail(db *gorm.DB, clientId string) (*Client, error) {
+	app, err := dao.GetAppInfoByClientId(db, clientId)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, fmt.Errorf("client not found, err: %w", server.ErrNotFound)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return newClient(app), nil
+}
+
+// ListClients 按条件查询客户端列表，按照aid倒序，使用aid作为游标分页
+func ListClients(db *gorm.DB, req ListClientsRequest) (*ListClientsResponse, error) {
+	limit := req.Limit
+	if limit <= 0 {
+		limit = defaultListLimit
+	}
+	if limit > maxListLimit {
+		limit = maxListLimit
+	}
+
+	query := db.Model(dao.App{})
+	if req.Name != "" {
+		query = query.Where("name like ? escape ?", "%"+escapeLike(req.Name)+"%", likeEscape)
+	}
+	if req.ClientId != "" {
+		query = query.Where("client_id like ? escape ?", escapeLike(req.ClientId)+"%", likeEscape)
+	}
+	if len(req.Statuses) > 0 {
+		// 复制一份，append不能写到调用方的底层数组里
+		statuses := append(make([]int, 0, len(req.Statuses)+1), req.Statuses...)
+		// 老数据的状态为0，按照active处理
+		for _, status := range req.Statuses {
+			if status == dao.AppStatusActive {
+				statuses = append(statuses, 0)
+				break
+			}
+		}
+		query = query.Where("status in ?", statuses)
+	}
+	if req.CtimeStart > 0 {
+		query = query.Where("ctime >= ?", req.CtimeStart)
+	}
+	if req.CtimeEnd > 0 {
+		query = query.Where("ctime < ?", req.CtimeEnd)
+	}
+	if !req.IncludeDeleted {
+		query = query.Where("dtime = 0")
+	}
+	if req.Cursor != "" {
+		aid, err := decodeCursor(req.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		query = query.Where("aid < ?", aid)
+	}
+
+	// 多查一条，用于判断是否有下一页
+	apps := make([]dao.App, 0)
+	err := query.Order("aid desc").Limit(limit + 1).Find(&apps).Error
+	if err != nil {
+		return nil, fmt.Errorf("ListClients failed, err: %w", err)
+	}
+	resp := &ListClientsResponse{
+		List: make([]*Client, 0, limit),
+	}
+	for i, app := range apps {
+		if i == limit {
+			resp.NextCursor = encodeCursor(apps[i-1].Aid)
+			break
+		}
+		resp.List = append(resp.List, newClient(app))
+	}
+	return resp, nil
+}
+
+func encodeCursor(aid int) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.Itoa(aid)))
+}
+
+func decodeCursor(cursor string) (int, error) {
+	bytes, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, fmt.Errorf("cursor invalid, err: %w", err)
+	}
+	aid, err := strconv.Atoi(string(bytes))
+	if err != nil {
+		return 0, fmt.Errorf("cursor invalid, err: %w", err)
+	}
+	return aid, nil
+}
+
+// likeEscape like的转义字符，SQLite没有默认的转义字符，需要显式的 escape
+// 转义字符作为参数传入，避免反斜杠在MySQL、PostgreSQL字符串字面量里的含义不同
+const likeEscape = `\`
+
+// escapeLike 转义like里的通配符
+func escapeLike(value string) string {
+	var out []rune
+	for _, r := range value {
+		if r == '%' || r == '_' || r == '\\' {
+			out = append(out, '\\')
+		}
+		out = append(out, r)
+	}
+	return string(out)
+}
@@ -0,0 +1,140 @@
+package admin
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/ego-component/eoauth2/storage/dao"
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+)
+
+// newTestDB 使用sqlite内存数据库，写入测试用的客户端，aid按照apps的顺序从1开始
+func newTestDB(t *testing.T, apps ...dao.App) *gorm.DB {
+	db, err := gorm.Open(sqlite.Open("file:"+t.Name()+"?mode=memory&cache=shared"), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB.SetMaxOpenConns(1)
+	t.Cleanup(func() { _ = sqlDB.Close() })
+	if err = db.AutoMigrate(&dao.App{}); err != nil {
+		t.Fatal(err)
+	}
+	for i := range apps {
+		if err = db.Create(&apps[i]).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	return db
+}
+
+// listClientIds 查询客户端列表，返回client id
+func listClientIds(t *testing.T, db *gorm.DB, req ListClientsRequest) ([]string, string) {
+	t.Helper()
+	resp, err := ListClients(db, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := make([]string, 0, len(resp.List))
+	for _, client := range resp.List {
+		ids = append(ids, client.ClientId)
+	}
+	return ids, resp.NextCursor
+}
+
+func TestListClientsCursor(t *testing.T) {
+	db := newTestDB(t,
+		dao.App{ClientId: "c1", Status: dao.AppStatusActive},
+		dao.App{ClientId: "c2", Status: dao.AppStatusActive},
+		dao.App{ClientId: "c3", Status: dao.AppStatusActive},
+		dao.App{ClientId: "c4", Status: dao.AppStatusActive},
+		dao.App{ClientId: "c5", Status: dao.AppStatusActive},
+	)
+	var pages [][]string
+	cursor := ""
+	for {
+		ids, next := listClientIds(t, db, ListClientsRequest{Limit: 2, Cursor: cursor})
+		pages = append(pages, ids)
+		if next == "" {
+			break
+		}
+		cursor = next
+	}
+	want := [][]string{{"c5", "c4"}, {"c3", "c2"}, {"c1"}}
+	if !reflect.DeepEqual(pages, want) {
+		t.Errorf("pages, want: %v, got: %v", want, pages)
+	}
+
+	// 刚好一页时没有下一页
+	if _, next := listClientIds(t, db, ListClientsRequest{Limit: 5}); next != "" {
+		t.Errorf("next cursor of the last page, want empty, got: %s", next)
+	}
+	if _, err := ListClients(db, ListClientsRequest{Cursor: "not-a-cursor"}); err == nil {
+		t.Error("invalid cursor should fail")
+	}
+}
+
+func TestListClientsSearch(t *testing.T) {
+	db := newTestDB(t,
+		dao.App{Name: "my_app", ClientId: "a_1", Status: dao.AppStatusActive},
+		dao.App{Name: "myXapp", ClientId: "ab1", Status: dao.AppStatusActive},
+		dao.App{Name: "100% app", ClientId: "a%1", Status: dao.AppStatusActive},
+		dao.App{Name: "1000 app", ClientId: `a\1`, Status: dao.AppStatusActive},
+	)
+	for name, c := range map[string]struct {
+		req  ListClientsRequest
+		want []string
+	}{
+		"name contains":       {req: ListClientsRequest{Name: "app"}, want: []string{`a\1`, "a%1", "ab1", "a_1"}},
+		"name underscore":     {req: ListClientsRequest{Name: "y_a"}, want: []string{"a_1"}},
+		"name percent":        {req: ListClientsRequest{Name: "0%"}, want: []string{"a%1"}},
+		"client id prefix":    {req: ListClientsRequest{ClientId: "ab"}, want: []string{"ab1"}},
+		"client id wildcard":  {req: ListClientsRequest{ClientId: "a_"}, want: []string{"a_1"}},
+		"client id percent":   {req: ListClientsRequest{ClientId: "a%"}, want: []string{"a%1"}},
+		"client id backslash": {req: ListClientsRequest{ClientId: `a\`}, want: []string{`a\1`}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			if ids, _ := listClientIds(t, db, c.req); !reflect.DeepEqual(ids, c.want) {
+				t.Errorf("clients, want: %v, got: %v", c.want, ids)
+			}
+		})
+	}
+}
+
+func TestListClientsStatus(t *testing.T) {
+	db := newTestDB(t,
+		dao.App{ClientId: "legacy", Status: 0},
+		dao.App{ClientId: "active", Status: dao.AppStatusActive},
+		dao.App{ClientId: "suspended", Status: dao.AppStatusSuspended},
+		dao.App{ClientId: "deleted", Status: dao.AppStatusDeleted, Dtime: 1},
+		dao.App{ClientId: "old", Status: dao.AppStatusActive, Ctime: 100},
+	)
+	for name, c := range map[string]struct {
+		req  ListClientsRequest
+		want []string
+	}{
+		// 老数据的状态为0，按照active处理
+		"active":          {req: ListClientsRequest{Statuses: []int{dao.AppStatusActive}}, want: []string{"old", "active", "legacy"}},
+		"suspended":       {req: ListClientsRequest{Statuses: []int{dao.AppStatusSuspended}}, want: []string{"suspended"}},
+		"deleted":         {req: ListClientsRequest{Statuses: []int{dao.AppStatusDeleted}, IncludeDeleted: true}, want: []string{"deleted"}},
+		"exclude deleted": {req: ListClientsRequest{}, want: []string{"old", "suspended", "active", "legacy"}},
+		"ctime":           {req: ListClientsRequest{CtimeStart: 50, CtimeEnd: 150}, want: []string{"old"}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			if ids, _ := listClientIds(t, db, c.req); !reflect.DeepEqual(ids, c.want) {
+				t.Errorf("clients, want: %v, got: %v", c.want, ids)
+			}
+		})
+	}
+
+	// 不能修改调用方传入的切片
+	backing := []int{dao.AppStatusActive, dao.AppStatusSuspended}
+	listClientIds(t, db, ListClientsRequest{Statuses: backing[:1]})
+	if backing[1] != dao.AppStatusSuspended {
+		t.Errorf("caller statuses should not be modified, got: %v", backing)
+	}
+}
@@ -0,0 +1,81 @@
+package clientcache
+
+import (
+	"container/list"
+	"time"
+
+	"github.com/ego-component/eoauth2/server"
+)
+
+type entry struct {
+	clientId string
+	client   server.Client
+	expireAt time.Time
+}
+
+// lru 按照最近使用淘汰的客户端缓存，不是并发安全的，由Storage加锁
+type lru struct {
+	maxEntries int
+	ll         *list.List
+	items      map[string]*list.Element
+}
+
+func newLRU(maxEntries int) *lru {
+	return &lru{
+		maxEntries: maxEntries,
+		ll:         list.New(),
+		items:      make(map[string]*list.Element),
+	}
+}
+
+func (c *lru) get(clientId string, now time.Time) (server.Client, bool) {
+	elem, ok := c.items[clientId]
+	if !ok {
+		return nil, false
+	}
+	value := elem.Value.(*entry)
+	if now.After(value.expireAt) {
+		c.removeElement(elem)
+		return nil, false
+	}
+	c.ll.MoveToFront(elem)
+	return value.client, true
+}
+
+func (c *lru) set(clientId string, client server.Client, expireAt time.Time) {
+	if elem, ok := c.items[clientId]; ok {
+		value := elem.Value.(*entry)
+		value.client = client
+		value.expireAt = expireAt
+		c.ll.MoveToFront(elem)
+		return
+	}
+	c.items[clientId] = c.ll.PushFront(&entry{
+		clientId: clientId,
+		client:   client,
+		expireAt: expireAt,
+	})
+	for c.maxEntries > 0 && c.ll.Len() > c.maxEntries {
+		c.removeElement(c.ll.Back())
+	}
+}
+
+func (c *lru) remove(clientId string) {
+	if elem, ok := c.items[clientId]; ok {
+		c.removeElement(elem)
+	}
+}
+
+func (c *lru) clear() {
+	c.ll.Init()
+	c.items = make(map[string]*list.Element)
+}
+
+func (c *lru) len() int {
+	return c.ll.Len()
+}
+
+func (c *lru) removeElement(elem *list.Element) {
+	c.ll.Remove(elem)
+	delete(c.items, elem.Value.(*entry).clientId)
+}
@@ -0,0 +1,36 @@
+package clientcache
+
+import (
+	"time"
+
+	"github.com/ego-component/eredis"
+)
+
+// Option 可选项
+type Option func(s *Storage)
+
+// WithTTL 客户端在进程内缓存的有效期，默认1分钟，收不到失效通知时最多使用这么久的旧数据
+func WithTTL(ttl time.Duration) Option {
+	return func(s *Storage) {
+		s.ttl = ttl
+	}
+}
+
+// WithMaxEntries 最多缓存的客户端个数，默认1000，超过时淘汰最久没有使用的客户端
+func WithMaxEntries(maxEntries int) Option {
+	return func(s *Storage) {
+		s.maxEntries = maxEntries
+	}
+}
+
+// WithInvalidation 订阅Redis的失效通知，channel为空时使用 DefaultChannel
+// ssostorage.API 修改、删除客户端时会发布通知，其他方式修改客户端时调用 Invalidate
+func WithInvalidation(redis *eredis.Component, channel string) Option {
+	return func(s *Storage) {
+		if channel == "" {
+			channel = DefaultChannel
+		}
+		s.redis = redis
+		s.channel = channel
+	}
+}
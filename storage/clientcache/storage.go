@@ -0,0 +1,177 @@
+// Package clientcache server.Storage 的客户端缓存，GetClient 命中进程内缓存时不访问下层存储
+// 客户端修改后通过Redis pub/sub通知所有节点删除缓存
+package clientcache
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ego-component/eoauth2/server"
+	"github.com/ego-component/eredis"
+	"github.com/go-redis/redis/v8"
+	"github.com/gotomicro/ego/core/elog"
+)
+
+// DefaultChannel 默认的失效通知channel，消息内容为client id
+const DefaultChannel = "sso:client:invalidate"
+
+// Publish 发布客户端失效通知，订阅了channel的节点会删除该客户端的缓存
+func Publish(ctx context.Context, rds *eredis.Component, channel string, clientId string) error {
+	if channel == "" {
+		channel = DefaultChannel
+	}
+	if err := rds.Client().Publish(ctx, channel, clientId).Err(); err != nil {
+		return fmt.Errorf("client cache publish failed, err: %w", err)
+	}
+	return nil
+}
+
+// subscriber 支持订阅的redis客户端，单机、哨兵、集群模式都支持
+type subscriber interface {
+	Subscribe(ctx context.Context, channels ...string) *redis.PubSub
+}
+
+// Storage 包装 server.Storage，缓存 GetClient 的结果，其他方法直接调用下层存储
+// 只缓存成功的结果，客户端不存在、被暂停时每次都会访问下层存储
+type Storage struct {
+	server.Storage
+	ttl        time.Duration
+	maxEntries int
+	redis      *eredis.Component
+	channel    string
+	logger     *elog.Component
+
+	mu      sync.Mutex
+	cache   *lru
+	version uint64 // 每次失效加一，加载期间发生失效时不写入缓存
+
+	cancel context.CancelFunc
+	pubsub *redis.PubSub
+	done   chan struct{}
+}
+
+// NewStorage 创建客户端缓存，设置 WithInvalidation 时会启动订阅，需要调用 Close 停止
+func NewStorage(storage server.Storage, options ...Option) *Storage {
+	s := &Storage{
+		Storage:    storage,
+		ttl:        time.Minute,
+		maxEntries: 1000,
+		logger:     elog.EgoLogger.With(elog.FieldComponent("oauth2.clientcache")),
+	}
+	for _, option := range options {
+		option(s)
+	}
+	s.cache = newLRU(s.maxEntries)
+	if s.redis != nil {
+		s.subscribe()
+	}
+	return s
+}
+
+// Clone 共用缓存和订阅，不克隆下层存储
+func (s *Storage) Clone() server.Storage {
+	return s
+}
+
+// Close 停止订阅，并关闭下层存储
+func (s *Storage) Close() {
+	if s.cancel != nil {
+		// Receive阻塞读取时不检查ctx，需要关闭连接才能返回
+		s.cancel()
+		_ = s.pubsub.Close()
+		<-s.done
+		s.cancel = nil
+	}
+	s.Storage.Close()
+}
+
+// GetClient 优先从缓存获取客户端
+func (s *Storage) GetClient(ctx context.Context, clientId string) (server.Client, error) {
+	s.mu.Lock()
+	client, ok := s.cache.get(clientId, time.Now())
+	version := s.version
+	s.mu.Unlock()
+	if ok {
+		return client, nil
+	}
+
+	client, err := s.Storage.GetClient(ctx, clientId)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	if s.version == version {
+		s.cache.set(clientId, client, time.Now().Add(s.ttl))
+	}
+	s.mu.Unlock()
+	return client, nil
+}
+
+// Invalidate 删除客户端的缓存，设置了 WithInvalidation 时同时通知其他节点
+func (s *Storage) Invalidate(ctx context.Context, clientId string) error {
+	s.remove(clientId)
+	if s.redis == nil {
+		return nil
+	}
+	return Publish(ctx, s.redis, s.channel, clientId)
+}
+
+// Len 缓存的客户端个数
+func (s *Storage) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.cache.len()
+}
+
+func (s *Storage) remove(clientId string) {
+	s.mu.Lock()
+	s.cache.remove(clientId)
+	s.version++
+	s.mu.Unlock()
+}
+
+func (s *Storage) clear() {
+	s.mu.Lock()
+	s.cache.clear()
+	s.version++
+	s.mu.Unlock()
+}
+
+// subscribe 订阅失效通知，断线重连后清空缓存，避免漏掉断线期间的通知
+func (s *Storage) subscribe() {
+	client, ok := s.redis.Client().(subscriber)
+	if !ok {
+		s.logger.Error("client cache redis client does not support subscribe, invalidation disabled", elog.FieldValueAny(fmt.Sprintf("%T", s.redis.Client())))
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	s.cancel = cancel
+	s.done = make(chan struct{})
+	s.pubsub = client.Subscribe(ctx, s.channel)
+	go func() {
+		defer close(s.done)
+		for {
+			msg, err := s.pubsub.Receive(ctx)
+			if ctx.Err() != nil {
+				return
+			}
+			if err != nil {
+				s.logger.Warn("client cache receive failed", elog.FieldErr(err))
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(time.Second):
+				}
+				continue
+			}
+			switch value := msg.(type) {
+			case *redis.Subscription:
+				s.clear()
+			case *redis.Message:
+				s.remove(value.Payload)
+			}
+		}
+	}()
+}
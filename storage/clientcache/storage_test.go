@@ -0,0 +1,203 @@
+package clientcache
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/ego-component/eoauth2/server"
+	"github.com/ego-component/eoauth2/storage/memstorage"
+	"github.com/ego-component/eoauth2/storage/storagetest"
+	"github.com/ego-component/eredis"
+)
+
+func TestStorage(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) *storagetest.Harness {
+		mr := miniredis.RunT(t)
+		rds := eredis.DefaultContainer().Build(eredis.WithStub(), eredis.WithAddr(mr.Addr()))
+		client := &server.DefaultClient{Id: "storagetest", Secret: "secret", RedirectUri: "http://localhost/callback"}
+		mem := memstorage.NewStorage(memstorage.WithClients(client), memstorage.WithCleanupInterval(0))
+		s := NewStorage(mem, WithInvalidation(rds, ""))
+		t.Cleanup(s.Close)
+		return &storagetest.Harness{
+			Storage:           s,
+			Client:            client,
+			RemoveParentToken: mem.RemoveParentToken,
+		}
+	})
+}
+
+// countingStorage 记录 GetClient 访问下层存储的次数，客户端可以修改
+type countingStorage struct {
+	server.Storage
+	mu      sync.Mutex
+	clients map[string]*server.DefaultClient
+	loads   map[string]int
+}
+
+func newCountingStorage(clientIds ...string) *countingStorage {
+	s := &countingStorage{
+		Storage: memstorage.NewStorage(memstorage.WithCleanupInterval(0)),
+		clients: make(map[string]*server.DefaultClient),
+		loads:   make(map[string]int),
+	}
+	for _, clientId := range clientIds {
+		s.clients[clientId] = &server.DefaultClient{Id: clientId, Secret: "secret", RedirectUri: "http://localhost/callback"}
+	}
+	return s
+}
+
+func (s *countingStorage) GetClient(ctx context.Context, clientId string) (server.Client, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.loads[clientId]++
+	client, ok := s.clients[clientId]
+	if !ok {
+		return nil, server.ErrNotFound
+	}
+	copied := *client
+	return &copied, nil
+}
+
+func (s *countingStorage) setRedirectUri(clientId string, redirectUri string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.clients[clientId].RedirectUri = redirectUri
+}
+
+func (s *countingStorage) loadCount(clientId string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.loads[clientId]
+}
+
+func mustGetClient(t *testing.T, s *Storage, clientId string) server.Client {
+	t.Helper()
+	client, err := s.GetClient(context.Background(), clientId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+func TestCacheHit(t *testing.T) {
+	backend := newCountingStorage("a")
+	s := NewStorage(backend)
+	defer s.Close()
+
+	for i := 0; i < 3; i++ {
+		mustGetClient(t, s, "a")
+	}
+	if n := backend.loadCount("a"); n != 1 {
+		t.Errorf("load count, want: 1, got: %d", n)
+	}
+
+	// 客户端不存在时不缓存
+	for i := 0; i < 2; i++ {
+		if _, err := s.GetClient(context.Background(), "missing"); !errors.Is(err, server.ErrNotFound) {
+			t.Fatalf("get missing client, want ErrNotFound, got: %v", err)
+		}
+	}
+	if n := backend.loadCount("missing"); n != 2 {
+		t.Errorf("missing client load count, want: 2, got: %d", n)
+	}
+}
+
+func TestCacheTTL(t *testing.T) {
+	backend := newCountingStorage("a")
+	s := NewStorage(backend, WithTTL(50*time.Millisecond))
+	defer s.Close()
+
+	mustGetClient(t, s, "a")
+	backend.setRedirectUri("a", "http://localhost/changed")
+	if client := mustGetClient(t, s, "a"); client.GetRedirectUri() != "http://localhost/callback" {
+		t.Errorf("cached redirect uri, got: %s", client.GetRedirectUri())
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	if client := mustGetClient(t, s, "a"); client.GetRedirectUri() != "http://localhost/changed" {
+		t.Errorf("redirect uri after ttl, got: %s", client.GetRedirectUri())
+	}
+	if n := backend.loadCount("a"); n != 2 {
+		t.Errorf("load count, want: 2, got: %d", n)
+	}
+}
+
+func TestCacheMaxEntries(t *testing.T) {
+	backend := newCountingStorage("a", "b", "c")
+	s := NewStorage(backend, WithMaxEntries(2))
+	defer s.Close()
+
+	mustGetClient(t, s, "a")
+	mustGetClient(t, s, "b")
+	// a最近使用过，淘汰b
+	mustGetClient(t, s, "a")
+	mustGetClient(t, s, "c")
+	if n := s.Len(); n != 2 {
+		t.Errorf("cache len, want: 2, got: %d", n)
+	}
+
+	mustGetClient(t, s, "a")
+	mustGetClient(t, s, "b")
+	if n := backend.loadCount("a"); n != 1 {
+		t.Errorf("a load count, want: 1, got: %d", n)
+	}
+	if n := backend.loadCount("b"); n != 2 {
+		t.Errorf("b load count, want: 2, got: %d", n)
+	}
+}
+
+// waitFor 等待条件满足，订阅和通知是异步的
+func waitFor(t *testing.T, msg string, fn func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !fn() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timeout waiting for %s", msg)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestCacheInvalidation(t *testing.T) {
+	mr := miniredis.RunT(t)
+	rds := eredis.DefaultContainer().Build(eredis.WithStub(), eredis.WithAddr(mr.Addr()))
+	backend := newCountingStorage("a")
+	first := NewStorage(backend, WithInvalidation(rds, ""))
+	defer first.Close()
+	second := NewStorage(backend, WithInvalidation(rds, ""))
+	defer second.Close()
+	waitFor(t, "subscribe", func() bool {
+		return mr.PubSubNumSub(DefaultChannel)[DefaultChannel] == 2
+	})
+
+	mustGetClient(t, first, "a")
+	mustGetClient(t, second, "a")
+	backend.setRedirectUri("a", "http://localhost/changed")
+
+	// 一个节点修改客户端后发布通知，其他节点删除缓存后重新加载
+	if err := first.Invalidate(context.Background(), "a"); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "invalidation", func() bool {
+		return second.Len() == 0
+	})
+	if client := mustGetClient(t, second, "a"); client.GetRedirectUri() != "http://localhost/changed" {
+		t.Errorf("redirect uri after invalidation, got: %s", client.GetRedirectUri())
+	}
+
+	// 直接发布通知
+	mustGetClient(t, first, "a")
+	if err := Publish(context.Background(), rds, "", "a"); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "publish", func() bool {
+		return first.Len() == 0 && second.Len() == 0
+	})
+	if n := backend.loadCount("a"); n != 4 {
+		t.Errorf("load count, want: 4, got: %d", n)
+	}
+}
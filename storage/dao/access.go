@@ -8,17 +8,26 @@ import (
 )
 
 type Access struct {
-	Id           int    `gorm:"not null;primary_key;AUTO_INCREMENT" json:"id"`       // FormID
-	Client       string `gorm:"not null;default:'';comment:客户端" json:"client"`       // client
-	Authorize    string `gorm:"not null;default:'';comment:授权" json:"authorize"`     // authorize
-	Previous     string `gorm:"not null;default:'';" json:"previous"`                // previous
-	AccessToken  string `gorm:"not null;default:'';" json:"accessToken"`             // access_token
-	RefreshToken string `gorm:"not null;default:'';" json:"refreshToken"`            // refresh_token
-	ExpiresIn    int64  `gorm:"not null;default:0;comment:过期时间" json:"expiresIn"`    // expires_in
-	Scope        string `gorm:"not null;default:'';comment:作用域" json:"scope"`        // scope
-	RedirectUri  string `gorm:"not null;default:'';comment:跳转地址" json:"redirectUri"` // redirect_uri
-	Extra        string `gorm:"not null;type:longtext;comment:额外信息" json:"extra"`    // extra
-	Ctime        int64  `gorm:"not null;default:0;comment:创建时间" json:"ctime"`        // 创建时间
+	Id               int    `gorm:"not null;primaryKey;autoIncrement" json:"id"`                          // FormID
+	Client           string `gorm:"not null;default:'';comment:客户端" json:"client"`                        // client
+	Authorize        string `gorm:"not null;default:'';comment:授权" json:"authorize"`                      // authorize
+	Previous         string `gorm:"not null;default:'';" json:"previous"`                                 // previous
+	AccessToken      string `gorm:"not null;default:'';uniqueIndex" json:"accessToken"`                   // access_token
+	RefreshToken     string `gorm:"not null;default:'';" json:"refreshToken"`                             // refresh_token
+	ExpiresIn        int64  `gorm:"not null;default:0;comment:过期时间" json:"expiresIn"`                     // expires_in
+	RefreshExpiresIn int64  `gorm:"not null;default:0;comment:refresh token过期时间" json:"refreshExpiresIn"` // refresh token expires_in，0表示不过期
+	Scope            string `gorm:"not null;default:'';comment:作用域" json:"scope"`                         // scope
+	RedirectUri      string `gorm:"not null;default:'';comment:跳转地址" json:"redirectUri"`                  // redirect_uri
+	Extra            string `gorm:"not null;comment:额外信息" json:"extra"`                                   // extra
+	Ctime            int64  `gorm:"not null;default:0;comment:创建时间" json:"ctime"`                         // 创建时间
+	// 单点登录，sub token所属的parent token，开启token hash时为parent token的hash
+	Ptoken   string `gorm:"not null;default:'';index;comment:parent token" json:"ptoken"`
+	Uid      int64  `gorm:"not null;default:0;comment:多账号下token所属的账号" json:"uid"`
+	Ua       string `gorm:"not null;default:'';size:512;comment:user agent" json:"ua"`
+	ClientIp string `gorm:"not null;default:'';size:64;comment:客户端IP" json:"clientIp"`
+	// 刷新链，Chain为链上第一个access token，Generation为刷新的次数，用于一次查询整条链
+	Chain      string `gorm:"not null;default:'';index:idx_access_chain;comment:刷新链" json:"chain"`
+	Generation int    `gorm:"not null;default:0;index:idx_access_chain;comment:刷新次数" json:"generation"`
 }
 
 func (t *Access) TableName() string {
@@ -28,7 +37,10 @@ func (t *Access) TableName() string {
 // CreateAccess insert a new Access into database and returns
 // last inserted Id on success.
 func CreateAccess(db *gorm.DB, data *Access) (err error) {
-	data.Ctime = time.Now().Unix()
+	// 过期时间根据创建时间计算，保留调用方传入的创建时间
+	if data.Ctime == 0 {
+		data.Ctime = time.Now().Unix()
+	}
 	if err = db.Create(data).Error; err != nil {
 		err = fmt.Errorf("CreateAccess, err: %w", err)
 		return
@@ -53,3 +65,30 @@ func GetAccessByAccessToken(db *gorm.DB, accessToken string) (resp Access, err e
 	}
 	return
 }
+
+// DeleteAccessByAccessTokens 根据多个access token删除记录，开启token hash时会同时传入hash和明文
+func DeleteAccessByAccessTokens(db *gorm.DB, accessTokens []string) (err error) {
+	if err = db.Where("access_token in (?)", accessTokens).Delete(&Access{}).Error; err != nil {
+		err = fmt.Errorf("DeleteAccessByAccessTokens, err: %w", err)
+		return
+	}
+	return
+}
+
+// GetAccessByAccessTokens 根据多个access token查询单条记录，开启token hash时会同时传入hash和明文
+func GetAccessByAccessTokens(db *gorm.DB, accessTokens []string) (resp Access, err error) {
+	if err = db.Where("access_token in (?)", accessTokens).First(&resp).Error; err != nil {
+		err = fmt.Errorf("GetAccessByAccessTokens, err: %w", err)
+		return
+	}
+	return
+}
+
+// ListAccessesByChain 查询刷新链上generation小于等于传入值的access，按照generation倒序，最多limit条
+func ListAccessesByChain(db *gorm.DB, chain string, generation int, limit int) (resp []Access, err error) {
+	if err = db.Where("chain = ? and generation <= ?", chain, generation).Order("generation desc").Limit(limit).Find(&resp).Error; err != nil {
+		err = fmt.Errorf("ListAccessesByChain, err: %w", err)
+		return
+	}
+	return
+}
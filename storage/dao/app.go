@@ -8,25 +8,48 @@ import (
 	"gorm.io/gorm"
 )
 
+// 客户端状态，老数据的状态为0，按照active处理
+const (
+	AppStatusActive    = 1 // 正常
+	AppStatusSuspended = 2 // 暂停，客户端不能登录、换取token
+	AppStatusDeleted   = 3 // 删除
+)
+
 type App struct {
-	Aid         int    `gorm:"not null;primary_key;AUTO_INCREMENT" json:"aid"`      // 应用id
+	Aid         int    `gorm:"not null;primaryKey;autoIncrement" json:"aid"`        // 应用id
 	Name        string `gorm:"not null;default:'';comment:名称" json:"name"`          // 名称
 	ClientId    string `gorm:"not null;default:'';comment:客户度ID" json:"clientId"`   // 客户端
 	Secret      string `gorm:"not null;default:'';comment:密钥" json:"secret"`        // 秘钥
 	RedirectUri string `gorm:"not null;default:'';comment:跳转地址" json:"redirectUri"` // 跳转地址
 	Url         string `gorm:"not null;default:'';comment:访问地址" json:"url"`         // 访问地址
-	Extra       string `gorm:"not null;type:longtext;comment:额外信息" json:"extra"`    // 额外信息
+	Extra       string `gorm:"not null;comment:额外信息" json:"extra"`                  // 额外信息
 	CntCall     int    `gorm:"not null;default:0;comment:调用次数" json:"cntCall"`      // 调用次数
 	Status      int    `gorm:"not null;default:0;comment:状态" json:"status"`         // 状态
 	Ctime       int64  `gorm:"not null;default:0;comment:创建时间" json:"ctime"`        // 创建时间
 	Utime       int64  `gorm:"not null;default:0;comment:更新时间" json:"utime"`        // 更新时间
 	Dtime       int64  `gorm:"not null;default:0;comment:删除时间" json:"dtime"`        // 删除时间
+	// 动态注册客户端的元数据，RFC 7591 JSON
+	Metadata string `gorm:"not null;comment:客户端元数据" json:"metadata"`
+	// 动态注册客户端的 registration access token sha256，手动创建的客户端为空
+	RegistrationToken string `gorm:"not null;default:'';comment:注册访问令牌" json:"-"`
+	// 客户端的token策略，server.TokenPolicy JSON，为空表示使用全局配置
+	TokenPolicy string `gorm:"not null;comment:token策略" json:"tokenPolicy"`
 }
 
 func (t *App) TableName() string {
 	return "app"
 }
 
+// IsActive 客户端是否可用
+func (t *App) IsActive() bool {
+	return IsAppStatusActive(t.Status) && t.Dtime == 0
+}
+
+// IsAppStatusActive 状态是否可用，老数据的状态为0，按照active处理
+func IsAppStatusActive(status int) bool {
+	return status == 0 || status == AppStatusActive
+}
+
 // GetAppInfoByClientId Info的扩展方法，根据Cond查询单条记录
 func GetAppInfoByClientId(db *egorm.Component, clientId string) (resp App, err error) {
 	if err = db.Where("client_id = ? and dtime = 0", clientId).First(&resp).Error; err != nil {
@@ -55,8 +78,23 @@ func UpdateApp(db *gorm.DB, clientId string, updates map[string]interface{}) (er
 	return
 }
 
+// UpdateAppStatus 更新客户端状态，已删除的客户端不能再修改
+func UpdateAppStatus(db *gorm.DB, clientId string, status int) (err error) {
+	err = db.Model(App{}).Where("client_id = ? and dtime = 0", clientId).Updates(map[string]interface{}{
+		"status": status,
+		"utime":  time.Now().Unix(),
+	}).Error
+	if err != nil {
+		return fmt.Errorf("UpdateAppStatus failed, err:%w", err)
+	}
+	return
+}
+
 func DeleteApp(db *gorm.DB, clientId string) (err error) {
-	err = db.Model(App{}).Where("client_id = ?", clientId).Updates(map[string]interface{}{"dtime": time.Now().Unix()}).Error
+	err = db.Model(App{}).Where("client_id = ?", clientId).Updates(map[string]interface{}{
+		"status": AppStatusDeleted,
+		"dtime":  time.Now().Unix(),
+	}).Error
 	if err != nil {
 		return fmt.Errorf("DeleteApp failed, err:%w", err)
 	}
@@ -0,0 +1,58 @@
+package dao
+
+import (
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// AppSecret 客户端密钥，只存储hash，一个客户端可以同时有多个有效密钥，用于无缝轮换
+type AppSecret struct {
+	Id        int    `gorm:"not null;primaryKey;autoIncrement" json:"id"`             // 密钥id
+	ClientId  string `gorm:"not null;default:'';index;comment:客户端ID" json:"clientId"` // 客户端
+	Hash      string `gorm:"not null;default:'';comment:密钥hash" json:"-"`             // 密钥bcrypt hash
+	ExpiresAt int64  `gorm:"not null;default:0;comment:过期时间" json:"expiresAt"`        // 过期时间，0表示不过期
+	Ctime     int64  `gorm:"not null;default:0;comment:创建时间" json:"ctime"`            // 创建时间
+	Dtime     int64  `gorm:"not null;default:0;comment:删除时间" json:"dtime"`            // 删除时间，退役的密钥
+}
+
+func (t *AppSecret) TableName() string {
+	return "app_secret"
+}
+
+// CreateAppSecret insert a new AppSecret into database
+func CreateAppSecret(db *gorm.DB, data *AppSecret) (err error) {
+	data.Ctime = time.Now().Unix()
+	if err = db.Create(data).Error; err != nil {
+		return fmt.Errorf("CreateAppSecret failed, err:%w", err)
+	}
+	return
+}
+
+// ListAppSecretsByClientId 查询客户端所有未退役的密钥，包含已过期的
+func ListAppSecretsByClientId(db *gorm.DB, clientId string) (resp []AppSecret, err error) {
+	if err = db.Where("client_id = ? and dtime = 0", clientId).Order("id desc").Find(&resp).Error; err != nil {
+		err = fmt.Errorf("ListAppSecretsByClientId failed, err: %w", err)
+		return
+	}
+	return
+}
+
+// RetireAppSecret 退役客户端的某个密钥
+func RetireAppSecret(db *gorm.DB, clientId string, id int) (err error) {
+	err = db.Model(AppSecret{}).Where("client_id = ? and id = ? and dtime = 0", clientId, id).Updates(map[string]interface{}{"dtime": time.Now().Unix()}).Error
+	if err != nil {
+		return fmt.Errorf("RetireAppSecret failed, err:%w", err)
+	}
+	return
+}
+
+// RetireAppSecretsByClientId 退役客户端的所有密钥
+func RetireAppSecretsByClientId(db *gorm.DB, clientId string) (err error) {
+	err = db.Model(AppSecret{}).Where("client_id = ? and dtime = 0", clientId).Updates(map[string]interface{}{"dtime": time.Now().Unix()}).Error
+	if err != nil {
+		return fmt.Errorf("RetireAppSecretsByClientId failed, err:%w", err)
+	}
+	return
+}
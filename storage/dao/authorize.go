@@ -9,15 +9,22 @@ import (
 )
 
 type Authorize struct {
-	Id          int    `gorm:"not null;primary_key;AUTO_INCREMENT" json:"id"`       // FormID
-	Client      string `gorm:"not null;default:'';comment:客户端" json:"client"`       // 客户端
-	Code        string `gorm:"not null;default:'';comment:CODE码" json:"code"`       // CODE码
-	ExpiresIn   int64  `gorm:"not null;default:0;comment:过期时间" json:"expiresIn"`    // 过期时间
-	Scope       string `gorm:"not null;default:'';comment:范围" json:"scope"`         // 范围
-	RedirectUri string `gorm:"not null;default:'';comment:跳转地址" json:"redirectUri"` // 跳转地址
-	State       string `gorm:"not null;default:'';comment:状态" json:"state"`         // state信息，来自于url上的state信息
-	Extra       string `gorm:"not null;type:longtext;comment:额外信息" json:"extra"`    // 额外信息
-	Ctime       int64  `gorm:"not null;default:0;comment:创建时间" json:"ctime"`        // 创建时间
+	Id          int    `gorm:"not null;primaryKey;autoIncrement" json:"id"`               // FormID
+	Client      string `gorm:"not null;default:'';comment:客户端" json:"client"`             // 客户端
+	Code        string `gorm:"not null;default:'';uniqueIndex;comment:CODE码" json:"code"` // CODE码
+	ExpiresIn   int64  `gorm:"not null;default:0;comment:过期时间" json:"expiresIn"`          // 过期时间
+	Scope       string `gorm:"not null;default:'';comment:范围" json:"scope"`               // 范围
+	RedirectUri string `gorm:"not null;default:'';comment:跳转地址" json:"redirectUri"`       // 跳转地址
+	State       string `gorm:"not null;default:'';comment:状态" json:"state"`               // state信息，来自于url上的state信息
+	Extra       string `gorm:"not null;comment:额外信息" json:"extra"`                        // 额外信息
+	Ctime       int64  `gorm:"not null;default:0;comment:创建时间" json:"ctime"`              // 创建时间
+	// PKCE，rfc7636
+	CodeChallenge       string `gorm:"not null;default:'';size:128;comment:code_challenge" json:"codeChallenge"`
+	CodeChallengeMethod string `gorm:"not null;default:'';size:16;comment:code_challenge_method" json:"codeChallengeMethod"`
+	// 单点登录，code对应的parent token，开启token hash时为parent token的hash
+	Ptoken          string `gorm:"not null;default:'';comment:parent token" json:"ptoken"`
+	PtokenExpiresIn int64  `gorm:"not null;default:0;comment:parent token过期时间" json:"ptokenExpiresIn"`
+	Uid             int64  `gorm:"not null;default:0;comment:登录的账号" json:"uid"`
 }
 
 func (t *Authorize) TableName() string {
@@ -27,7 +34,10 @@ func (t *Authorize) TableName() string {
 // CreateAuthorize insert a new Authorize into database and returns
 // last inserted Id on success.
 func CreateAuthorize(db *gorm.DB, data *Authorize) (err error) {
-	data.Ctime = time.Now().Unix()
+	// 过期时间根据创建时间计算，保留调用方传入的创建时间
+	if data.Ctime == 0 {
+		data.Ctime = time.Now().Unix()
+	}
 	if err = db.Create(data).Error; err != nil {
 		err = fmt.Errorf("CreateAuthorize, err: %w", err)
 		return
@@ -52,3 +62,21 @@ func GetAuthorizeInfoByCode(db *egorm.Component, code string) (resp Authorize, e
 	}
 	return
 }
+
+// DeleteAuthorizeByCodes 根据多个code删除记录，开启token hash时会同时传入hash和明文
+func DeleteAuthorizeByCodes(db *gorm.DB, codes []string) (err error) {
+	if err = db.Where("code in (?)", codes).Delete(&Authorize{}).Error; err != nil {
+		err = fmt.Errorf("DeleteAuthorizeByCodes, err: %w", err)
+		return
+	}
+	return
+}
+
+// GetAuthorizeInfoByCodes 根据多个code查询单条记录，开启token hash时会同时传入hash和明文
+func GetAuthorizeInfoByCodes(db *gorm.DB, codes []string) (resp Authorize, err error) {
+	if err = db.Where("code in (?)", codes).First(&resp).Error; err != nil {
+		err = fmt.Errorf("GetAuthorizeInfoByCodes, err: %w", err)
+		return
+	}
+	return
+}
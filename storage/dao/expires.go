@@ -8,10 +8,10 @@ import (
 )
 
 type Expires struct {
-	Id        int    `gorm:"not null;primary_key;AUTO_INCREMENT" json:"id"`            // 客户端
-	Token     string `gorm:"not null;default:'';comment:token" json:"token"`           // token
-	ExpiresAt int64  `gorm:"not null;default:0;comment:过期时间" json:"expiresAt"`         // 过期时间
-	Ptoken    string `gorm:"not null;default:'';comment:parent token信息" json:"ptoken"` // parent token信息
+	Id        int    `gorm:"not null;primaryKey;autoIncrement" json:"id"`                // 客户端
+	Token     string `gorm:"not null;default:'';uniqueIndex;comment:token" json:"token"` // token
+	ExpiresAt int64  `gorm:"not null;default:0;comment:过期时间" json:"expiresAt"`           // 过期时间
+	Ptoken    string `gorm:"not null;default:'';comment:parent token信息" json:"ptoken"`   // parent token信息
 }
 
 func (t *Expires) TableName() string {
@@ -45,3 +45,12 @@ func GetExpireInfoByToken(db *egorm.Component, token string) (resp Expires, err
 	}
 	return
 }
+
+// DeleteExpiresByTokens 根据多个token删除记录，开启token hash时会同时传入hash和明文
+func DeleteExpiresByTokens(db *gorm.DB, tokens []string) (err error) {
+	if err = db.Where("token in (?)", tokens).Delete(&Expires{}).Error; err != nil {
+		err = fmt.Errorf("DeleteExpiresByTokens, err: %w", err)
+		return
+	}
+	return
+}
@@ -0,0 +1,104 @@
+package dao
+
+import (
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ParentToken 单点登录的parent token，多个子系统的sub token共用一个parent token
+type ParentToken struct {
+	Id        int    `gorm:"not null;primaryKey;autoIncrement" json:"id"`                       // id
+	Token     string `gorm:"not null;default:'';uniqueIndex;comment:parent token" json:"token"` // parent token，开启token hash时为hash
+	AuthAt    int64  `gorm:"not null;default:0;comment:登录时间" json:"authAt"`                     // 登录时间
+	ExpiresIn int64  `gorm:"not null;default:0;comment:有效期" json:"expiresIn"`                   // 有效期(s)，0表示不过期
+	ExpiresAt int64  `gorm:"not null;default:0;index;comment:过期时间" json:"expiresAt"`            // 过期时间，0表示不过期
+	Ctime     int64  `gorm:"not null;default:0;comment:创建时间" json:"ctime"`                      // 创建时间
+}
+
+func (t *ParentToken) TableName() string {
+	return "parent_token"
+}
+
+// IsExpiredAt 在now时是否已经过期
+func (t *ParentToken) IsExpiredAt(now int64) bool {
+	return t.ExpiresAt > 0 && t.ExpiresAt < now
+}
+
+// ParentTokenUser parent token下登录的账号，多账号登录时一个parent token有多个账号
+type ParentTokenUser struct {
+	Id       int    `gorm:"not null;primaryKey;autoIncrement" json:"id"`                                                         // id
+	Ptoken   string `gorm:"not null;default:'';uniqueIndex:idx_parent_token_user_ptoken_uid;comment:parent token" json:"ptoken"` // parent token，开启token hash时为hash
+	Uid      int64  `gorm:"not null;default:0;uniqueIndex:idx_parent_token_user_ptoken_uid;index;comment:账号" json:"uid"`         // 账号
+	Ctime    int64  `gorm:"not null;default:0;comment:登录时间" json:"ctime"`                                                        // 登录时间
+	Ua       string `gorm:"not null;default:'';size:512;comment:user agent" json:"ua"`                                           // user agent
+	ClientIp string `gorm:"not null;default:'';size:64;comment:客户端IP" json:"clientIp"`                                           // 客户端IP
+	Platform string `gorm:"not null;default:'';size:64;comment:平台" json:"platform"`                                              // 平台
+}
+
+func (t *ParentTokenUser) TableName() string {
+	return "parent_token_user"
+}
+
+// CreateParentToken 创建parent token
+func CreateParentToken(db *gorm.DB, data *ParentToken) (err error) {
+	if data.Ctime == 0 {
+		data.Ctime = time.Now().Unix()
+	}
+	if err = db.Create(data).Error; err != nil {
+		err = fmt.Errorf("CreateParentToken, err: %w", err)
+		return
+	}
+	return
+}
+
+// GetParentTokenByTokens 根据多个token查询单条记录，开启token hash时会同时传入hash和明文
+func GetParentTokenByTokens(db *gorm.DB, tokens []string) (resp ParentToken, err error) {
+	if err = db.Where("token in (?)", tokens).First(&resp).Error; err != nil {
+		err = fmt.Errorf("GetParentTokenByTokens, err: %w", err)
+		return
+	}
+	return
+}
+
+// SaveParentTokenUser 保存parent token下登录的账号，已经存在时更新登录信息
+func SaveParentTokenUser(db *gorm.DB, data *ParentTokenUser) (err error) {
+	err = db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "ptoken"}, {Name: "uid"}},
+		DoUpdates: clause.AssignmentColumns([]string{"ctime", "ua", "client_ip", "platform"}),
+	}).Create(data).Error
+	if err != nil {
+		err = fmt.Errorf("SaveParentTokenUser, err: %w", err)
+		return
+	}
+	return
+}
+
+// GetParentTokenUser 查询parent token下的账号
+func GetParentTokenUser(db *gorm.DB, ptoken string, uid int64) (resp ParentTokenUser, err error) {
+	if err = db.Where("ptoken = ? and uid = ?", ptoken, uid).First(&resp).Error; err != nil {
+		err = fmt.Errorf("GetParentTokenUser, err: %w", err)
+		return
+	}
+	return
+}
+
+// ListParentTokenUsersByPtoken 查询parent token下登录的所有账号，按照登录顺序
+func ListParentTokenUsersByPtoken(db *gorm.DB, ptoken string) (resp []ParentTokenUser, err error) {
+	if err = db.Where("ptoken = ?", ptoken).Order("id asc").Find(&resp).Error; err != nil {
+		err = fmt.Errorf("ListParentTokenUsersByPtoken, err: %w", err)
+		return
+	}
+	return
+}
+
+// ListParentTokenUsersByUid 查询账号登录的所有parent token
+func ListParentTokenUsersByUid(db *gorm.DB, uid int64) (resp []ParentTokenUser, err error) {
+	if err = db.Where("uid = ?", uid).Order("id asc").Find(&resp).Error; err != nil {
+		err = fmt.Errorf("ListParentTokenUsersByUid, err: %w", err)
+		return
+	}
+	return
+}
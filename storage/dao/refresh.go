@@ -7,9 +7,9 @@ import (
 )
 
 type Refresh struct {
-	Id     int    `gorm:"not null;primary_key;AUTO_INCREMENT" json:"id"`    // FormID
-	Token  string `gorm:"not null;default:'';comment:token" json:"token"`   // token
-	Access string `gorm:"not null;default:'';comment:access" json:"access"` // access
+	Id     int    `gorm:"not null;primaryKey;autoIncrement" json:"id"`                // FormID
+	Token  string `gorm:"not null;default:'';uniqueIndex;comment:token" json:"token"` // token
+	Access string `gorm:"not null;default:'';comment:access" json:"access"`           // access
 }
 
 func (t *Refresh) TableName() string {
@@ -41,3 +41,21 @@ func GetRefreshInfoByToken(db *gorm.DB, token string) (resp Refresh, err error)
 	}
 	return
 }
+
+// DeleteRefreshByTokens 根据多个token删除记录，开启token hash时会同时传入hash和明文
+func DeleteRefreshByTokens(db *gorm.DB, tokens []string) (err error) {
+	if err = db.Where("token in (?)", tokens).Delete(&Refresh{}).Error; err != nil {
+		err = fmt.Errorf("DeleteRefreshByTokens, err: %w", err)
+		return
+	}
+	return
+}
+
+// GetRefreshInfoByTokens 根据多个token查询单条记录，开启token hash时会同时传入hash和明文
+func GetRefreshInfoByTokens(db *gorm.DB, tokens []string) (resp Refresh, err error) {
+	if err = db.Where("token in (?)", tokens).First(&resp).Error; err != nil {
+		err = fmt.Errorf("GetRefreshInfoByTokens, err: %w", err)
+		return
+	}
+	return
+}
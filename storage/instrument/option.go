@@ -0,0 +1,11 @@
+package instrument
+
+// Option 可选项
+type Option func(s *Storage)
+
+// WithName 存储名称，作为监控的name标签，同一个进程包装多个存储时用于区分，默认default
+func WithName(name string) Option {
+	return func(s *Storage) {
+		s.name = name
+	}
+}
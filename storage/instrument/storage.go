@@ -0,0 +1,213 @@
+// Package instrument server.Storage 的监控装饰器，记录每个方法的耗时、错误次数，并创建链路追踪的span
+// span只记录客户端ID，不记录token、code等凭证
+package instrument
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/ego-component/eoauth2/server"
+	"github.com/gotomicro/ego/core/emetric"
+	"github.com/gotomicro/ego/core/etrace"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// handleHistogram 存储方法的耗时
+var handleHistogram = emetric.HistogramVecOpts{
+	Namespace: emetric.DefaultNamespace,
+	Name:      "oauth2_storage_handle_seconds",
+	Help:      "oauth2 storage method latency",
+	Labels:    []string{"name", "method"},
+	Buckets:   []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5},
+}.Build()
+
+// errorCounter 存储方法返回错误的次数，code为not_found、client_suspended或error
+var errorCounter = emetric.CounterVecOpts{
+	Namespace: emetric.DefaultNamespace,
+	Name:      "oauth2_storage_error_total",
+	Help:      "oauth2 storage method errors",
+	Labels:    []string{"name", "method", "code"},
+}.Build()
+
+const (
+	codeNotFound        = "not_found"
+	codeClientSuspended = "client_suspended"
+	codeError           = "error"
+)
+
+// Storage 包装 server.Storage，每个方法调用都记录监控和链路
+// 只包装 server.Storage 的方法，下层存储的其他方法需要直接调用下层存储
+type Storage struct {
+	storage server.Storage
+	name    string
+	tracer  *etrace.Tracer
+}
+
+// NewStorage 创建监控装饰器
+func NewStorage(storage server.Storage, options ...Option) *Storage {
+	s := &Storage{
+		storage: storage,
+		name:    "default",
+		tracer:  etrace.NewTracer(trace.SpanKindInternal),
+	}
+	for _, option := range options {
+		option(s)
+	}
+	return s
+}
+
+// WithStorage 用监控装饰器包装存储并注入server
+func WithStorage(storage server.Storage, options ...Option) server.Option {
+	return server.WithStorage(NewStorage(storage, options...))
+}
+
+// Unwrap 返回下层存储
+func (s *Storage) Unwrap() server.Storage {
+	return s.storage
+}
+
+// Clone 克隆下层存储，克隆出的存储使用相同的监控配置
+func (s *Storage) Clone() server.Storage {
+	return &Storage{
+		storage: s.storage.Clone(),
+		name:    s.name,
+		tracer:  s.tracer,
+	}
+}
+
+// Close 关闭下层存储
+func (s *Storage) Close() {
+	s.storage.Close()
+}
+
+// GetClient ...
+func (s *Storage) GetClient(ctx context.Context, id string) (client server.Client, err error) {
+	ctx, done := s.start(ctx, "GetClient", id)
+	defer func() { done(err) }()
+	return s.storage.GetClient(ctx, id)
+}
+
+// SaveAuthorize ...
+func (s *Storage) SaveAuthorize(ctx context.Context, data *server.AuthorizeData) (err error) {
+	ctx, done := s.start(ctx, "SaveAuthorize", clientId(data.Client))
+	defer func() { done(err) }()
+	return s.storage.SaveAuthorize(ctx, data)
+}
+
+// LoadAuthorize ...
+func (s *Storage) LoadAuthorize(ctx context.Context, code string) (data *server.AuthorizeData, err error) {
+	ctx, done := s.start(ctx, "LoadAuthorize", "")
+	defer func() {
+		if data != nil {
+			s.setClientId(ctx, data.Client)
+		}
+		done(err)
+	}()
+	return s.storage.LoadAuthorize(ctx, code)
+}
+
+// RemoveAuthorize ...
+func (s *Storage) RemoveAuthorize(ctx context.Context, code string) (err error) {
+	ctx, done := s.start(ctx, "RemoveAuthorize", "")
+	defer func() { done(err) }()
+	return s.storage.RemoveAuthorize(ctx, code)
+}
+
+// SaveAccess ...
+func (s *Storage) SaveAccess(ctx context.Context, data *server.AccessData) (err error) {
+	ctx, done := s.start(ctx, "SaveAccess", clientId(data.Client))
+	defer func() { done(err) }()
+	return s.storage.SaveAccess(ctx, data)
+}
+
+// LoadAccess ...
+func (s *Storage) LoadAccess(ctx context.Context, token string) (data *server.AccessData, err error) {
+	ctx, done := s.start(ctx, "LoadAccess", "")
+	defer func() {
+		if data != nil {
+			s.setClientId(ctx, data.Client)
+		}
+		done(err)
+	}()
+	return s.storage.LoadAccess(ctx, token)
+}
+
+// RemoveAccess ...
+func (s *Storage) RemoveAccess(ctx context.Context, token string) (err error) {
+	ctx, done := s.start(ctx, "RemoveAccess", "")
+	defer func() { done(err) }()
+	return s.storage.RemoveAccess(ctx, token)
+}
+
+// LoadRefresh ...
+func (s *Storage) LoadRefresh(ctx context.Context, token string) (data *server.AccessData, err error) {
+	ctx, done := s.start(ctx, "LoadRefresh", "")
+	defer func() {
+		if data != nil {
+			s.setClientId(ctx, data.Client)
+		}
+		done(err)
+	}()
+	return s.storage.LoadRefresh(ctx, token)
+}
+
+// RemoveRefresh ...
+func (s *Storage) RemoveRefresh(ctx context.Context, token string) (err error) {
+	ctx, done := s.start(ctx, "RemoveRefresh", "")
+	defer func() { done(err) }()
+	return s.storage.RemoveRefresh(ctx, token)
+}
+
+// start 创建span并开始计时，返回的done在方法返回时调用
+// 客户端ID为空时不设置属性，加载类的方法在拿到数据之后再设置
+func (s *Storage) start(ctx context.Context, method string, id string) (context.Context, func(err error)) {
+	begin := time.Now()
+	ctx, span := s.tracer.Start(ctx, "oauth2.storage."+method, nil)
+	span.SetAttributes(
+		etrace.CustomTag("oauth2.storage.name", s.name),
+		etrace.CustomTag("oauth2.storage.method", method),
+	)
+	if id != "" {
+		span.SetAttributes(etrace.CustomTag("oauth2.client_id", id))
+	}
+	return ctx, func(err error) {
+		handleHistogram.Observe(time.Since(begin).Seconds(), s.name, method)
+		if err != nil {
+			code := errorCode(err)
+			errorCounter.Inc(s.name, method, code)
+			// 错误信息由下层存储生成，可能带有token，span里只记录错误类型
+			span.SetAttributes(etrace.CustomTag("oauth2.storage.code", code))
+			if code == codeError {
+				span.SetStatus(codes.Error, code)
+			}
+		}
+		span.End()
+	}
+}
+
+func (s *Storage) setClientId(ctx context.Context, client server.Client) {
+	if id := clientId(client); id != "" {
+		trace.SpanFromContext(ctx).SetAttributes(etrace.CustomTag("oauth2.client_id", id))
+	}
+}
+
+func clientId(client server.Client) string {
+	if client == nil {
+		return ""
+	}
+	return client.GetId()
+}
+
+// errorCode 不存在、客户端被暂停是正常的业务结果，单独统计，不把span标记为失败
+func errorCode(err error) string {
+	switch {
+	case errors.Is(err, server.ErrNotFound):
+		return codeNotFound
+	case errors.Is(err, server.ErrClientSuspended):
+		return codeClientSuspended
+	default:
+		return codeError
+	}
+}
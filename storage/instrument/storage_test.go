@@ -0,0 +1,198 @@
+package instrument
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ego-component/eoauth2/server"
+	"github.com/ego-component/eoauth2/server/model"
+	"github.com/ego-component/eoauth2/storage/memstorage"
+	"github.com/ego-component/eoauth2/storage/storagetest"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestStorage(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) *storagetest.Harness {
+		client := &server.DefaultClient{Id: "storagetest", Secret: "secret", RedirectUri: "http://localhost/callback"}
+		mem := memstorage.NewStorage(memstorage.WithClients(client), memstorage.WithCleanupInterval(0))
+		s := NewStorage(mem, WithName("storagetest"))
+		t.Cleanup(s.Close)
+		return &storagetest.Harness{
+			Storage:           s,
+			Client:            client,
+			RemoveParentToken: mem.RemoveParentToken,
+		}
+	})
+}
+
+// suspendedStorage client id为suspended的客户端被暂停
+type suspendedStorage struct {
+	*memstorage.Storage
+}
+
+func (s *suspendedStorage) GetClient(ctx context.Context, clientId string) (server.Client, error) {
+	if clientId == "suspended" {
+		return nil, server.ErrClientSuspended
+	}
+	return s.Storage.GetClient(ctx, clientId)
+}
+
+// newRecordedStorage 使用内存的span recorder记录链路
+func newRecordedStorage(t *testing.T, name string) (*Storage, *tracetest.SpanRecorder) {
+	recorder := tracetest.NewSpanRecorder()
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+
+	client := &server.DefaultClient{Id: "instrumenttest", Secret: "secret", RedirectUri: "http://localhost/callback"}
+	mem := memstorage.NewStorage(memstorage.WithClients(client), memstorage.WithCleanupInterval(0))
+	s := NewStorage(&suspendedStorage{Storage: mem}, WithName(name))
+	t.Cleanup(s.Close)
+	return s, recorder
+}
+
+func histogramCount(t *testing.T, name string, method string) uint64 {
+	t.Helper()
+	metric := &dto.Metric{}
+	if err := handleHistogram.WithLabelValues(name, method).(prometheus.Metric).Write(metric); err != nil {
+		t.Fatal(err)
+	}
+	return metric.GetHistogram().GetSampleCount()
+}
+
+func errorCount(t *testing.T, name string, method string, code string) float64 {
+	t.Helper()
+	metric := &dto.Metric{}
+	if err := errorCounter.WithLabelValues(name, method, code).Write(metric); err != nil {
+		t.Fatal(err)
+	}
+	return metric.GetCounter().GetValue()
+}
+
+func TestMetrics(t *testing.T) {
+	s, _ := newRecordedStorage(t, "metricstest")
+	ctx := context.Background()
+
+	if _, err := s.GetClient(ctx, "instrumenttest"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.GetClient(ctx, "missing"); !errors.Is(err, server.ErrNotFound) {
+		t.Fatalf("get missing client, want ErrNotFound, got: %v", err)
+	}
+	if _, err := s.GetClient(ctx, "suspended"); !errors.Is(err, server.ErrClientSuspended) {
+		t.Fatalf("get suspended client, want ErrClientSuspended, got: %v", err)
+	}
+	if _, err := s.LoadAccess(ctx, "missing"); err == nil {
+		t.Fatal("load missing access should fail")
+	}
+
+	if n := histogramCount(t, "metricstest", "GetClient"); n != 3 {
+		t.Errorf("GetClient histogram count, want: 3, got: %d", n)
+	}
+	if n := histogramCount(t, "metricstest", "LoadAccess"); n != 1 {
+		t.Errorf("LoadAccess histogram count, want: 1, got: %d", n)
+	}
+	for _, c := range []struct {
+		method string
+		code   string
+		want   float64
+	}{
+		{"GetClient", codeNotFound, 1},
+		{"GetClient", codeClientSuspended, 1},
+		{"GetClient", codeError, 0},
+		{"LoadAccess", codeNotFound, 1},
+	} {
+		if n := errorCount(t, "metricstest", c.method, c.code); n != c.want {
+			t.Errorf("%s %s error count, want: %v, got: %v", c.method, c.code, c.want, n)
+		}
+	}
+}
+
+func TestSpans(t *testing.T) {
+	s, recorder := newRecordedStorage(t, "spantest")
+	ctx := context.Background()
+	client, err := s.GetClient(ctx, "instrumenttest")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	authorize := &server.AuthorizeData{
+		Client:      client,
+		Code:        model.NewToken(0).Token,
+		ExpiresIn:   600,
+		RedirectUri: client.GetRedirectUri(),
+		CreatedAt:   time.Now(),
+	}
+	if err = s.SaveAuthorize(ctx, authorize); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := s.LoadAuthorize(ctx, authorize.Code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	access := &server.AccessData{
+		Client:         client,
+		AuthorizeData:  loaded,
+		AccessToken:    model.NewToken(0).Token,
+		RefreshToken:   model.NewToken(0).Token,
+		TokenExpiresIn: 3600,
+		RedirectUri:    client.GetRedirectUri(),
+		CreatedAt:      time.Now(),
+	}
+	if err = s.SaveAccess(ctx, access); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = s.LoadAccess(ctx, access.AccessToken); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = s.LoadRefresh(ctx, access.RefreshToken); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = s.LoadAccess(ctx, "missing"); err == nil {
+		t.Fatal("load missing access should fail")
+	}
+
+	secrets := []string{authorize.Code, access.AccessToken, access.RefreshToken, "secret"}
+	methods := make(map[string]bool)
+	for _, span := range recorder.Ended() {
+		attributes := make(map[string]string)
+		for _, attribute := range span.Attributes() {
+			value := attribute.Value.Emit()
+			attributes[string(attribute.Key)] = value
+			for _, secret := range secrets {
+				if strings.Contains(value, secret) {
+					t.Errorf("span %s attribute %s contains credential", span.Name(), attribute.Key)
+				}
+			}
+		}
+		method := attributes["oauth2.storage.method"]
+		methods[method] = true
+		if span.Name() != "oauth2.storage."+method {
+			t.Errorf("span name, want: oauth2.storage.%s, got: %s", method, span.Name())
+		}
+		if attributes["oauth2.storage.name"] != "spantest" {
+			t.Errorf("span %s storage name, got: %s", span.Name(), attributes["oauth2.storage.name"])
+		}
+		if code, ok := attributes["oauth2.storage.code"]; ok {
+			if code != codeNotFound {
+				t.Errorf("span %s code, want: %s, got: %s", span.Name(), codeNotFound, code)
+			}
+			continue
+		}
+		if attributes["oauth2.client_id"] != "instrumenttest" {
+			t.Errorf("span %s client id, want: instrumenttest, got: %s", span.Name(), attributes["oauth2.client_id"])
+		}
+	}
+	for _, method := range []string{"GetClient", "SaveAuthorize", "LoadAuthorize", "SaveAccess", "LoadAccess", "LoadRefresh"} {
+		if !methods[method] {
+			t.Errorf("missing span for %s", method)
+		}
+	}
+}
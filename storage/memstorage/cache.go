@@ -0,0 +1,106 @@
+package memstorage
+
+import (
+	"container/list"
+	"time"
+)
+
+// cacheItem 缓存里的一条数据，expireAt为零值表示不过期
+type cacheItem struct {
+	key      string
+	value    interface{}
+	expireAt time.Time
+}
+
+// cache 有容量上限的LRU缓存，数据到期后不会再被读到，由 removeExpired 定期清理
+// cache 本身不加锁，由 Storage 的锁保护
+type cache struct {
+	maxEntries int // 最多保存的条数，0表示不限制
+	ll         *list.List
+	items      map[string]*list.Element
+}
+
+func newCache(maxEntries int) *cache {
+	return &cache{
+		maxEntries: maxEntries,
+		ll:         list.New(),
+		items:      make(map[string]*list.Element),
+	}
+}
+
+// set 写入数据，超过容量时淘汰最久没有使用的数据
+func (c *cache) set(key string, value interface{}, expireAt time.Time) {
+	if element, ok := c.items[key]; ok {
+		item := element.Value.(*cacheItem)
+		item.value = value
+		item.expireAt = expireAt
+		c.ll.MoveToFront(element)
+		return
+	}
+	c.items[key] = c.ll.PushFront(&cacheItem{key: key, value: value, expireAt: expireAt})
+	for c.maxEntries > 0 && c.ll.Len() > c.maxEntries {
+		c.removeElement(c.ll.Back())
+	}
+}
+
+// get 读取数据，数据已经过期时删除并返回false
+func (c *cache) get(key string, now time.Time) (interface{}, bool) {
+	element, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	item := element.Value.(*cacheItem)
+	if item.expired(now) {
+		c.removeElement(element)
+		return nil, false
+	}
+	c.ll.MoveToFront(element)
+	return item.value, true
+}
+
+// remove 删除数据，返回删除前的数据
+func (c *cache) remove(key string) (interface{}, bool) {
+	element, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	c.removeElement(element)
+	return element.Value.(*cacheItem).value, true
+}
+
+// removeExpired 删除所有过期的数据，返回删除的条数
+func (c *cache) removeExpired(now time.Time) (cnt int) {
+	for element := c.ll.Back(); element != nil; {
+		prev := element.Prev()
+		if element.Value.(*cacheItem).expired(now) {
+			c.removeElement(element)
+			cnt++
+		}
+		element = prev
+	}
+	return cnt
+}
+
+// each 从最久没有使用的数据开始遍历没有过期的数据，遍历时不能修改缓存
+func (c *cache) each(now time.Time, fn func(item *cacheItem)) {
+	for element := c.ll.Back(); element != nil; element = element.Prev() {
+		item := element.Value.(*cacheItem)
+		if item.expired(now) {
+			continue
+		}
+		fn(item)
+	}
+}
+
+func (c *cache) len() int {
+	return c.ll.Len()
+}
+
+func (c *cache) removeElement(element *list.Element) {
+	c.ll.Remove(element)
+	delete(c.items, element.Value.(*cacheItem).key)
+}
+
+func (i *cacheItem) expired(now time.Time) bool {
+	return !i.expireAt.IsZero() && !now.Before(i.expireAt)
+}
@@ -0,0 +1,34 @@
+package memstorage
+
+import (
+	"time"
+
+	"github.com/ego-component/eoauth2/server"
+)
+
+// Option 可选项
+type Option func(s *Storage)
+
+// WithMaxEntries code、access token、refresh token、parent token各自最多保存的条数，默认100000，0表示不限制
+// 超过上限时淘汰最久没有使用的数据
+func WithMaxEntries(maxEntries int) Option {
+	return func(s *Storage) {
+		s.maxEntries = maxEntries
+	}
+}
+
+// WithCleanupInterval 后台清理过期数据的间隔，默认1分钟，0表示不在后台清理，过期数据在读取时删除
+func WithCleanupInterval(interval time.Duration) Option {
+	return func(s *Storage) {
+		s.cleanupInterval = interval
+	}
+}
+
+// WithClients 初始化客户端
+func WithClients(clients ...server.Client) Option {
+	return func(s *Storage) {
+		for _, client := range clients {
+			s.clients[client.GetId()] = client
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package memstorage
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/ego-component/eoauth2/server"
+	"github.com/ego-component/eoauth2/server/model"
+)
+
+var _ server.RegistrationStorage = (*Storage)(nil)
+
+// CreateInitialAccessToken 创建动态注册客户端使用的initial access token，expiration为0表示不过期
+func (s *Storage) CreateInitialAccessToken(ctx context.Context, expiration time.Duration) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	token := model.NewToken(0).Token
+	var expireAt time.Time
+	if expiration > 0 {
+		expireAt = time.Now().Add(expiration)
+	}
+	s.initialAccessTokens[token] = expireAt
+	return token, nil
+}
+
+// ValidateInitialAccessToken 校验initial access token
+func (s *Storage) ValidateInitialAccessToken(ctx context.Context, token string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	expireAt, ok := s.initialAccessTokens[token]
+	if ok && !expireAt.IsZero() && !expireAt.After(time.Now()) {
+		delete(s.initialAccessTokens, token)
+		ok = false
+	}
+	if !ok {
+		return fmt.Errorf("mem storage ValidateInitialAccessToken failed, err: %w", server.ErrNotFound)
+	}
+	return nil
+}
+
+// CreateRegisteredClient 保存动态注册的客户端，同时注册为oauth2客户端，密钥只保存hash
+func (s *Storage) CreateRegisteredClient(ctx context.Context, client *server.RegisteredClient) error {
+	oauthClient := &server.DefaultClient{}
+	if err := setRegisteredClient(oauthClient, client); err != nil {
+		return fmt.Errorf("mem storage CreateRegisteredClient failed, err: %w", err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.registeredClients[client.ClientId] = copyRegisteredClient(client)
+	s.clients[client.ClientId] = oauthClient
+	return nil
+}
+
+// GetRegisteredClient 获取动态注册的客户端，不包含明文密钥
+func (s *Storage) GetRegisteredClient(ctx context.Context, clientId string) (*server.RegisteredClient, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	client, ok := s.registeredClients[clientId]
+	if !ok {
+		return nil, fmt.Errorf("mem storage GetRegisteredClient failed, client: %s, err: %w", clientId, server.ErrNotFound)
+	}
+	return copyRegisteredClient(client), nil
+}
+
+// UpdateRegisteredClient 更新动态注册的客户端，变为公共客户端时退役所有密钥
+func (s *Storage) UpdateRegisteredClient(ctx context.Context, client *server.RegisteredClient) error {
+	s.mu.Lock()
+	oauthClient := &server.DefaultClient{}
+	if old, ok := s.clients[client.ClientId]; ok {
+		oauthClient.CopyFrom(old)
+	}
+	s.mu.Unlock()
+	if err := setRegisteredClient(oauthClient, client); err != nil {
+		return fmt.Errorf("mem storage UpdateRegisteredClient failed, err: %w", err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.registeredClients[client.ClientId]; !ok {
+		return fmt.Errorf("mem storage UpdateRegisteredClient failed, client: %s, err: %w", client.ClientId, server.ErrNotFound)
+	}
+	s.registeredClients[client.ClientId] = copyRegisteredClient(client)
+	s.clients[client.ClientId] = oauthClient
+	return nil
+}
+
+// DeleteRegisteredClient 删除动态注册的客户端，客户端下发的token在读取时返回ErrNotFound
+func (s *Storage) DeleteRegisteredClient(ctx context.Context, clientId string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.registeredClients[clientId]; !ok {
+		return fmt.Errorf("mem storage DeleteRegisteredClient failed, client: %s, err: %w", clientId, server.ErrNotFound)
+	}
+	delete(s.registeredClients, clientId)
+	delete(s.clients, clientId)
+	return nil
+}
+
+// setRegisteredClient 把动态注册的客户端信息写入oauth2客户端，有新下发的密钥时替换原有密钥
+func setRegisteredClient(oauthClient *server.DefaultClient, client *server.RegisteredClient) error {
+	oauthClient.Id = client.ClientId
+	oauthClient.RedirectUri = client.RedirectUri
+	oauthClient.Secret = ""
+	if client.Metadata.TokenEndpointAuthMethod == server.AUTH_METHOD_NONE {
+		oauthClient.Public = true
+		oauthClient.Secrets = nil
+		return nil
+	}
+	oauthClient.Public = false
+	if client.ClientSecret == "" {
+		return nil
+	}
+	hash, err := server.HashClientSecret(client.ClientSecret)
+	if err != nil {
+		return err
+	}
+	oauthClient.Secrets = []server.ClientSecret{{Hash: hash, ExpiresAt: client.ClientSecretExpiresAt}}
+	return nil
+}
+
+// copyRegisteredClient 复制客户端信息，不保存明文密钥
+func copyRegisteredClient(client *server.RegisteredClient) *server.RegisteredClient {
+	ret := *client
+	ret.ClientSecret = ""
+	return &ret
+}
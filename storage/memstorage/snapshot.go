@@ -0,0 +1,184 @@
+package memstorage
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/ego-component/eoauth2/server/model"
+)
+
+// snapshotVersion 快照格式的版本
+const snapshotVersion = 1
+
+// Snapshot 内存数据的快照，只包含没有过期的数据
+// 客户端、UserData不在快照里，客户端需要在导入之前通过 WithClients、SetClient 设置
+type Snapshot struct {
+	Version      int                  `json:"version"`
+	CreatedAt    int64                `json:"createdAt"`
+	Authorizes   []*AuthorizeRecord   `json:"authorizes"`
+	Accesses     []*AccessRecord      `json:"accesses"`
+	Refreshes    []*RefreshRecord     `json:"refreshes"`
+	ParentTokens []*ParentTokenRecord `json:"parentTokens"`
+}
+
+// AuthorizeRecord 存储的code信息
+type AuthorizeRecord struct {
+	ClientId             string            `json:"clientId"`
+	Code                 string            `json:"code"`
+	ExpiresIn            int64             `json:"expiresIn"`
+	ParentTokenExpiresIn int64             `json:"parentTokenExpiresIn"`
+	Scope                string            `json:"scope"`
+	RedirectUri          string            `json:"redirectUri"`
+	State                string            `json:"state"`
+	CreatedAt            int64             `json:"createdAt"`
+	CodeChallenge        string            `json:"codeChallenge"`
+	CodeChallengeMethod  string            `json:"codeChallengeMethod"`
+	SsoData              model.ParentToken `json:"ssoData"`
+	ExpireAt             int64             `json:"expireAt"` // 过期时间戳，0表示不过期
+	UserData             interface{}       `json:"-"`
+}
+
+// AccessRecord 存储的access token信息，单点登录下为sub token
+type AccessRecord struct {
+	ClientId              string         `json:"clientId"`
+	AccessToken           string         `json:"accessToken"`
+	RefreshToken          string         `json:"refreshToken"`
+	AuthorizeCode         string         `json:"authorizeCode"`
+	PreviousToken         string         `json:"previousToken"`
+	ParentToken           string         `json:"parentToken"` // 单点登录的parent token，为空表示不属于任何parent token
+	TokenExpiresIn        int64          `json:"tokenExpiresIn"`
+	ParentTokenExpiresIn  int64          `json:"parentTokenExpiresIn"`
+	RefreshTokenExpiresIn int64          `json:"refreshTokenExpiresIn"`
+	Scope                 string         `json:"scope"`
+	RedirectUri           string         `json:"redirectUri"`
+	CreatedAt             int64          `json:"createdAt"`
+	TokenData             model.SubToken `json:"tokenData"`
+	ExpireAt              int64          `json:"expireAt"` // 过期时间戳，有refresh token时为refresh token的过期时间，0表示不过期
+	UserData              interface{}    `json:"-"`
+}
+
+// RefreshRecord 存储的refresh token信息
+type RefreshRecord struct {
+	RefreshToken string `json:"refreshToken"`
+	AccessToken  string `json:"accessToken"`
+	ExpireAt     int64  `json:"expireAt"`
+}
+
+// ParentTokenRecord 存储的parent token信息
+type ParentTokenRecord struct {
+	Token     model.Token                     `json:"token"`
+	Uids      []int64                         `json:"uids"`  // 登录的账号，单账号只有一个
+	Users     map[int64]model.ParentTokenData `json:"users"` // 账号的登录信息
+	SubTokens map[string]struct{}             `json:"subTokens"`
+	ExpireAt  int64                           `json:"expireAt"` // 过期时间戳，0表示不过期
+}
+
+// Snapshot 导出没有过期的数据
+func (s *Storage) Snapshot() *Snapshot {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	snapshot := &Snapshot{
+		Version:      snapshotVersion,
+		CreatedAt:    now.Unix(),
+		Authorizes:   make([]*AuthorizeRecord, 0, s.authorizes.len()),
+		Accesses:     make([]*AccessRecord, 0, s.accesses.len()),
+		Refreshes:    make([]*RefreshRecord, 0, s.refreshes.len()),
+		ParentTokens: make([]*ParentTokenRecord, 0, s.parentTokens.len()),
+	}
+	s.authorizes.each(now, func(item *cacheItem) {
+		record := *item.value.(*AuthorizeRecord)
+		snapshot.Authorizes = append(snapshot.Authorizes, &record)
+	})
+	s.accesses.each(now, func(item *cacheItem) {
+		record := *item.value.(*AccessRecord)
+		snapshot.Accesses = append(snapshot.Accesses, &record)
+	})
+	s.refreshes.each(now, func(item *cacheItem) {
+		snapshot.Refreshes = append(snapshot.Refreshes, &RefreshRecord{
+			RefreshToken: item.key,
+			AccessToken:  item.value.(string),
+			ExpireAt:     unixOf(item.expireAt),
+		})
+	})
+	s.parentTokens.each(now, func(item *cacheItem) {
+		snapshot.ParentTokens = append(snapshot.ParentTokens, item.value.(*ParentTokenRecord).clone())
+	})
+	return snapshot
+}
+
+// Restore 导入快照，已经过期的数据会被忽略，快照里的数据覆盖已有的同名数据
+func (s *Storage) Restore(snapshot *Snapshot) error {
+	if snapshot.Version != snapshotVersion {
+		return fmt.Errorf("mem storage Restore failed, unsupported snapshot version: %d", snapshot.Version)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now().Unix()
+	alive := func(expireAt int64) bool {
+		return expireAt == 0 || expireAt > now
+	}
+	// 快照按照最久没有使用的顺序导出，按照同样的顺序导入可以保留LRU顺序
+	for _, record := range snapshot.ParentTokens {
+		if alive(record.ExpireAt) {
+			s.parentTokens.set(record.Token.Token, record.clone(), expireTime(record.ExpireAt))
+		}
+	}
+	for _, value := range snapshot.Authorizes {
+		if record := *value; alive(record.ExpireAt) {
+			s.authorizes.set(record.Code, &record, expireTime(record.ExpireAt))
+		}
+	}
+	for _, value := range snapshot.Accesses {
+		if record := *value; alive(record.ExpireAt) {
+			s.accesses.set(record.AccessToken, &record, expireTime(record.ExpireAt))
+		}
+	}
+	for _, record := range snapshot.Refreshes {
+		if alive(record.ExpireAt) {
+			s.refreshes.set(record.RefreshToken, record.AccessToken, expireTime(record.ExpireAt))
+		}
+	}
+	return nil
+}
+
+// Export 将快照以json格式写入w
+func (s *Storage) Export(w io.Writer) error {
+	if err := json.NewEncoder(w).Encode(s.Snapshot()); err != nil {
+		return fmt.Errorf("mem storage Export failed, err: %w", err)
+	}
+	return nil
+}
+
+// Import 从r读取json格式的快照并导入
+func (s *Storage) Import(r io.Reader) error {
+	snapshot := &Snapshot{}
+	if err := json.NewDecoder(r).Decode(snapshot); err != nil {
+		return fmt.Errorf("mem storage Import failed, err: %w", err)
+	}
+	return s.Restore(snapshot)
+}
+
+func (r *ParentTokenRecord) clone() *ParentTokenRecord {
+	record := *r
+	record.Uids = append([]int64(nil), r.Uids...)
+	record.Users = make(map[int64]model.ParentTokenData, len(r.Users))
+	for uid, data := range r.Users {
+		record.Users[uid] = data
+	}
+	record.SubTokens = make(map[string]struct{}, len(r.SubTokens))
+	for subToken := range r.SubTokens {
+		record.SubTokens[subToken] = struct{}{}
+	}
+	return &record
+}
+
+// unixOf time转换为过期时间戳，零值表示不过期
+func unixOf(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.Unix()
+}
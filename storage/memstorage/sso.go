@@ -0,0 +1,72 @@
+package memstorage
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/ego-component/eoauth2/server"
+)
+
+// GetUidsByParentToken 获取parent token下登录的所有账号
+func (s *Storage) GetUidsByParentToken(ctx context.Context, pToken string) ([]int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	value, ok := s.parentTokens.get(pToken, time.Now())
+	if !ok {
+		return nil, fmt.Errorf("mem storage GetUidsByParentToken failed, err: %w", server.ErrNotFound)
+	}
+	record := value.(*ParentTokenRecord)
+	uids := make([]int64, len(record.Uids))
+	copy(uids, record.Uids)
+	return uids, nil
+}
+
+// GetUidsByToken 通过sub token获取parent token下登录的所有账号
+func (s *Storage) GetUidsByToken(ctx context.Context, token string) ([]int64, error) {
+	pToken, err := s.GetParentToken(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+	return s.GetUidsByParentToken(ctx, pToken)
+}
+
+// GetParentToken 获取sub token对应的parent token
+func (s *Storage) GetParentToken(ctx context.Context, token string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	record, ok := s.getAccessRecord(token, time.Now())
+	if !ok || record.ParentToken == "" {
+		return "", fmt.Errorf("mem storage GetParentToken failed, err: %w", server.ErrNotFound)
+	}
+	return record.ParentToken, nil
+}
+
+// RemoveAllAccess 通过sub token退出登录，删除parent token以及下面所有的sub token
+func (s *Storage) RemoveAllAccess(ctx context.Context, token string) error {
+	pToken, err := s.GetParentToken(ctx, token)
+	if err != nil {
+		return err
+	}
+	return s.RemoveParentToken(ctx, pToken)
+}
+
+// RemoveParentToken 删除parent token以及下面所有的sub token、refresh token
+func (s *Storage) RemoveParentToken(ctx context.Context, pToken string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	value, ok := s.parentTokens.remove(pToken)
+	if !ok {
+		return nil
+	}
+	for subToken := range value.(*ParentTokenRecord).SubTokens {
+		accessValue, ok := s.accesses.remove(subToken)
+		if !ok {
+			continue
+		}
+		if refreshToken := accessValue.(*AccessRecord).RefreshToken; refreshToken != "" {
+			s.refreshes.remove(refreshToken)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,371 @@
+// Package memstorage 内存存储，实现了 server.Storage，包括单点登录的parent token、sub token
+// 适用于单机部署以及基于eoauth2的业务代码的单元测试，重启后数据丢失，可以通过 Export、Import 保存快照
+package memstorage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ego-component/eoauth2/server"
+	"github.com/ego-component/eoauth2/server/model"
+)
+
+// Storage 内存存储，并发安全
+type Storage struct {
+	mu                  sync.Mutex
+	clients             map[string]server.Client
+	registeredClients   map[string]*server.RegisteredClient // 动态注册的客户端
+	initialAccessTokens map[string]time.Time                // initial access token => 过期时间，零值表示不过期
+	authorizes          *cache                              // code => *AuthorizeRecord
+	accesses            *cache                              // access token => *AccessRecord
+	refreshes           *cache                              // refresh token => access token
+	parentTokens        *cache                              // parent token => *ParentTokenRecord
+	maxEntries          int
+	cleanupInterval     time.Duration
+	closeOnce           sync.Once
+	closed              chan struct{}
+}
+
+var _ server.Storage = (*Storage)(nil)
+
+// NewStorage returns a new memory storage instance.
+func NewStorage(options ...Option) *Storage {
+	s := &Storage{
+		clients:             make(map[string]server.Client),
+		registeredClients:   make(map[string]*server.RegisteredClient),
+		initialAccessTokens: make(map[string]time.Time),
+		maxEntries:          100000,
+		cleanupInterval:     time.Minute,
+		closed:              make(chan struct{}),
+	}
+	for _, option := range options {
+		option(s)
+	}
+	s.authorizes = newCache(s.maxEntries)
+	s.accesses = newCache(s.maxEntries)
+	s.refreshes = newCache(s.maxEntries)
+	s.parentTokens = newCache(s.maxEntries)
+	if s.cleanupInterval > 0 {
+		go s.cleanup()
+	}
+	return s
+}
+
+// Clone the storage if needed. For example, using mgo, you can clone the session with session.Clone
+// to avoid concurrent access problems.
+// This is to avoid cloning the connection at each method access.
+// Can return itself if not a problem.
+func (s *Storage) Clone() server.Storage {
+	return s
+}
+
+// Close 停止后台清理，Close之后存储仍然可以读写
+func (s *Storage) Close() {
+	s.closeOnce.Do(func() {
+		close(s.closed)
+	})
+}
+
+// SetClient 添加或者更新客户端
+func (s *Storage) SetClient(client server.Client) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.clients[client.GetId()] = client
+}
+
+// RemoveClient 删除客户端，客户端下发的token在读取时返回ErrNotFound
+func (s *Storage) RemoveClient(clientId string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.clients, clientId)
+}
+
+// GetClient loads the client by id
+func (s *Storage) GetClient(ctx context.Context, clientId string) (server.Client, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.getClient(clientId)
+}
+
+func (s *Storage) getClient(clientId string) (server.Client, error) {
+	client, ok := s.clients[clientId]
+	if !ok {
+		return nil, fmt.Errorf("mem storage GetClient failed, client: %s, err: %w", clientId, server.ErrNotFound)
+	}
+	return client, nil
+}
+
+// SaveAuthorize saves authorize data.
+// 单点登录，会创建parent token，多账号登录时在已有的parent token里加上账号
+func (s *Storage) SaveAuthorize(ctx context.Context, data *server.AuthorizeData) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	if data.SsoData.Token.Token != "" {
+		s.saveParentToken(data.SsoData, now)
+	}
+	record := &AuthorizeRecord{
+		ClientId:             data.Client.GetId(),
+		Code:                 data.Code,
+		ExpiresIn:            data.ExpiresIn,
+		ParentTokenExpiresIn: data.ParentTokenExpiresIn,
+		Scope:                data.Scope,
+		RedirectUri:          data.RedirectUri,
+		State:                data.State,
+		CreatedAt:            data.CreatedAt.Unix(),
+		CodeChallenge:        data.CodeChallenge,
+		CodeChallengeMethod:  data.CodeChallengeMethod,
+		SsoData:              data.SsoData,
+		ExpireAt:             data.ExpireAt().Unix(),
+		UserData:             data.UserData,
+	}
+	s.authorizes.set(data.Code, record, time.Unix(record.ExpireAt, 0))
+	return nil
+}
+
+// LoadAuthorize looks up AuthorizeData by a code.
+// Client information MUST be loaded together.
+// Optionally can return error if expired.
+func (s *Storage) LoadAuthorize(ctx context.Context, code string) (*server.AuthorizeData, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	value, ok := s.authorizes.get(code, time.Now())
+	if !ok {
+		return nil, fmt.Errorf("mem storage LoadAuthorize failed, err: %w", server.ErrNotFound)
+	}
+	record := value.(*AuthorizeRecord)
+	client, err := s.getClient(record.ClientId)
+	if err != nil {
+		return nil, err
+	}
+	return &server.AuthorizeData{
+		Client:               client,
+		Code:                 record.Code,
+		ExpiresIn:            record.ExpiresIn,
+		ParentTokenExpiresIn: record.ParentTokenExpiresIn,
+		Scope:                record.Scope,
+		RedirectUri:          record.RedirectUri,
+		State:                record.State,
+		CreatedAt:            time.Unix(record.CreatedAt, 0),
+		UserData:             record.UserData,
+		CodeChallenge:        record.CodeChallenge,
+		CodeChallengeMethod:  record.CodeChallengeMethod,
+		SsoData:              record.SsoData,
+	}, nil
+}
+
+// RemoveAuthorize revokes or deletes the authorization code.
+func (s *Storage) RemoveAuthorize(ctx context.Context, code string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.authorizes.remove(code)
+	return nil
+}
+
+// SaveAccess writes AccessData.
+// If RefreshToken is not blank, it must save in a way that can be loaded using LoadRefresh.
+// 单点登录下，access token作为sub token挂在parent token下，parent token不存在时返回ErrNotFound
+func (s *Storage) SaveAccess(ctx context.Context, data *server.AccessData) error {
+	if data.Client == nil {
+		return errors.New("data.Client must not be nil")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+
+	record := &AccessRecord{
+		ClientId:              data.Client.GetId(),
+		AccessToken:           data.AccessToken,
+		RefreshToken:          data.RefreshToken,
+		TokenExpiresIn:        data.TokenExpiresIn,
+		ParentTokenExpiresIn:  data.ParentTokenExpiresIn,
+		RefreshTokenExpiresIn: data.RefreshTokenExpiresIn,
+		Scope:                 data.Scope,
+		RedirectUri:           data.RedirectUri,
+		CreatedAt:             data.CreatedAt.Unix(),
+		TokenData:             data.TokenData,
+		ExpireAt:              data.ExpireAt().Unix(),
+		UserData:              data.UserData,
+	}
+	// 如果是authorize token，parent token在code里
+	// 如果是refresh token，沿用老token的parent token
+	if data.AuthorizeData != nil {
+		record.AuthorizeCode = data.AuthorizeData.Code
+		record.ParentToken = data.AuthorizeData.SsoData.Token.Token
+	}
+	if data.AccessData != nil {
+		record.PreviousToken = data.AccessData.AccessToken
+		if value, ok := s.accesses.get(data.AccessData.AccessToken, now); ok {
+			record.ParentToken = value.(*AccessRecord).ParentToken
+		}
+	}
+	if record.ParentToken != "" {
+		value, ok := s.parentTokens.get(record.ParentToken, now)
+		if !ok {
+			return fmt.Errorf("mem storage SaveAccess parent token not found, err: %w", server.ErrNotFound)
+		}
+		value.(*ParentTokenRecord).SubTokens[record.AccessToken] = struct{}{}
+	}
+
+	// refresh token有效期内需要保留access，用于换取新的token
+	if record.RefreshToken != "" {
+		if record.RefreshTokenExpiresIn <= 0 {
+			record.ExpireAt = 0
+		} else if refreshExpireAt := record.CreatedAt + record.RefreshTokenExpiresIn; refreshExpireAt > record.ExpireAt {
+			record.ExpireAt = refreshExpireAt
+		}
+		s.refreshes.set(record.RefreshToken, record.AccessToken, expireTime(record.ExpireAt))
+	}
+	s.accesses.set(record.AccessToken, record, expireTime(record.ExpireAt))
+	return nil
+}
+
+// LoadAccess retrieves access data by token. Client information MUST be loaded together.
+// AuthorizeData and AccessData DON'T NEED to be loaded if not easily available.
+// Optionally can return error if expired.
+func (s *Storage) LoadAccess(ctx context.Context, token string) (*server.AccessData, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.loadAccess(token, time.Now())
+}
+
+func (s *Storage) loadAccess(token string, now time.Time) (*server.AccessData, error) {
+	record, ok := s.getAccessRecord(token, now)
+	if !ok {
+		return nil, fmt.Errorf("mem storage LoadAccess failed, err: %w", server.ErrNotFound)
+	}
+	client, err := s.getClient(record.ClientId)
+	if err != nil {
+		return nil, err
+	}
+	return &server.AccessData{
+		Client:                client,
+		AccessToken:           record.AccessToken,
+		RefreshToken:          record.RefreshToken,
+		TokenExpiresIn:        record.TokenExpiresIn,
+		ParentTokenExpiresIn:  record.ParentTokenExpiresIn,
+		RefreshTokenExpiresIn: record.RefreshTokenExpiresIn,
+		Scope:                 record.Scope,
+		RedirectUri:           record.RedirectUri,
+		CreatedAt:             time.Unix(record.CreatedAt, 0),
+		UserData:              record.UserData,
+		TokenData:             record.TokenData,
+	}, nil
+}
+
+// getAccessRecord 获取access token，单点登录下parent token已经退出或者过期时，sub token也失效
+func (s *Storage) getAccessRecord(token string, now time.Time) (*AccessRecord, bool) {
+	value, ok := s.accesses.get(token, now)
+	if !ok {
+		return nil, false
+	}
+	record := value.(*AccessRecord)
+	if record.ParentToken != "" {
+		if _, ok = s.parentTokens.get(record.ParentToken, now); !ok {
+			s.removeAccess(record)
+			return nil, false
+		}
+	}
+	return record, true
+}
+
+// RemoveAccess revokes or deletes an AccessData.
+func (s *Storage) RemoveAccess(ctx context.Context, token string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if value, ok := s.accesses.get(token, time.Now()); ok {
+		s.removeAccess(value.(*AccessRecord))
+	}
+	return nil
+}
+
+// removeAccess 删除access token，以及parent token里的sub token
+func (s *Storage) removeAccess(record *AccessRecord) {
+	s.accesses.remove(record.AccessToken)
+	if record.ParentToken == "" {
+		return
+	}
+	if value, ok := s.parentTokens.get(record.ParentToken, time.Now()); ok {
+		delete(value.(*ParentTokenRecord).SubTokens, record.AccessToken)
+	}
+}
+
+// LoadRefresh retrieves refresh AccessData. Client information MUST be loaded together.
+// AuthorizeData and AccessData DON'T NEED to be loaded if not easily available.
+// Optionally can return error if expired.
+func (s *Storage) LoadRefresh(ctx context.Context, token string) (*server.AccessData, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	value, ok := s.refreshes.get(token, now)
+	if !ok {
+		return nil, fmt.Errorf("mem storage LoadRefresh failed, err: %w", server.ErrNotFound)
+	}
+	return s.loadAccess(value.(string), now)
+}
+
+// RemoveRefresh revokes or deletes refresh AccessData.
+func (s *Storage) RemoveRefresh(ctx context.Context, token string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.refreshes.remove(token)
+	return nil
+}
+
+// saveParentToken 创建parent token，parent token已经存在时把账号加到parent token里
+func (s *Storage) saveParentToken(data model.ParentToken, now time.Time) {
+	if value, ok := s.parentTokens.get(data.Token.Token, now); ok {
+		record := value.(*ParentTokenRecord)
+		if _, ok = record.Users[data.Uid]; !ok {
+			record.Uids = append(record.Uids, data.Uid)
+		}
+		record.Users[data.Uid] = data.StoreData
+		return
+	}
+	record := &ParentTokenRecord{
+		Token:     data.Token,
+		Uids:      []int64{data.Uid},
+		Users:     map[int64]model.ParentTokenData{data.Uid: data.StoreData},
+		SubTokens: make(map[string]struct{}),
+	}
+	if data.Token.ExpiresIn > 0 {
+		record.ExpireAt = now.Unix() + data.Token.ExpiresIn
+	}
+	s.parentTokens.set(data.Token.Token, record, expireTime(record.ExpireAt))
+}
+
+// cleanup 定期清理过期数据
+func (s *Storage) cleanup() {
+	ticker := time.NewTicker(s.cleanupInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.closed:
+			return
+		case <-ticker.C:
+			s.RemoveExpired()
+		}
+	}
+}
+
+// RemoveExpired 清理过期数据，返回清理的条数
+func (s *Storage) RemoveExpired() (cnt int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	for _, c := range []*cache{s.authorizes, s.accesses, s.refreshes, s.parentTokens} {
+		cnt += c.removeExpired(now)
+	}
+	return cnt
+}
+
+// expireTime 过期时间戳转换为time，0表示不过期
+func expireTime(expireAt int64) time.Time {
+	if expireAt == 0 {
+		return time.Time{}
+	}
+	return time.Unix(expireAt, 0)
+}
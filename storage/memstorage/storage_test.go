@@ -0,0 +1,24 @@
+package memstorage
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ego-component/eoauth2/server"
+	"github.com/ego-component/eoauth2/storage/storagetest"
+)
+
+func TestStorage(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) *storagetest.Harness {
+		client := &server.DefaultClient{Id: "storagetest", Secret: "secret", RedirectUri: "http://localhost/callback"}
+		s := NewStorage(WithClients(client), WithCleanupInterval(0))
+		t.Cleanup(s.Close)
+		return &storagetest.Harness{
+			Storage: s,
+			Client:  client,
+			RemoveParentToken: func(ctx context.Context, pToken string) error {
+				return s.RemoveParentToken(ctx, pToken)
+			},
+		}
+	})
+}
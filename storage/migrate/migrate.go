@@ -0,0 +1,242 @@
+// Package migrate sql存储的schema版本管理，记录已经执行的版本到schema_version表，支持升级和回滚
+package migrate
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/gotomicro/ego/core/elog"
+	"github.com/gotomicro/ego/task/ejob"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// Migration 一次schema变更，Up、Down 在同一个事务里执行，并写入、删除schema_version记录
+// MySQL的DDL会隐式提交，Up、Down需要可以重复执行
+type Migration struct {
+	Version int64                // 版本号，递增，不能重复
+	Name    string               // 名称
+	Up      func(*gorm.DB) error // 升级
+	Down    func(*gorm.DB) error // 回滚，nil表示不能回滚
+}
+
+// SchemaVersion 已经执行的版本
+type SchemaVersion struct {
+	Version int64  `gorm:"not null;primaryKey;autoIncrement:false;comment:版本号" json:"version"` // 版本号
+	Name    string `gorm:"not null;default:'';comment:名称" json:"name"`                         // 名称
+	Ctime   int64  `gorm:"not null;default:0;comment:执行时间" json:"ctime"`                       // 执行时间
+}
+
+func (t *SchemaVersion) TableName() string {
+	return "schema_version"
+}
+
+// Status 版本的执行状态
+type Status struct {
+	Version   int64  `json:"version"`   // 版本号
+	Name      string `json:"name"`      // 名称
+	Applied   bool   `json:"applied"`   // 是否已经执行
+	AppliedAt int64  `json:"appliedAt"` // 执行时间
+}
+
+// Migrator 执行schema变更
+type Migrator struct {
+	db         *gorm.DB
+	migrations []Migration
+}
+
+// NewMigrator 创建Migrator，默认包含 Migrations 里的所有内置版本
+func NewMigrator(db *gorm.DB, options ...Option) *Migrator {
+	m := &Migrator{
+		db:         db,
+		migrations: Migrations(),
+	}
+	for _, option := range options {
+		option(m)
+	}
+	sort.SliceStable(m.migrations, func(i, j int) bool {
+		return m.migrations[i].Version < m.migrations[j].Version
+	})
+	return m
+}
+
+// Version 当前已经执行的最大版本号，0表示没有执行过
+func (m *Migrator) Version(ctx context.Context) (int64, error) {
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return 0, err
+	}
+	var version int64
+	for v := range applied {
+		if v > version {
+			version = v
+		}
+	}
+	return version, nil
+}
+
+// Status 所有版本的执行状态，按照版本号升序
+func (m *Migrator) Status(ctx context.Context) ([]Status, error) {
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+	list := make([]Status, 0, len(m.migrations))
+	for _, migration := range m.migrations {
+		status := Status{
+			Version: migration.Version,
+			Name:    migration.Name,
+		}
+		if info, ok := applied[migration.Version]; ok {
+			status.Applied = true
+			status.AppliedAt = info.Ctime
+		}
+		list = append(list, status)
+	}
+	return list, nil
+}
+
+// Up 执行所有没有执行的版本，返回本次执行的版本
+func (m *Migrator) Up(ctx context.Context) ([]Migration, error) {
+	return m.UpTo(ctx, 0)
+}
+
+// UpTo 执行版本号小于等于target的所有没有执行的版本，target为0表示执行到最新版本
+func (m *Migrator) UpTo(ctx context.Context, target int64) ([]Migration, error) {
+	if err := m.validate(); err != nil {
+		return nil, err
+	}
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+	done := make([]Migration, 0)
+	for _, migration := range m.migrations {
+		if target > 0 && migration.Version > target {
+			break
+		}
+		if _, ok := applied[migration.Version]; ok {
+			continue
+		}
+		err = m.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			if err := migration.Up(tx); err != nil {
+				return err
+			}
+			return tx.Create(&SchemaVersion{
+				Version: migration.Version,
+				Name:    migration.Name,
+				Ctime:   time.Now().Unix(),
+			}).Error
+		})
+		if err != nil {
+			return done, fmt.Errorf("migrate up %d %s failed, err: %w", migration.Version, migration.Name, err)
+		}
+		done = append(done, migration)
+	}
+	return done, nil
+}
+
+// Down 按照版本号倒序回滚steps个已经执行的版本，返回本次回滚的版本
+func (m *Migrator) Down(ctx context.Context, steps int) ([]Migration, error) {
+	if steps <= 0 {
+		return nil, fmt.Errorf("migrate down steps must be positive, steps: %d", steps)
+	}
+	if err := m.validate(); err != nil {
+		return nil, err
+	}
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+	versions := make([]int64, 0, len(applied))
+	for v := range applied {
+		versions = append(versions, v)
+	}
+	sort.Slice(versions, func(i, j int) bool {
+		return versions[i] > versions[j]
+	})
+
+	done := make([]Migration, 0, steps)
+	for _, version := range versions {
+		if len(done) == steps {
+			break
+		}
+		migration, ok := m.find(version)
+		if !ok {
+			return done, fmt.Errorf("migrate down %d failed, err: unknown version, upgrade eoauth2 first", version)
+		}
+		if migration.Down == nil {
+			return done, fmt.Errorf("migrate down %d %s failed, err: irreversible migration", migration.Version, migration.Name)
+		}
+		err = m.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			if err := migration.Down(tx); err != nil {
+				return err
+			}
+			return tx.Where("version = ?", migration.Version).Delete(&SchemaVersion{}).Error
+		})
+		if err != nil {
+			return done, fmt.Errorf("migrate down %d %s failed, err: %w", migration.Version, migration.Name, err)
+		}
+		done = append(done, migration)
+	}
+	return done, nil
+}
+
+// Job 执行所有没有执行的版本的ego job，通过 --job=name 运行
+func (m *Migrator) Job(name string) *ejob.Component {
+	return ejob.Job(name, func(ctx ejob.Context) error {
+		logger := elog.EgoLogger.With(elog.FieldComponent("oauth2.migrate"))
+		done, err := m.Up(ctx.Ctx)
+		for _, migration := range done {
+			logger.Info("migrate up", zap.Int64("version", migration.Version), zap.String("name", migration.Name))
+		}
+		if err != nil {
+			logger.Error("migrate up failed", elog.FieldErr(err))
+			return err
+		}
+		return nil
+	})
+}
+
+// applied 已经执行的版本，schema_version表不存在时自动创建
+func (m *Migrator) applied(ctx context.Context) (map[int64]SchemaVersion, error) {
+	db := m.db.WithContext(ctx)
+	if err := db.AutoMigrate(&SchemaVersion{}); err != nil {
+		return nil, fmt.Errorf("migrate create schema_version failed, err: %w", err)
+	}
+	list := make([]SchemaVersion, 0)
+	if err := db.Find(&list).Error; err != nil {
+		return nil, fmt.Errorf("migrate load schema_version failed, err: %w", err)
+	}
+	applied := make(map[int64]SchemaVersion, len(list))
+	for _, info := range list {
+		applied[info.Version] = info
+	}
+	return applied, nil
+}
+
+func (m *Migrator) find(version int64) (Migration, bool) {
+	for _, migration := range m.migrations {
+		if migration.Version == version {
+			return migration, true
+		}
+	}
+	return Migration{}, false
+}
+
+func (m *Migrator) validate() error {
+	for i, migration := range m.migrations {
+		if migration.Version <= 0 {
+			return fmt.Errorf("migrate version must be positive, name: %s", migration.Name)
+		}
+		if migration.Up == nil {
+			return fmt.Errorf("migrate %d %s has no up", migration.Version, migration.Name)
+		}
+		if i > 0 && m.migrations[i-1].Version == migration.Version {
+			return fmt.Errorf("migrate version duplicated, version: %d", migration.Version)
+		}
+	}
+	return nil
+}
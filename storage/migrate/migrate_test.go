@@ -0,0 +1,183 @@
+package migrate
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+)
+
+// newTestDB 使用sqlite内存数据库
+func newTestDB(t *testing.T) *gorm.DB {
+	db, err := gorm.Open(sqlite.Open("file:"+t.Name()+"?mode=memory&cache=shared"), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB.SetMaxOpenConns(1)
+	t.Cleanup(func() { _ = sqlDB.Close() })
+	return db
+}
+
+func schemaVersions(t *testing.T, db *gorm.DB) []SchemaVersion {
+	t.Helper()
+	list := make([]SchemaVersion, 0)
+	if err := db.Order("version").Find(&list).Error; err != nil {
+		t.Fatal(err)
+	}
+	return list
+}
+
+func latestVersion() int64 {
+	migrations := Migrations()
+	return migrations[len(migrations)-1].Version
+}
+
+// TestUpTwice 第二次执行没有需要执行的版本，schema_version记录不变
+func TestUpTwice(t *testing.T) {
+	db := newTestDB(t)
+	ctx := context.Background()
+	m := NewMigrator(db)
+
+	done, err := m.Up(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(done) != len(Migrations()) {
+		t.Errorf("first up, want: %d migrations, got: %d", len(Migrations()), len(done))
+	}
+	first := schemaVersions(t, db)
+	if len(first) != len(Migrations()) {
+		t.Fatalf("schema_version rows, want: %d, got: %+v", len(Migrations()), first)
+	}
+	for i, migration := range Migrations() {
+		if first[i].Version != migration.Version || first[i].Name != migration.Name || first[i].Ctime == 0 {
+			t.Errorf("schema_version row %d, want: %d %s, got: %+v", i, migration.Version, migration.Name, first[i])
+		}
+	}
+	for _, table := range []string{"app", "app_secret", "access", "parent_token", "parent_token_user"} {
+		if !db.Migrator().HasTable(table) {
+			t.Errorf("table %s should be created", table)
+		}
+	}
+	if !db.Migrator().HasColumn(&v3Access{}, "Chain") {
+		t.Error("access.chain should be created")
+	}
+
+	done, err = NewMigrator(db).Up(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(done) != 0 {
+		t.Errorf("second up should be a no-op, got: %+v", done)
+	}
+	second := schemaVersions(t, db)
+	if len(second) != len(first) {
+		t.Fatalf("schema_version rows after second up, want: %+v, got: %+v", first, second)
+	}
+	for i := range first {
+		if second[i] != first[i] {
+			t.Errorf("schema_version row %d changed, want: %+v, got: %+v", i, first[i], second[i])
+		}
+	}
+	version, err := m.Version(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != latestVersion() {
+		t.Errorf("version, want: %d, got: %d", latestVersion(), version)
+	}
+}
+
+// TestUpToAndDown 升级到指定版本、回滚之后再升级
+func TestUpToAndDown(t *testing.T) {
+	db := newTestDB(t)
+	ctx := context.Background()
+	m := NewMigrator(db)
+
+	if _, err := m.UpTo(ctx, 2); err != nil {
+		t.Fatal(err)
+	}
+	if version, _ := m.Version(ctx); version != 2 {
+		t.Errorf("version after up to 2, want: 2, got: %d", version)
+	}
+	list, err := m.Status(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, status := range list {
+		if want := status.Version <= 2; status.Applied != want {
+			t.Errorf("status of %d, want applied: %v, got: %+v", status.Version, want, status)
+		}
+	}
+
+	done, err := m.Up(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(done) != 1 || done[0].Version != 3 {
+		t.Errorf("up after up to 2, want only version 3, got: %+v", done)
+	}
+
+	done, err = m.Down(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(done) != 1 || done[0].Version != 3 {
+		t.Errorf("down 1, want version 3, got: %+v", done)
+	}
+	if version, _ := m.Version(ctx); version != 2 {
+		t.Errorf("version after down, want: 2, got: %d", version)
+	}
+	if db.Migrator().HasColumn(&v3Access{}, "Chain") {
+		t.Error("access.chain should be dropped")
+	}
+	if _, err = m.Up(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if version, _ := m.Version(ctx); version != 3 {
+		t.Errorf("version after up again, want: 3, got: %d", version)
+	}
+}
+
+// TestWithMigrations 业务自己的版本只执行一次，失败时不写入schema_version
+func TestWithMigrations(t *testing.T) {
+	db := newTestDB(t)
+	ctx := context.Background()
+	cnt := 0
+	custom := Migration{Version: 10000, Name: "custom", Up: func(tx *gorm.DB) error {
+		cnt++
+		return nil
+	}}
+	for i := 0; i < 2; i++ {
+		if _, err := NewMigrator(db, WithMigrations(custom)).Up(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if cnt != 1 {
+		t.Errorf("custom migration should run once, got: %d", cnt)
+	}
+	if version, _ := NewMigrator(db).Version(ctx); version != 10000 {
+		t.Errorf("version, want: 10000, got: %d", version)
+	}
+
+	failed := Migration{Version: 10001, Name: "failed", Up: func(tx *gorm.DB) error {
+		return errors.New("failed")
+	}}
+	if _, err := NewMigrator(db, WithMigrations(custom, failed)).Up(ctx); err == nil {
+		t.Error("failed migration should return error")
+	}
+	if version, _ := NewMigrator(db).Version(ctx); version != 10000 {
+		t.Errorf("failed migration should not be recorded, got version: %d", version)
+	}
+
+	duplicated := Migration{Version: 1, Name: "duplicated", Up: custom.Up}
+	if _, err := NewMigrator(db, WithMigrations(duplicated)).Up(ctx); err == nil {
+		t.Error("duplicated version should return error")
+	}
+}
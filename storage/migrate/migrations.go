@@ -0,0 +1,240 @@
+package migrate
+
+import (
+	"gorm.io/gorm"
+)
+
+// Migrations 内置的所有版本
+// 每个版本使用自己的表结构快照，不直接引用dao里的结构体，dao修改之后老版本执行的结果也不会变化
+func Migrations() []Migration {
+	return []Migration{
+		{
+			Version: 1,
+			Name:    "init",
+			Up: func(tx *gorm.DB) error {
+				// 已经使用AutoMigrate创建过的表，只会补齐缺少的字段和索引
+				return tx.AutoMigrate(v1Tables()...)
+			},
+			Down: func(tx *gorm.DB) error {
+				return tx.Migrator().DropTable(v1Tables()...)
+			},
+		},
+		{
+			Version: 2,
+			Name:    "pkce_sso",
+			Up: func(tx *gorm.DB) error {
+				return tx.AutoMigrate(&v2Authorize{}, &v2Access{}, &v2ParentToken{}, &v2ParentTokenUser{})
+			},
+			Down: func(tx *gorm.DB) error {
+				if err := tx.Migrator().DropTable(&v2ParentTokenUser{}, &v2ParentToken{}); err != nil {
+					return err
+				}
+				if tx.Migrator().HasIndex(&v2Access{}, "Ptoken") {
+					if err := tx.Migrator().DropIndex(&v2Access{}, "Ptoken"); err != nil {
+						return err
+					}
+				}
+				return dropColumns(tx, map[interface{}][]string{
+					&v2Authorize{}: {"CodeChallenge", "CodeChallengeMethod", "Ptoken", "PtokenExpiresIn", "Uid"},
+					&v2Access{}:    {"Ptoken", "Uid", "Ua", "ClientIp"},
+				})
+			},
+		},
+		{
+			Version: 3,
+			Name:    "access_chain",
+			Up: func(tx *gorm.DB) error {
+				return tx.AutoMigrate(&v3Access{})
+			},
+			Down: func(tx *gorm.DB) error {
+				if tx.Migrator().HasIndex(&v3Access{}, "idx_access_chain") {
+					if err := tx.Migrator().DropIndex(&v3Access{}, "idx_access_chain"); err != nil {
+						return err
+					}
+				}
+				return dropColumns(tx, map[interface{}][]string{
+					&v3Access{}: {"Chain", "Generation"},
+				})
+			},
+		},
+	}
+}
+
+// dropColumns 删除存在的字段，Down需要可以重复执行
+func dropColumns(tx *gorm.DB, columns map[interface{}][]string) error {
+	for model, names := range columns {
+		for _, name := range names {
+			if !tx.Migrator().HasColumn(model, name) {
+				continue
+			}
+			if err := tx.Migrator().DropColumn(model, name); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func v1Tables() []interface{} {
+	return []interface{}{
+		&v1App{},
+		&v1AppSecret{},
+		&v1Authorize{},
+		&v1Access{},
+		&v1Refresh{},
+		&v1Expires{},
+	}
+}
+
+type v1App struct {
+	Aid               int    `gorm:"not null;primaryKey;autoIncrement"`
+	Name              string `gorm:"not null;default:'';comment:名称"`
+	ClientId          string `gorm:"not null;default:'';comment:客户度ID"`
+	Secret            string `gorm:"not null;default:'';comment:密钥"`
+	RedirectUri       string `gorm:"not null;default:'';comment:跳转地址"`
+	Url               string `gorm:"not null;default:'';comment:访问地址"`
+	Extra             string `gorm:"not null;comment:额外信息"`
+	CntCall           int    `gorm:"not null;default:0;comment:调用次数"`
+	Status            int    `gorm:"not null;default:0;comment:状态"`
+	Ctime             int64  `gorm:"not null;default:0;comment:创建时间"`
+	Utime             int64  `gorm:"not null;default:0;comment:更新时间"`
+	Dtime             int64  `gorm:"not null;default:0;comment:删除时间"`
+	Metadata          string `gorm:"not null;comment:客户端元数据"`
+	RegistrationToken string `gorm:"not null;default:'';comment:注册访问令牌"`
+	TokenPolicy       string `gorm:"not null;comment:token策略"`
+}
+
+func (t *v1App) TableName() string {
+	return "app"
+}
+
+type v1AppSecret struct {
+	Id        int    `gorm:"not null;primaryKey;autoIncrement"`
+	ClientId  string `gorm:"not null;default:'';index;comment:客户端ID"`
+	Hash      string `gorm:"not null;default:'';comment:密钥hash"`
+	ExpiresAt int64  `gorm:"not null;default:0;comment:过期时间"`
+	Ctime     int64  `gorm:"not null;default:0;comment:创建时间"`
+	Dtime     int64  `gorm:"not null;default:0;comment:删除时间"`
+}
+
+func (t *v1AppSecret) TableName() string {
+	return "app_secret"
+}
+
+type v1Authorize struct {
+	Id          int    `gorm:"not null;primaryKey;autoIncrement"`
+	Client      string `gorm:"not null;default:'';comment:客户端"`
+	Code        string `gorm:"not null;default:'';uniqueIndex;comment:CODE码"`
+	ExpiresIn   int64  `gorm:"not null;default:0;comment:过期时间"`
+	Scope       string `gorm:"not null;default:'';comment:范围"`
+	RedirectUri string `gorm:"not null;default:'';comment:跳转地址"`
+	State       string `gorm:"not null;default:'';comment:状态"`
+	Extra       string `gorm:"not null;comment:额外信息"`
+	Ctime       int64  `gorm:"not null;default:0;comment:创建时间"`
+}
+
+func (t *v1Authorize) TableName() string {
+	return "authorize"
+}
+
+type v1Access struct {
+	Id               int    `gorm:"not null;primaryKey;autoIncrement"`
+	Client           string `gorm:"not null;default:'';comment:客户端"`
+	Authorize        string `gorm:"not null;default:'';comment:授权"`
+	Previous         string `gorm:"not null;default:'';"`
+	AccessToken      string `gorm:"not null;default:'';uniqueIndex"`
+	RefreshToken     string `gorm:"not null;default:'';"`
+	ExpiresIn        int64  `gorm:"not null;default:0;comment:过期时间"`
+	RefreshExpiresIn int64  `gorm:"not null;default:0;comment:refresh token过期时间"`
+	Scope            string `gorm:"not null;default:'';comment:作用域"`
+	RedirectUri      string `gorm:"not null;default:'';comment:跳转地址"`
+	Extra            string `gorm:"not null;comment:额外信息"`
+	Ctime            int64  `gorm:"not null;default:0;comment:创建时间"`
+}
+
+func (t *v1Access) TableName() string {
+	return "access"
+}
+
+type v1Refresh struct {
+	Id     int    `gorm:"not null;primaryKey;autoIncrement"`
+	Token  string `gorm:"not null;default:'';uniqueIndex;comment:token"`
+	Access string `gorm:"not null;default:'';comment:access"`
+}
+
+func (t *v1Refresh) TableName() string {
+	return "refresh"
+}
+
+type v1Expires struct {
+	Id        int    `gorm:"not null;primaryKey;autoIncrement"`
+	Token     string `gorm:"not null;default:'';uniqueIndex;comment:token"`
+	ExpiresAt int64  `gorm:"not null;default:0;comment:过期时间"`
+	Ptoken    string `gorm:"not null;default:'';comment:parent token信息"`
+}
+
+func (t *v1Expires) TableName() string {
+	return "expires"
+}
+
+type v2Authorize struct {
+	v1Authorize
+	CodeChallenge       string `gorm:"not null;default:'';size:128;comment:code_challenge"`
+	CodeChallengeMethod string `gorm:"not null;default:'';size:16;comment:code_challenge_method"`
+	Ptoken              string `gorm:"not null;default:'';comment:parent token"`
+	PtokenExpiresIn     int64  `gorm:"not null;default:0;comment:parent token过期时间"`
+	Uid                 int64  `gorm:"not null;default:0;comment:登录的账号"`
+}
+
+func (t *v2Authorize) TableName() string {
+	return "authorize"
+}
+
+type v2Access struct {
+	v1Access
+	Ptoken   string `gorm:"not null;default:'';index;comment:parent token"`
+	Uid      int64  `gorm:"not null;default:0;comment:多账号下token所属的账号"`
+	Ua       string `gorm:"not null;default:'';size:512;comment:user agent"`
+	ClientIp string `gorm:"not null;default:'';size:64;comment:客户端IP"`
+}
+
+func (t *v2Access) TableName() string {
+	return "access"
+}
+
+type v2ParentToken struct {
+	Id        int    `gorm:"not null;primaryKey;autoIncrement"`
+	Token     string `gorm:"not null;default:'';uniqueIndex;comment:parent token"`
+	AuthAt    int64  `gorm:"not null;default:0;comment:登录时间"`
+	ExpiresIn int64  `gorm:"not null;default:0;comment:有效期"`
+	ExpiresAt int64  `gorm:"not null;default:0;index;comment:过期时间"`
+	Ctime     int64  `gorm:"not null;default:0;comment:创建时间"`
+}
+
+func (t *v2ParentToken) TableName() string {
+	return "parent_token"
+}
+
+type v2ParentTokenUser struct {
+	Id       int    `gorm:"not null;primaryKey;autoIncrement"`
+	Ptoken   string `gorm:"not null;default:'';uniqueIndex:idx_parent_token_user_ptoken_uid;comment:parent token"`
+	Uid      int64  `gorm:"not null;default:0;uniqueIndex:idx_parent_token_user_ptoken_uid;index;comment:账号"`
+	Ctime    int64  `gorm:"not null;default:0;comment:登录时间"`
+	Ua       string `gorm:"not null;default:'';size:512;comment:user agent"`
+	ClientIp string `gorm:"not null;default:'';size:64;comment:客户端IP"`
+	Platform string `gorm:"not null;default:'';size:64;comment:平台"`
+}
+
+func (t *v2ParentTokenUser) TableName() string {
+	return "parent_token_user"
+}
+
+type v3Access struct {
+	v2Access
+	Chain      string `gorm:"not null;default:'';index:idx_access_chain;comment:刷新链"`
+	Generation int    `gorm:"not null;default:0;index:idx_access_chain;comment:刷新次数"`
+}
+
+func (t *v3Access) TableName() string {
+	return "access"
+}
@@ -0,0 +1,11 @@
+package migrate
+
+// Option 可选项
+type Option func(m *Migrator)
+
+// WithMigrations 追加业务自己的版本，版本号不能和内置版本重复，建议从10000开始
+func WithMigrations(migrations ...Migration) Option {
+	return func(m *Migrator) {
+		m.migrations = append(m.migrations, migrations...)
+	}
+}
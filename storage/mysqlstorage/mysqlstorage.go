@@ -0,0 +1,61 @@
+// Package mysqlstorage 已经迁移到 storage/sqlstorage，同时支持MySQL、PostgreSQL、SQLite
+//
+// Deprecated: 请使用 sqlstorage
+package mysqlstorage
+
+import (
+	"time"
+
+	"github.com/ego-component/eoauth2/storage/sqlstorage"
+	"gorm.io/gorm"
+)
+
+// Option 可选项
+//
+// Deprecated: 请使用 sqlstorage.Option
+type Option = sqlstorage.Option
+
+// PurgeOption 清理过期数据的选项
+//
+// Deprecated: 请使用 sqlstorage.PurgeOption
+type PurgeOption = sqlstorage.PurgeOption
+
+// PurgeStats 清理过期数据的统计信息
+//
+// Deprecated: 请使用 sqlstorage.PurgeStats
+type PurgeStats = sqlstorage.PurgeStats
+
+// NewStorage returns a new sql storage instance.
+//
+// Deprecated: 请使用 sqlstorage.NewStorage
+func NewStorage(db *gorm.DB, options ...Option) *sqlstorage.Storage {
+	return sqlstorage.NewStorage(db, options...)
+}
+
+// WithTokenHash 使用HMAC-SHA256存储token的hash
+//
+// Deprecated: 请使用 sqlstorage.WithTokenHash
+func WithTokenHash(secret []byte, legacyLookup bool) Option {
+	return sqlstorage.WithTokenHash(secret, legacyLookup)
+}
+
+// WithPurgeBatchSize 每批处理的expires行数
+//
+// Deprecated: 请使用 sqlstorage.WithPurgeBatchSize
+func WithPurgeBatchSize(size int) PurgeOption {
+	return sqlstorage.WithPurgeBatchSize(size)
+}
+
+// WithPurgeInterval 每批之间的间隔
+//
+// Deprecated: 请使用 sqlstorage.WithPurgeInterval
+func WithPurgeInterval(interval time.Duration) PurgeOption {
+	return sqlstorage.WithPurgeInterval(interval)
+}
+
+// WithPurgeMaxBatches 每次最多处理的批数
+//
+// Deprecated: 请使用 sqlstorage.WithPurgeMaxBatches
+func WithPurgeMaxBatches(maxBatches int) PurgeOption {
+	return sqlstorage.WithPurgeMaxBatches(maxBatches)
+}
@@ -0,0 +1,82 @@
+package sqlstorage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/ego-component/eoauth2/server"
+	"github.com/ego-component/eoauth2/storage/dao"
+	"gorm.io/gorm"
+)
+
+// MaxAccessChainDepth LoadAccessChain 最多返回的token个数
+const MaxAccessChainDepth = 100
+
+// LoadAccessChain 加载刷新链，用于审计，第一个元素为token本身，之后依次为之前的token，AccessData指向下一个元素
+// maxDepth 为最多返回的token个数，小于等于0或者超过 MaxAccessChainDepth 时使用 MaxAccessChainDepth
+// 刷新之后被删除的token不在链上；不校验是否过期以及parent token是否有效
+// 注意：默认 RetainTokenAfterRefresh 为false，刷新之后会删除之前的token，只能返回token本身，
+// 审计需要完整的刷新链时，必须开启 server.Config 或者客户端 TokenPolicy 的 RetainTokenAfterRefresh
+func (s *Storage) LoadAccessChain(ctx context.Context, token string, maxDepth int) ([]*server.AccessData, error) {
+	if maxDepth <= 0 || maxDepth > MaxAccessChainDepth {
+		maxDepth = MaxAccessChainDepth
+	}
+	db := s.db.WithContext(ctx)
+	info, err := dao.GetAccessByAccessTokens(db, s.hasher.Lookups(token))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, fmt.Errorf("sql storage LoadAccessChain not found, err: %w", server.ErrNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("sql storage LoadAccessChain failed, err: %w", err)
+	}
+
+	var infos []dao.Access
+	if info.Chain != "" {
+		infos, err = dao.ListAccessesByChain(db, info.Chain, info.Generation, maxDepth)
+		if err != nil {
+			return nil, fmt.Errorf("sql storage LoadAccessChain failed, err: %w", err)
+		}
+	} else {
+		infos, err = s.walkAccessChain(db, info, maxDepth)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	clients := make(map[string]server.Client)
+	chain := make([]*server.AccessData, 0, len(infos))
+	for _, value := range infos {
+		client, ok := clients[value.Client]
+		if !ok {
+			client, err = s.GetClient(ctx, value.Client)
+			if err != nil {
+				return nil, err
+			}
+			clients[value.Client] = client
+		}
+		data := newAccessData(value, client)
+		if len(chain) > 0 {
+			chain[len(chain)-1].AccessData = data
+		}
+		chain = append(chain, data)
+	}
+	return chain, nil
+}
+
+// walkAccessChain 没有记录刷新链的老数据，按照previous逐个查询
+func (s *Storage) walkAccessChain(db *gorm.DB, info dao.Access, maxDepth int) ([]dao.Access, error) {
+	infos := []dao.Access{info}
+	for len(infos) < maxDepth && info.Previous != "" {
+		var err error
+		info, err = dao.GetAccessByAccessTokens(db, s.hasher.Refs(info.Previous))
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("sql storage LoadAccessChain failed, err: %w", err)
+		}
+		infos = append(infos, info)
+	}
+	return infos, nil
+}
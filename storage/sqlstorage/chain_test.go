@@ -0,0 +1,88 @@
+package sqlstorage
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ego-component/eoauth2/server"
+	"github.com/ego-component/eoauth2/storage/dao"
+)
+
+// refreshThroughServer 通过server登录，然后刷新n次token，返回最后一次的access token
+func refreshThroughServer(t *testing.T, s *Storage, n int) string {
+	t.Helper()
+	ctx := context.Background()
+	srv := server.DefaultContainer().Build(server.WithStorage(s))
+	authorize := srv.HandleAuthorizeRequest(ctx, server.AuthorizeRequestParam{
+		ClientId:     "storagetest",
+		RedirectUri:  "http://localhost/callback",
+		ResponseType: string(server.CODE),
+	})
+	if err := authorize.Build(server.WithAuthorizeRequestAuthorized(true)); err != nil {
+		t.Fatalf("authorize failed, err: %v", err)
+	}
+
+	clientAuth := server.ClientAuthParam{ClientId: "storagetest", ClientSecret: "secret"}
+	access := srv.HandleAccessRequest(ctx, server.ParamAccessRequest{
+		Method:    "POST",
+		GrantType: string(server.AUTHORIZATION_CODE),
+		AccessRequestParam: server.AccessRequestParam{
+			Code:            authorize.GetOutput("code").(string),
+			RedirectUri:     "http://localhost/callback",
+			ClientAuthParam: clientAuth,
+		},
+	})
+	if err := access.Build(server.WithAccessRequestAuthorized(true)); err != nil {
+		t.Fatalf("access failed, err: %v", err)
+	}
+	for i := 0; i < n; i++ {
+		access = srv.HandleAccessRequest(ctx, server.ParamAccessRequest{
+			Method:    "POST",
+			GrantType: string(server.REFRESH_TOKEN),
+			AccessRequestParam: server.AccessRequestParam{
+				Code:            access.GetOutput("refresh_token").(string),
+				ClientAuthParam: clientAuth,
+			},
+		})
+		if err := access.Build(server.WithAccessRequestAuthorized(true)); err != nil {
+			t.Fatalf("refresh %d failed, err: %v", i, err)
+		}
+	}
+	return access.GetOutput("access_token").(string)
+}
+
+func TestLoadAccessChain(t *testing.T) {
+	for name, c := range map[string]struct {
+		tokenPolicy string
+		want        int
+	}{
+		// 默认刷新之后删除之前的token，链上只有token本身
+		"default": {tokenPolicy: "", want: 1},
+		"retain":  {tokenPolicy: `{"retainTokenAfterRefresh":true}`, want: 4},
+	} {
+		t.Run(name, func(t *testing.T) {
+			db := newTestDB(t)
+			if err := db.Model(&dao.App{}).Where("client_id = ?", "storagetest").Update("token_policy", c.tokenPolicy).Error; err != nil {
+				t.Fatal(err)
+			}
+			s := NewStorage(db)
+			token := refreshThroughServer(t, s, 3)
+
+			chain, err := s.LoadAccessChain(context.Background(), token, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(chain) != c.want {
+				t.Fatalf("chain length, want: %d, got: %d", c.want, len(chain))
+			}
+			if chain[0].AccessToken != token {
+				t.Errorf("chain head, want: %s, got: %s", token, chain[0].AccessToken)
+			}
+			for i := 1; i < len(chain); i++ {
+				if chain[i-1].AccessData != chain[i] {
+					t.Errorf("chain[%d].AccessData should point to chain[%d]", i-1, i)
+				}
+			}
+		})
+	}
+}
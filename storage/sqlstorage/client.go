@@ -0,0 +1,84 @@
+package sqlstorage
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/ego-component/eoauth2/storage/admin"
+	"github.com/ego-component/eoauth2/storage/dao"
+)
+
+var _ admin.ClientManager = &Storage{}
+
+// CreateClient 创建客户端，app.Secret 为明文密钥，只保存hash到app_secret表
+func (s *Storage) CreateClient(ctx context.Context, app *dao.App) (err error) {
+	err = admin.CreateClient(s.db.WithContext(ctx), app)
+	if err != nil {
+		return fmt.Errorf("sql storage CreateClient error,err: %w", err)
+	}
+	return nil
+}
+
+// UpdateClient 更新客户端，updates里有secret时会退役所有老密钥
+func (s *Storage) UpdateClient(ctx context.Context, clientId string, updates map[string]interface{}) (err error) {
+	err = admin.UpdateClient(s.db.WithContext(ctx), clientId, updates)
+	if err != nil {
+		return fmt.Errorf("sql storage UpdateClient error,err: %w", err)
+	}
+	return nil
+}
+
+// DeleteClient 删除客户端
+func (s *Storage) DeleteClient(ctx context.Context, clientId string) (err error) {
+	err = dao.DeleteApp(s.db.WithContext(ctx), clientId)
+	if err != nil {
+		return fmt.Errorf("sql storage DeleteClient error,err: %w", err)
+	}
+	return nil
+}
+
+// GenerateClientSecret 为客户端生成一个新密钥，返回的明文只有这一次可以拿到
+// expiresAt 为过期时间戳，0表示不过期。老密钥在退役前仍然有效，可以做到无缝轮换
+func (s *Storage) GenerateClientSecret(ctx context.Context, clientId string, expiresAt int64) (secret string, info *dao.AppSecret, err error) {
+	secret, info, err = admin.GenerateClientSecret(s.db.WithContext(ctx), clientId, expiresAt)
+	if err != nil {
+		return "", nil, fmt.Errorf("sql storage GenerateClientSecret error,err: %w", err)
+	}
+	return secret, info, nil
+}
+
+// RetireClientSecret 退役客户端的某个密钥，不能退役最后一个有效密钥，返回 admin.ErrLastClientSecret
+func (s *Storage) RetireClientSecret(ctx context.Context, clientId string, secretId int) (err error) {
+	err = admin.RetireClientSecret(s.db.WithContext(ctx), clientId, secretId)
+	if err != nil {
+		return fmt.Errorf("sql storage RetireClientSecret error,err: %w", err)
+	}
+	return nil
+}
+
+// ListClientSecrets 查询客户端未退役的密钥，不包含hash
+func (s *Storage) ListClientSecrets(ctx context.Context, clientId string) (list []dao.AppSecret, err error) {
+	list, err = dao.ListAppSecretsByClientId(s.db.WithContext(ctx), clientId)
+	if err != nil {
+		return nil, fmt.Errorf("sql storage ListClientSecrets error,err: %w", err)
+	}
+	return list, nil
+}
+
+// GetClientDetail 查询客户端详情，包含调用次数和跳转地址
+func (s *Storage) GetClientDetail(ctx context.Context, clientId string) (client *admin.Client, err error) {
+	client, err = admin.GetClientDetail(s.db.WithContext(ctx), clientId)
+	if err != nil {
+		return nil, fmt.Errorf("sql storage GetClientDetail error,err: %w", err)
+	}
+	return client, nil
+}
+
+// ListClients 按照名称、client id、状态、创建时间查询客户端列表，使用游标分页
+func (s *Storage) ListClients(ctx context.Context, req admin.ListClientsRequest) (resp *admin.ListClientsResponse, err error) {
+	resp, err = admin.ListClients(s.db.WithContext(ctx), req)
+	if err != nil {
+		return nil, fmt.Errorf("sql storage ListClients error,err: %w", err)
+	}
+	return resp, nil
+}
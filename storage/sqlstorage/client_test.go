@@ -0,0 +1,69 @@
+package sqlstorage
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/ego-component/eoauth2/server"
+	"github.com/ego-component/eoauth2/storage/dao"
+)
+
+func TestGetClientStatus(t *testing.T) {
+	for name, c := range map[string]struct {
+		status int
+		dtime  int64
+		want   error
+	}{
+		"legacy":                {status: 0},
+		"active":                {status: dao.AppStatusActive},
+		"suspended":             {status: dao.AppStatusSuspended, want: server.ErrClientSuspended},
+		"deleted":               {status: dao.AppStatusDeleted, dtime: 1, want: server.ErrNotFound},
+		"deleted without dtime": {status: dao.AppStatusDeleted, want: server.ErrNotFound},
+	} {
+		t.Run(name, func(t *testing.T) {
+			db := newTestDB(t)
+			err := db.Model(&dao.App{}).Where("client_id = ?", "storagetest").Updates(map[string]interface{}{"status": c.status, "dtime": c.dtime}).Error
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = NewStorage(db).GetClient(context.Background(), "storagetest")
+			if c.want == nil && err != nil {
+				t.Errorf("GetClient, want nil, got: %v", err)
+			}
+			if c.want != nil && !errors.Is(err, c.want) {
+				t.Errorf("GetClient, want: %v, got: %v", c.want, err)
+			}
+		})
+	}
+}
+
+func TestSuspendResumeClient(t *testing.T) {
+	s := NewStorage(newTestDB(t))
+	ctx := context.Background()
+	token := refreshThroughServer(t, s, 0)
+
+	revoked, err := s.SuspendClient(ctx, "storagetest", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if revoked != 1 {
+		t.Errorf("revoked tokens, want: 1, got: %d", revoked)
+	}
+	if _, err = s.GetClient(ctx, "storagetest"); !errors.Is(err, server.ErrClientSuspended) {
+		t.Errorf("GetClient after suspend, want ErrClientSuspended, got: %v", err)
+	}
+	if _, err = s.LoadAccess(ctx, token); err == nil {
+		t.Error("token should be revoked after suspend")
+	}
+
+	if err = s.ResumeClient(ctx, "storagetest"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = s.GetClient(ctx, "storagetest"); err != nil {
+		t.Errorf("GetClient after resume, want nil, got: %v", err)
+	}
+	if _, err = s.SuspendClient(ctx, "missing", false); !errors.Is(err, server.ErrNotFound) {
+		t.Errorf("suspend missing client, want ErrNotFound, got: %v", err)
+	}
+}
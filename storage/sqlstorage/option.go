@@ -0,0 +1,14 @@
+package sqlstorage
+
+import "github.com/ego-component/eoauth2/storage/tokenhash"
+
+// Option 可选项
+type Option func(s *Storage)
+
+// WithTokenHash 使用HMAC-SHA256存储token的hash，数据库里不保存明文token
+// legacyLookup 为true时，会同时按照明文查询开启之前下发的token，老token全部过期后可以关闭
+func WithTokenHash(secret []byte, legacyLookup bool) Option {
+	return func(s *Storage) {
+		s.hasher = tokenhash.New(secret, legacyLookup)
+	}
+}
@@ -0,0 +1,244 @@
+package sqlstorage
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/ego-component/eoauth2/storage/dao"
+	"github.com/gotomicro/ego/core/elog"
+	"github.com/gotomicro/ego/core/emetric"
+	"github.com/gotomicro/ego/task/ecron"
+	"github.com/gotomicro/ego/task/ejob"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+// purgeCounter 清理的过期数据行数，table为 authorize、access、refresh、expires、parent_token
+var purgeCounter = emetric.CounterVecOpts{
+	Namespace: emetric.DefaultNamespace,
+	Name:      "oauth2_storage_purge_total",
+	Help:      "oauth2 storage purged expired rows",
+	Labels:    []string{"table"},
+}.Build()
+
+// purgeErrorCounter 清理失败的次数
+var purgeErrorCounter = emetric.CounterVecOpts{
+	Namespace: emetric.DefaultNamespace,
+	Name:      "oauth2_storage_purge_error_total",
+	Help:      "oauth2 storage purge errors",
+}.Build()
+
+type purgeConfig struct {
+	batchSize  int           // 每批处理的expires行数
+	interval   time.Duration // 每批之间的间隔，避免清理时占满数据库
+	maxBatches int           // 每次清理时，token、parent token各自最多处理的批数，0表示处理完所有过期数据
+}
+
+// PurgeOption 清理过期数据的选项
+type PurgeOption func(c *purgeConfig)
+
+// WithPurgeBatchSize 每批处理的expires行数，默认500
+func WithPurgeBatchSize(size int) PurgeOption {
+	return func(c *purgeConfig) {
+		c.batchSize = size
+	}
+}
+
+// WithPurgeInterval 每批之间的间隔，默认100ms
+func WithPurgeInterval(interval time.Duration) PurgeOption {
+	return func(c *purgeConfig) {
+		c.interval = interval
+	}
+}
+
+// WithPurgeMaxBatches 每次清理时，token、parent token各自最多处理的批数，默认0，处理完所有过期数据
+func WithPurgeMaxBatches(maxBatches int) PurgeOption {
+	return func(c *purgeConfig) {
+		c.maxBatches = maxBatches
+	}
+}
+
+// PurgeStats 清理过期数据的统计信息
+type PurgeStats struct {
+	Authorizes int // 删除的authorize行数
+	Accesses   int // 删除的access行数
+	Refreshes  int // 删除的refresh行数
+	Expires    int // 删除的expires行数
+	// 删除的过期parent token个数，下面的sub token、refresh token一起删除，不计入Accesses、Refreshes
+	ParentTokens int
+	Batches      int // 处理的总批数
+}
+
+// PurgeExpired 根据expires表清理过期的authorize、access以及对应的refresh，然后清理过期的parent token
+// access的refresh token还没有过期时，保留access，并把expires里的过期时间改为refresh token的过期时间；refresh token不过期时，删除expires记录
+func (s *Storage) PurgeExpired(ctx context.Context, options ...PurgeOption) (*PurgeStats, error) {
+	config := &purgeConfig{
+		batchSize:  500,
+		interval:   100 * time.Millisecond,
+		maxBatches: 0,
+	}
+	for _, option := range options {
+		option(config)
+	}
+	stats := &PurgeStats{}
+	// 每种数据单独计算批数，token过期数据很多时也会清理parent token
+	for _, purgeBatch := range []func(context.Context, int, *PurgeStats) (int, error){s.purgeBatch, s.purgeParentTokenBatch} {
+		for batches := 0; config.maxBatches <= 0 || batches < config.maxBatches; batches++ {
+			n, err := purgeBatch(ctx, config.batchSize, stats)
+			if err != nil {
+				purgeErrorCounter.Inc()
+				return stats, fmt.Errorf("sql storage PurgeExpired failed, err: %w", err)
+			}
+			stats.Batches++
+			if n < config.batchSize {
+				break
+			}
+			select {
+			case <-ctx.Done():
+				return stats, ctx.Err()
+			case <-time.After(config.interval):
+			}
+		}
+	}
+	return stats, nil
+}
+
+// purgeBatch 在一个事务里清理一批过期数据，返回处理的expires行数
+func (s *Storage) purgeBatch(ctx context.Context, batchSize int, stats *PurgeStats) (n int, err error) {
+	now := time.Now().Unix()
+	err = s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var expires []dao.Expires
+		if err := tx.Where("expires_at < ?", now).Order("id asc").Limit(batchSize).Find(&expires).Error; err != nil {
+			return err
+		}
+		n = len(expires)
+		if n == 0 {
+			return nil
+		}
+		tokens := make([]string, 0, n)
+		for _, value := range expires {
+			tokens = append(tokens, value.Token)
+		}
+
+		// refresh token还有效的access需要保留
+		var accesses []dao.Access
+		if err := tx.Select("access_token", "refresh_expires_in", "ctime").
+			Where("access_token in (?) and refresh_token != ''", tokens).Find(&accesses).Error; err != nil {
+			return err
+		}
+		kept := make(map[string]bool)
+		for _, access := range accesses {
+			if access.RefreshExpiresIn == 0 {
+				kept[access.AccessToken] = true
+				if err := tx.Where("token = ?", access.AccessToken).Delete(&dao.Expires{}).Error; err != nil {
+					return err
+				}
+				continue
+			}
+			if refreshExpireAt := access.Ctime + access.RefreshExpiresIn; refreshExpireAt >= now {
+				kept[access.AccessToken] = true
+				if err := tx.Model(&dao.Expires{}).Where("token = ?", access.AccessToken).Update("expires_at", refreshExpireAt).Error; err != nil {
+					return err
+				}
+			}
+		}
+		ids := make([]int, 0, n)
+		purged := make([]string, 0, n)
+		for _, value := range expires {
+			if kept[value.Token] {
+				continue
+			}
+			ids = append(ids, value.Id)
+			purged = append(purged, value.Token)
+		}
+		if len(purged) == 0 {
+			return nil
+		}
+
+		result := tx.Where("code in (?)", purged).Delete(&dao.Authorize{})
+		if result.Error != nil {
+			return result.Error
+		}
+		stats.Authorizes += int(result.RowsAffected)
+		purgeCounter.Add(float64(result.RowsAffected), "authorize")
+
+		result = tx.Where("access in (?)", purged).Delete(&dao.Refresh{})
+		if result.Error != nil {
+			return result.Error
+		}
+		stats.Refreshes += int(result.RowsAffected)
+		purgeCounter.Add(float64(result.RowsAffected), "refresh")
+
+		result = tx.Where("access_token in (?)", purged).Delete(&dao.Access{})
+		if result.Error != nil {
+			return result.Error
+		}
+		stats.Accesses += int(result.RowsAffected)
+		purgeCounter.Add(float64(result.RowsAffected), "access")
+
+		result = tx.Where("id in (?)", ids).Delete(&dao.Expires{})
+		if result.Error != nil {
+			return result.Error
+		}
+		stats.Expires += int(result.RowsAffected)
+		purgeCounter.Add(float64(result.RowsAffected), "expires")
+		return nil
+	})
+	return n, err
+}
+
+// purgeParentTokenBatch 在一个事务里清理一批过期的parent token，返回处理的parent token个数
+func (s *Storage) purgeParentTokenBatch(ctx context.Context, batchSize int, stats *PurgeStats) (n int, err error) {
+	now := time.Now().Unix()
+	err = s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		pTokens := make([]string, 0)
+		if err := tx.Model(&dao.ParentToken{}).Where("expires_at > 0 and expires_at < ?", now).
+			Order("id asc").Limit(batchSize).Pluck("token", &pTokens).Error; err != nil {
+			return err
+		}
+		n = len(pTokens)
+		if n == 0 {
+			return nil
+		}
+		if err := s.removeParentTokens(tx, pTokens); err != nil {
+			return err
+		}
+		stats.ParentTokens += n
+		purgeCounter.Add(float64(n), "parent_token")
+		return nil
+	})
+	return n, err
+}
+
+// PurgeJob 清理过期数据的ego job，通过 ego.Job 注册后运行
+func (s *Storage) PurgeJob(name string, options ...PurgeOption) *ejob.Component {
+	return ejob.Job(name, func(ctx ejob.Context) error {
+		return s.purge(ctx.Ctx, options...)
+	})
+}
+
+// PurgeCronJob 清理过期数据的ecron任务，通过 ecron.WithJob 定时执行
+func (s *Storage) PurgeCronJob(options ...PurgeOption) ecron.FuncJob {
+	return func(ctx context.Context) error {
+		return s.purge(ctx, options...)
+	}
+}
+
+func (s *Storage) purge(ctx context.Context, options ...PurgeOption) error {
+	logger := elog.EgoLogger.With(elog.FieldComponent("oauth2.storage"))
+	stats, err := s.PurgeExpired(ctx, options...)
+	if err != nil {
+		logger.Error("sql storage purge failed", elog.FieldErr(err))
+		return err
+	}
+	logger.Info("sql storage purge finished",
+		zap.Int("authorizes", stats.Authorizes),
+		zap.Int("accesses", stats.Accesses),
+		zap.Int("refreshes", stats.Refreshes),
+		zap.Int("expires", stats.Expires),
+		zap.Int("parentTokens", stats.ParentTokens),
+		zap.Int("batches", stats.Batches),
+	)
+	return nil
+}
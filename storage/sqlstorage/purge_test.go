@@ -0,0 +1,122 @@
+package sqlstorage
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ego-component/eoauth2/storage/dao"
+	"gorm.io/gorm"
+)
+
+// seedPurgeData 写入清理过期数据测试用的数据
+// a1 refresh token为空，a4 refresh token已经过期，需要删除；a2 refresh token不过期，a3 refresh token还有效，需要保留
+// p1 过期的parent token，p2 没有过期的parent token
+func seedPurgeData(t *testing.T, db *gorm.DB) {
+	t.Helper()
+	now := time.Now().Unix()
+	rows := []interface{}{
+		&dao.Access{Client: "storagetest", AccessToken: "a1", ExpiresIn: 60, Ctime: now - 100},
+		&dao.Access{Client: "storagetest", AccessToken: "a2", RefreshToken: "r2", ExpiresIn: 60, Ctime: now - 100},
+		&dao.Access{Client: "storagetest", AccessToken: "a3", RefreshToken: "r3", ExpiresIn: 60, RefreshExpiresIn: 3600, Ctime: now - 100},
+		&dao.Access{Client: "storagetest", AccessToken: "a4", RefreshToken: "r4", ExpiresIn: 60, RefreshExpiresIn: 3600, Ctime: now - 7200},
+		&dao.Access{Client: "storagetest", AccessToken: "a5", RefreshToken: "r5", ExpiresIn: 3600, Ctime: now, Ptoken: "p1"},
+		&dao.Access{Client: "storagetest", AccessToken: "a6", ExpiresIn: 3600, Ctime: now, Ptoken: "p2"},
+		&dao.Refresh{Token: "r2", Access: "a2"},
+		&dao.Refresh{Token: "r3", Access: "a3"},
+		&dao.Refresh{Token: "r4", Access: "a4"},
+		&dao.Refresh{Token: "r5", Access: "a5"},
+		&dao.Authorize{Client: "storagetest", Code: "c1", ExpiresIn: 60, Ctime: now - 100},
+		&dao.Expires{Token: "a1", ExpiresAt: now - 40},
+		&dao.Expires{Token: "a2", ExpiresAt: now - 40},
+		&dao.Expires{Token: "a3", ExpiresAt: now - 40},
+		&dao.Expires{Token: "a4", ExpiresAt: now - 7140},
+		&dao.Expires{Token: "c1", ExpiresAt: now - 40},
+		&dao.Expires{Token: "a5", ExpiresAt: now + 3600, Ptoken: "p1"},
+		&dao.Expires{Token: "a6", ExpiresAt: now + 3600, Ptoken: "p2"},
+		&dao.ParentToken{Token: "p1", AuthAt: now - 200, ExpiresIn: 100, ExpiresAt: now - 100, Ctime: now - 200},
+		&dao.ParentToken{Token: "p2", AuthAt: now, ExpiresIn: 100, ExpiresAt: now + 100, Ctime: now},
+		&dao.ParentTokenUser{Ptoken: "p1", Uid: 1, Ctime: now - 200},
+		&dao.ParentTokenUser{Ptoken: "p2", Uid: 1, Ctime: now},
+	}
+	for _, row := range rows {
+		if err := db.Create(row).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// assertRows 检查表里剩下的数据
+func assertRows(t *testing.T, db *gorm.DB, model interface{}, column string, want []string) {
+	t.Helper()
+	var got []string
+	if err := db.Model(model).Order(column+" asc").Pluck(column, &got).Error; err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(want) {
+		t.Errorf("%T rows, want: %v, got: %v", model, want, got)
+		return
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("%T rows, want: %v, got: %v", model, want, got)
+			return
+		}
+	}
+}
+
+func TestPurgeExpired(t *testing.T) {
+	db := newTestDB(t)
+	seedPurgeData(t, db)
+	s := NewStorage(db)
+
+	stats, err := s.PurgeExpired(context.Background(), WithPurgeInterval(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Accesses != 2 || stats.Refreshes != 1 || stats.Authorizes != 1 || stats.Expires != 3 || stats.ParentTokens != 1 {
+		t.Errorf("purge stats, got: %+v", stats)
+	}
+	assertRows(t, db, &dao.Access{}, "access_token", []string{"a2", "a3", "a6"})
+	assertRows(t, db, &dao.Refresh{}, "token", []string{"r2", "r3"})
+	assertRows(t, db, &dao.Authorize{}, "code", nil)
+	// a2 refresh token不过期，删除expires记录；a3 过期时间改为refresh token的过期时间
+	assertRows(t, db, &dao.Expires{}, "token", []string{"a3", "a6"})
+	var expires dao.Expires
+	if err = db.Where("token = ?", "a3").First(&expires).Error; err != nil {
+		t.Fatal(err)
+	}
+	if want := time.Now().Unix() - 100 + 3600; expires.ExpiresAt < want-5 || expires.ExpiresAt > want {
+		t.Errorf("kept access expires at, want: %d, got: %d", want, expires.ExpiresAt)
+	}
+	assertRows(t, db, &dao.ParentToken{}, "token", []string{"p2"})
+	assertRows(t, db, &dao.ParentTokenUser{}, "ptoken", []string{"p2"})
+
+	// 再次清理没有需要处理的数据
+	stats, err = s.PurgeExpired(context.Background(), WithPurgeInterval(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Accesses != 0 || stats.Expires != 0 || stats.ParentTokens != 0 {
+		t.Errorf("purge again stats, got: %+v", stats)
+	}
+}
+
+// TestPurgeExpiredMaxBatches token过期数据超过批数限制时，parent token也要清理
+func TestPurgeExpiredMaxBatches(t *testing.T) {
+	db := newTestDB(t)
+	seedPurgeData(t, db)
+	s := NewStorage(db)
+
+	stats, err := s.PurgeExpired(context.Background(), WithPurgeBatchSize(1), WithPurgeMaxBatches(1), WithPurgeInterval(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Batches != 2 {
+		t.Errorf("purge batches, want: 2, got: %d", stats.Batches)
+	}
+	if stats.ParentTokens != 1 {
+		t.Errorf("purge parent tokens, want: 1, got: %d", stats.ParentTokens)
+	}
+	assertRows(t, db, &dao.ParentToken{}, "token", []string{"p2"})
+}
@@ -0,0 +1,30 @@
+package sqlstorage
+
+import (
+	"fmt"
+
+	"github.com/ego-component/eoauth2/storage/dao"
+	"gorm.io/gorm"
+)
+
+// Models 存储使用的所有表
+func Models() []interface{} {
+	return []interface{}{
+		&dao.App{},
+		&dao.AppSecret{},
+		&dao.Authorize{},
+		&dao.Access{},
+		&dao.Refresh{},
+		&dao.Expires{},
+		&dao.ParentToken{},
+		&dao.ParentTokenUser{},
+	}
+}
+
+// AutoMigrate 根据 Models 创建表和索引，支持MySQL、PostgreSQL、SQLite，适用于测试和开发环境
+func AutoMigrate(db *gorm.DB) error {
+	if err := db.AutoMigrate(Models()...); err != nil {
+		return fmt.Errorf("sql storage AutoMigrate failed, err: %w", err)
+	}
+	return nil
+}
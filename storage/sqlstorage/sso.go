@@ -0,0 +1,212 @@
+package sqlstorage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ego-component/eoauth2/server"
+	"github.com/ego-component/eoauth2/server/model"
+	"github.com/ego-component/eoauth2/storage/dao"
+	"gorm.io/gorm"
+)
+
+// GetUidsByParentToken 获取parent token下登录的所有账号，按照登录顺序
+func (s *Storage) GetUidsByParentToken(ctx context.Context, pToken string) ([]int64, error) {
+	db := s.db.WithContext(ctx)
+	parent, err := s.getParentToken(db, s.hasher.Lookups(pToken))
+	if err != nil {
+		return nil, err
+	}
+	return s.getUids(db, parent.Token)
+}
+
+// GetUidsByToken 通过sub token获取parent token下登录的所有账号
+func (s *Storage) GetUidsByToken(ctx context.Context, token string) ([]int64, error) {
+	pToken, err := s.GetParentToken(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+	return s.getUids(s.db.WithContext(ctx), pToken)
+}
+
+// GetParentToken 获取sub token对应的parent token，开启token hash时返回的是parent token的hash
+func (s *Storage) GetParentToken(ctx context.Context, token string) (string, error) {
+	db := s.db.WithContext(ctx)
+	info, err := dao.GetAccessByAccessTokens(db, s.hasher.Lookups(token))
+	if errors.Is(err, gorm.ErrRecordNotFound) || (err == nil && info.Ptoken == "") {
+		return "", fmt.Errorf("sql storage GetParentToken not found, err: %w", server.ErrNotFound)
+	}
+	if err != nil {
+		return "", fmt.Errorf("sql storage GetParentToken failed, err: %w", err)
+	}
+	parent, err := s.getParentToken(db, []string{info.Ptoken})
+	if err != nil {
+		return "", err
+	}
+	return parent.Token, nil
+}
+
+// GetParentTokensByUid 获取账号登录的所有没有过期的parent token，开启token hash时返回的是parent token的hash
+func (s *Storage) GetParentTokensByUid(ctx context.Context, uid int64) ([]string, error) {
+	db := s.db.WithContext(ctx)
+	users, err := dao.ListParentTokenUsersByUid(db, uid)
+	if err != nil {
+		return nil, fmt.Errorf("sql storage GetParentTokensByUid failed, err: %w", err)
+	}
+	pTokens := make([]string, 0, len(users))
+	for _, user := range users {
+		pTokens = append(pTokens, user.Ptoken)
+	}
+	if len(pTokens) == 0 {
+		return pTokens, nil
+	}
+	valid := make([]string, 0, len(pTokens))
+	err = db.Model(&dao.ParentToken{}).Where("token in (?) and (expires_at = 0 or expires_at >= ?)", pTokens, time.Now().Unix()).
+		Order("id asc").Pluck("token", &valid).Error
+	if err != nil {
+		return nil, fmt.Errorf("sql storage GetParentTokensByUid failed, err: %w", err)
+	}
+	return valid, nil
+}
+
+// RemoveAllAccess 通过sub token退出登录，删除parent token以及下面所有的sub token
+func (s *Storage) RemoveAllAccess(ctx context.Context, token string) error {
+	pToken, err := s.GetParentToken(ctx, token)
+	if err != nil {
+		return err
+	}
+	return s.RemoveParentToken(ctx, pToken)
+}
+
+// RemoveParentToken 删除parent token、登录的账号，以及下面所有的sub token、refresh token和没有兑换的code
+// pToken 可以是cookie里的parent token，也可以是 GetParentToken、GetParentTokensByUid 返回的值
+func (s *Storage) RemoveParentToken(ctx context.Context, pToken string) error {
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		return s.removeParentTokens(tx, s.hasher.Refs(pToken))
+	})
+	if err != nil {
+		return fmt.Errorf("sql storage RemoveParentToken failed, err: %w", err)
+	}
+	return nil
+}
+
+// saveParentToken 保存parent token以及登录的账号，返回存储使用的parent token
+// 多账号登录时传入的是已经存在的parent token，只增加账号；已经过期的parent token会先删除再重新创建
+func (s *Storage) saveParentToken(tx *gorm.DB, data model.ParentToken) (string, error) {
+	now := time.Now().Unix()
+	info, err := dao.GetParentTokenByTokens(tx, s.hasher.Lookups(data.Token.Token))
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return "", fmt.Errorf("sql storage saveParentToken failed, err: %w", err)
+	}
+	found := err == nil
+	if found && info.IsExpiredAt(now) {
+		if err = s.removeParentTokens(tx, []string{info.Token}); err != nil {
+			return "", fmt.Errorf("sql storage saveParentToken remove expired failed, err: %w", err)
+		}
+		found = false
+	}
+	if !found {
+		info = dao.ParentToken{
+			Token:     s.hasher.Key(data.Token.Token),
+			AuthAt:    data.Token.AuthAt,
+			ExpiresIn: data.Token.ExpiresIn,
+		}
+		if info.ExpiresIn > 0 {
+			info.ExpiresAt = now + info.ExpiresIn
+		}
+		if err = dao.CreateParentToken(tx, &info); err != nil {
+			return "", err
+		}
+	}
+	err = dao.SaveParentTokenUser(tx, &dao.ParentTokenUser{
+		Ptoken:   info.Token,
+		Uid:      data.Uid,
+		Ctime:    data.StoreData.Ctime,
+		Ua:       data.StoreData.UA,
+		ClientIp: data.StoreData.ClientIP,
+		Platform: data.StoreData.Platform,
+	})
+	if err != nil {
+		return "", err
+	}
+	return info.Token, nil
+}
+
+// getParentToken 查询没有过期的parent token
+func (s *Storage) getParentToken(db *gorm.DB, pTokens []string) (dao.ParentToken, error) {
+	info, err := dao.GetParentTokenByTokens(db, pTokens)
+	if errors.Is(err, gorm.ErrRecordNotFound) || (err == nil && info.IsExpiredAt(time.Now().Unix())) {
+		return info, fmt.Errorf("sql storage parent token not found, err: %w", server.ErrNotFound)
+	}
+	if err != nil {
+		return info, fmt.Errorf("sql storage get parent token failed, err: %w", err)
+	}
+	return info, nil
+}
+
+// loadSsoData code里的单点登录信息，Token为存储使用的parent token
+func (s *Storage) loadSsoData(ctx context.Context, pToken string, uid int64) (model.ParentToken, error) {
+	db := s.db.WithContext(ctx)
+	parent, err := s.getParentToken(db, []string{pToken})
+	if err != nil {
+		return model.ParentToken{}, err
+	}
+	data := model.ParentToken{
+		Token: model.Token{
+			Token:     parent.Token,
+			AuthAt:    parent.AuthAt,
+			ExpiresIn: parent.ExpiresIn,
+		},
+		Uid: uid,
+	}
+	user, err := dao.GetParentTokenUser(db, parent.Token, uid)
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return data, fmt.Errorf("sql storage loadSsoData failed, err: %w", err)
+	}
+	data.StoreData = model.ParentTokenData{
+		Ctime:    user.Ctime,
+		UA:       user.Ua,
+		ClientIP: user.ClientIp,
+		Platform: user.Platform,
+	}
+	return data, nil
+}
+
+func (s *Storage) getUids(db *gorm.DB, pToken string) ([]int64, error) {
+	users, err := dao.ListParentTokenUsersByPtoken(db, pToken)
+	if err != nil {
+		return nil, fmt.Errorf("sql storage get uids failed, err: %w", err)
+	}
+	uids := make([]int64, 0, len(users))
+	for _, user := range users {
+		uids = append(uids, user.Uid)
+	}
+	return uids, nil
+}
+
+// removeParentTokens 在事务里删除parent token以及关联的数据
+func (s *Storage) removeParentTokens(tx *gorm.DB, pTokens []string) error {
+	accessTokens := tx.Model(dao.Access{}).Select("access_token").Where("ptoken in (?)", pTokens)
+	if err := tx.Where("access in (?)", accessTokens).Delete(&dao.Refresh{}).Error; err != nil {
+		return err
+	}
+	if err := tx.Where("token in (?)", accessTokens).Delete(&dao.Expires{}).Error; err != nil {
+		return err
+	}
+	if err := tx.Where("ptoken in (?)", pTokens).Delete(&dao.Access{}).Error; err != nil {
+		return err
+	}
+	codes := tx.Model(dao.Authorize{}).Select("code").Where("ptoken in (?)", pTokens)
+	if err := tx.Where("token in (?)", codes).Delete(&dao.Expires{}).Error; err != nil {
+		return err
+	}
+	if err := tx.Where("ptoken in (?)", pTokens).Delete(&dao.Authorize{}).Error; err != nil {
+		return err
+	}
+	if err := tx.Where("ptoken in (?)", pTokens).Delete(&dao.ParentTokenUser{}).Error; err != nil {
+		return err
+	}
+	return tx.Where("token in (?)", pTokens).Delete(&dao.ParentToken{}).Error
+}
@@ -0,0 +1,471 @@
+// Package sqlstorage 基于gorm的存储，支持MySQL、PostgreSQL、SQLite
+// 表结构使用 storage/dao 里的model，不依赖具体的数据库方言
+package sqlstorage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ego-component/egorm"
+	"github.com/ego-component/eoauth2/server"
+	"github.com/ego-component/eoauth2/server/model"
+	"github.com/ego-component/eoauth2/storage/dao"
+	"github.com/ego-component/eoauth2/storage/tokenhash"
+	"github.com/spf13/cast"
+	"gorm.io/gorm"
+)
+
+// Storage sql存储，实现了 server.Storage 以及客户端管理
+type Storage struct {
+	db     *egorm.Component
+	hasher *tokenhash.Hasher // 不为nil时，token只存储hash
+}
+
+// NewStorage returns a new sql storage instance.
+func NewStorage(db *gorm.DB, options ...Option) *Storage {
+	s := &Storage{
+		db: db,
+	}
+	for _, option := range options {
+		option(s)
+	}
+	return s
+}
+
+// Clone the storage if needed. For example, using mgo, you can clone the session with session.Clone
+// to avoid concurrent access problems.
+// This is to avoid cloning the connection at each method access.
+// Can return itself if not a problem.
+func (s *Storage) Clone() server.Storage {
+	return s
+}
+
+// Close the resources the storage potentially holds (using Clone for example)
+func (s *Storage) Close() {
+}
+
+// GetClient loads the client by id
+func (s *Storage) GetClient(ctx context.Context, clientId string) (client server.Client, err error) {
+	app, err := dao.GetAppInfoByClientId(s.db.WithContext(ctx), clientId)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		err = fmt.Errorf("sql storage get client not found,"+err.Error()+",err: %w", server.ErrNotFound)
+		return
+	}
+	if err != nil {
+		err = fmt.Errorf("sql storage get client error,err: %w", err)
+		return
+	}
+	// 删除的客户端不管dtime是否设置，都按照不存在处理，不暴露客户端存在
+	if app.Status == dao.AppStatusDeleted {
+		err = fmt.Errorf("sql storage get client deleted,err: %w", server.ErrNotFound)
+		return
+	}
+	if !app.IsActive() {
+		err = fmt.Errorf("sql storage get client status is %d,err: %w", app.Status, server.ErrClientSuspended)
+		return
+	}
+	secrets, err := dao.ListAppSecretsByClientId(s.db.WithContext(ctx), clientId)
+	if err != nil {
+		err = fmt.Errorf("sql storage get client secrets error,err: %w", err)
+		return
+	}
+	c := server.DefaultClient{
+		Id:          app.ClientId,
+		Secret:      app.Secret,
+		RedirectUri: app.RedirectUri,
+		UserData:    app.Extra,
+	}
+	for _, value := range secrets {
+		c.Secrets = append(c.Secrets, server.ClientSecret{
+			Hash:      value.Hash,
+			ExpiresAt: value.ExpiresAt,
+		})
+	}
+	// 创建时没有密钥的是公共客户端，机密客户端不能退役最后一个密钥
+	c.Public = c.Secret == "" && len(c.Secrets) == 0
+	if app.TokenPolicy != "" {
+		c.TokenPolicy = &server.TokenPolicy{}
+		if err = json.Unmarshal([]byte(app.TokenPolicy), c.TokenPolicy); err != nil {
+			err = fmt.Errorf("sql storage get client token policy error,err: %w", err)
+			return
+		}
+	}
+	return &c, nil
+}
+
+// SaveAuthorize saves authorize data.
+func (s *Storage) SaveAuthorize(ctx context.Context, data *server.AuthorizeData) (err error) {
+	obj := dao.Authorize{
+		Client:      data.Client.GetId(),
+		Code:        s.hasher.Key(data.Code),
+		ExpiresIn:   data.ExpiresIn,
+		Scope:       data.Scope,
+		RedirectUri: data.RedirectUri,
+		State:       data.State,
+		Ctime:       data.CreatedAt.Unix(),
+		Extra:       cast.ToString(data.UserData),
+		// PKCE需要在换取token时校验
+		CodeChallenge:       data.CodeChallenge,
+		CodeChallengeMethod: data.CodeChallengeMethod,
+		PtokenExpiresIn:     data.ParentTokenExpiresIn,
+	}
+
+	tx := s.db.WithContext(ctx).Begin()
+	if data.SsoData.Token.Token != "" {
+		obj.Ptoken, err = s.saveParentToken(tx, data.SsoData)
+		if err != nil {
+			tx.Rollback()
+			return
+		}
+		obj.Uid = data.SsoData.Uid
+	}
+	err = dao.CreateAuthorize(tx, &obj)
+	if err != nil {
+		tx.Rollback()
+		return
+	}
+
+	err = s.AddExpireAtData(tx, s.hasher.Key(data.Code), data.ExpireAt())
+	if err != nil {
+		tx.Rollback()
+		return
+	}
+	tx.Commit()
+	return
+}
+
+// LoadAuthorize looks up AuthorizeData by a code.
+// Client information MUST be loaded together.
+// Optionally can return error if expired.
+func (s *Storage) LoadAuthorize(ctx context.Context, code string) (*server.AuthorizeData, error) {
+	return s.loadAuthorize(ctx, s.hasher.Lookups(code))
+}
+
+// loadAuthorize 根据存储的code查询，开启token hash时，Code为code的hash
+func (s *Storage) loadAuthorize(ctx context.Context, codes []string) (*server.AuthorizeData, error) {
+	var data server.AuthorizeData
+
+	info, err := dao.GetAuthorizeInfoByCodes(s.db.WithContext(ctx), codes)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, fmt.Errorf("sql storage LoadAuthorize not found,"+err.Error()+",err: %w", server.ErrNotFound)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	data = server.AuthorizeData{
+		Code:                 info.Code,
+		ExpiresIn:            info.ExpiresIn,
+		ParentTokenExpiresIn: info.PtokenExpiresIn,
+		Scope:                info.Scope,
+		RedirectUri:          info.RedirectUri,
+		State:                info.State,
+		CreatedAt:            time.Unix(info.Ctime, 0),
+		UserData:             info.Extra,
+		CodeChallenge:        info.CodeChallenge,
+		CodeChallengeMethod:  info.CodeChallengeMethod,
+	}
+	c, err := s.GetClient(ctx, info.Client)
+	if err != nil {
+		return nil, err
+	}
+
+	if data.ExpireAt().Before(time.Now()) {
+		return nil, fmt.Errorf("ParentToken expired at %s.", data.ExpireAt().String())
+	}
+
+	// parent token已经退出或者过期时，code不能再换取sub token
+	if info.Ptoken != "" {
+		data.SsoData, err = s.loadSsoData(ctx, info.Ptoken, info.Uid)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	data.Client = c
+	return &data, nil
+}
+
+// RemoveAuthorize revokes or deletes the authorization code.
+func (s *Storage) RemoveAuthorize(ctx context.Context, code string) (err error) {
+	err = dao.DeleteAuthorizeByCodes(s.db.WithContext(ctx), s.hasher.Refs(code))
+	if err != nil {
+		return
+	}
+
+	if err = s.removeExpireAtData(ctx, code); err != nil {
+		return err
+	}
+	return nil
+}
+
+// SaveAccess writes AccessData.
+// If RefreshToken is not blank, it must save in a way that can be loaded using LoadRefresh.
+func (s *Storage) SaveAccess(ctx context.Context, data *server.AccessData) (err error) {
+	prev := ""
+	ptoken := ""
+	chain := s.hasher.Key(data.AccessToken)
+	generation := 0
+	authorizeData := &server.AuthorizeData{}
+
+	if data.AuthorizeData != nil {
+		authorizeData = data.AuthorizeData
+		ptoken = authorizeData.SsoData.Token.Token
+	}
+
+	extra := cast.ToString(data.UserData)
+
+	tx := s.db.WithContext(ctx).Begin()
+
+	if data.AccessData != nil {
+		prev = data.AccessData.AccessToken
+		// refresh token沿用老token的parent token以及刷新链
+		chain, generation = prev, 1
+		if prevInfo, err := dao.GetAccessByAccessTokens(tx, s.hasher.Refs(prev)); err == nil {
+			ptoken = prevInfo.Ptoken
+			chain, generation = prevInfo.AccessToken, prevInfo.Generation+1
+			if prevInfo.Chain != "" {
+				chain = prevInfo.Chain
+			}
+		}
+	}
+
+	if ptoken != "" {
+		parent, err := s.getParentToken(tx, s.hasher.Refs(ptoken))
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+		ptoken = parent.Token
+	}
+
+	if data.RefreshToken != "" {
+		if err := s.saveRefresh(tx, s.hasher.Key(data.RefreshToken), s.hasher.Key(data.AccessToken)); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	if data.Client == nil {
+		return errors.New("data.Client must not be nil")
+	}
+
+	obj := dao.Access{
+		Client:           data.Client.GetId(),
+		Authorize:        authorizeData.Code,
+		Previous:         prev,
+		AccessToken:      s.hasher.Key(data.AccessToken),
+		RefreshToken:     s.hasher.Key(data.RefreshToken),
+		ExpiresIn:        data.TokenExpiresIn,
+		RefreshExpiresIn: data.RefreshTokenExpiresIn,
+		Scope:            data.Scope,
+		RedirectUri:      data.RedirectUri,
+		Ctime:            data.CreatedAt.Unix(),
+		Extra:            extra,
+		Ptoken:           ptoken,
+		Uid:              data.TokenData.StoreData.Uid,
+		Ua:               data.TokenData.StoreData.UA,
+		ClientIp:         data.TokenData.StoreData.ClientIP,
+		Chain:            chain,
+		Generation:       generation,
+	}
+
+	err = dao.CreateAccess(tx, &obj)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	_, err = dao.GetAppInfoByClientId(tx, data.Client.GetId())
+	if err != nil {
+		tx.Rollback()
+		return
+	}
+
+	err = tx.WithContext(ctx).Model(dao.App{}).Where("client_id = ?", data.Client.GetId()).Updates(map[string]interface{}{
+		"cnt_call": gorm.Expr("cnt_call + ?", 1),
+	}).Error
+	if err != nil {
+		tx.Rollback()
+		return
+	}
+	err = s.AddExpireAtData(tx, s.hasher.Key(data.AccessToken), data.ExpireAt())
+	if err != nil {
+		tx.Rollback()
+		return
+	}
+	tx.Commit()
+	return nil
+}
+
+// LoadAccess retrieves access data by token. Client information MUST be loaded together.
+// AuthorizeData and AccessData DON'T NEED to be loaded if not easily available.
+// Optionally can return error if expired.
+// 只按照access token查询一次，不加载刷新链上之前的token，需要时使用 LoadAccessChain
+func (s *Storage) LoadAccess(ctx context.Context, code string) (*server.AccessData, error) {
+	return s.loadAccess(ctx, s.hasher.Lookups(code))
+}
+
+// loadAccess 根据存储的access token查询，开启token hash时，AccessToken、RefreshToken为token的hash
+func (s *Storage) loadAccess(ctx context.Context, codes []string) (*server.AccessData, error) {
+	info, err := dao.GetAccessByAccessTokens(s.db.WithContext(ctx), codes)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, fmt.Errorf("sql storage LoadAccess not found,"+err.Error()+",err: %w", server.ErrNotFound)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := s.GetClient(ctx, info.Client)
+	if err != nil {
+		return nil, err
+	}
+	result := newAccessData(info, client)
+
+	// 单点登录下parent token已经退出或者过期时，sub token也失效
+	if info.Ptoken != "" {
+		parent, err := s.getParentToken(s.db.WithContext(ctx), []string{info.Ptoken})
+		if err != nil {
+			return nil, err
+		}
+		result.ParentTokenExpiresIn = parent.ExpiresIn
+	}
+	return result, nil
+}
+
+// newAccessData 转换存储的access，不包含AuthorizeData和之前的AccessData
+func newAccessData(info dao.Access, client server.Client) *server.AccessData {
+	return &server.AccessData{
+		Client:                client,
+		AccessToken:           info.AccessToken,
+		RefreshToken:          info.RefreshToken,
+		TokenExpiresIn:        info.ExpiresIn,
+		RefreshTokenExpiresIn: info.RefreshExpiresIn,
+		Scope:                 info.Scope,
+		RedirectUri:           info.RedirectUri,
+		CreatedAt:             time.Unix(info.Ctime, 0),
+		UserData:              info.Extra,
+		TokenData: model.SubToken{
+			Token: model.Token{
+				Token:     info.AccessToken,
+				AuthAt:    info.Ctime,
+				ExpiresIn: info.ExpiresIn,
+			},
+			StoreData: model.SubTokenData{
+				UA:       info.Ua,
+				ClientIP: info.ClientIp,
+				Uid:      info.Uid,
+			},
+		},
+	}
+}
+
+// RemoveAccess revokes or deletes an AccessData.
+func (s *Storage) RemoveAccess(ctx context.Context, code string) (err error) {
+	err = dao.DeleteAccessByAccessTokens(s.db.WithContext(ctx), s.hasher.Refs(code))
+	if err != nil {
+		return
+	}
+	err = s.removeExpireAtData(ctx, code)
+	return
+}
+
+// LoadRefresh retrieves refresh AccessData. Client information MUST be loaded together.
+// AuthorizeData and AccessData DON'T NEED to be loaded if not easily available.
+// Optionally can return error if expired.
+func (s *Storage) LoadRefresh(ctx context.Context, code string) (*server.AccessData, error) {
+	info, err := dao.GetRefreshInfoByTokens(s.db.WithContext(ctx), s.hasher.Lookups(code))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, fmt.Errorf("sql storage LoadRefresh not found,"+err.Error()+",err: %w", server.ErrNotFound)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return s.loadAccess(ctx, s.hasher.Refs(info.Access))
+}
+
+// RemoveRefresh revokes or deletes refresh AccessData.
+func (s *Storage) RemoveRefresh(ctx context.Context, code string) (err error) {
+	err = dao.DeleteRefreshByTokens(s.db.WithContext(ctx), s.hasher.Refs(code))
+	return
+}
+
+// SuspendClient 暂停客户端，暂停后客户端不能登录、换取token
+// revokeTokens 为true时，删除该客户端所有的access、refresh token，返回删除的access token个数
+func (s *Storage) SuspendClient(ctx context.Context, clientId string, revokeTokens bool) (revoked int, err error) {
+	err = s.updateClientStatus(ctx, clientId, dao.AppStatusSuspended)
+	if err != nil || !revokeTokens {
+		return
+	}
+	err = s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		accessTokens := tx.Model(dao.Access{}).Select("access_token").Where("client = ?", clientId)
+		if err := tx.Where("access in (?)", accessTokens).Delete(&dao.Refresh{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("token in (?)", accessTokens).Delete(&dao.Expires{}).Error; err != nil {
+			return err
+		}
+		result := tx.Where("client = ?", clientId).Delete(&dao.Access{})
+		revoked = int(result.RowsAffected)
+		return result.Error
+	})
+	if err != nil {
+		err = fmt.Errorf("sql storage SuspendClient revoke tokens error,err: %w", err)
+	}
+	return
+}
+
+// ResumeClient 恢复被暂停的客户端
+func (s *Storage) ResumeClient(ctx context.Context, clientId string) (err error) {
+	return s.updateClientStatus(ctx, clientId, dao.AppStatusActive)
+}
+
+func (s *Storage) updateClientStatus(ctx context.Context, clientId string, status int) (err error) {
+	_, err = dao.GetAppInfoByClientId(s.db.WithContext(ctx), clientId)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return fmt.Errorf("sql storage updateClientStatus client not found,err: %w", server.ErrNotFound)
+	}
+	if err != nil {
+		return fmt.Errorf("sql storage updateClientStatus error,err: %w", err)
+	}
+	return dao.UpdateAppStatus(s.db.WithContext(ctx), clientId, status)
+}
+
+// CreateClientWithInformation Makes easy to create a osin.DefaultClient
+func (s *Storage) CreateClientWithInformation(id string, secret string, redirectURI string, userData interface{}) server.Client {
+	return &server.DefaultClient{
+		Id:          id,
+		Secret:      secret,
+		RedirectUri: redirectURI,
+		UserData:    userData,
+	}
+}
+
+func (s *Storage) saveRefresh(tx *gorm.DB, refresh, access string) (err error) {
+	obj := dao.Refresh{
+		Token:  refresh,
+		Access: access,
+	}
+
+	err = dao.CreateRefreash(tx, &obj)
+	return
+}
+
+// AddExpireAtData add info in expires table
+func (s *Storage) AddExpireAtData(tx *gorm.DB, code string, expireAt time.Time) (err error) {
+	obj := dao.Expires{
+		Token:     code,
+		ExpiresAt: expireAt.Unix(),
+	}
+	err = dao.CreateExpires(tx, &obj)
+	return
+}
+
+// removeExpireAtData remove info in expires table
+func (s *Storage) removeExpireAtData(ctx context.Context, code string) (err error) {
+	err = dao.DeleteExpiresByTokens(s.db.WithContext(ctx), s.hasher.Refs(code))
+	return
+}
@@ -0,0 +1,63 @@
+package sqlstorage
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ego-component/eoauth2/storage/dao"
+	"github.com/ego-component/eoauth2/storage/storagetest"
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+)
+
+// newTestDB 使用sqlite内存数据库
+func newTestDB(t *testing.T) *gorm.DB {
+	db, err := gorm.Open(sqlite.Open("file:"+t.Name()+"?mode=memory&cache=shared"), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB.SetMaxOpenConns(1)
+	t.Cleanup(func() { _ = sqlDB.Close() })
+	if err = AutoMigrate(db); err != nil {
+		t.Fatal(err)
+	}
+	err = db.Create(&dao.App{ClientId: "storagetest", Secret: "secret", RedirectUri: "http://localhost/callback", Status: dao.AppStatusActive}).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func TestStorage(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) *storagetest.Harness {
+		s := NewStorage(newTestDB(t))
+		client, err := s.GetClient(context.Background(), "storagetest")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return &storagetest.Harness{
+			Storage:           s,
+			Client:            client,
+			RemoveParentToken: s.RemoveParentToken,
+		}
+	})
+}
+
+func TestStorageTokenHash(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) *storagetest.Harness {
+		s := NewStorage(newTestDB(t), WithTokenHash([]byte("storagetest"), true))
+		client, err := s.GetClient(context.Background(), "storagetest")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return &storagetest.Harness{
+			Storage:           s,
+			Client:            client,
+			RemoveParentToken: s.RemoveParentToken,
+		}
+	})
+}
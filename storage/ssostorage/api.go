@@ -7,6 +7,7 @@ import (
 
 	"github.com/ego-component/egorm"
 	"github.com/ego-component/eoauth2/server"
+	"github.com/ego-component/eoauth2/storage/admin"
 	"github.com/ego-component/eoauth2/storage/dao"
 	"github.com/ego-component/eredis"
 	"github.com/go-redis/redis/v8"
@@ -21,9 +22,10 @@ type API struct {
 	uidMapParentToken *userToken
 	parentToken       *parentToken
 	subToken          *subToken
+	tokenServer       *tokenServer
 }
 
-func newAPI(config *config, logger *elog.Component, db *egorm.Component, redis *eredis.Component, uidMapParentToken *userToken, parentToken *parentToken, subToken *subToken) *API {
+func newAPI(config *config, logger *elog.Component, db *egorm.Component, redis *eredis.Component, uidMapParentToken *userToken, parentToken *parentToken, subToken *subToken, tokenServer *tokenServer) *API {
 	return &API{
 		config:            config,
 		redis:             redis,
@@ -32,43 +34,32 @@ func newAPI(config *config, logger *elog.Component, db *egorm.Component, redis *
 		uidMapParentToken: uidMapParentToken,
 		parentToken:       parentToken,
 		subToken:          subToken,
+		tokenServer:       tokenServer,
 	}
 }
 
 // CreateClient create client
+// app.Secret 为明文密钥，入库时只保存hash到app_secret表，app表里不保存明文
 func (s *API) CreateClient(ctx context.Context, app *dao.App) (err error) {
-	err = dao.CreateApp(s.db.WithContext(ctx), app)
+	err = admin.CreateClient(s.db.WithContext(ctx), app)
 	if err != nil {
 		return fmt.Errorf("sso storage CreateClient failed, err: %w", err)
 	}
-	client := &ClientInfo{
-		ClientId:    app.ClientId,
-		Secret:      app.Secret,
-		RedirectUri: app.RedirectUri,
-	}
-	err = s.redis.HSet(ctx, s.config.storeClientInfoKey, app.ClientId, client.Marshal())
+	err = s.refreshClientCache(ctx, app.ClientId)
 	if err != nil {
 		return fmt.Errorf("sso storage CreateClient failed2, err: %w", err)
 	}
 	return nil
 }
 
+// UpdateClient update client
+// 如果updates里有secret，那么会退役所有老密钥，并保存新密钥的hash；需要无缝轮换请使用GenerateClientSecret
 func (s *API) UpdateClient(ctx context.Context, clientId string, updates map[string]interface{}) (err error) {
-	err = dao.UpdateApp(s.db.WithContext(ctx), clientId, updates)
+	err = admin.UpdateClient(s.db.WithContext(ctx), clientId, updates)
 	if err != nil {
 		return fmt.Errorf("sso storage UpdateClient failed, err: %w", err)
 	}
-	// todo 判断
-	info, err := dao.GetAppInfoByClientId(s.db.WithContext(ctx), clientId)
-	if err != nil {
-		return fmt.Errorf("sso storage UpdateClient get info failed, err: %w", err)
-	}
-	client := &ClientInfo{
-		ClientId:    info.ClientId,
-		Secret:      info.Secret,
-		RedirectUri: info.RedirectUri,
-	}
-	err = s.redis.HSet(ctx, s.config.storeClientInfoKey, clientId, client.Marshal())
+	err = s.refreshClientCache(ctx, clientId)
 	if err != nil {
 		return fmt.Errorf("sso storage UpdateClient failed2, err: %w", err)
 	}
@@ -81,16 +72,17 @@ func (s *API) DeleteClient(ctx context.Context, clientId string) (err error) {
 		return fmt.Errorf("sso storage DeleteClient failed, err: %w", err)
 	}
 
-	err = s.redis.HDel(ctx, s.config.storeClientInfoKey, clientId)
+	err = s.redis.HDel(ctx, s.config.layout.clientKey(clientId), clientId)
 	if err != nil {
 		return fmt.Errorf("sso storage DeleteClient failed2, err: %w", err)
 	}
+	s.publishClientInvalidation(ctx, clientId)
 	return nil
 }
 
 // GetClient hgetall sso:client
 func (s *API) GetClient(ctx context.Context, clientId string) (info *ClientInfo, err error) {
-	infoBytes, err := s.redis.Client().HGet(ctx, s.config.storeClientInfoKey, clientId).Bytes()
+	infoBytes, err := s.redis.Client().HGet(ctx, s.config.layout.clientKey(clientId), clientId).Bytes()
 	if err != nil && !errors.Is(err, redis.Nil) {
 		err = fmt.Errorf("sso storage GetClient redis get failed, err: %w", err)
 		return
@@ -111,6 +103,9 @@ func (s *API) GetClient(ctx context.Context, clientId string) (info *ClientInfo,
 }
 
 func (s *API) GetAllByParentToken(ctx context.Context, pToken string) (tokenInfo *ParentTokenStore, err error) {
+	if pToken, err = s.tokenServer.lookupParentToken(ctx, pToken); err != nil {
+		return nil, err
+	}
 	return s.parentToken.getAll(ctx, pToken)
 }
 
@@ -119,5 +114,8 @@ func (s *API) GetAllByUser(ctx context.Context, uid int64) (output *UserStore, e
 }
 
 func (s *API) GetAllBySubToken(ctx context.Context, token string) (output *SubTokenStore, err error) {
+	if token, err = s.tokenServer.lookupSubToken(ctx, token); err != nil {
+		return nil, err
+	}
 	return s.subToken.getAll(ctx, token)
 }
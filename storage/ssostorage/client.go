@@ -0,0 +1,207 @@
+package ssostorage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ego-component/egorm"
+	"github.com/ego-component/eoauth2/server"
+	"github.com/ego-component/eoauth2/storage/admin"
+	"github.com/ego-component/eoauth2/storage/clientcache"
+	"github.com/ego-component/eoauth2/storage/dao"
+	"github.com/gotomicro/ego/core/elog"
+	"gorm.io/gorm"
+)
+
+var _ admin.ClientManager = &API{}
+
+// loadClientInfo 从数据库加载客户端以及未退役的密钥，用于写入sso:client缓存
+func loadClientInfo(ctx context.Context, db *egorm.Component, clientId string) (*ClientInfo, error) {
+	appInfo, err := dao.GetAppInfoByClientId(db.WithContext(ctx), clientId)
+	if err != nil {
+		return nil, err
+	}
+	secrets, err := dao.ListAppSecretsByClientId(db.WithContext(ctx), clientId)
+	if err != nil {
+		return nil, err
+	}
+	client := &ClientInfo{
+		ClientId:    appInfo.ClientId,
+		Secret:      appInfo.Secret,
+		Secrets:     make([]ClientSecretInfo, 0, len(secrets)),
+		RedirectUri: appInfo.RedirectUri,
+		Status:      appInfo.Status,
+		CachedAt:    time.Now().Unix(),
+	}
+	for _, value := range secrets {
+		client.Secrets = append(client.Secrets, ClientSecretInfo{
+			Hash:      value.Hash,
+			ExpiresAt: value.ExpiresAt,
+		})
+	}
+	if appInfo.TokenPolicy != "" {
+		client.TokenPolicy = &server.TokenPolicy{}
+		if err = json.Unmarshal([]byte(appInfo.TokenPolicy), client.TokenPolicy); err != nil {
+			return nil, fmt.Errorf("unmarshal token policy failed, err: %w", err)
+		}
+	}
+	return client, nil
+}
+
+// toServerClient 转换为oauth2 server使用的client，密钥校验走ClientSecretMatcher
+func (u *ClientInfo) toServerClient() *server.DefaultClient {
+	info := &server.DefaultClient{
+		Id:          u.ClientId,
+		Secret:      u.Secret,
+		RedirectUri: u.RedirectUri,
+		TokenPolicy: u.TokenPolicy,
+	}
+	for _, value := range u.Secrets {
+		info.Secrets = append(info.Secrets, server.ClientSecret{
+			Hash:      value.Hash,
+			ExpiresAt: value.ExpiresAt,
+		})
+	}
+	// 创建时没有密钥的是公共客户端，机密客户端不能退役最后一个密钥
+	info.Public = info.Secret == "" && len(info.Secrets) == 0
+	return info
+}
+
+// refreshClientCache 从数据库重新加载客户端信息，写入sso:client缓存
+func (s *API) refreshClientCache(ctx context.Context, clientId string) (err error) {
+	client, err := loadClientInfo(ctx, s.db, clientId)
+	if err != nil {
+		return fmt.Errorf("sso storage refreshClientCache load failed, err: %w", err)
+	}
+	err = s.redis.HSet(ctx, s.config.layout.clientKey(clientId), clientId, client.Marshal())
+	if err != nil {
+		return fmt.Errorf("sso storage refreshClientCache failed, err: %w", err)
+	}
+	s.publishClientInvalidation(ctx, clientId)
+	return nil
+}
+
+// publishClientInvalidation 通知所有节点删除进程内的客户端缓存，失败时只记录日志，进程内缓存会在有效期后过期
+func (s *API) publishClientInvalidation(ctx context.Context, clientId string) {
+	if s.config.clientInvalidationChannel == "" {
+		return
+	}
+	if err := clientcache.Publish(ctx, s.redis, s.config.clientInvalidationChannel, clientId); err != nil {
+		s.logger.Warn("sso storage publish client invalidation failed", elog.FieldErr(err), elog.FieldKey(clientId))
+	}
+}
+
+// SuspendClient 暂停客户端，暂停后客户端不能登录、换取token
+// revokeTokens 为true时，吊销该客户端所有的sub token，返回吊销的个数
+func (s *API) SuspendClient(ctx context.Context, clientId string, revokeTokens bool) (revoked int, err error) {
+	err = s.updateClientStatus(ctx, clientId, dao.AppStatusSuspended)
+	if err != nil {
+		return 0, err
+	}
+	if !revokeTokens {
+		return 0, nil
+	}
+	revoked, err = s.tokenServer.revokeTokensByClientId(ctx, clientId)
+	if err != nil {
+		return revoked, fmt.Errorf("sso storage SuspendClient revoke tokens failed, err: %w", err)
+	}
+	return revoked, nil
+}
+
+// ResumeClient 恢复被暂停的客户端
+func (s *API) ResumeClient(ctx context.Context, clientId string) (err error) {
+	return s.updateClientStatus(ctx, clientId, dao.AppStatusActive)
+}
+
+func (s *API) updateClientStatus(ctx context.Context, clientId string, status int) (err error) {
+	_, err = dao.GetAppInfoByClientId(s.db.WithContext(ctx), clientId)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return fmt.Errorf("sso storage updateClientStatus client not found, err: %w", server.ErrNotFound)
+	}
+	if err != nil {
+		return fmt.Errorf("sso storage updateClientStatus failed, err: %w", err)
+	}
+	err = dao.UpdateAppStatus(s.db.WithContext(ctx), clientId, status)
+	if err != nil {
+		return fmt.Errorf("sso storage updateClientStatus failed2, err: %w", err)
+	}
+	return s.refreshClientCache(ctx, clientId)
+}
+
+// GenerateClientSecret 为客户端生成一个新密钥，返回的明文只有这一次可以拿到
+// expiresAt 为过期时间戳，0表示不过期。老密钥在退役前仍然有效，可以做到无缝轮换
+func (s *API) GenerateClientSecret(ctx context.Context, clientId string, expiresAt int64) (secret string, info *dao.AppSecret, err error) {
+	secret, info, err = admin.GenerateClientSecret(s.db.WithContext(ctx), clientId, expiresAt)
+	if err != nil {
+		return "", nil, fmt.Errorf("sso storage GenerateClientSecret failed, err: %w", err)
+	}
+	if err = s.refreshClientCache(ctx, clientId); err != nil {
+		return "", nil, err
+	}
+	return secret, info, nil
+}
+
+// RetireClientSecret 退役客户端的某个密钥，退役后立即失效
+// 不能退役最后一个有效密钥，返回 admin.ErrLastClientSecret
+func (s *API) RetireClientSecret(ctx context.Context, clientId string, secretId int) (err error) {
+	err = admin.RetireClientSecret(s.db.WithContext(ctx), clientId, secretId)
+	if err != nil {
+		return fmt.Errorf("sso storage RetireClientSecret failed, err: %w", err)
+	}
+	return s.refreshClientCache(ctx, clientId)
+}
+
+// ListClientSecrets 查询客户端未退役的密钥，不包含hash
+func (s *API) ListClientSecrets(ctx context.Context, clientId string) (list []dao.AppSecret, err error) {
+	list, err = dao.ListAppSecretsByClientId(s.db.WithContext(ctx), clientId)
+	if err != nil {
+		return nil, fmt.Errorf("sso storage ListClientSecrets failed, err: %w", err)
+	}
+	return list, nil
+}
+
+// HashLegacyClientSecrets 将app表里的明文密钥迁移为hash密钥，返回迁移的客户端个数
+func (s *API) HashLegacyClientSecrets(ctx context.Context) (cnt int, err error) {
+	apps := make([]dao.App, 0)
+	err = s.db.WithContext(ctx).Where("secret != '' and dtime = 0").Find(&apps).Error
+	if err != nil {
+		return 0, fmt.Errorf("sso storage HashLegacyClientSecrets find failed, err: %w", err)
+	}
+	for _, app := range apps {
+		err = s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			if _, err := admin.CreateClientSecret(tx, app.ClientId, app.Secret, 0); err != nil {
+				return err
+			}
+			return dao.UpdateApp(tx, app.ClientId, map[string]interface{}{"secret": ""})
+		})
+		if err != nil {
+			return cnt, fmt.Errorf("sso storage HashLegacyClientSecrets failed, client_id: %s, err: %w", app.ClientId, err)
+		}
+		if err = s.refreshClientCache(ctx, app.ClientId); err != nil {
+			return cnt, err
+		}
+		cnt++
+	}
+	return cnt, nil
+}
+
+// GetClientDetail 从数据库查询客户端详情，包含调用次数和跳转地址
+func (s *API) GetClientDetail(ctx context.Context, clientId string) (client *admin.Client, err error) {
+	client, err = admin.GetClientDetail(s.db.WithContext(ctx), clientId)
+	if err != nil {
+		return nil, fmt.Errorf("sso storage GetClientDetail failed, err: %w", err)
+	}
+	return client, nil
+}
+
+// ListClients 按照名称、client id、状态、创建时间查询客户端列表，使用游标分页
+func (s *API) ListClients(ctx context.Context, req admin.ListClientsRequest) (resp *admin.ListClientsResponse, err error) {
+	resp, err = admin.ListClients(s.db.WithContext(ctx), req)
+	if err != nil {
+		return nil, fmt.Errorf("sso storage ListClients failed, err: %w", err)
+	}
+	return resp, nil
+}
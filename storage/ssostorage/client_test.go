@@ -0,0 +1,135 @@
+package ssostorage
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ego-component/eoauth2/server"
+	"github.com/ego-component/eoauth2/server/model"
+	"github.com/ego-component/eoauth2/storage/admin"
+	"github.com/ego-component/eoauth2/storage/dao"
+)
+
+func TestRetireClientSecret(t *testing.T) {
+	c, _ := newTestComponent(t)
+	api := c.GetAPI()
+	ctx := context.Background()
+	// 明文密钥迁移为hash之后，只剩hash密钥
+	if _, err := api.HashLegacyClientSecrets(ctx); err != nil {
+		t.Fatal(err)
+	}
+	list, err := api.ListClientSecrets(ctx, "storagetest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 1 {
+		t.Fatalf("secrets after hash legacy, want: 1, got: %d", len(list))
+	}
+	legacyId := list[0].Id
+
+	// 最后一个有效密钥不能退役，过期的密钥不算有效密钥
+	if _, _, err = api.GenerateClientSecret(ctx, "storagetest", time.Now().Add(-time.Second).Unix()); err != nil {
+		t.Fatal(err)
+	}
+	if err = api.RetireClientSecret(ctx, "storagetest", legacyId); !errors.Is(err, admin.ErrLastClientSecret) {
+		t.Fatalf("retire last secret, want ErrLastClientSecret, got: %v", err)
+	}
+	assertClientSecret(t, c, "secret", true)
+
+	// 生成新密钥之后可以退役老密钥，老密钥立即失效
+	secret, info, err := api.GenerateClientSecret(ctx, "storagetest", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = api.RetireClientSecret(ctx, "storagetest", legacyId); err != nil {
+		t.Fatal(err)
+	}
+	assertClientSecret(t, c, "secret", false)
+	assertClientSecret(t, c, secret, true)
+	assertClientSecret(t, c, "", false)
+
+	if err = api.RetireClientSecret(ctx, "storagetest", info.Id); !errors.Is(err, admin.ErrLastClientSecret) {
+		t.Errorf("retire new secret, want ErrLastClientSecret, got: %v", err)
+	}
+	if err = api.RetireClientSecret(ctx, "storagetest", legacyId); !errors.Is(err, server.ErrNotFound) {
+		t.Errorf("retire retired secret, want ErrNotFound, got: %v", err)
+	}
+}
+
+// assertClientSecret 检查客户端密钥是否匹配
+func assertClientSecret(t *testing.T, c *Component, secret string, want bool) {
+	t.Helper()
+	client, err := c.GetStorage().GetClient(context.Background(), "storagetest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := server.CheckClientSecret(client, secret); got != want {
+		t.Errorf("secret %q matches, want: %v, got: %v", secret, want, got)
+	}
+}
+
+func TestGetClientStatus(t *testing.T) {
+	for name, c := range map[string]struct {
+		status int
+		dtime  int64
+		want   error
+	}{
+		"legacy":                {status: 0},
+		"active":                {status: dao.AppStatusActive},
+		"suspended":             {status: dao.AppStatusSuspended, want: server.ErrClientSuspended},
+		"deleted":               {status: dao.AppStatusDeleted, dtime: 1, want: server.ErrNotFound},
+		"deleted without dtime": {status: dao.AppStatusDeleted, want: server.ErrNotFound},
+	} {
+		t.Run(name, func(t *testing.T) {
+			component, _ := newTestComponent(t)
+			err := component.db.Model(&dao.App{}).Where("client_id = ?", "storagetest").Updates(map[string]interface{}{"status": c.status, "dtime": c.dtime}).Error
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = component.GetStorage().GetClient(context.Background(), "storagetest")
+			if c.want == nil && err != nil {
+				t.Errorf("GetClient, want nil, got: %v", err)
+			}
+			if c.want != nil && !errors.Is(err, c.want) {
+				t.Errorf("GetClient, want: %v, got: %v", c.want, err)
+			}
+		})
+	}
+}
+
+func TestSuspendResumeClient(t *testing.T) {
+	c, _ := newTestComponent(t)
+	api := c.GetAPI()
+	ctx := context.Background()
+	access := login(t, c, 1, model.NewToken(100))
+	// 写入sso:client缓存，暂停、恢复之后缓存需要更新
+	if _, err := c.GetStorage().GetClient(ctx, "storagetest"); err != nil {
+		t.Fatal(err)
+	}
+
+	revoked, err := api.SuspendClient(ctx, "storagetest", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if revoked != 1 {
+		t.Errorf("revoked tokens, want: 1, got: %d", revoked)
+	}
+	if _, err = c.GetStorage().GetClient(ctx, "storagetest"); !errors.Is(err, server.ErrClientSuspended) {
+		t.Errorf("GetClient after suspend, want ErrClientSuspended, got: %v", err)
+	}
+	if _, err = c.tokenServer.getAccess(ctx, access.AccessToken); err == nil {
+		t.Error("token should be revoked after suspend")
+	}
+
+	if err = api.ResumeClient(ctx, "storagetest"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = c.GetStorage().GetClient(ctx, "storagetest"); err != nil {
+		t.Errorf("GetClient after resume, want nil, got: %v", err)
+	}
+	if _, err = api.SuspendClient(ctx, "missing", false); !errors.Is(err, server.ErrNotFound) {
+		t.Errorf("suspend missing client, want ErrNotFound, got: %v", err)
+	}
+}
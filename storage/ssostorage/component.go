@@ -2,6 +2,7 @@ package ssostorage
 
 import (
 	"context"
+	"time"
 
 	"github.com/ego-component/egorm"
 	"github.com/ego-component/eoauth2/server"
@@ -29,16 +30,17 @@ func NewComponent(db *egorm.Component, redis *eredis.Component, options ...Optio
 	for _, option := range options {
 		option(container)
 	}
+	container.config.initLayout()
 
 	uidMapParentTokenObj := newUidMapParentToken(container.config, redis)
 	parentTokenObj := newParentToken(container.config, redis)
 	subTokenObj := newSubToken(container.config, redis)
 
-	tSrv := initTokenServer(container.config, uidMapParentTokenObj, parentTokenObj, subTokenObj)
+	tSrv := initTokenServer(container.config, container.logger, redis, uidMapParentTokenObj, parentTokenObj, subTokenObj)
 	container.tokenServer = tSrv
 	container.redis = redis
 	container.storage = newStorage(container.config, container.logger, db, redis, tSrv)
-	container.api = newAPI(container.config, container.logger, db, redis, uidMapParentTokenObj, parentTokenObj, subTokenObj)
+	container.api = newAPI(container.config, container.logger, db, redis, uidMapParentTokenObj, parentTokenObj, subTokenObj, tSrv)
 	return container
 }
 
@@ -52,6 +54,9 @@ func (s *Component) GetAPI() *API {
 
 // RemoveAllAccess 通过token，删除自己的token，以及父token
 func (s *Component) RemoveAllAccess(ctx context.Context, token string) (err error) {
+	if token, err = s.tokenServer.lookupSubToken(ctx, token); err != nil {
+		return err
+	}
 	pToken, err := s.tokenServer.getParentTokenByToken(ctx, token)
 	if err != nil {
 		return err
@@ -62,7 +67,7 @@ func (s *Component) RemoveAllAccess(ctx context.Context, token string) (err erro
 
 // GetUidByParentToken 用于单账号
 func (s *Component) GetUidByParentToken(ctx context.Context, token string) (uid int64, err error) {
-	uids, err := s.tokenServer.getUidsByParentToken(ctx, token)
+	uids, err := s.GetUidsByParentToken(ctx, token)
 	if err != nil {
 		return 0, err
 	}
@@ -72,7 +77,7 @@ func (s *Component) GetUidByParentToken(ctx context.Context, token string) (uid
 
 // GetUidByToken 用于单账号
 func (s *Component) GetUidByToken(ctx context.Context, token string) (uid int64, err error) {
-	uids, err := s.tokenServer.getUidsByToken(ctx, token)
+	uids, err := s.GetUidsByToken(ctx, token)
 	if err != nil {
 		return 0, err
 	}
@@ -82,19 +87,59 @@ func (s *Component) GetUidByToken(ctx context.Context, token string) (uid int64,
 
 // GetUidsByParentToken 用于多账号
 func (s *Component) GetUidsByParentToken(ctx context.Context, token string) (uids []int64, err error) {
+	if token, err = s.tokenServer.lookupParentToken(ctx, token); err != nil {
+		return nil, err
+	}
 	return s.tokenServer.getUidsByParentToken(ctx, token)
 }
 
 // GetUidsByToken 用于多账号
 func (s *Component) GetUidsByToken(ctx context.Context, token string) (uid []int64, err error) {
+	if token, err = s.tokenServer.lookupSubToken(ctx, token); err != nil {
+		return nil, err
+	}
 	return s.tokenServer.getUidsByToken(ctx, token)
 }
 
+// GetActiveUidByToken 多账号下获得token所属的账号，token没有指定账号时返回第一个账号
+func (s *Component) GetActiveUidByToken(ctx context.Context, token string) (uid int64, err error) {
+	if token, err = s.tokenServer.lookupSubToken(ctx, token); err != nil {
+		return 0, err
+	}
+	return s.tokenServer.getTokenUid(ctx, token)
+}
+
+// SetActiveUid 多账号下切换token所属的账号，账号没有在parent token里登录返回 ErrUidNotInParentToken
+func (s *Component) SetActiveUid(ctx context.Context, token string, uid int64) error {
+	token, err := s.tokenServer.lookupSubToken(ctx, token)
+	if err != nil {
+		return err
+	}
+	return s.tokenServer.setTokenUid(ctx, token, uid)
+}
+
+// RemoveAccount 多账号下退出parent token里的一个账号，其他账号保持登录，属于该账号的token会被吊销
+// 退出的是最后一个账号时，删除整个parent token
+func (s *Component) RemoveAccount(ctx context.Context, pToken string, uid int64) error {
+	pToken, err := s.tokenServer.lookupParentToken(ctx, pToken)
+	if err != nil {
+		return err
+	}
+	return s.tokenServer.removeParentTokenUid(ctx, pToken, uid)
+}
+
 func (s *Component) RemoveParentToken(ctx context.Context, pToken string) (err error) {
+	if pToken, err = s.tokenServer.lookupParentToken(ctx, pToken); err != nil {
+		return err
+	}
 	return s.tokenServer.removeParentToken(ctx, pToken)
 }
 
-// todo 后期再说 RenewParentToken 续期父级token
-//func (s *Component) RenewParentToken(ctx context.Context, pToken model.Field) (err error) {
-//	return s.tokenServer.renewParentToken(ctx, pToken)
-//}
+// RenewParentToken 续期父级token，expiration为0时使用登录时的有效期
+// 续期后的过期时间不能超过 WithParentTokenMaxLifetime 设置的最大会话时长，返回续期后的过期时间戳
+func (s *Component) RenewParentToken(ctx context.Context, pToken string, expiration time.Duration) (expireAt int64, err error) {
+	if pToken, err = s.tokenServer.lookupParentToken(ctx, pToken); err != nil {
+		return 0, err
+	}
+	return s.tokenServer.renewParentToken(ctx, pToken, int64(expiration.Seconds()))
+}
@@ -1,5 +1,11 @@
 package ssostorage
 
+import (
+	"time"
+
+	"github.com/ego-component/eoauth2/storage/tokenhash"
+)
+
 type Option func(c *Component)
 
 func WithUidMapParentTokenKey(key string) Option {
@@ -25,3 +31,69 @@ func WithEnableMultipleAccounts(flag bool) Option {
 		c.config.enableMultipleAccounts = flag
 	}
 }
+
+// WithClientCacheExpiration sso:client 缓存的有效期，过期后从数据库重新加载客户端信息
+func WithClientCacheExpiration(expiration time.Duration) Option {
+	return func(c *Component) {
+		c.config.clientCacheExpiration = int64(expiration.Seconds())
+	}
+}
+
+// WithParentTokenSliding parent token 滑动过期，每次下发、刷新sub token都会续期parent token
+func WithParentTokenSliding(flag bool) Option {
+	return func(c *Component) {
+		c.config.parentTokenSliding = flag
+	}
+}
+
+// WithParentTokenMaxLifetime parent token 从登录开始的最大会话时长，续期不能超过该时长
+func WithParentTokenMaxLifetime(maxLifetime time.Duration) Option {
+	return func(c *Component) {
+		c.config.parentTokenMaxLifetime = int64(maxLifetime.Seconds())
+	}
+}
+
+// WithRedeemedCodeExpiration 已兑换code墓碑的有效期，有效期内code被重复使用会吊销已经下发的token
+func WithRedeemedCodeExpiration(expiration time.Duration) Option {
+	return func(c *Component) {
+		c.config.redeemedCodeExpiration = int64(expiration.Seconds())
+	}
+}
+
+// WithClusterKeyLayout 使用Redis Cluster的key布局，同一个用户的token在同一个slot，客户端缓存分片存储
+// 老数据需要使用 MigrateKeyLayout 迁移
+func WithClusterKeyLayout(prefix string) Option {
+	return func(c *Component) {
+		c.config.clusterKeyPrefix = prefix
+	}
+}
+
+// WithClientCacheShards Redis Cluster key布局下，sso:client 缓存的分片个数，默认16
+func WithClientCacheShards(shards int) Option {
+	return func(c *Component) {
+		c.config.clientCacheShards = shards
+	}
+}
+
+// WithSessionPolicy 限制用户同时在线的会话个数，超过限制时踢掉最早登录的会话，或者拒绝新的登录
+func WithSessionPolicy(policy SessionPolicy) Option {
+	return func(c *Component) {
+		c.config.sessionPolicy = &policy
+	}
+}
+
+// WithTokenHash redis里只保存token的HMAC-SHA256，不保存明文的parent token、sub token、code
+// legacyLookup 为true时，查询token会同时查询明文，开启之前下发的token在过期之前仍然有效
+func WithTokenHash(secret []byte, legacyLookup bool) Option {
+	return func(c *Component) {
+		c.config.hasher = tokenhash.New(secret, legacyLookup)
+	}
+}
+
+// WithClientInvalidationChannel 修改、删除客户端后发布失效通知的channel，默认 clientcache.DefaultChannel，为空表示不发布
+// 和 clientcache.WithInvalidation 使用同一个channel
+func WithClientInvalidationChannel(channel string) Option {
+	return func(c *Component) {
+		c.config.clientInvalidationChannel = channel
+	}
+}
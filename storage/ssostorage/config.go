@@ -1,5 +1,10 @@
 package ssostorage
 
+import (
+	"github.com/ego-component/eoauth2/storage/clientcache"
+	"github.com/ego-component/eoauth2/storage/tokenhash"
+)
+
 type config struct {
 	enableMultipleAccounts bool // 开启多账号，默认false
 	/*
@@ -41,15 +46,45 @@ type config struct {
 	subTokenMapParentTokenKey string // token与父级token的映射关系
 	storeClientInfoKey        string // 存储sso client的信息
 	storeAuthorizeKey         string // 存储sso authorize的信息
+	storeInitialAccessKey     string // 存储动态注册客户端的initial access token，key里是token的sha256
+	storeRedeemedCodeKey      string // 存储已兑换code的墓碑，记录用该code下发的token
+	redeemedCodeExpiration    int64  // 已兑换code墓碑的有效期(s)
+	clientCacheExpiration     int64  // sso:client 缓存的有效期(s)，过期后从数据库重新加载，避免绕过API修改数据库后缓存一直有效
+	clusterKeyPrefix          string // 不为空时使用Redis Cluster的key布局，key以该前缀开头
+	clientCacheShards         int    // Redis Cluster key布局下，sso:client 缓存的分片个数
+	layout                    keyLayout
+	parentTokenSliding        bool              // parent token 滑动过期，每次下发sub token都续期，默认false
+	parentTokenMaxLifetime    int64             // parent token 从登录开始的最大会话时长(s)，续期不能超过该时长，0表示不限制
+	sessionPolicy             *SessionPolicy    // 用户同时在线的会话个数限制，nil表示不限制
+	hasher                    *tokenhash.Hasher // token hash，nil表示redis里存储明文token
+	clientInvalidationChannel string            // 修改客户端后发布失效通知的channel，为空表示不发布
 }
 
 func defaultConfig() *config {
 	return &config{
 		enableMultipleAccounts:    false,
-		uidMapParentTokenKey:      "sso:uid:%d",  // uid map parent token type
-		parentTokenMapSubTokenKey: "sso:ptk:%s",  // parent token map
-		subTokenMapParentTokenKey: "sso:stk:%s",  // sub token map parent token
-		storeClientInfoKey:        "sso:client",  // sso的client信息，使用hash map
-		storeAuthorizeKey:         "sso:auth:%s", // 存储auth信息
+		uidMapParentTokenKey:      "sso:uid:%d",   // uid map parent token type
+		parentTokenMapSubTokenKey: "sso:ptk:%s",   // parent token map
+		subTokenMapParentTokenKey: "sso:stk:%s",   // sub token map parent token
+		storeClientInfoKey:        "sso:client",   // sso的client信息，使用hash map
+		storeAuthorizeKey:         "sso:auth:%s",  // 存储auth信息
+		storeInitialAccessKey:     "sso:iat:%s",   // 存储initial access token信息
+		storeRedeemedCodeKey:      "sso:rcode:%s", // 存储已兑换code的墓碑
+		redeemedCodeExpiration:    600,
+		clientCacheExpiration:     60,
+		clusterKeyPrefix:          "",
+		clientCacheShards:         16,
+		parentTokenSliding:        false,
+		parentTokenMaxLifetime:    0,
+		clientInvalidationChannel: clientcache.DefaultChannel,
+	}
+}
+
+// initLayout 根据配置初始化key布局
+func (c *config) initLayout() {
+	if c.clusterKeyPrefix != "" {
+		c.layout = newClusterKeyLayout(c.clusterKeyPrefix, c.clientCacheShards)
+		return
 	}
+	c.layout = newLegacyKeyLayout(c)
 }
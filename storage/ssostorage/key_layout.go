@@ -0,0 +1,247 @@
+package ssostorage
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/ego-component/eredis"
+	"github.com/go-redis/redis/v8"
+)
+
+// keyLayout sso在redis里的key布局
+type keyLayout interface {
+	uidKey(uid int64) string
+	parentTokenKey(pToken string) string
+	subTokenKey(subToken string) string
+	authorizeKey(code string) string
+	redeemedCodeKey(code string) string
+	// clientKey sso:client 缓存的hash key，field为client id
+	clientKey(clientId string) string
+	// subTokenScanPattern SCAN所有sub token的pattern
+	subTokenScanPattern() string
+	// parentTokenScanPattern SCAN所有parent token的pattern
+	parentTokenScanPattern() string
+	// uidScanPattern SCAN所有uid的pattern
+	uidScanPattern() string
+	// subTokenFromKey 从sub token key里解析出sub token
+	subTokenFromKey(key string) string
+	// tagParentToken 为新生成的parent token加上hash tag
+	tagParentToken(pToken string, uid int64) string
+	// tagSubToken 为新生成的sub token加上parent token的hash tag
+	tagSubToken(subToken string, pToken string) string
+	// colocated uid key和parent token key是否可以在同一个lua脚本里操作
+	colocated(uid int64, pToken string) bool
+	// colocatedSubToken sub token key和parent token key是否可以在同一个lua脚本里操作，迁移过来的老token不在同一个slot
+	colocatedSubToken(subToken string, pToken string) bool
+}
+
+// legacyKeyLayout 老的key布局，key格式可以通过Option配置，只能用于单节点redis
+type legacyKeyLayout struct {
+	config *config
+}
+
+func newLegacyKeyLayout(config *config) *legacyKeyLayout {
+	return &legacyKeyLayout{config: config}
+}
+
+func (l *legacyKeyLayout) uidKey(uid int64) string {
+	return fmt.Sprintf(l.config.uidMapParentTokenKey, uid)
+}
+
+func (l *legacyKeyLayout) parentTokenKey(pToken string) string {
+	return fmt.Sprintf(l.config.parentTokenMapSubTokenKey, pToken)
+}
+
+func (l *legacyKeyLayout) subTokenKey(subToken string) string {
+	return fmt.Sprintf(l.config.subTokenMapParentTokenKey, subToken)
+}
+
+func (l *legacyKeyLayout) authorizeKey(code string) string {
+	return fmt.Sprintf(l.config.storeAuthorizeKey, code)
+}
+
+func (l *legacyKeyLayout) redeemedCodeKey(code string) string {
+	return fmt.Sprintf(l.config.storeRedeemedCodeKey, code)
+}
+
+func (l *legacyKeyLayout) clientKey(clientId string) string {
+	return l.config.storeClientInfoKey
+}
+
+func (l *legacyKeyLayout) subTokenScanPattern() string {
+	return l.subTokenKey("*")
+}
+
+func (l *legacyKeyLayout) parentTokenScanPattern() string {
+	return l.parentTokenKey("*")
+}
+
+func (l *legacyKeyLayout) uidScanPattern() string {
+	prefix, suffix := splitKeyFormat(l.config.uidMapParentTokenKey)
+	return prefix + "*" + suffix
+}
+
+func (l *legacyKeyLayout) subTokenFromKey(key string) string {
+	prefix, suffix := splitKeyFormat(l.config.subTokenMapParentTokenKey)
+	return strings.TrimSuffix(strings.TrimPrefix(key, prefix), suffix)
+}
+
+func (l *legacyKeyLayout) tagParentToken(pToken string, uid int64) string {
+	return pToken
+}
+
+func (l *legacyKeyLayout) tagSubToken(subToken string, pToken string) string {
+	return subToken
+}
+
+func (l *legacyKeyLayout) colocated(uid int64, pToken string) bool {
+	return true
+}
+
+func (l *legacyKeyLayout) colocatedSubToken(subToken string, pToken string) bool {
+	return true
+}
+
+// clusterKeyLayout Redis Cluster使用的key布局
+// token格式为 {tag}.{random}，tag由uid计算，同一个用户的uid、parent token、sub token key在同一个slot
+// 没有tag的老token，使用整个token作为tag
+type clusterKeyLayout struct {
+	prefix       string
+	clientShards int
+}
+
+func newClusterKeyLayout(prefix string, clientShards int) *clusterKeyLayout {
+	if clientShards <= 0 {
+		clientShards = 1
+	}
+	return &clusterKeyLayout{
+		prefix:       prefix,
+		clientShards: clientShards,
+	}
+}
+
+// uidTag 根据uid计算hash tag，不直接暴露uid
+func (l *clusterKeyLayout) uidTag(uid int64) string {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(strconv.FormatInt(uid, 10)))
+	return fmt.Sprintf("%04x", h.Sum32()%16384)
+}
+
+// tokenTag 从token里解析出hash tag
+func (l *clusterKeyLayout) tokenTag(token string) string {
+	if index := strings.Index(token, "."); index > 0 {
+		return token[:index]
+	}
+	return token
+}
+
+func (l *clusterKeyLayout) uidKey(uid int64) string {
+	return fmt.Sprintf("%s:{%s}:uid:%d", l.prefix, l.uidTag(uid), uid)
+}
+
+func (l *clusterKeyLayout) parentTokenKey(pToken string) string {
+	return fmt.Sprintf("%s:{%s}:ptk:%s", l.prefix, l.tokenTag(pToken), pToken)
+}
+
+func (l *clusterKeyLayout) subTokenKey(subToken string) string {
+	return fmt.Sprintf("%s:{%s}:stk:%s", l.prefix, l.tokenTag(subToken), subToken)
+}
+
+func (l *clusterKeyLayout) authorizeKey(code string) string {
+	return fmt.Sprintf("%s:{%s}:auth", l.prefix, code)
+}
+
+func (l *clusterKeyLayout) redeemedCodeKey(code string) string {
+	return fmt.Sprintf("%s:{%s}:rcode", l.prefix, code)
+}
+
+// clientKey 客户端缓存按照client id分片，避免一个大hash成为热点key
+func (l *clusterKeyLayout) clientKey(clientId string) string {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(clientId))
+	return fmt.Sprintf("%s:client:%d", l.prefix, h.Sum32()%uint32(l.clientShards))
+}
+
+func (l *clusterKeyLayout) subTokenScanPattern() string {
+	return l.prefix + ":{*}:stk:*"
+}
+
+func (l *clusterKeyLayout) parentTokenScanPattern() string {
+	return l.prefix + ":{*}:ptk:*"
+}
+
+func (l *clusterKeyLayout) uidScanPattern() string {
+	return l.prefix + ":{*}:uid:*"
+}
+
+func (l *clusterKeyLayout) subTokenFromKey(key string) string {
+	if index := strings.Index(key, "}:stk:"); index > 0 {
+		return key[index+len("}:stk:"):]
+	}
+	return key
+}
+
+func (l *clusterKeyLayout) tagParentToken(pToken string, uid int64) string {
+	// 多账号复用的parent token已经有tag
+	if strings.Contains(pToken, ".") {
+		return pToken
+	}
+	return l.uidTag(uid) + "." + pToken
+}
+
+func (l *clusterKeyLayout) tagSubToken(subToken string, pToken string) string {
+	return l.tokenTag(pToken) + "." + subToken
+}
+
+func (l *clusterKeyLayout) colocated(uid int64, pToken string) bool {
+	return l.uidTag(uid) == l.tokenTag(pToken)
+}
+
+func (l *clusterKeyLayout) colocatedSubToken(subToken string, pToken string) bool {
+	return l.tokenTag(subToken) == l.tokenTag(pToken)
+}
+
+// splitKeyFormat 将 sso:uid:%d 这种key格式拆分为前缀和后缀
+func splitKeyFormat(format string) (prefix string, suffix string) {
+	index := strings.Index(format, "%")
+	if index < 0 || index+1 >= len(format) {
+		return format, ""
+	}
+	return format[:index], format[index+2:]
+}
+
+// scanKeys 遍历匹配pattern的key，Redis Cluster会遍历所有master节点，fn串行执行
+func scanKeys(ctx context.Context, rds *eredis.Component, pattern string, fn func(keys []string) error) error {
+	var mu sync.Mutex
+	scan := func(ctx context.Context, client redis.Cmdable) error {
+		var cursor uint64
+		for {
+			keys, nextCursor, err := client.Scan(ctx, cursor, pattern, 500).Result()
+			if err != nil {
+				return fmt.Errorf("scanKeys failed, err: %w", err)
+			}
+			if len(keys) > 0 {
+				mu.Lock()
+				err = fn(keys)
+				mu.Unlock()
+				if err != nil {
+					return err
+				}
+			}
+			if nextCursor == 0 {
+				return nil
+			}
+			cursor = nextCursor
+		}
+	}
+	if cluster, ok := rds.Client().(*redis.ClusterClient); ok {
+		return cluster.ForEachMaster(ctx, func(ctx context.Context, client *redis.Client) error {
+			return scan(ctx, client)
+		})
+	}
+	return scan(ctx, rds.Client())
+}
@@ -0,0 +1,113 @@
+package ssostorage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/ego-component/eredis"
+	"github.com/go-redis/redis/v8"
+	"github.com/spf13/cast"
+)
+
+// MigrateStats 迁移key布局的统计信息
+type MigrateStats struct {
+	Users        int // 迁移的 sso:uid 个数
+	ParentTokens int // 迁移的 sso:ptk 个数
+	SubTokens    int // 迁移的 sso:stk 个数
+	Codes        int // 迁移的 sso:auth、sso:rcode 个数
+	Clients      int // 迁移的 sso:client 缓存个数
+}
+
+// MigrateKeyLayout 将老的key布局里的数据迁移到当前组件的key布局，用于切换到Redis Cluster
+// src 为老数据所在的redis，key格式使用组件配置的格式；数据使用 DUMP、RESTORE 复制，保留过期时间
+// 老token没有hash tag，迁移后仍然可以使用，新下发的token会使用新的hash tag
+// deleteSource 为true时，迁移成功后删除src里的key
+func (c *Component) MigrateKeyLayout(ctx context.Context, src *eredis.Component, deleteSource bool) (*MigrateStats, error) {
+	if _, ok := c.config.layout.(*legacyKeyLayout); ok {
+		return nil, errors.New("ssostorage.MigrateKeyLayout failed, err: target key layout is legacy, use WithClusterKeyLayout")
+	}
+	legacy := newLegacyKeyLayout(c.config)
+	stats := &MigrateStats{}
+	items := []struct {
+		format string
+		target func(id string) string
+		cnt    *int
+	}{
+		{c.config.uidMapParentTokenKey, func(id string) string { return c.config.layout.uidKey(cast.ToInt64(id)) }, &stats.Users},
+		{c.config.parentTokenMapSubTokenKey, c.config.layout.parentTokenKey, &stats.ParentTokens},
+		{c.config.subTokenMapParentTokenKey, c.config.layout.subTokenKey, &stats.SubTokens},
+		{c.config.storeAuthorizeKey, c.config.layout.authorizeKey, &stats.Codes},
+		{c.config.storeRedeemedCodeKey, c.config.layout.redeemedCodeKey, &stats.Codes},
+	}
+	for _, item := range items {
+		prefix, suffix := splitKeyFormat(item.format)
+		err := scanKeys(ctx, src, prefix+"*"+suffix, func(keys []string) error {
+			for _, key := range keys {
+				id := strings.TrimSuffix(strings.TrimPrefix(key, prefix), suffix)
+				ok, err := c.migrateKey(ctx, src, key, item.target(id), deleteSource)
+				if err != nil {
+					return err
+				}
+				if ok {
+					*item.cnt++
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return stats, fmt.Errorf("ssostorage.MigrateKeyLayout failed, err: %w", err)
+		}
+	}
+
+	// 客户端缓存按照client id分片
+	clients, err := src.Client().HGetAll(ctx, legacy.clientKey("")).Result()
+	if err != nil {
+		return stats, fmt.Errorf("ssostorage.MigrateKeyLayout get clients failed, err: %w", err)
+	}
+	for clientId, info := range clients {
+		err = c.redis.HSet(ctx, c.config.layout.clientKey(clientId), clientId, info)
+		if err != nil {
+			return stats, fmt.Errorf("ssostorage.MigrateKeyLayout set client failed, err: %w", err)
+		}
+		stats.Clients++
+	}
+	if deleteSource && len(clients) > 0 {
+		err = src.Client().Del(ctx, legacy.clientKey("")).Err()
+		if err != nil {
+			return stats, fmt.Errorf("ssostorage.MigrateKeyLayout delete clients failed, err: %w", err)
+		}
+	}
+	return stats, nil
+}
+
+// migrateKey 使用 DUMP、RESTORE 复制一个key，保留过期时间，key已经过期时返回false
+func (c *Component) migrateKey(ctx context.Context, src *eredis.Component, srcKey string, dstKey string, deleteSource bool) (bool, error) {
+	value, err := src.Client().Dump(ctx, srcKey).Result()
+	if errors.Is(err, redis.Nil) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("migrateKey dump failed, key: %s, err: %w", srcKey, err)
+	}
+	ttl, err := src.Client().PTTL(ctx, srcKey).Result()
+	if err != nil {
+		return false, fmt.Errorf("migrateKey pttl failed, key: %s, err: %w", srcKey, err)
+	}
+	// 没有过期时间时PTTL返回负数，RESTORE需要传0
+	if ttl < 0 {
+		ttl = 0
+	}
+	err = c.redis.Client().RestoreReplace(ctx, dstKey, ttl, value).Err()
+	if err != nil {
+		return false, fmt.Errorf("migrateKey restore failed, key: %s, err: %w", dstKey, err)
+	}
+	if deleteSource {
+		err = src.Client().Del(ctx, srcKey).Err()
+		if err != nil {
+			return false, fmt.Errorf("migrateKey delete failed, key: %s, err: %w", srcKey, err)
+		}
+	}
+	return true, nil
+}
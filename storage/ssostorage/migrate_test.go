@@ -0,0 +1,224 @@
+package ssostorage
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/alicebob/miniredis/v2/server"
+	oauth2server "github.com/ego-component/eoauth2/server"
+	"github.com/ego-component/eoauth2/server/model"
+	"github.com/ego-component/eoauth2/storage/dao"
+	"github.com/ego-component/eredis"
+)
+
+// dumpValue 测试里DUMP返回的序列化数据
+type dumpValue struct {
+	Type   string            `json:"type"`
+	String []byte            `json:"string,omitempty"` // 值里有msgpack二进制，使用[]byte按照base64编码
+	Hash   map[string][]byte `json:"hash,omitempty"`
+}
+
+// registerDumpRestore miniredis不支持hash的DUMP、RESTORE，测试里注册一个支持string、hash的实现
+// 序列化格式只在测试的miniredis之间使用，过期时间由RESTORE的参数设置
+func registerDumpRestore(t *testing.T, mr *miniredis.Miniredis) {
+	t.Helper()
+	err := mr.Server().Register("DUMP", func(c *server.Peer, cmd string, args []string) {
+		if len(args) != 1 {
+			c.WriteError("ERR wrong number of arguments for 'dump' command")
+			return
+		}
+		value := dumpValue{Type: mr.Type(args[0])}
+		switch value.Type {
+		case "none":
+			c.WriteNull()
+			return
+		case "string":
+			str, _ := mr.Get(args[0])
+			value.String = []byte(str)
+		case "hash":
+			fields, _ := mr.HKeys(args[0])
+			value.Hash = make(map[string][]byte, len(fields))
+			for _, field := range fields {
+				value.Hash[field] = []byte(mr.HGet(args[0], field))
+			}
+		default:
+			c.WriteError("ERR dump type not supported: " + value.Type)
+			return
+		}
+		bytes, _ := json.Marshal(value)
+		c.WriteBulk(string(bytes))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = mr.Server().Register("RESTORE", func(c *server.Peer, cmd string, args []string) {
+		// RESTORE key ttl serialized-value REPLACE
+		if len(args) != 4 || strings.ToUpper(args[3]) != "REPLACE" {
+			c.WriteError("ERR restore only supports REPLACE")
+			return
+		}
+		ttl, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil {
+			c.WriteError("ERR invalid ttl")
+			return
+		}
+		value := dumpValue{}
+		if err = json.Unmarshal([]byte(args[2]), &value); err != nil {
+			c.WriteError("ERR DUMP payload version or checksum are wrong")
+			return
+		}
+		mr.Del(args[0])
+		switch value.Type {
+		case "string":
+			_ = mr.Set(args[0], string(value.String))
+		case "hash":
+			for field, v := range value.Hash {
+				mr.HSet(args[0], field, string(v))
+			}
+		}
+		if ttl > 0 {
+			mr.SetTTL(args[0], time.Duration(ttl)*time.Millisecond)
+		}
+		c.WriteOK()
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func newTestRedis(t *testing.T) (*eredis.Component, *miniredis.Miniredis) {
+	mr := miniredis.RunT(t)
+	registerDumpRestore(t, mr)
+	return eredis.DefaultContainer().Build(eredis.WithStub(), eredis.WithAddr(mr.Addr())), mr
+}
+
+// TestMigrateKeyLayout 老的key布局迁移到Redis Cluster key布局，迁移后老token可以继续使用
+func TestMigrateKeyLayout(t *testing.T) {
+	for _, deleteSource := range []bool{false, true} {
+		deleteSource := deleteSource
+		t.Run("deleteSource="+strconv.FormatBool(deleteSource), func(t *testing.T) {
+			ctx := context.Background()
+			db := newTestDB(t)
+			clientIds := []string{"storagetest", "client-1", "client-2", "client-3"}
+			for _, clientId := range clientIds[1:] {
+				err := db.Create(&dao.App{ClientId: clientId, Secret: "secret", RedirectUri: "http://localhost/callback", Status: dao.AppStatusActive}).Error
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			srcRedis, srcMr := newTestRedis(t)
+			dstRedis, dstMr := newTestRedis(t)
+			old := NewComponent(db, srcRedis)
+			c := NewComponent(db, dstRedis, WithClusterKeyLayout("oauth2"), WithClientCacheShards(4))
+
+			// 老布局下登录，并留下一个还没有兑换的code
+			access := login(t, old, 1, model.NewToken(100))
+			pToken := storedParentToken(t, old, access)
+			pending := newAuthorize(t, old, 1, model.Token{Token: pToken, AuthAt: time.Now().Unix(), ExpiresIn: 100})
+			if err := old.GetStorage().SaveAuthorize(ctx, pending); err != nil {
+				t.Fatal(err)
+			}
+			for _, clientId := range clientIds {
+				if _, err := old.GetStorage().GetClient(ctx, clientId); err != nil {
+					t.Fatal(err)
+				}
+			}
+			srcMr.FastForward(30 * time.Second)
+			subToken, err := old.tokenServer.lookupSubToken(ctx, access.AccessToken)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ttls := map[string]time.Duration{}
+			for srcKey, dstKey := range map[string]string{
+				old.config.layout.uidKey(1):                  c.config.layout.uidKey(1),
+				old.config.layout.parentTokenKey(pToken):     c.config.layout.parentTokenKey(pToken),
+				old.config.layout.subTokenKey(subToken):      c.config.layout.subTokenKey(subToken),
+				old.config.layout.authorizeKey(pending.Code): c.config.layout.authorizeKey(pending.Code),
+			} {
+				ttl := srcMr.TTL(srcKey)
+				if ttl <= 0 {
+					t.Fatalf("source key %s should have ttl, got: %v", srcKey, ttl)
+				}
+				// PTTL只精确到毫秒
+				ttls[dstKey] = ttl.Truncate(time.Millisecond)
+			}
+
+			stats, err := c.MigrateKeyLayout(ctx, srcRedis, deleteSource)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := MigrateStats{Users: 1, ParentTokens: 1, SubTokens: 1, Codes: 2, Clients: len(clientIds)}
+			if *stats != want {
+				t.Errorf("migrate stats, want: %+v, got: %+v", want, *stats)
+			}
+
+			// 迁移时保留过期时间
+			for dstKey, ttl := range ttls {
+				if got := dstMr.TTL(dstKey); got != ttl {
+					t.Errorf("ttl of %s, want: %v, got: %v", dstKey, ttl, got)
+				}
+			}
+
+			// 通过cluster布局读取老token
+			loaded, err := c.GetStorage().LoadAccess(ctx, access.AccessToken)
+			if err != nil {
+				t.Fatalf("LoadAccess after migrate failed, err: %v", err)
+			}
+			if loaded.Client.GetId() != "storagetest" {
+				t.Errorf("client of migrated token, want: storagetest, got: %s", loaded.Client.GetId())
+			}
+			if got := storedParentToken(t, c, access); got != pToken {
+				t.Errorf("parent token of migrated token, want: %s, got: %s", pToken, got)
+			}
+			if expireTime := userExpireTime(t, c, 1, pToken); expireTime == 0 {
+				t.Error("migrated user should have the parent token")
+			}
+			authorize, err := c.GetStorage().LoadAuthorize(ctx, pending.Code)
+			if err != nil {
+				t.Fatalf("LoadAuthorize after migrate failed, err: %v", err)
+			}
+			issueToken(t, c, authorize, nil)
+
+			// 客户端缓存拆分到多个分片
+			shards := map[string]bool{}
+			for _, clientId := range clientIds {
+				key := c.config.layout.clientKey(clientId)
+				if !strings.HasPrefix(key, "oauth2:client:") {
+					t.Errorf("client cache key, want prefix oauth2:client:, got: %s", key)
+				}
+				if dstMr.HGet(key, clientId) == "" {
+					t.Errorf("client %s should be migrated to %s", clientId, key)
+				}
+				shards[key] = true
+			}
+			if len(shards) < 2 {
+				t.Errorf("client cache should be split into shards, got: %v", shards)
+			}
+			if dstMr.Exists(old.config.storeClientInfoKey) {
+				t.Error("legacy client cache key should not be written to target")
+			}
+			client, err := c.GetStorage().GetClient(ctx, "client-1")
+			if err != nil || !oauth2server.CheckClientSecret(client, "secret") {
+				t.Errorf("GetClient after migrate, err: %v", err)
+			}
+
+			if keys := srcMr.Keys(); deleteSource != (len(keys) == 0) {
+				t.Errorf("source keys after migrate, deleteSource: %v, got: %v", deleteSource, keys)
+			}
+		})
+	}
+}
+
+// TestMigrateKeyLayoutLegacyTarget 目标是老的key布局时不能迁移
+func TestMigrateKeyLayoutLegacyTarget(t *testing.T) {
+	c, _ := newTestComponent(t)
+	src, _ := newTestRedis(t)
+	if _, err := c.MigrateKeyLayout(context.Background(), src, false); err == nil {
+		t.Error("migrate to legacy key layout should fail")
+	}
+}
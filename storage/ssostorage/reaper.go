@@ -0,0 +1,143 @@
+package ssostorage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/gotomicro/ego/core/elog"
+	"github.com/gotomicro/ego/task/ejob"
+	"go.uber.org/zap"
+)
+
+// ReapStats 清理失效索引的统计信息
+type ReapStats struct {
+	Users        int // 扫描的 sso:uid 个数
+	ParentTokens int // 扫描的 sso:ptk 个数
+	Fields       int // 移除的失效field个数
+	DeletedUsers int // 没有任何parent token，被删除的 sso:uid 个数
+}
+
+// Reap 清理失效的索引
+// sso:stk、sso:ptk 过期后，parent token、用户里的 _etl 列表还保留着它们，直到用户下次登录才会清理
+// Reap 通过SCAN遍历所有的 sso:ptk、sso:uid，移除已经过期或者key已经不存在的field，没有parent token的用户会被删除
+func (c *Component) Reap(ctx context.Context) (*ReapStats, error) {
+	stats := &ReapStats{}
+	err := scanKeys(ctx, c.redis, c.config.layout.parentTokenScanPattern(), func(keys []string) error {
+		for _, key := range keys {
+			stats.ParentTokens++
+			cnt, _, err := c.reapExpireList(ctx, key, false, func(field string) (string, error) {
+				subToken, err := c.tokenServer.parentToken.getSubTokenByExpireTimeListField(field)
+				if err != nil {
+					return "", err
+				}
+				return c.tokenServer.subToken.getKey(subToken), nil
+			})
+			if err != nil {
+				return err
+			}
+			stats.Fields += cnt
+		}
+		return nil
+	})
+	if err != nil {
+		return stats, fmt.Errorf("ssostorage.Reap parent token failed, err: %w", err)
+	}
+
+	// 先清理parent token，再清理用户，用户里的parent token可能刚刚被清理掉
+	err = scanKeys(ctx, c.redis, c.config.layout.uidScanPattern(), func(keys []string) error {
+		for _, key := range keys {
+			stats.Users++
+			cnt, deleted, err := c.reapExpireList(ctx, key, true, func(field string) (string, error) {
+				pToken, err := c.tokenServer.parentToken.getSubTokenByExpireTimeListField(field)
+				if err != nil {
+					return "", err
+				}
+				return c.tokenServer.parentToken.getKey(pToken), nil
+			})
+			if err != nil {
+				return err
+			}
+			stats.Fields += cnt
+			if deleted {
+				stats.DeletedUsers++
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return stats, fmt.Errorf("ssostorage.Reap user failed, err: %w", err)
+	}
+	return stats, nil
+}
+
+// reapExpireList 移除 _etl 列表里已经过期，或者对应的key已经不存在的field，返回移除的个数，以及key是否被删除
+// fieldKey 返回field对应的key，sso:ptk 里的field对应 sso:stk，sso:uid 里的field对应 sso:ptk
+func (c *Component) reapExpireList(ctx context.Context, key string, deleteEmpty bool, fieldKey func(field string) (string, error)) (cnt int, deleted bool, err error) {
+	infoBytes, err := c.redis.Client().HGet(ctx, key, "_etl").Bytes()
+	if errors.Is(err, redis.Nil) {
+		// key已经过期，或者没有 _etl
+		return 0, false, nil
+	}
+	if err != nil {
+		// 单个key读取失败不影响其他key的清理
+		c.logger.Warn("ssostorage reap get expire list failed", elog.FieldErr(err), elog.FieldKey(key))
+		return 0, false, nil
+	}
+	var expireTimeList UserTokenExpires
+	if err = expireTimeList.Unmarshal(infoBytes); err != nil {
+		c.logger.Warn("ssostorage reap unmarshal expire list failed", elog.FieldErr(err), elog.FieldKey(key))
+		return 0, false, nil
+	}
+	now := time.Now().Unix()
+	args := []interface{}{"0"}
+	if deleteEmpty {
+		args[0] = "1"
+	}
+	for _, value := range expireTimeList {
+		if value.ExpireTime > now {
+			valueKey, err := fieldKey(value.Field)
+			if err != nil {
+				continue
+			}
+			// 使用 EXISTS 判断，迁移过来的老sub token可能不在同一个slot，不能在脚本里判断
+			exists, err := c.redis.Client().Exists(ctx, valueKey).Result()
+			if err != nil {
+				return 0, false, fmt.Errorf("reapExpireList exists failed, err: %w", err)
+			}
+			if exists == 1 {
+				continue
+			}
+		}
+		args = append(args, value.Field)
+	}
+	// 没有失效的field，也需要删除空的用户
+	if len(args) == 1 && (!deleteEmpty || len(expireTimeList) > 0) {
+		return 0, false, nil
+	}
+	res, err := pruneExpireListScript.Run(ctx, c.redis.Client(), []string{key}, args...).Int()
+	if err != nil {
+		return 0, false, fmt.Errorf("reapExpireList prune failed, err: %w", err)
+	}
+	return len(args) - 1, res == 1, nil
+}
+
+// ReaperJob 清理失效索引的ego job，可以通过 ego.Job 注册后运行，也可以放在ecron里定时执行
+func (c *Component) ReaperJob(name string) *ejob.Component {
+	return ejob.Job(name, func(ctx ejob.Context) error {
+		stats, err := c.Reap(ctx.Ctx)
+		if err != nil {
+			c.logger.Error("ssostorage reap failed", elog.FieldErr(err))
+			return err
+		}
+		c.logger.Info("ssostorage reap finished",
+			zap.Int("users", stats.Users),
+			zap.Int("parentTokens", stats.ParentTokens),
+			zap.Int("fields", stats.Fields),
+			zap.Int("deletedUsers", stats.DeletedUsers),
+		)
+		return nil
+	})
+}
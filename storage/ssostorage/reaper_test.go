@@ -0,0 +1,135 @@
+package ssostorage
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ego-component/eoauth2/server"
+	"github.com/ego-component/eoauth2/server/model"
+)
+
+// expireListFields parent token里 _etl 列表的所有field
+func expireListFields(t *testing.T, c *Component, pToken string) map[string]bool {
+	t.Helper()
+	store, err := c.tokenServer.parentToken.getAll(context.Background(), pToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fields := map[string]bool{}
+	for _, value := range *store.ExpireTimeList {
+		fields[value.Field] = true
+	}
+	return fields
+}
+
+// subTokenField sub token在parent token的 _etl 列表里的field
+func subTokenField(t *testing.T, c *Component, access *server.AccessData) string {
+	t.Helper()
+	subToken, err := c.tokenServer.lookupSubToken(context.Background(), access.AccessToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c.tokenServer.parentToken.getClientField(subToken)
+}
+
+// TestReap 清理过期的sub token、parent token索引，有效的索引保留
+func TestReap(t *testing.T) {
+	layouts := map[string][]Option{
+		"legacy":  nil,
+		"cluster": {WithClusterKeyLayout("oauth2")},
+	}
+	for name, options := range layouts {
+		options := options
+		t.Run(name, func(t *testing.T) {
+			c, mr := newTestComponent(t, options...)
+			ctx := context.Background()
+			// uid 1 的会话里有一个长期有效、一个很快过期的sub token
+			live := login(t, c, 1, model.NewToken(100))
+			pToken := storedParentToken(t, c, live)
+			authorize, err := c.GetStorage().LoadAuthorize(ctx, saveAuthorize(t, c, 1, model.Token{Token: pToken, AuthAt: time.Now().Unix(), ExpiresIn: 100}))
+			if err != nil {
+				t.Fatal(err)
+			}
+			expired := issueTokenExpiresIn(t, c, authorize, nil, 10)
+			// uid 2 有一个很快过期的会话和一个有效的会话
+			other := login(t, c, 2, model.NewToken(10))
+			otherToken := storedParentToken(t, c, other)
+			otherLive := login(t, c, 2, model.NewToken(100))
+			// uid 3 的parent token已经不存在，只剩下用户里的索引
+			lost := login(t, c, 3, model.NewToken(100))
+			mr.Del(c.config.layout.parentTokenKey(storedParentToken(t, c, lost)))
+
+			liveField := subTokenField(t, c, live)
+			expiredField := subTokenField(t, c, expired)
+			if fields := expireListFields(t, c, pToken); !fields[liveField] || !fields[expiredField] {
+				t.Fatalf("parent token should have both sub tokens, got: %v", fields)
+			}
+
+			mr.FastForward(20 * time.Second)
+			stats, err := c.Reap(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// uid 1 里过期的sub token field，uid 2 里过期的parent token field，uid 3 里不存在的parent token field
+			want := ReapStats{Users: 3, ParentTokens: 2, Fields: 3, DeletedUsers: 1}
+			if *stats != want {
+				t.Errorf("reap stats, want: %+v, got: %+v", want, *stats)
+			}
+
+			fields := expireListFields(t, c, pToken)
+			if !fields[liveField] {
+				t.Error("live sub token should be kept")
+			}
+			if fields[expiredField] {
+				t.Error("expired sub token should be removed")
+			}
+			if expireTime := userExpireTime(t, c, 1, pToken); expireTime == 0 {
+				t.Error("live parent token should be kept in user")
+			}
+			if expireTime := userExpireTime(t, c, 2, otherToken); expireTime != 0 {
+				t.Errorf("expired parent token should be removed from user, got expire time: %d", expireTime)
+			}
+			if expireTime := userExpireTime(t, c, 2, storedParentToken(t, c, otherLive)); expireTime == 0 {
+				t.Error("live parent token of uid 2 should be kept")
+			}
+			if mr.Exists(c.config.layout.uidKey(3)) {
+				t.Error("user without parent token should be deleted")
+			}
+			for _, access := range []*server.AccessData{live, otherLive} {
+				if _, err = c.GetStorage().LoadAccess(ctx, access.AccessToken); err != nil {
+					t.Errorf("live sub token should still be valid, err: %v", err)
+				}
+			}
+
+			// 再次清理没有需要移除的field
+			stats, err = c.Reap(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want = (ReapStats{Users: 2, ParentTokens: 2}); *stats != want {
+				t.Errorf("reap again, want: %+v, got: %+v", want, *stats)
+			}
+		})
+	}
+}
+
+// TestReapInvalidExpireList _etl 无法解析时跳过该key，不影响其他key的清理
+func TestReapInvalidExpireList(t *testing.T) {
+	c, mr := newTestComponent(t)
+	live := login(t, c, 1, model.NewToken(100))
+	broken := login(t, c, 2, model.NewToken(100))
+	mr.HSet(c.config.layout.parentTokenKey(storedParentToken(t, c, broken)), "_etl", "invalid")
+	mr.Del(c.config.layout.subTokenKey(live.AccessToken))
+
+	stats, err := c.Reap(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (ReapStats{Users: 2, ParentTokens: 2, Fields: 1}); *stats != want {
+		t.Errorf("reap stats, want: %+v, got: %+v", want, *stats)
+	}
+	if value := mr.HGet(c.config.layout.parentTokenKey(storedParentToken(t, c, broken)), "_etl"); value != "invalid" {
+		t.Errorf("invalid expire list should be skipped, got: %q", value)
+	}
+}
@@ -1,6 +1,7 @@
 package ssostorage
 
 import (
+	"github.com/ego-component/eoauth2/server"
 	"github.com/vmihailenco/msgpack"
 )
 
@@ -24,9 +25,19 @@ func (u *UserTokenExpires) Unmarshal(content []byte) error {
 
 // ClientInfo 存储客户端信息
 type ClientInfo struct {
-	ClientId    string `msgpack:"id" json:"clientId"`
-	Secret      string `msgpack:"s" json:"secret"`
-	RedirectUri string `msgpack:"r" json:"redirectUri"`
+	ClientId    string              `msgpack:"id" json:"clientId"`
+	Secret      string              `msgpack:"s" json:"-"`        // 明文密钥，只用于兼容未迁移到hash的老数据
+	Secrets     []ClientSecretInfo  `msgpack:"ss" json:"secrets"` // hash密钥，可以同时有多个有效密钥
+	RedirectUri string              `msgpack:"r" json:"redirectUri"`
+	Status      int                 `msgpack:"st" json:"status"`      // 客户端状态
+	CachedAt    int64               `msgpack:"ca" json:"cachedAt"`    // 写入缓存的时间，超过有效期需要从数据库重新加载
+	TokenPolicy *server.TokenPolicy `msgpack:"tp" json:"tokenPolicy"` // 客户端的token策略，nil表示使用全局配置
+}
+
+// ClientSecretInfo 客户端密钥的hash信息
+type ClientSecretInfo struct {
+	Hash      string `msgpack:"h" json:"-"`
+	ExpiresAt int64  `msgpack:"e" json:"expiresAt"`
 }
 
 func (u ClientInfo) Marshal() []byte {
@@ -45,7 +56,7 @@ type authorizeData struct {
 	ExpiresIn   int64  `msgpack:"ei"`   // 过期时间
 	Scope       string `msgpack:"s"`    // 范围
 	RedirectUri string `msgpack:"r"`    // 跳转地址
-	State       string `msgpack:"s"`    // 状态
+	State       string `msgpack:"st"`   // 状态
 	Ctime       int64  `msgpack:"ct"`   // 创建时间
 }
 
@@ -59,13 +70,14 @@ func (u *authorizeData) Unmarshal(content []byte) error {
 }
 
 type AccessData struct {
-	ClientId      string `msgpack:"id" json:"clientId"`        // 客户端ID
-	PreviousToken string `msgpack:"pret" json:"previousToken"` // 上一个Token信息
-	CurrentToken  string `msgpack:"curt" json:"currentToken"`  // 当前Token信息，这个用于刷新token使用
-	ExpiresIn     int64  `msgpack:"ei" json:"expiresIn"`       // 过期时间
-	Scope         string `msgpack:"s" json:"scope"`            // 范围
-	RedirectUri   string `msgpack:"r" json:"redirectUri"`      // 跳转地址
-	Ctime         int64  `msgpack:"ct" json:"ctime"`           // 创建时间
+	ClientId         string `msgpack:"id" json:"clientId"`          // 客户端ID
+	PreviousToken    string `msgpack:"pret" json:"previousToken"`   // 上一个Token信息
+	CurrentToken     string `msgpack:"curt" json:"currentToken"`    // 当前Token信息，这个用于刷新token使用
+	ExpiresIn        int64  `msgpack:"ei" json:"expiresIn"`         // 过期时间
+	RefreshExpiresIn int64  `msgpack:"rei" json:"refreshExpiresIn"` // refresh token过期时间，0表示不过期
+	Scope            string `msgpack:"s" json:"scope"`              // 范围
+	RedirectUri      string `msgpack:"r" json:"redirectUri"`        // 跳转地址
+	Ctime            int64  `msgpack:"ct" json:"ctime"`             // 创建时间
 }
 
 func (u AccessData) Marshal() []byte {
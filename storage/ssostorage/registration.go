@@ -0,0 +1,125 @@
+package ssostorage
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ego-component/eoauth2/server"
+	"github.com/ego-component/eoauth2/storage/dao"
+	"github.com/pborman/uuid"
+	"gorm.io/gorm"
+)
+
+var _ server.RegistrationStorage = &API{}
+
+// CreateInitialAccessToken 创建动态注册客户端使用的initial access token，只存储sha256
+func (s *API) CreateInitialAccessToken(ctx context.Context, expiration time.Duration) (token string, err error) {
+	token = base64.RawURLEncoding.EncodeToString(uuid.NewRandom())
+	err = s.redis.SetEX(ctx, s.getInitialAccessKey(token), time.Now().Unix(), expiration)
+	if err != nil {
+		return "", fmt.Errorf("sso storage CreateInitialAccessToken failed, err: %w", err)
+	}
+	return token, nil
+}
+
+// RevokeInitialAccessToken 删除initial access token
+func (s *API) RevokeInitialAccessToken(ctx context.Context, token string) (err error) {
+	_, err = s.redis.Del(ctx, s.getInitialAccessKey(token))
+	if err != nil {
+		return fmt.Errorf("sso storage RevokeInitialAccessToken failed, err: %w", err)
+	}
+	return nil
+}
+
+// ValidateInitialAccessToken 校验initial access token
+func (s *API) ValidateInitialAccessToken(ctx context.Context, token string) error {
+	exist, err := s.redis.Exists(ctx, s.getInitialAccessKey(token))
+	if err != nil {
+		return fmt.Errorf("sso storage ValidateInitialAccessToken failed, err: %w", err)
+	}
+	if !exist {
+		return fmt.Errorf("initial access token not found, err: %w", server.ErrNotFound)
+	}
+	return nil
+}
+
+// CreateRegisteredClient 保存动态注册的客户端
+func (s *API) CreateRegisteredClient(ctx context.Context, client *server.RegisteredClient) (err error) {
+	metadata, err := json.Marshal(client.Metadata)
+	if err != nil {
+		return fmt.Errorf("sso storage CreateRegisteredClient marshal failed, err: %w", err)
+	}
+	return s.CreateClient(ctx, &dao.App{
+		Name:              client.Metadata.ClientName,
+		ClientId:          client.ClientId,
+		Secret:            client.ClientSecret,
+		RedirectUri:       client.RedirectUri,
+		Url:               client.Metadata.ClientUri,
+		Status:            1,
+		Metadata:          string(metadata),
+		RegistrationToken: client.RegistrationTokenHash,
+	})
+}
+
+// GetRegisteredClient 获取动态注册的客户端
+func (s *API) GetRegisteredClient(ctx context.Context, clientId string) (client *server.RegisteredClient, err error) {
+	app, err := dao.GetAppInfoByClientId(s.db.WithContext(ctx), clientId)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, fmt.Errorf("sso storage GetRegisteredClient not found, err: %w", server.ErrNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("sso storage GetRegisteredClient failed, err: %w", err)
+	}
+	client = &server.RegisteredClient{
+		ClientId:              app.ClientId,
+		ClientIdIssuedAt:      app.Ctime,
+		RedirectUri:           app.RedirectUri,
+		RegistrationTokenHash: app.RegistrationToken,
+	}
+	if app.Metadata != "" {
+		err = json.Unmarshal([]byte(app.Metadata), &client.Metadata)
+		if err != nil {
+			return nil, fmt.Errorf("sso storage GetRegisteredClient unmarshal failed, err: %w", err)
+		}
+	}
+	return client, nil
+}
+
+// UpdateRegisteredClient 更新动态注册的客户端
+func (s *API) UpdateRegisteredClient(ctx context.Context, client *server.RegisteredClient) (err error) {
+	metadata, err := json.Marshal(client.Metadata)
+	if err != nil {
+		return fmt.Errorf("sso storage UpdateRegisteredClient marshal failed, err: %w", err)
+	}
+	updates := map[string]interface{}{
+		"name":               client.Metadata.ClientName,
+		"redirect_uri":       client.RedirectUri,
+		"url":                client.Metadata.ClientUri,
+		"metadata":           string(metadata),
+		"registration_token": client.RegistrationTokenHash,
+		"utime":              time.Now().Unix(),
+	}
+	// 变为公共客户端，退役所有密钥；变为机密客户端，保存新下发的密钥
+	if client.Metadata.TokenEndpointAuthMethod == server.AUTH_METHOD_NONE {
+		updates["secret"] = ""
+	} else if client.ClientSecret != "" {
+		updates["secret"] = client.ClientSecret
+	}
+	return s.UpdateClient(ctx, client.ClientId, updates)
+}
+
+// DeleteRegisteredClient 删除动态注册的客户端
+func (s *API) DeleteRegisteredClient(ctx context.Context, clientId string) (err error) {
+	return s.DeleteClient(ctx, clientId)
+}
+
+func (s *API) getInitialAccessKey(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return fmt.Sprintf(s.config.storeInitialAccessKey, hex.EncodeToString(hash[:]))
+}
@@ -0,0 +1,597 @@
+package ssostorage
+
+import "github.com/go-redis/redis/v8"
+
+// maxLuaInt lua脚本里可以精确表示的最大整数，uid的绝对值不能超过该值
+const maxLuaInt = 1<<53 - 1
+
+// luaMsgpack 纯lua实现的msgpack编解码，只支持 _etl、_u 用到的字符串、整数、数组、map
+// 不依赖redis内置的cmsgpack，方便在不支持cmsgpack的redis实现中使用
+// lua的数字是double，只能精确表示绝对值不超过 maxLuaInt 的整数，超出范围时报错，不会写入错误的数据
+const luaMsgpack = `
+local mp = {}
+
+function mp.decode(s)
+	if not s then
+		return {}
+	end
+	local pos = 1
+	local function u(n)
+		local v = 0
+		for i = 0, n - 1 do
+			v = v * 256 + string.byte(s, pos + i)
+		end
+		pos = pos + n
+		return v
+	end
+	local function i(n)
+		local v = u(n)
+		local m = 2 ^ (8 * n)
+		if v >= m / 2 then
+			v = v - m
+		end
+		return v
+	end
+	local function str(n)
+		local v = string.sub(s, pos, pos + n - 1)
+		pos = pos + n
+		return v
+	end
+	local read
+	local function arr(n)
+		local t = {}
+		for k = 1, n do
+			t[k] = read()
+		end
+		return t
+	end
+	local function map(n)
+		local t = {}
+		for k = 1, n do
+			local key = read()
+			t[key] = read()
+		end
+		return t
+	end
+	read = function()
+		local b = u(1)
+		if b <= 0x7f then return b end
+		if b >= 0xe0 then return b - 256 end
+		if b >= 0xa0 and b <= 0xbf then return str(b - 0xa0) end
+		if b >= 0x90 and b <= 0x9f then return arr(b - 0x90) end
+		if b >= 0x80 and b <= 0x8f then return map(b - 0x80) end
+		if b == 0xc0 then return nil end
+		if b == 0xc2 then return false end
+		if b == 0xc3 then return true end
+		if b == 0xcc then return u(1) end
+		if b == 0xcd then return u(2) end
+		if b == 0xce then return u(4) end
+		if b == 0xcf then
+			local hi, lo = u(4), u(4)
+			if hi >= 2097152 then
+				error("msgpack decode integer out of range")
+			end
+			return hi * 4294967296 + lo
+		end
+		if b == 0xd0 then return i(1) end
+		if b == 0xd1 then return i(2) end
+		if b == 0xd2 then return i(4) end
+		if b == 0xd3 then
+			local hi, lo = i(4), u(4)
+			if hi >= 2097152 or hi < -2097152 or (hi == -2097152 and lo == 0) then
+				error("msgpack decode integer out of range")
+			end
+			return hi * 4294967296 + lo
+		end
+		if b == 0xd9 then return str(u(1)) end
+		if b == 0xda then return str(u(2)) end
+		if b == 0xdb then return str(u(4)) end
+		if b == 0xdc then return arr(u(2)) end
+		if b == 0xdd then return arr(u(4)) end
+		if b == 0xde then return map(u(2)) end
+		if b == 0xdf then return map(u(4)) end
+		error("msgpack decode unsupported type " .. b)
+	end
+	return read() or {}
+end
+
+local function be(n, bytes)
+	local out = {}
+	for k = bytes, 1, -1 do
+		out[k] = string.char(n % 256)
+		n = math.floor(n / 256)
+	end
+	return table.concat(out)
+end
+
+function mp.encode(v)
+	local t = type(v)
+	if t == "string" then
+		local n = string.len(v)
+		if n < 32 then return string.char(0xa0 + n) .. v end
+		if n < 256 then return string.char(0xd9) .. be(n, 1) .. v end
+		if n < 65536 then return string.char(0xda) .. be(n, 2) .. v end
+		return string.char(0xdb) .. be(n, 4) .. v
+	end
+	if t == "number" then
+		if v > 9007199254740991 or v < -9007199254740991 or v ~= math.floor(v) then
+			error("msgpack encode integer out of range")
+		end
+		if v >= 0 then
+			if v < 128 then return string.char(v) end
+			if v < 256 then return string.char(0xcc) .. be(v, 1) end
+			if v < 65536 then return string.char(0xcd) .. be(v, 2) end
+			if v < 4294967296 then return string.char(0xce) .. be(v, 4) end
+			return string.char(0xcf) .. be(v, 8)
+		end
+		-- 负数的补码按照高低32位分别计算，避免 2^64 + v 超出double的精度
+		if v >= -32 then return string.char(256 + v) end
+		if v >= -128 then return string.char(0xd0) .. be(256 + v, 1) end
+		if v >= -32768 then return string.char(0xd1) .. be(65536 + v, 2) end
+		if v >= -2147483648 then return string.char(0xd2) .. be(4294967296 + v, 4) end
+		local lo = v % 4294967296
+		local hi = (v - lo) / 4294967296
+		return string.char(0xd3) .. be(4294967296 + hi, 4) .. be(lo, 4)
+	end
+	if t == "boolean" then
+		if v then return string.char(0xc3) end
+		return string.char(0xc2)
+	end
+	if t == "table" then
+		local out = {}
+		-- 空table和有序下标的table按照数组编码
+		if next(v) == nil or v[1] ~= nil then
+			local n = #v
+			if n < 16 then
+				out[1] = string.char(0x90 + n)
+			else
+				out[1] = string.char(0xdd) .. be(n, 4)
+			end
+			for k = 1, n do
+				out[#out + 1] = mp.encode(v[k])
+			end
+			return table.concat(out)
+		end
+		local keys = {}
+		for key in pairs(v) do
+			keys[#keys + 1] = key
+		end
+		table.sort(keys)
+		if #keys < 16 then
+			out[1] = string.char(0x80 + #keys)
+		else
+			out[1] = string.char(0xdf) .. be(#keys, 4)
+		end
+		for _, key in ipairs(keys) do
+			out[#out + 1] = mp.encode(key)
+			out[#out + 1] = mp.encode(v[key])
+		end
+		return table.concat(out)
+	end
+	return string.char(0xc0)
+end
+`
+
+// luaUpsertExpireList 维护hash里的 _etl：新的field加到最前面并删除过期数据，或者移除某个field
+const luaUpsertExpireList = `
+local function upsertExpireList(key, field, platform, expireTime, now)
+	local list = mp.decode(redis.call("HGET", key, "_etl"))
+	local newList = {{f = field, p = platform, et = expireTime}}
+	local hdelFields = {}
+	for _, value in ipairs(list) do
+		if value.et <= now then
+			hdelFields[#hdelFields + 1] = value.f
+		elseif value.f ~= field then
+			newList[#newList + 1] = value
+		end
+	end
+	if #hdelFields > 0 then
+		redis.call("HDEL", key, unpack(hdelFields))
+	end
+	redis.call("HSET", key, "_etl", mp.encode(newList))
+end
+
+local function removeFromExpireList(key, field)
+	if redis.call("EXISTS", key) == 0 then
+		return
+	end
+	redis.call("HDEL", key, field)
+	local list = mp.decode(redis.call("HGET", key, "_etl"))
+	local newList = {}
+	for _, value in ipairs(list) do
+		if value.f ~= field then
+			newList[#newList + 1] = value
+		end
+	end
+	redis.call("HSET", key, "_etl", mp.encode(newList))
+end
+`
+
+// luaRenewParentToken 续期parent token，同时更新uid key里 _etl 的过期时间
+const luaRenewParentToken = `
+-- renewExpireList 更新 _etl 里field的过期时间，field不存在时不处理，key的过期时间只延长不缩短
+local function renewExpireList(key, field, expireAt, now)
+	local list = mp.decode(redis.call("HGET", key, "_etl"))
+	local found = false
+	for _, value in ipairs(list) do
+		if value.f == field then
+			value.et = expireAt
+			found = true
+		end
+	end
+	if not found then
+		return
+	end
+	redis.call("HSET", key, "_etl", mp.encode(list))
+	if expireAt - now > redis.call("TTL", key) then
+		redis.call("EXPIREAT", key, expireAt)
+	end
+end
+
+-- renewParentToken expiresIn为0时使用登录时的有效期，过期时间不超过最大会话时长，只延长不缩短
+-- 返回续期后的过期时间，parent token不存在返回0，老数据没有续期时长返回-1
+local function renewParentToken(pKey, uidKeys, uidField, expiresIn, maxLifetime, now)
+	local values = redis.call("HMGET", pKey, "_ct", "_ex")
+	if not values[1] then
+		return 0
+	end
+	if expiresIn == 0 then
+		if not values[2] then
+			return -1
+		end
+		expiresIn = tonumber(values[2])
+	end
+	local expireAt = now + expiresIn
+	if maxLifetime > 0 and expireAt > tonumber(values[1]) + maxLifetime then
+		expireAt = tonumber(values[1]) + maxLifetime
+	end
+	local current = now + redis.call("TTL", pKey)
+	if current >= expireAt then
+		expireAt = current
+	else
+		redis.call("EXPIREAT", pKey, expireAt)
+	end
+	for _, uidKey in ipairs(uidKeys) do
+		renewExpireList(uidKey, uidField, expireAt, now)
+	end
+	return expireAt
+end
+`
+
+// luaEnforceSessionPolicy 登录时检查用户的会话个数限制
+const luaEnforceSessionPolicy = `
+-- enforceSessionPolicy 返回需要踢掉的会话field，超过限制并且拒绝登录时返回nil
+-- 用户的parent token按照登录时间倒序存储，最早登录的在最后面，踢掉时保留最新的 limit-1 个，给新的会话留位置
+local function enforceSessionPolicy(uidKey, uidField, platform, maxSessions, maxPerPlatform, reject, now)
+	local alive = {}
+	for _, value in ipairs(mp.decode(redis.call("HGET", uidKey, "_etl"))) do
+		-- 多账号复用已有的会话，不是新的会话
+		if value.f == uidField then
+			return {}
+		end
+		if value.et > now then
+			alive[#alive + 1] = value
+		end
+	end
+	local evicted, evictedSet = {}, {}
+	local function over(list, limit)
+		if limit <= 0 or #list < limit then
+			return true
+		end
+		if reject then
+			return false
+		end
+		for i = limit, #list do
+			evicted[#evicted + 1] = list[i].f
+			evictedSet[list[i].f] = true
+		end
+		return true
+	end
+	local samePlatform = {}
+	for _, value in ipairs(alive) do
+		if value.p == platform then
+			samePlatform[#samePlatform + 1] = value
+		end
+	end
+	if not over(samePlatform, maxPerPlatform) then
+		return nil
+	end
+	local remaining = {}
+	for _, value in ipairs(alive) do
+		if not evictedSet[value.f] then
+			remaining[#remaining + 1] = value
+		end
+	end
+	if not over(remaining, maxSessions) then
+		return nil
+	end
+	return evicted
+end
+`
+
+// createParentTokenScript 登录时原子的检查会话个数限制，并写入 sso:uid、sso:ptk
+// 多账号复用已有的parent token时，过期时间不超过最大会话时长，只延长不缩短
+// mode all、uid 返回 {1, 踢掉的会话field...}，超过会话个数限制并且拒绝登录时返回 {0}，不写入任何数据
+// mode parent 返回 {1, parent token的过期时间}
+// Redis Cluster key布局下，uid key和parent token key不在同一个slot时（多账号），分两次执行
+// mode all: KEYS[1] sso:uid:{uid}  KEYS[2] sso:ptk:{parentToken}
+// mode uid: KEYS[1] sso:uid:{uid}
+// mode parent: KEYS[1] sso:ptk:{parentToken}
+// ARGV mode, now, expiresIn, uid, platform, uid hash里的field, parent token信息, parent token里的用户field, 用户信息, 是否多账号,
+// 最多的会话个数, 当前平台最多的会话个数, 超过限制时是否拒绝登录, 最大会话时长
+var createParentTokenScript = redis.NewScript(luaMsgpack + luaUpsertExpireList + luaEnforceSessionPolicy + `
+local mode = ARGV[1]
+local now, expiresIn, uid = tonumber(ARGV[2]), tonumber(ARGV[3]), tonumber(ARGV[4])
+local platform, uidField, tokenInfo, userField, userInfo, multiple = ARGV[5], ARGV[6], ARGV[7], ARGV[8], ARGV[9], ARGV[10]
+local maxLifetime = tonumber(ARGV[14])
+
+local pKey = KEYS[#KEYS]
+local uidsRaw = false
+local expireAt = now + expiresIn
+if mode ~= "uid" and multiple == "1" then
+	uidsRaw = redis.call("HGET", pKey, "_u")
+end
+if uidsRaw then
+	local ct = tonumber(redis.call("HGET", pKey, "_ct"))
+	if maxLifetime > 0 and ct and expireAt > ct + maxLifetime then
+		expireAt = ct + maxLifetime
+	end
+	local current = now + redis.call("TTL", pKey)
+	if current > expireAt then
+		expireAt = current
+	end
+end
+
+local res = {1}
+if mode ~= "parent" then
+	local uidKey = KEYS[1]
+	local evicted = enforceSessionPolicy(uidKey, uidField, platform, tonumber(ARGV[11]), tonumber(ARGV[12]), ARGV[13] == "1", now)
+	if not evicted then
+		return {0}
+	end
+	for _, field in ipairs(evicted) do
+		removeFromExpireList(uidKey, field)
+		res[#res + 1] = field
+	end
+	local uidTTL = redis.call("TTL", uidKey)
+	upsertExpireList(uidKey, uidField, platform, expireAt, now)
+	redis.call("HSET", uidKey, uidField, tokenInfo)
+	redis.call("HSETNX", uidKey, "_ct", now)
+	if expireAt - now > uidTTL then
+		redis.call("EXPIREAT", uidKey, expireAt)
+	end
+end
+if mode == "uid" then
+	return res
+end
+
+if not uidsRaw then
+	redis.call("HSET", pKey, "_ct", now, "_ex", expiresIn, "_u", mp.encode({uid}), userField, userInfo)
+else
+	local uids = mp.decode(uidsRaw)
+	local exist = false
+	for _, value in ipairs(uids) do
+		if value == uid then
+			exist = true
+		end
+	end
+	if not exist then
+		uids[#uids + 1] = uid
+	end
+	redis.call("HSET", pKey, "_ex", expiresIn, "_u", mp.encode(uids), userField, userInfo)
+end
+redis.call("EXPIREAT", pKey, expireAt)
+if mode == "parent" then
+	return {1, expireAt}
+end
+return res
+`)
+
+// createSubTokenScript 原子的在 sso:ptk 里加入sub token，并写入 sso:stk，滑动过期时同时续期parent token
+// 返回 {状态, 续期后的过期时间}，状态 1 成功，0 parent token不存在，-1 uid不在parent token里；没有续期时过期时间为0
+// KEYS[1] sso:ptk:{parentToken}  KEYS[2] sso:stk:{subToken}  KEYS[3...] 和parent token在同一个slot的 sso:uid:{uid}
+// ARGV now, expiresIn, parent token里的field, token信息, parentToken, clientId, access信息, sub token信息, token所属的uid(0表示不指定),
+// 是否滑动过期, 最大会话时长, uid hash里的field
+var createSubTokenScript = redis.NewScript(luaMsgpack + luaUpsertExpireList + luaRenewParentToken + `
+local pKey, subKey = KEYS[1], KEYS[2]
+local now, expiresIn = tonumber(ARGV[1]), tonumber(ARGV[2])
+local field, tokenInfo, uid = ARGV[3], ARGV[4], tonumber(ARGV[9])
+
+-- 因为authorize阶段创建了parent token，所以如果不存在parent token key是有问题的
+if redis.call("HEXISTS", pKey, "_ct") == 0 then
+	return {0, 0}
+end
+if uid ~= 0 then
+	local exist = false
+	for _, value in ipairs(mp.decode(redis.call("HGET", pKey, "_u"))) do
+		if value == uid then
+			exist = true
+		end
+	end
+	if not exist then
+		return {-1, 0}
+	end
+end
+upsertExpireList(pKey, field, "", now + expiresIn, now)
+redis.call("HSET", pKey, field, tokenInfo)
+redis.call("HSET", subKey, "_pt", ARGV[5], "_id", ARGV[6], "_ct", now, "_a", ARGV[7], "_t", ARGV[8])
+if uid ~= 0 then
+	redis.call("HSET", subKey, "_uid", uid)
+end
+redis.call("EXPIRE", subKey, expiresIn)
+if ARGV[10] ~= "1" then
+	return {1, 0}
+end
+local uidKeys = {}
+for i = 3, #KEYS do
+	uidKeys[#uidKeys + 1] = KEYS[i]
+end
+local expireAt = renewParentToken(pKey, uidKeys, ARGV[12], 0, tonumber(ARGV[11]), now)
+if expireAt < 0 then
+	expireAt = 0
+end
+return {1, expireAt}
+`)
+
+// renewParentTokenScript 原子的续期parent token，以及同一个slot的uid key里的过期时间
+// 返回续期后的过期时间，parent token不存在返回0，老数据没有续期时长返回-1
+// KEYS[1] sso:ptk:{parentToken}  KEYS[2...] 和parent token在同一个slot的 sso:uid:{uid}
+// ARGV now, expiresIn(0表示使用登录时的有效期), 最大会话时长, uid hash里的field
+var renewParentTokenScript = redis.NewScript(luaMsgpack + luaRenewParentToken + `
+local uidKeys = {}
+for i = 2, #KEYS do
+	uidKeys[#uidKeys + 1] = KEYS[i]
+end
+return renewParentToken(KEYS[1], uidKeys, ARGV[4], tonumber(ARGV[2]), tonumber(ARGV[3]), tonumber(ARGV[1]))
+`)
+
+// renewUserParentTokenScript 原子的更新 sso:uid 里parent token的过期时间，用于和parent token不在同一个slot的uid key
+// KEYS[1] sso:uid:{uid}
+// ARGV uid hash里的field, 过期时间戳, now
+var renewUserParentTokenScript = redis.NewScript(luaMsgpack + luaRenewParentToken + `
+renewExpireList(KEYS[1], ARGV[1], tonumber(ARGV[2]), tonumber(ARGV[3]))
+return 1
+`)
+
+// removeParentTokenUidScript 多账号下原子的从 sso:ptk 里移除一个账号
+// 返回 -1 parent token不存在或者uid不在parent token里，0 uid是最后一个账号没有移除，大于0 移除后剩余的账号个数
+// KEYS[1] sso:ptk:{parentToken}
+// ARGV uid, parent token里的用户field
+var removeParentTokenUidScript = redis.NewScript(luaMsgpack + `
+local uidsRaw = redis.call("HGET", KEYS[1], "_u")
+if not uidsRaw then
+	return -1
+end
+local uid = tonumber(ARGV[1])
+local uids, newUids, exist = mp.decode(uidsRaw), {}, false
+for _, value in ipairs(uids) do
+	if value == uid then
+		exist = true
+	else
+		newUids[#newUids + 1] = value
+	end
+end
+if not exist then
+	return -1
+end
+if #newUids == 0 then
+	return 0
+end
+redis.call("HSET", KEYS[1], "_u", mp.encode(newUids))
+redis.call("HDEL", KEYS[1], ARGV[2])
+return #newUids
+`)
+
+// setSubTokenUidScript 原子的设置sub token所属的账号，sub token已经过期时不处理，避免重新创建没有过期时间的key
+// 返回 1 成功，0 sub token不存在
+// KEYS[1] sso:stk:{subToken}
+// ARGV sub token里的field, uid
+var setSubTokenUidScript = redis.NewScript(`
+if redis.call("EXISTS", KEYS[1]) == 0 then
+	return 0
+end
+redis.call("HSET", KEYS[1], ARGV[1], ARGV[2])
+return 1
+`)
+
+// removeSubTokenScript 原子的从 sso:ptk 里移除sub token，并让 sso:stk 过期
+// KEYS[1] sso:ptk:{parentToken}  KEYS[2] sso:stk:{subToken}，KEYS[2]不传时只处理parent token
+// ARGV parent token里的field, sub token保留的秒数，0表示立即删除
+var removeSubTokenScript = redis.NewScript(luaMsgpack + luaUpsertExpireList + `
+removeFromExpireList(KEYS[1], ARGV[1])
+local grace = tonumber(ARGV[2])
+if not KEYS[2] then
+	return 1
+end
+if grace > 0 then
+	redis.call("EXPIRE", KEYS[2], grace)
+else
+	redis.call("DEL", KEYS[2])
+end
+return 1
+`)
+
+// removeParentTokenScript 退出登录时原子的删除 sso:ptk，以及用户、sub token里的数据
+// 调用方先读取 _etl、_u 生成KEYS，脚本里比较读取的值，发生变化时返回 {0}，由调用方重新读取后重试
+// 返回 {1, parent token里的uid...}，parent token不存在返回nil
+// KEYS[1] sso:ptk:{parentToken}  KEYS[2...subKeys+1] 同一个slot的 sso:stk:{subToken}  之后为同一个slot的 sso:uid:{uid}
+// ARGV 读取的 _etl, 读取的 _u, uid hash里的field, sub token保留的秒数, sub token key的个数
+var removeParentTokenScript = redis.NewScript(luaMsgpack + luaUpsertExpireList + `
+local pKey = KEYS[1]
+if redis.call("EXISTS", pKey) == 0 then
+	return false
+end
+local values = redis.call("HMGET", pKey, "_etl", "_u")
+if (values[1] or "") ~= ARGV[1] or (values[2] or "") ~= ARGV[2] then
+	return {0}
+end
+local uidField, grace, subKeys = ARGV[3], tonumber(ARGV[4]), tonumber(ARGV[5])
+for i = 2, subKeys + 1 do
+	redis.call("EXPIRE", KEYS[i], grace)
+end
+for i = subKeys + 2, #KEYS do
+	removeFromExpireList(KEYS[i], uidField)
+end
+redis.call("DEL", pKey)
+local res = {1}
+for _, uid in ipairs(mp.decode(values[2])) do
+	res[#res + 1] = uid
+end
+return res
+`)
+
+// pruneExpireListScript 原子的从 _etl 列表里移除已经失效的field，deleteEmpty为1时列表为空删除整个key
+// KEYS[1] sso:uid:{uid} 或者 sso:ptk:{parentToken}
+// ARGV deleteEmpty, 需要移除的field...
+var pruneExpireListScript = redis.NewScript(luaMsgpack + luaUpsertExpireList + `
+for i = 2, #ARGV do
+	removeFromExpireList(KEYS[1], ARGV[i])
+end
+if ARGV[1] == "1" and redis.call("EXISTS", KEYS[1]) == 1 and #mp.decode(redis.call("HGET", KEYS[1], "_etl")) == 0 then
+	redis.call("DEL", KEYS[1])
+	return 1
+end
+return 0
+`)
+
+// removeUserParentTokenScript 原子的从 sso:uid 里移除parent token
+// KEYS[1] sso:uid:{uid}
+// ARGV uid hash里的field
+var removeUserParentTokenScript = redis.NewScript(luaMsgpack + luaUpsertExpireList + `
+removeFromExpireList(KEYS[1], ARGV[1])
+return 1
+`)
+
+// redeemAuthorizeScript 原子的兑换code，兑换成功删除code并留下墓碑；code不存在时，根据墓碑判断是否被重复使用
+// 墓碑里保存authorize信息，重复使用时可以找到code对应的parent token
+// KEYS[1] sso:auth:{code}  KEYS[2] sso:rcode:{code}
+// ARGV now, 墓碑有效期(s)
+// 返回 {状态, authorize信息}，状态 0 不存在，1 兑换成功，2 重复使用
+var redeemAuthorizeScript = redis.NewScript(`
+local data = redis.call("GET", KEYS[1])
+if data then
+	redis.call("DEL", KEYS[1])
+	redis.call("HSET", KEYS[2], "_ct", ARGV[1], "_d", data)
+	redis.call("EXPIRE", KEYS[2], ARGV[2])
+	return {1, data}
+end
+if redis.call("EXISTS", KEYS[2]) == 1 then
+	redis.call("HSET", KEYS[2], "_r", 1)
+	return {2, ""}
+end
+return {0, ""}
+`)
+
+// recordRedeemedCodeTokenScript 在code墓碑里记录下发的token，墓碑里有重复使用标记时返回1
+// KEYS[1] sso:rcode:{code}
+// ARGV token field
+var recordRedeemedCodeTokenScript = redis.NewScript(`
+if redis.call("EXISTS", KEYS[1]) == 0 then
+	return 0
+end
+redis.call("HSET", KEYS[1], ARGV[1], 1)
+if redis.call("HEXISTS", KEYS[1], "_r") == 1 then
+	return 1
+end
+return 0
+`)
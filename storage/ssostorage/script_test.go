@@ -0,0 +1,140 @@
+package ssostorage
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+)
+
+// newTestScriptClient 执行lua脚本的redis客户端
+func newTestScriptClient(t *testing.T) *redis.Client {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { _ = client.Close() })
+	return client
+}
+
+// TestLuaMsgpackDecodeInGo lua里编码的 _etl、_u，Go里可以解码
+func TestLuaMsgpackDecodeInGo(t *testing.T) {
+	client := newTestScriptClient(t)
+	ctx := context.Background()
+	res, err := client.Eval(ctx, luaMsgpack+`
+local list = {
+	{f = "_c:abc", p = "web", et = tonumber(ARGV[1])},
+	{f = "_c:hash:def", p = "", et = tonumber(ARGV[2])},
+}
+return {mp.encode(list), mp.encode({tonumber(ARGV[3]), tonumber(ARGV[4]), tonumber(ARGV[5])})}
+`, nil, 1700000000, 4102444800, 1, 300, 5000000000).Slice()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var expireList UserTokenExpires
+	if err = expireList.Unmarshal([]byte(res[0].(string))); err != nil {
+		t.Fatalf("unmarshal lua _etl failed, err: %v", err)
+	}
+	wantList := UserTokenExpires{
+		{Field: "_c:abc", Platform: "web", ExpireTime: 1700000000},
+		{Field: "_c:hash:def", Platform: "", ExpireTime: 4102444800},
+	}
+	if !reflect.DeepEqual(expireList, wantList) {
+		t.Errorf("lua _etl, want: %+v, got: %+v", wantList, expireList)
+	}
+
+	var uids UidsStore
+	if err = uids.Unmarshal([]byte(res[1].(string))); err != nil {
+		t.Fatalf("unmarshal lua _u failed, err: %v", err)
+	}
+	if wantUids := (UidsStore{1, 300, 5000000000}); !reflect.DeepEqual(uids, wantUids) {
+		t.Errorf("lua _u, want: %v, got: %v", wantUids, uids)
+	}
+}
+
+// TestGoMsgpackDecodeInLua Go里编码的 _etl、_u，lua里可以解码
+func TestGoMsgpackDecodeInLua(t *testing.T) {
+	client := newTestScriptClient(t)
+	ctx := context.Background()
+	expireList := UserTokenExpires{
+		{Field: "_c:abc", Platform: "web", ExpireTime: 1700000000},
+		{Field: "_ui:1", Platform: "", ExpireTime: 4102444800},
+	}
+	uids := UidsStore{1, 300, 5000000000}
+	res, err := client.Eval(ctx, luaMsgpack+`
+local res = {}
+for _, value in ipairs(mp.decode(ARGV[1])) do
+	res[#res + 1] = value.f
+	res[#res + 1] = value.p
+	res[#res + 1] = tostring(value.et)
+end
+for _, uid in ipairs(mp.decode(ARGV[2])) do
+	res[#res + 1] = tostring(uid)
+end
+return res
+`, nil, expireList.Marshal(), uids.Marshal()).StringSlice()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"_c:abc", "web", "1700000000",
+		"_ui:1", "", "4102444800",
+		"1", "300", "5000000000",
+	}
+	if !reflect.DeepEqual(res, want) {
+		t.Errorf("lua decode, want: %v, got: %v", want, res)
+	}
+}
+
+// TestLuaMsgpackRoundTrip Go编码的数据经过lua解码、编码之后，Go解码的结果不变
+func TestLuaMsgpackRoundTrip(t *testing.T) {
+	client := newTestScriptClient(t)
+	ctx := context.Background()
+	roundTrip := func(t *testing.T, data []byte) []byte {
+		t.Helper()
+		res, err := client.Eval(ctx, luaMsgpack+`return mp.encode(mp.decode(ARGV[1]))`, nil, data).Text()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return []byte(res)
+	}
+
+	t.Run("uids", func(t *testing.T) {
+		uids := UidsStore{
+			0, 1, 127, 128, 255, 256, 65535, 65536, 4294967295, 4294967296, maxLuaInt,
+			-1, -32, -33, -128, -129, -32768, -32769, -2147483648, -2147483649, -3000000000, -maxLuaInt,
+		}
+		var got UidsStore
+		if err := got.Unmarshal(roundTrip(t, uids.Marshal())); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, uids) {
+			t.Errorf("uids round trip, want: %v, got: %v", uids, got)
+		}
+	})
+
+	t.Run("expire list", func(t *testing.T) {
+		expireList := UserTokenExpires{
+			{Field: "_c:" + strings.Repeat("a", 40), Platform: "web", ExpireTime: 4102444800}, // str8
+			{Field: "_c:" + strings.Repeat("b", 300), Platform: "", ExpireTime: -1700000000},  // str16
+			{Field: "_c:" + strings.Repeat("c", 28), Platform: strings.Repeat("p", 31), ExpireTime: maxLuaInt},
+		}
+		var got UserTokenExpires
+		if err := got.Unmarshal(roundTrip(t, expireList.Marshal())); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, expireList) {
+			t.Errorf("expire list round trip, want: %+v, got: %+v", expireList, got)
+		}
+	})
+
+	// 超出lua精度的整数报错，不会写入错误的数据
+	for _, uid := range []int64{maxLuaInt + 1, -maxLuaInt - 1, 1 << 62, -1 << 63} {
+		_, err := client.Eval(ctx, luaMsgpack+`return mp.encode(mp.decode(ARGV[1]))`, nil, UidsStore{uid}.Marshal()).Text()
+		if err == nil || !strings.Contains(err.Error(), "out of range") {
+			t.Errorf("decode uid %d, want out of range error, got: %v", uid, err)
+		}
+	}
+}
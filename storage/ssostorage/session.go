@@ -0,0 +1,199 @@
+package ssostorage
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/ego-component/eoauth2/server"
+)
+
+// Session 用户的一个登录会话，对应一个parent token
+type Session struct {
+	SessionId  string           `json:"sessionId"`  // 会话id，parent token的hash，不暴露parent token
+	Platform   string           `json:"platform"`   // 登录平台
+	ClientIP   string           `json:"clientIp"`   // 登录IP
+	UA         string           `json:"ua"`         // 登录UA
+	Ctime      int64            `json:"ctime"`      // 登录时间
+	ExpireTime int64            `json:"expireTime"` // 会话过期时间
+	Current    bool             `json:"current"`    // 是否为当前会话
+	Clients    []*SessionClient `json:"clients"`    // 通过该会话登录的子系统
+}
+
+// SessionClient 通过会话登录的子系统
+type SessionClient struct {
+	ClientId   string `json:"clientId"`
+	ExpireTime int64  `json:"expireTime"` // sub token过期时间
+}
+
+// SessionLimitAction 超过会话个数限制时的处理方式
+type SessionLimitAction int
+
+const (
+	// SessionLimitEvict 踢掉最早登录的会话
+	SessionLimitEvict SessionLimitAction = iota
+	// SessionLimitReject 拒绝新的登录，返回 server.ErrSessionLimitExceeded
+	SessionLimitReject
+)
+
+// SessionPolicy 用户同时在线的会话个数限制
+type SessionPolicy struct {
+	MaxSessions            int                // 每个用户最多的会话个数，0表示不限制
+	MaxSessionsPerPlatform map[string]int     // 每个平台最多的会话个数，例如 {"web": 1} 表示web只能有一个会话
+	Action                 SessionLimitAction // 超过限制时的处理方式
+}
+
+// sessionIdOf 使用parent token的sha256作为会话id
+func sessionIdOf(pToken string) string {
+	hash := sha256.Sum256([]byte(pToken))
+	return hex.EncodeToString(hash[:16])
+}
+
+// ListSessions 获取用户所有有效的登录会话，按照登录时间倒序
+// currentParentToken 为当前请求的parent token，用于标记当前会话，可以为空
+func (s *API) ListSessions(ctx context.Context, uid int64, currentParentToken string) (list []*Session, err error) {
+	pTokens, err := s.listParentTokens(ctx, uid)
+	if err != nil {
+		return nil, err
+	}
+	if currentParentToken != "" {
+		if currentParentToken, err = s.tokenServer.lookupParentToken(ctx, currentParentToken); err != nil {
+			return nil, err
+		}
+	}
+	list = make([]*Session, 0, len(pTokens))
+	for _, pToken := range pTokens {
+		session, err := s.getSession(ctx, uid, pToken)
+		if err != nil {
+			return nil, err
+		}
+		// parent token已经过期
+		if session == nil {
+			continue
+		}
+		session.Current = currentParentToken != "" && pToken == currentParentToken
+		list = append(list, session)
+	}
+	sort.SliceStable(list, func(i, j int) bool {
+		return list[i].Ctime > list[j].Ctime
+	})
+	return list, nil
+}
+
+// RevokeSession 退出用户的某个登录会话，会话不存在返回 server.ErrNotFound
+// 多账号登录时，会话里的其他账号也会一起退出
+func (s *API) RevokeSession(ctx context.Context, uid int64, sessionId string) (err error) {
+	pTokens, err := s.listParentTokens(ctx, uid)
+	if err != nil {
+		return err
+	}
+	for _, pToken := range pTokens {
+		if sessionIdOf(pToken) == sessionId {
+			return s.revokeSession(ctx, uid, pToken)
+		}
+	}
+	return fmt.Errorf("ssostorage.RevokeSession failed, session: %s, err: %w", sessionId, server.ErrNotFound)
+}
+
+// RevokeOtherSessions 退出用户除当前会话外的所有会话，返回退出的会话个数
+func (s *API) RevokeOtherSessions(ctx context.Context, uid int64, currentParentToken string) (cnt int, err error) {
+	if currentParentToken != "" {
+		if currentParentToken, err = s.tokenServer.lookupParentToken(ctx, currentParentToken); err != nil {
+			return 0, err
+		}
+	}
+	return s.revokeSessions(ctx, uid, currentParentToken)
+}
+
+// RevokeAllSessions 退出用户所有的会话，用于重置密码、离职等场景，返回退出的会话个数
+func (s *API) RevokeAllSessions(ctx context.Context, uid int64) (cnt int, err error) {
+	return s.revokeSessions(ctx, uid, "")
+}
+
+func (s *API) revokeSessions(ctx context.Context, uid int64, keepParentToken string) (cnt int, err error) {
+	pTokens, err := s.listParentTokens(ctx, uid)
+	if err != nil {
+		return 0, err
+	}
+	for _, pToken := range pTokens {
+		if pToken == keepParentToken {
+			continue
+		}
+		if err = s.revokeSession(ctx, uid, pToken); err != nil {
+			return cnt, err
+		}
+		cnt++
+	}
+	return cnt, nil
+}
+
+// revokeSession 立即删除parent token以及下面所有的sub token
+func (s *API) revokeSession(ctx context.Context, uid int64, pToken string) error {
+	err := s.tokenServer.removeUserParentToken(ctx, uid, pToken, 0)
+	if err != nil {
+		return fmt.Errorf("ssostorage.revokeSession failed, err: %w", err)
+	}
+	return nil
+}
+
+// listParentTokens 获取用户下所有的parent token
+func (s *API) listParentTokens(ctx context.Context, uid int64) (pTokens []string, err error) {
+	expireTimeList, err := s.uidMapParentToken.getExpireTimeList(ctx, uid)
+	if err != nil {
+		return nil, fmt.Errorf("ssostorage.listParentTokens failed, err: %w", err)
+	}
+	pTokens = make([]string, 0, len(expireTimeList))
+	for _, value := range expireTimeList {
+		pToken, err := s.parentToken.getSubTokenByExpireTimeListField(value.Field)
+		if err != nil {
+			continue
+		}
+		pTokens = append(pTokens, pToken)
+	}
+	return pTokens, nil
+}
+
+// getSession 获取parent token对应的会话，parent token不存在返回nil
+func (s *API) getSession(ctx context.Context, uid int64, pToken string) (*Session, error) {
+	store, err := s.parentToken.getAll(ctx, pToken)
+	if err != nil {
+		return nil, fmt.Errorf("ssostorage.getSession failed, err: %w", err)
+	}
+	if store.Ctime == 0 {
+		return nil, nil
+	}
+	session := &Session{
+		SessionId:  sessionIdOf(pToken),
+		Ctime:      store.Ctime,
+		ExpireTime: time.Now().Unix() + store.TTL,
+		Clients:    make([]*SessionClient, 0),
+	}
+	if user, ok := store.Users[uid]; ok {
+		session.Platform = user.Platform
+		session.ClientIP = user.ClientIP
+		session.UA = user.UA
+		session.Ctime = user.Ctime
+	}
+	now := time.Now().Unix()
+	for _, value := range *store.ExpireTimeList {
+		if value.ExpireTime < now {
+			continue
+		}
+		subToken, err := s.parentToken.getSubTokenByExpireTimeListField(value.Field)
+		if err != nil {
+			continue
+		}
+		clientId, err := s.subToken.getClientId(ctx, subToken)
+		if err != nil {
+			continue
+		}
+		session.Clients = append(session.Clients, &SessionClient{
+			ClientId:   clientId,
+			ExpireTime: value.ExpireTime,
+		})
+	}
+	return session, nil
+}
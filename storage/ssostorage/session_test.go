@@ -0,0 +1,165 @@
+package ssostorage
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/ego-component/eoauth2/server"
+	"github.com/ego-component/eoauth2/server/model"
+)
+
+// assertSubToken 校验sub token是否还能使用，以及redis里的key是否还存在
+func assertSubToken(t *testing.T, c *Component, mr *miniredis.Miniredis, access *server.AccessData, want bool) {
+	t.Helper()
+	ctx := context.Background()
+	subToken, err := c.tokenServer.lookupSubToken(ctx, access.AccessToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exist := mr.Exists(c.config.layout.subTokenKey(subToken)); exist != want {
+		t.Errorf("sub token key exists, want: %v, got: %v", want, exist)
+	}
+	_, err = c.GetStorage().LoadAccess(ctx, access.AccessToken)
+	if want && err != nil {
+		t.Errorf("LoadAccess failed, err: %v", err)
+	}
+	if !want && !errors.Is(err, server.ErrNotFound) {
+		t.Errorf("LoadAccess after revoke, want ErrNotFound, got: %v", err)
+	}
+}
+
+// findSession 按照parent token查找会话
+func findSession(list []*Session, pToken string) *Session {
+	for _, session := range list {
+		if session.SessionId == sessionIdOf(pToken) {
+			return session
+		}
+	}
+	return nil
+}
+
+// TestRevokeSession 退出一个会话，删除parent token以及下面所有的sub token，其他会话不受影响
+func TestRevokeSession(t *testing.T) {
+	layouts := map[string][]Option{
+		"legacy":  nil,
+		"cluster": {WithClusterKeyLayout("oauth2")},
+	}
+	for name, options := range layouts {
+		options := options
+		t.Run(name, func(t *testing.T) {
+			c, mr := newTestComponent(t, options...)
+			ctx := context.Background()
+			api := c.GetAPI()
+			first := login(t, c, 1, model.NewToken(100))
+			pToken := storedParentToken(t, c, first)
+			// 同一个会话登录第二个sub token
+			firstAgain := login(t, c, 1, model.Token{Token: pToken, AuthAt: time.Now().Unix(), ExpiresIn: 100})
+			second := login(t, c, 1, model.NewToken(100))
+			// cluster布局下新的parent token会加上hash tag，没有开启token hash时存储的就是cookie里的明文
+			currentToken := storedParentToken(t, c, second)
+
+			list, err := api.ListSessions(ctx, 1, currentToken)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(list) != 2 {
+				t.Fatalf("sessions, want: 2, got: %d", len(list))
+			}
+			session := findSession(list, pToken)
+			if session == nil || session.Current || len(session.Clients) != 2 || session.Platform != "web" {
+				t.Fatalf("first session, got: %+v", session)
+			}
+			if session.Clients[0].ClientId != "storagetest" {
+				t.Errorf("session client, want: storagetest, got: %s", session.Clients[0].ClientId)
+			}
+			if s := findSession(list, currentToken); s == nil || !s.Current {
+				t.Errorf("current session should be marked, got: %+v", s)
+			}
+
+			if err = api.RevokeSession(ctx, 1, session.SessionId); err != nil {
+				t.Fatal(err)
+			}
+			if mr.Exists(c.config.layout.parentTokenKey(pToken)) {
+				t.Error("revoked parent token should be removed")
+			}
+			if expireTime := userExpireTime(t, c, 1, pToken); expireTime != 0 {
+				t.Errorf("revoked session should be removed from user, got expire time: %d", expireTime)
+			}
+			// 退出会话立即删除sub token，不保留宽限期
+			assertSubToken(t, c, mr, first, false)
+			assertSubToken(t, c, mr, firstAgain, false)
+			assertSubToken(t, c, mr, second, true)
+
+			list, err = api.ListSessions(ctx, 1, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(list) != 1 || list[0].SessionId != sessionIdOf(currentToken) {
+				t.Errorf("sessions after revoke, want only the current session, got: %+v", list)
+			}
+			if err = api.RevokeSession(ctx, 1, session.SessionId); !errors.Is(err, server.ErrNotFound) {
+				t.Errorf("revoke again, want ErrNotFound, got: %v", err)
+			}
+		})
+	}
+}
+
+// TestRevokeOtherSessions 退出其他会话保留当前会话，开启token hash时当前会话传入的是cookie里的明文
+func TestRevokeOtherSessions(t *testing.T) {
+	configs := map[string][]Option{
+		"plain":      nil,
+		"token hash": {WithTokenHash([]byte("storagetest"), true)},
+	}
+	for name, options := range configs {
+		options := options
+		t.Run(name, func(t *testing.T) {
+			c, mr := newTestComponent(t, options...)
+			ctx := context.Background()
+			api := c.GetAPI()
+			others := []*server.AccessData{login(t, c, 1, model.NewToken(100)), login(t, c, 1, model.NewToken(100))}
+			current := model.NewToken(100)
+			currentAccess := login(t, c, 1, current)
+			otherUser := login(t, c, 2, model.NewToken(100))
+
+			cnt, err := api.RevokeOtherSessions(ctx, 1, current.Token)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cnt != 2 {
+				t.Errorf("revoked sessions, want: 2, got: %d", cnt)
+			}
+			for _, access := range others {
+				assertSubToken(t, c, mr, access, false)
+			}
+			assertSubToken(t, c, mr, currentAccess, true)
+			assertSubToken(t, c, mr, otherUser, true)
+			list, err := api.ListSessions(ctx, 1, current.Token)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(list) != 1 || !list[0].Current {
+				t.Errorf("sessions after revoking others, want only the current session, got: %+v", list)
+			}
+
+			cnt, err = api.RevokeAllSessions(ctx, 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cnt != 1 {
+				t.Errorf("revoked sessions, want: 1, got: %d", cnt)
+			}
+			assertSubToken(t, c, mr, currentAccess, false)
+			assertSubToken(t, c, mr, otherUser, true)
+			list, err = api.ListSessions(ctx, 1, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(list) != 0 {
+				t.Errorf("sessions after revoking all, want: 0, got: %d", len(list))
+			}
+		})
+	}
+}
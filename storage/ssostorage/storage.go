@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/ego-component/egorm"
@@ -12,6 +13,30 @@ import (
 	"github.com/ego-component/eredis"
 	"github.com/go-redis/redis/v8"
 	"github.com/gotomicro/ego/core/elog"
+	"gorm.io/gorm"
+)
+
+// ErrAuthorizeCodeReused code被重复使用，已经用该code下发的token会被吊销
+var ErrAuthorizeCodeReused = errors.New("authorization code reused")
+
+// ErrUidNotInParentToken 多账号下，指定的账号没有在parent token里登录
+var ErrUidNotInParentToken = errors.New("uid not in parent token")
+
+// ErrUidOutOfRange uid的绝对值超过 2^53-1，lua脚本里不能精确表示
+var ErrUidOutOfRange = errors.New("uid out of range")
+
+// code兑换的状态
+const (
+	redeemStatusNotFound int64 = 0 // code不存在或者已过期
+	redeemStatusRedeemed int64 = 1 // 兑换成功
+	redeemStatusReplayed int64 = 2 // code已经兑换过，被重复使用
+)
+
+const (
+	// redeemedCodeTokenField code墓碑里记录下发token的field前缀
+	redeemedCodeTokenField = "_t:"
+	// redeemedCodeDataField code墓碑里保存authorize信息的field
+	redeemedCodeDataField = "_d"
 )
 
 type Storage struct {
@@ -47,102 +72,155 @@ func (s *Storage) Close() {
 }
 
 // GetClient loads the client by id
+// 客户端被删除返回ErrNotFound，被暂停返回ErrClientSuspended
 func (s *Storage) GetClient(ctx context.Context, clientId string) (c server.Client, err error) {
-	infoBytes, err := s.redis.Client().HGet(ctx, s.config.storeClientInfoKey, clientId).Bytes()
+	client, err := s.getClientInfo(ctx, clientId)
+	if err != nil {
+		return nil, err
+	}
+	// 删除的客户端不管dtime是否设置，都按照不存在处理，不暴露客户端存在
+	if client.Status == dao.AppStatusDeleted {
+		return nil, fmt.Errorf("sso storage GetClient client deleted, err: %w", server.ErrNotFound)
+	}
+	if !dao.IsAppStatusActive(client.Status) {
+		return nil, fmt.Errorf("sso storage GetClient client status is %d, err: %w", client.Status, server.ErrClientSuspended)
+	}
+	return client.toServerClient(), nil
+}
+
+// getClientInfo 先读sso:client缓存，缓存不存在或者超过有效期，从数据库重新加载
+func (s *Storage) getClientInfo(ctx context.Context, clientId string) (client *ClientInfo, err error) {
+	infoBytes, err := s.redis.Client().HGet(ctx, s.config.layout.clientKey(clientId), clientId).Bytes()
 	if err != nil && !errors.Is(err, redis.Nil) {
 		err = fmt.Errorf("sso storage GetClient redis get failed, err: %w", err)
 		return
 	}
-
-	// redis没查到，去数据库里查下
-	if errors.Is(err, redis.Nil) {
-		appInfo, err := dao.GetAppInfoByClientId(s.db.WithContext(ctx), clientId)
-		if err != nil {
-			return nil, fmt.Errorf("sso storage GetClient get mysql info failed,"+err.Error()+", err: %w", server.ErrNotFound)
-		}
-		client := &ClientInfo{
-			ClientId:    appInfo.ClientId,
-			Secret:      appInfo.Secret,
-			RedirectUri: appInfo.RedirectUri,
-		}
-		err = s.redis.HSet(ctx, s.config.storeClientInfoKey, clientId, client.Marshal())
+	if err == nil {
+		client = &ClientInfo{}
+		err = client.Unmarshal(infoBytes)
 		if err != nil {
-			return nil, fmt.Errorf("storage not found,"+err.Error()+",err: %w", server.ErrNotFound)
+			err = fmt.Errorf("sso storage GetClient unmarshal failed, err: %w", err)
+			return
 		}
-		info := server.DefaultClient{
-			Id:          client.ClientId,
-			Secret:      client.Secret,
-			RedirectUri: client.RedirectUri,
+		if time.Now().Unix()-client.CachedAt < s.config.clientCacheExpiration {
+			return client, nil
 		}
-		return &info, nil
 	}
 
-	client := &ClientInfo{}
-	err = client.Unmarshal(infoBytes)
+	// redis没查到或者缓存过期，去数据库里查下
+	client, err = loadClientInfo(ctx, s.db, clientId)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		// 数据库里已经删除，缓存也需要删除
+		_ = s.redis.HDel(ctx, s.config.layout.clientKey(clientId), clientId)
+		return nil, fmt.Errorf("sso storage GetClient get mysql info failed,"+err.Error()+", err: %w", server.ErrNotFound)
+	}
 	if err != nil {
-		err = fmt.Errorf("sso storage GetClient unmarshal failed, err: %w", err)
-		return
+		return nil, fmt.Errorf("sso storage GetClient get mysql info failed, err: %w", err)
 	}
-	info := server.DefaultClient{
-		Id:          client.ClientId,
-		Secret:      client.Secret,
-		RedirectUri: client.RedirectUri,
+	err = s.redis.HSet(ctx, s.config.layout.clientKey(clientId), clientId, client.Marshal())
+	if err != nil {
+		s.logger.Warn("sso storage GetClient set cache failed", elog.FieldErr(err), elog.FieldKey(clientId))
 	}
-	return &info, nil
+	return client, nil
 }
 
 // SaveAuthorize saves authorize data.
 // 单点登录，会多出一个parent token
 func (s *Storage) SaveAuthorize(ctx context.Context, data *server.AuthorizeData) (err error) {
+	// 开启token hash时，redis里只保存parent token的hash，cookie里的parent token明文保持不变
+	pToken := s.config.tokenKey(data.SsoData.Token.Token)
+	// Redis Cluster key布局下会给新的parent token加上hash tag，多账号复用的已有token保持不变
+	tagged := s.config.layout.tagParentToken(data.SsoData.Token.Token, data.SsoData.Uid)
+	if lookups := s.config.tokenLookups(data.SsoData.Token.Token); tagged != data.SsoData.Token.Token || len(lookups) > 1 {
+		key, exists, err := s.tokenServer.resolveToken(ctx, lookups, s.config.layout.parentTokenKey)
+		if err != nil {
+			return fmt.Errorf("sso storage SaveAuthorize check parent token failed, err: %w", err)
+		}
+		if exists {
+			pToken = key
+		} else if tagged != data.SsoData.Token.Token {
+			data.SsoData.Token.Token = tagged
+			pToken = s.config.tokenKey(tagged)
+		}
+	}
+
+	// 先创建父级Token，parent token里只保存hash；超过会话个数限制拒绝登录时不会留下code
+	ssoData := data.SsoData
+	ssoData.Token.Token = pToken
+	err = s.tokenServer.createParentToken(ctx, ssoData)
+	if err != nil {
+		err = fmt.Errorf("sso storage SaveAuthorize createParentToken failed, err: %w", err)
+		return
+	}
+
+	code := s.config.tokenKey(data.Code)
 	store := &authorizeData{
 		ClientId:    data.Client.GetId(),
-		Code:        data.Code,
-		Ptoken:      data.SsoData.Token.Token,
+		Code:        code,
+		Ptoken:      pToken,
 		ExpiresIn:   data.ExpiresIn,
 		Scope:       data.Scope,
 		RedirectUri: data.RedirectUri,
 		State:       data.State,
 		Ctime:       data.CreatedAt.Unix(),
 	}
-	err = s.redis.SetEX(ctx, fmt.Sprintf(s.config.storeAuthorizeKey, data.Code), store.Marshal(), time.Duration(data.ExpiresIn)*time.Second)
+	err = s.redis.SetEX(ctx, s.config.layout.authorizeKey(code), store.Marshal(), time.Duration(data.ExpiresIn)*time.Second)
 	if err != nil {
 		err = fmt.Errorf("sso storage SaveAuthorize failed, err: %w", err)
 		return
 	}
-
-	// 创建父级Token
-	err = s.tokenServer.createParentToken(ctx, data.SsoData)
-	if err != nil {
-		err = fmt.Errorf("sso storage SaveAuthorize createParentToken failed, err: %w", err)
-		return
-	}
 	return
 }
 
 // LoadAuthorize looks up AuthorizeData by a code.
 // Client information MUST be loaded together.
 // Optionally can return error if expired.
+// code只能兑换一次，兑换时原子的删除code并留下墓碑；code被重复使用时，吊销已经用该code下发的token
 func (s *Storage) LoadAuthorize(ctx context.Context, code string) (*server.AuthorizeData, error) {
 	var data server.AuthorizeData
-	storeBytes, err := s.redis.GetBytes(ctx, fmt.Sprintf(s.config.storeAuthorizeKey, code))
-	if err != nil {
-		err = fmt.Errorf("sso storage LoadAuthorize redis get failed, err: %w", err)
-		return nil, err
+	var res []interface{}
+	var err error
+	// 开启legacyLookup时，依次查询code的hash和明文
+	for _, key := range s.config.tokenLookups(code) {
+		res, err = redeemAuthorizeScript.Run(ctx, s.redis.Client(), []string{
+			s.config.layout.authorizeKey(key),
+			s.config.layout.redeemedCodeKey(key),
+		},
+			time.Now().Unix(),
+			s.config.redeemedCodeExpiration,
+		).Slice()
+		if err != nil {
+			err = fmt.Errorf("sso storage LoadAuthorize redis redeem failed, err: %w", err)
+			return nil, err
+		}
+		code = key
+		if res[0].(int64) != redeemStatusNotFound {
+			break
+		}
+	}
+	switch res[0].(int64) {
+	case redeemStatusReplayed:
+		s.revokeRedeemedCodeTokens(ctx, code)
+		return nil, fmt.Errorf("sso storage LoadAuthorize failed, err: %w", ErrAuthorizeCodeReused)
+	case redeemStatusNotFound:
+		return nil, fmt.Errorf("sso storage LoadAuthorize redis get failed, err: %w", server.ErrNotFound)
 	}
 	info := &authorizeData{}
-	err = info.Unmarshal(storeBytes)
+	err = info.Unmarshal([]byte(res[1].(string)))
 	if err != nil {
 		err = fmt.Errorf("sso storage LoadAuthorize unmarshal failed, err: %w", err)
 		return nil, err
 	}
 	data = server.AuthorizeData{
-		Code:        info.Code,
+		Code:        code,
 		ExpiresIn:   info.ExpiresIn,
 		Scope:       info.Scope,
 		RedirectUri: info.RedirectUri,
 		State:       info.State,
 		CreatedAt:   time.Unix(info.Ctime, 0),
 	}
+	// code已经删除，SaveAccess通过SsoData拿到parent token
+	data.SsoData.Token.Token = info.Ptoken
 	c, err := s.GetClient(ctx, info.ClientId)
 	if err != nil {
 		return nil, err
@@ -151,9 +229,62 @@ func (s *Storage) LoadAuthorize(ctx context.Context, code string) (*server.Autho
 	return &data, nil
 }
 
+// revokeRedeemedCodeTokens 吊销用重复使用的code下发的所有token，https://tools.ietf.org/html/rfc6749#section-4.1.2
+// 先吊销墓碑里记录的sub token，再删除code对应的parent token，刷新得到的sub token和单点登录会话一起失效
+func (s *Storage) revokeRedeemedCodeTokens(ctx context.Context, code string) {
+	fields, err := s.redis.Client().HGetAll(ctx, s.config.layout.redeemedCodeKey(code)).Result()
+	if err != nil {
+		s.logger.Error("sso storage revokeRedeemedCodeTokens failed", elog.FieldErr(err))
+		return
+	}
+	for field := range fields {
+		if !strings.HasPrefix(field, redeemedCodeTokenField) {
+			continue
+		}
+		token := strings.TrimPrefix(field, redeemedCodeTokenField)
+		if err = s.tokenServer.revokeToken(ctx, token); err != nil && !errors.Is(err, redis.Nil) {
+			s.logger.Warn("sso storage revokeRedeemedCodeTokens revoke failed", elog.FieldErr(err))
+		}
+	}
+	data, ok := fields[redeemedCodeDataField]
+	if !ok {
+		return
+	}
+	info := &authorizeData{}
+	if err = info.Unmarshal([]byte(data)); err != nil {
+		s.logger.Error("sso storage revokeRedeemedCodeTokens unmarshal failed", elog.FieldErr(err))
+		return
+	}
+	err = s.tokenServer.removeParentTokenWithGrace(ctx, info.Ptoken, 0)
+	if err != nil && !errors.Is(err, redis.Nil) {
+		s.logger.Warn("sso storage revokeRedeemedCodeTokens remove parent token failed", elog.FieldErr(err))
+	}
+}
+
+// recordRedeemedCodeToken 在code的墓碑里记录下发的token，如果code已经被重复使用，立即吊销用该code下发的所有token
+func (s *Storage) recordRedeemedCodeToken(ctx context.Context, code string, token string) error {
+	replayed, err := recordRedeemedCodeTokenScript.Run(ctx, s.redis.Client(), []string{
+		s.config.layout.redeemedCodeKey(code),
+	},
+		redeemedCodeTokenField+token,
+	).Int()
+	if err != nil {
+		return fmt.Errorf("sso storage recordRedeemedCodeToken failed, err: %w", err)
+	}
+	if replayed == 1 {
+		s.revokeRedeemedCodeTokens(ctx, code)
+		return fmt.Errorf("sso storage recordRedeemedCodeToken failed, err: %w", ErrAuthorizeCodeReused)
+	}
+	return nil
+}
+
 // RemoveAuthorize revokes or deletes the authorization code.
 func (s *Storage) RemoveAuthorize(ctx context.Context, code string) (err error) {
-	_, err = s.redis.Del(ctx, fmt.Sprintf(s.config.storeAuthorizeKey, code))
+	keys := make([]string, 0, 2)
+	for _, key := range s.config.tokenRefs(code) {
+		keys = append(keys, s.config.layout.authorizeKey(key))
+	}
+	err = s.redis.Client().Del(ctx, keys...).Err()
 	if err != nil {
 		err = fmt.Errorf("sso storage RemoveAuthorize failed, err: %w", err)
 		return
@@ -183,20 +314,8 @@ func (s *Storage) SaveAccess(ctx context.Context, data *server.AccessData) (err
 	pToken := ""
 	// 这种是在authorize token的时候，会有code信息
 	if authorizeDataInfo.Code != "" {
-		// 根据之前code码，取出parent token信息
-		var storeBytes []byte
-		storeBytes, err = s.redis.GetBytes(ctx, fmt.Sprintf(s.config.storeAuthorizeKey, authorizeDataInfo.Code))
-		if err != nil {
-			err = fmt.Errorf("sso storage redis GetBytes failed, err: %w", err)
-			return
-		}
-		info := &authorizeData{}
-		err = info.Unmarshal(storeBytes)
-		if err != nil {
-			err = fmt.Errorf("sso storage SaveAccess unmarshal failed, err: %w", err)
-			return
-		}
-		pToken = info.Ptoken
+		// code已经在LoadAuthorize里兑换并删除，parent token信息在SsoData里
+		pToken = authorizeDataInfo.SsoData.Token.Token
 		// refresh token的时候，没有该信息
 		// 1 拿到原先的sub token，看是否有效
 		// 2 再从sub token中找到对应parent token，看是否有效
@@ -212,26 +331,47 @@ func (s *Storage) SaveAccess(ctx context.Context, data *server.AccessData) (err
 	if pToken == "" {
 		return fmt.Errorf("ptoken is empty")
 	}
+	// 多账号下，刷新token时没有指定账号，沿用老token所属的账号
+	if prevToken != "" && data.TokenData.StoreData.Uid == 0 {
+		data.TokenData.StoreData.Uid, err = s.tokenServer.subToken.getUid(ctx, prevToken)
+		if err != nil {
+			return fmt.Errorf("sso storage SaveAccess get uid failed, err: %w", err)
+		}
+	}
 
 	if data.Client == nil {
 		return errors.New("data.Client must not be nil")
 	}
 
+	// Redis Cluster key布局下，sub token使用parent token的hash tag，和parent token在同一个slot
+	if data.AccessToken == data.TokenData.Token.Token {
+		data.TokenData.Token.Token = s.config.layout.tagSubToken(data.TokenData.Token.Token, pToken)
+		data.AccessToken = data.TokenData.Token.Token
+	}
+	// 开启token hash时，redis里只保存sub token的hash，返回给客户端的access token是明文
+	subToken := s.config.tokenKey(data.TokenData.Token.Token)
+	tokenData := data.TokenData
+	tokenData.Token.Token = subToken
+
 	storeData := &AccessData{
-		ClientId:      data.Client.GetId(),
-		PreviousToken: prevToken,
-		CurrentToken:  data.AccessToken,
-		ExpiresIn:     data.TokenExpiresIn,
-		Scope:         data.Scope,
-		RedirectUri:   data.RedirectUri,
-		Ctime:         data.CreatedAt.Unix(),
+		ClientId:         data.Client.GetId(),
+		PreviousToken:    prevToken,
+		CurrentToken:     subToken,
+		ExpiresIn:        data.TokenExpiresIn,
+		RefreshExpiresIn: data.RefreshTokenExpiresIn,
+		Scope:            data.Scope,
+		RedirectUri:      data.RedirectUri,
+		Ctime:            data.CreatedAt.Unix(),
 	}
 
 	// 单点登录下，refresh token，其实可以不需要，因为
-	err = s.tokenServer.createToken(ctx, data.Client.GetId(), data.TokenData, pToken, storeData)
+	err = s.tokenServer.createToken(ctx, data.Client.GetId(), tokenData, pToken, storeData)
 	if err != nil {
 		return fmt.Errorf("设置redis token失败, err:%w", err)
 	}
+	if authorizeDataInfo.Code != "" {
+		return s.recordRedeemedCodeToken(ctx, authorizeDataInfo.Code, subToken)
+	}
 	return nil
 }
 
@@ -240,7 +380,15 @@ func (s *Storage) SaveAccess(ctx context.Context, data *server.AccessData) (err
 // Optionally can return error if expired.
 func (s *Storage) LoadAccess(ctx context.Context, token string) (*server.AccessData, error) {
 	var result server.AccessData
-	info, err := s.tokenServer.getAccess(ctx, token)
+	var info *AccessData
+	var err error
+	// 开启legacyLookup时，依次查询token的hash和明文
+	for _, key := range s.config.tokenLookups(token) {
+		info, err = s.tokenServer.getAccess(ctx, key)
+		if err == nil {
+			break
+		}
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -248,6 +396,7 @@ func (s *Storage) LoadAccess(ctx context.Context, token string) (*server.AccessD
 	result.AccessToken = info.CurrentToken
 	//result.RefreshToken = info.RefreshToken
 	result.TokenExpiresIn = info.ExpiresIn
+	result.RefreshTokenExpiresIn = info.RefreshExpiresIn
 	result.Scope = info.Scope
 	result.RedirectUri = info.RedirectUri
 	result.CreatedAt = time.Unix(info.Ctime, 0)
@@ -262,7 +411,9 @@ func (s *Storage) LoadAccess(ctx context.Context, token string) (*server.AccessD
 // RemoveAccess revokes or deletes an AccessData.
 // 用于删除上一个token信息
 func (s *Storage) RemoveAccess(ctx context.Context, token string) (err error) {
-	s.tokenServer.removeToken(ctx, token)
+	for _, key := range s.config.tokenRefs(token) {
+		_ = s.tokenServer.removeToken(ctx, key)
+	}
 	return
 }
 
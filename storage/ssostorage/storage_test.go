@@ -0,0 +1,147 @@
+package ssostorage
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/ego-component/eoauth2/server"
+	"github.com/ego-component/eoauth2/server/model"
+	"github.com/ego-component/eoauth2/storage/dao"
+	"github.com/ego-component/eoauth2/storage/storagetest"
+	"github.com/ego-component/eredis"
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+)
+
+// newTestDB 使用sqlite内存数据库代替mysql
+func newTestDB(t *testing.T) *gorm.DB {
+	db, err := gorm.Open(sqlite.Open("file:"+t.Name()+"?mode=memory&cache=shared"), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB.SetMaxOpenConns(1)
+	t.Cleanup(func() { _ = sqlDB.Close() })
+	if err = db.AutoMigrate(&dao.App{}, &dao.AppSecret{}); err != nil {
+		t.Fatal(err)
+	}
+	err = db.Create(&dao.App{ClientId: "storagetest", Secret: "secret", RedirectUri: "http://localhost/callback", Status: dao.AppStatusActive}).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+// newTestComponent 使用miniredis和sqlite创建组件
+func newTestComponent(t *testing.T, options ...Option) (*Component, *miniredis.Miniredis) {
+	mr := miniredis.RunT(t)
+	rds := eredis.DefaultContainer().Build(eredis.WithStub(), eredis.WithAddr(mr.Addr()))
+	return NewComponent(newTestDB(t), rds, options...), mr
+}
+
+func runStorageTest(t *testing.T, options ...Option) {
+	storagetest.Run(t, func(t *testing.T) *storagetest.Harness {
+		c, mr := newTestComponent(t, options...)
+		client, err := c.GetStorage().GetClient(context.Background(), "storagetest")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return &storagetest.Harness{
+			Storage:                c.GetStorage(),
+			Client:                 client,
+			RefreshWithAccessToken: true,
+			RemoveGrace:            true,
+			Advance:                mr.FastForward,
+			RemoveParentToken:      c.RemoveParentToken,
+		}
+	})
+}
+
+func TestStorage(t *testing.T) {
+	runStorageTest(t)
+}
+
+func TestStorageClusterKeyLayout(t *testing.T) {
+	runStorageTest(t, WithClusterKeyLayout("oauth2"))
+}
+
+func TestStorageTokenHash(t *testing.T) {
+	runStorageTest(t, WithTokenHash([]byte("storagetest"), true))
+}
+
+func TestStorageClusterKeyLayoutTokenHash(t *testing.T) {
+	runStorageTest(t, WithClusterKeyLayout("oauth2"), WithTokenHash([]byte("storagetest"), true))
+}
+
+// TestLoadAuthorizeConcurrent 同一个code并发兑换，只有一个成功
+func TestLoadAuthorizeConcurrent(t *testing.T) {
+	c, _ := newTestComponent(t)
+	authorize := newAuthorize(t, c, 1, model.NewToken(100))
+	if err := c.GetStorage().SaveAuthorize(context.Background(), authorize); err != nil {
+		t.Fatal(err)
+	}
+
+	const n = 8
+	errs := make(chan error, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := c.GetStorage().LoadAuthorize(context.Background(), authorize.Code)
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	redeemed := 0
+	for err := range errs {
+		switch {
+		case err == nil:
+			redeemed++
+		case !errors.Is(err, ErrAuthorizeCodeReused):
+			t.Errorf("concurrent redeem, want ErrAuthorizeCodeReused, got: %v", err)
+		}
+	}
+	if redeemed != 1 {
+		t.Errorf("concurrent redeem, want exactly 1 success, got: %d", redeemed)
+	}
+}
+
+// TestLoadAuthorizeReplayAfterRefresh code被重复使用时，刷新得到的token、parent token都要吊销
+func TestLoadAuthorizeReplayAfterRefresh(t *testing.T) {
+	c, mr := newTestComponent(t)
+	ctx := context.Background()
+	authorize := newAuthorize(t, c, 1, model.NewToken(100))
+	if err := c.GetStorage().SaveAuthorize(ctx, authorize); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := c.GetStorage().LoadAuthorize(ctx, authorize.Code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := issueToken(t, c, loaded, nil)
+	pToken := storedParentToken(t, c, first)
+	refreshed := issueToken(t, c, nil, first)
+
+	if _, err = c.GetStorage().LoadAuthorize(ctx, authorize.Code); !errors.Is(err, ErrAuthorizeCodeReused) {
+		t.Fatalf("replay code, want ErrAuthorizeCodeReused, got: %v", err)
+	}
+	for _, access := range []*server.AccessData{first, refreshed} {
+		if _, err = c.GetStorage().LoadAccess(ctx, access.AccessToken); err == nil {
+			t.Errorf("token %s should be revoked after code replay", access.AccessToken)
+		}
+	}
+	if mr.Exists(c.config.layout.parentTokenKey(pToken)) {
+		t.Errorf("parent token should be removed after code replay")
+	}
+	if expireTime := userExpireTime(t, c, 1, pToken); expireTime != 0 {
+		t.Errorf("session should be removed from user after code replay, got expire time: %d", expireTime)
+	}
+}
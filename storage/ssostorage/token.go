@@ -2,58 +2,234 @@ package ssostorage
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"time"
 
+	"github.com/ego-component/eoauth2/server"
 	"github.com/ego-component/eoauth2/server/model"
+	"github.com/ego-component/eredis"
+	"github.com/go-redis/redis/v8"
+	"github.com/gotomicro/ego/core/elog"
 )
 
+// subTokenRemoveGrace 刷新token、退出登录时，sub token保留的时间
+const subTokenRemoveGrace = 30 * time.Second
+
+// removeParentTokenRetries 删除parent token时，读取之后数据发生变化的重试次数
+const removeParentTokenRetries = 5
+
+// errParentTokenExpiresInUnknown 老版本创建的parent token没有存储续期时长
+var errParentTokenExpiresInUnknown = errors.New("parent token expires in unknown")
+
 type tokenServer struct {
+	redis             *eredis.Component
+	logger            *elog.Component
 	uidMapParentToken *userToken
 	parentToken       *parentToken
 	subToken          *subToken
 	config            *config
 }
 
-func initTokenServer(config *config, uidMapParentToken *userToken, parentToken *parentToken, subToken *subToken) *tokenServer {
+func initTokenServer(config *config, logger *elog.Component, redis *eredis.Component, uidMapParentToken *userToken, parentToken *parentToken, subToken *subToken) *tokenServer {
 	return &tokenServer{
 		config:            config,
+		logger:            logger,
+		redis:             redis,
 		uidMapParentToken: uidMapParentToken,
 		parentToken:       parentToken,
 		subToken:          subToken,
 	}
 }
 
-// createParentToken sso的父节点token
+// createParentToken sso的父节点token，使用lua脚本原子的写入uid到parent token关系，以及父级的token信息
+// 配置了会话个数限制时，在同一个脚本里检查限制，超过限制时踢掉最早登录的会话，或者拒绝登录
 func (t *tokenServer) createParentToken(ctx context.Context, ssoData model.ParentToken) (err error) {
-	// 1 设置uid 到 parent token关系
-	err = t.uidMapParentToken.setToken(ctx, ssoData.Uid, ssoData)
+	if ssoData.Uid > maxLuaInt || ssoData.Uid < -maxLuaInt {
+		return fmt.Errorf("token.createParentToken failed, uid: %d, err: %w", ssoData.Uid, ErrUidOutOfRange)
+	}
+	tokenInfo, err := ssoData.Token.Marshal()
 	if err != nil {
-		return fmt.Errorf("token.createParentToken: create token map failed, err:%w", err)
+		return fmt.Errorf("token.createParentToken marshal failed, err:%w", err)
+	}
+	multiple := "0"
+	if t.config.enableMultipleAccounts {
+		multiple = "1"
+	}
+	maxSessions, maxSessionsPerPlatform, reject := 0, 0, "0"
+	if policy := t.config.sessionPolicy; policy != nil {
+		maxSessions = policy.MaxSessions
+		maxSessionsPerPlatform = policy.MaxSessionsPerPlatform[ssoData.StoreData.Platform]
+		if policy.Action == SessionLimitReject {
+			reject = "1"
+		}
+	}
+	uidKey := t.uidMapParentToken.getKey(ssoData.Uid)
+	pKey := t.parentToken.getKey(ssoData.Token.Token)
+	now := time.Now().Unix()
+	args := []interface{}{
+		now,
+		ssoData.Token.ExpiresIn,
+		ssoData.Uid,
+		ssoData.StoreData.Platform,
+		t.uidMapParentToken.getFieldKey(ssoData.Token.Token),
+		tokenInfo,
+		t.parentToken.getUserField(ssoData.Uid),
+		ssoData.StoreData.Marshal(),
+		multiple,
+		maxSessions,
+		maxSessionsPerPlatform,
+		reject,
+		t.config.parentTokenMaxLifetime,
 	}
+	var res []interface{}
+	if t.config.layout.colocated(ssoData.Uid, ssoData.Token.Token) {
+		res, err = createParentTokenScript.Run(ctx, t.redis.Client(), []string{uidKey, pKey}, append([]interface{}{"all"}, args...)...).Slice()
+	} else {
+		// uid key和parent token key不在同一个slot，分两次执行
+		res, err = createParentTokenScript.Run(ctx, t.redis.Client(), []string{uidKey}, append([]interface{}{"uid"}, args...)...).Slice()
+		if err == nil && res[0].(int64) == 1 {
+			var parentRes []interface{}
+			parentRes, err = createParentTokenScript.Run(ctx, t.redis.Client(), []string{pKey}, append([]interface{}{"parent"}, args...)...).Slice()
+			// 复用已有的parent token时过期时间受最大会话时长限制，同步到uid key里
+			if err == nil && parentRes[1].(int64) != now+ssoData.Token.ExpiresIn {
+				err = t.renewStrayUsers(ctx, []int64{ssoData.Uid}, ssoData.Token.Token, parentRes[1].(int64), now)
+			}
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("token.createParentToken failed, err:%w", err)
+	}
+	if res[0].(int64) == 0 {
+		return fmt.Errorf("token.createParentToken failed, uid: %d, platform: %s, err: %w", ssoData.Uid, ssoData.StoreData.Platform, server.ErrSessionLimitExceeded)
+	}
+	// 脚本里已经从用户里移除了踢掉的会话，再删除会话的parent token以及下面所有的sub token
+	for _, value := range res[1:] {
+		evictToken, err := t.parentToken.getSubTokenByExpireTimeListField(value.(string))
+		if err != nil {
+			continue
+		}
+		if err = t.removeUserParentToken(ctx, ssoData.Uid, evictToken, subTokenRemoveGrace); err != nil {
+			return fmt.Errorf("token.createParentToken evict failed, err: %w", err)
+		}
+	}
+	return nil
+}
 
-	// 2 创建父级的token信息
-	return t.parentToken.create(ctx, ssoData)
+// renewParentToken 续期parent token，同时续期用户下的parent token信息
+func (t *tokenServer) renewParentToken(ctx context.Context, pToken string, expiresIn int64) (expireAt int64, err error) {
+	keys, strayUids, err := t.renewKeys(ctx, pToken)
+	if err != nil {
+		return 0, fmt.Errorf("token.renewParentToken failed, err: %w", err)
+	}
+	now := time.Now().Unix()
+	expireAt, err = renewParentTokenScript.Run(ctx, t.redis.Client(), keys,
+		now,
+		expiresIn,
+		t.config.parentTokenMaxLifetime,
+		t.uidMapParentToken.getFieldKey(pToken),
+	).Int64()
+	if err != nil {
+		return 0, fmt.Errorf("token.renewParentToken failed, err: %w", err)
+	}
+	switch expireAt {
+	case 0:
+		return 0, fmt.Errorf("token.renewParentToken parent token empty, err: %w", redis.Nil)
+	case -1:
+		return 0, errParentTokenExpiresInUnknown
+	}
+	if err = t.renewStrayUsers(ctx, strayUids, pToken, expireAt, now); err != nil {
+		return 0, fmt.Errorf("token.renewParentToken renew user failed, err: %w", err)
+	}
+	return expireAt, nil
 }
 
-//
-//func (t *tokenServer) renewParentToken(ctx context.Context, pToken model.Field) (err error) {
-//	// 1 设置uid 到 parent token关系
-//	err = t.parentToken.renew(ctx, pToken)
-//	if err != nil {
-//		return fmt.Errorf("token.createParentToken: create token map failed, err:%w", err)
-//	}
-//	return nil
-//}
+// renewKeys 续期parent token时lua脚本需要的key，第一个为parent token key，之后为同一个slot的uid key
+// Redis Cluster key布局下和parent token不在同一个slot的uid（多账号）单独返回，由 renewStrayUsers 处理
+func (t *tokenServer) renewKeys(ctx context.Context, pToken string) (keys []string, strayUids []int64, err error) {
+	keys = []string{t.parentToken.getKey(pToken)}
+	uids, err := t.parentToken.getUids(ctx, pToken)
+	// parent token不存在时由脚本返回
+	if errors.Is(err, redis.Nil) {
+		return keys, nil, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, uid := range uids {
+		if t.config.layout.colocated(uid, pToken) {
+			keys = append(keys, t.uidMapParentToken.getKey(uid))
+		} else {
+			strayUids = append(strayUids, uid)
+		}
+	}
+	return keys, strayUids, nil
+}
 
-// createToken 创建TOKEN信息，并且存入access信息
+// renewStrayUsers 更新和parent token不在同一个slot的uid key里的过期时间
+func (t *tokenServer) renewStrayUsers(ctx context.Context, uids []int64, pToken string, expireAt int64, now int64) error {
+	for _, uid := range uids {
+		err := renewUserParentTokenScript.Run(ctx, t.redis.Client(), []string{
+			t.uidMapParentToken.getKey(uid),
+		}, t.uidMapParentToken.getFieldKey(pToken), expireAt, now).Err()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// createToken 创建TOKEN信息，并且存入access信息，使用lua脚本原子的写入parent token和sub token
+// 滑动过期时在同一个脚本里续期parent token；sub token已经写入后续期失败只记录日志，不影响下发token
 func (t *tokenServer) createToken(ctx context.Context, clientId string, token model.SubToken, pToken string, storeData *AccessData) (err error) {
-	err = t.parentToken.setToken(ctx, pToken, token.Token)
+	tokenInfo, err := token.Token.Marshal()
+	if err != nil {
+		return fmt.Errorf("tokenServer.createToken marshal failed, err:%w", err)
+	}
+	keys := []string{t.parentToken.getKey(pToken), t.subToken.getKey(token.Token.Token)}
+	sliding := "0"
+	var strayUids []int64
+	if t.config.parentTokenSliding {
+		sliding = "1"
+		renewKeys, stray, err := t.renewKeys(ctx, pToken)
+		if err != nil {
+			return fmt.Errorf("tokenServer.createToken failed, err:%w", err)
+		}
+		keys = append(keys, renewKeys[1:]...)
+		strayUids = stray
+	}
+	now := time.Now().Unix()
+	res, err := createSubTokenScript.Run(ctx, t.redis.Client(), keys,
+		now,
+		token.Token.ExpiresIn,
+		t.parentToken.getClientField(token.Token.Token),
+		tokenInfo,
+		pToken,
+		clientId,
+		storeData.Marshal(),
+		token.StoreData.Marshal(),
+		token.StoreData.Uid,
+		sliding,
+		t.config.parentTokenMaxLifetime,
+		t.uidMapParentToken.getFieldKey(pToken),
+	).Int64Slice()
 	if err != nil {
 		return fmt.Errorf("tokenServer.createToken failed, err:%w", err)
 	}
-	// setTTL new token
-	err = t.subToken.create(ctx, token, pToken, clientId, storeData)
-	return
+	if res[0] == -1 {
+		return fmt.Errorf("tokenServer.createToken uid: %d, err: %w", token.StoreData.Uid, ErrUidNotInParentToken)
+	}
+	// 因为authorize阶段创建了parent token，所以如果不存在parent token key是有问题的，需要报错
+	if res[0] == 0 {
+		return fmt.Errorf("tokenServer.createToken parent token empty, err: %w", redis.Nil)
+	}
+	// 老数据没有续期时长，脚本不续期，返回0
+	if expireAt := res[1]; expireAt > 0 && len(strayUids) > 0 {
+		if err = t.renewStrayUsers(ctx, strayUids, pToken, expireAt, now); err != nil {
+			t.logger.Warn("tokenServer.createToken renew user failed", elog.FieldErr(err))
+		}
+	}
+	return nil
 }
 
 func (t *tokenServer) getAccess(ctx context.Context, token string) (storeData *AccessData, err error) {
@@ -66,32 +242,250 @@ func (t *tokenServer) removeToken(ctx context.Context, subToken string) error {
 	if err != nil {
 		return err
 	}
-	// 删除掉parent token里的信息
-	_ = t.parentToken.removeSubToken(ctx, pToken, subToken)
-	// 最后移除，可能会有用到信息
-	_, _ = t.subToken.remove(ctx, subToken)
+	// 删除掉parent token里的信息，sub token给30s时间，避免换token的时间差，prev token过早失效导致的业务问题
+	return t.removeSubToken(ctx, pToken, subToken, subTokenRemoveGrace)
+}
+
+// revokeToken 立即吊销sub token，并移除parent token里的sub token
+func (t *tokenServer) revokeToken(ctx context.Context, subToken string) error {
+	pToken, err := t.getParentTokenByToken(ctx, subToken)
+	if err != nil {
+		return err
+	}
+	return t.removeSubToken(ctx, pToken, subToken, 0)
+}
+
+// removeSubToken 原子的移除parent token里的sub token，grace为sub token保留的时间，0表示立即删除
+func (t *tokenServer) removeSubToken(ctx context.Context, pToken string, subToken string, grace time.Duration) error {
+	keys := []string{t.parentToken.getKey(pToken), t.subToken.getKey(subToken)}
+	colocated := t.config.layout.colocatedSubToken(subToken, pToken)
+	if !colocated {
+		// 迁移过来的老sub token和parent token不在同一个slot，sub token单独处理
+		keys = keys[:1]
+	}
+	err := removeSubTokenScript.Run(ctx, t.redis.Client(), keys,
+		t.parentToken.getClientField(subToken),
+		int64(grace.Seconds()),
+	).Err()
+	if err == nil && !colocated {
+		err = t.expireSubTokenKey(ctx, subToken, grace)
+	}
+	if err != nil {
+		return fmt.Errorf("tokenServer.removeSubToken failed, err: %w", err)
+	}
 	return nil
 }
 
+// expireSubTokenKey 让sub token key过期，grace为0表示立即删除
+func (t *tokenServer) expireSubTokenKey(ctx context.Context, subToken string, grace time.Duration) error {
+	if grace > 0 {
+		return t.redis.Client().Expire(ctx, t.subToken.getKey(subToken), grace).Err()
+	}
+	return t.redis.Client().Del(ctx, t.subToken.getKey(subToken)).Err()
+}
+
+// revokeTokensByClientId 吊销某个客户端所有的sub token，返回吊销的个数
+// 没有客户端到sub token的索引，需要SCAN所有的sub token，只用于后台管理操作
+func (t *tokenServer) revokeTokensByClientId(ctx context.Context, clientId string) (cnt int, err error) {
+	err = t.subToken.scan(ctx, func(subTokens []string) error {
+		for _, subToken := range subTokens {
+			tokenClientId, err := t.subToken.getClientId(ctx, subToken)
+			if err != nil || tokenClientId != clientId {
+				continue
+			}
+			if err = t.revokeToken(ctx, subToken); err != nil {
+				return err
+			}
+			cnt++
+		}
+		return nil
+	})
+	if err != nil {
+		return cnt, fmt.Errorf("tokenServer.revokeTokensByClientId failed, err: %w", err)
+	}
+	return cnt, nil
+}
+
 // removeParentToken 这个地方还要移除user里面的parent token。要不然数据会有很多脏数据
-// 还需要删除长token里的所有短token
+// 还需要删除长token里的所有短token，使用lua脚本原子的处理
 func (t *tokenServer) removeParentToken(ctx context.Context, pToken string) (err error) {
-	// 删除所有里面的sub token
-	expireList, _ := t.parentToken.getExpireTimeList(ctx, pToken)
-	for _, value := range expireList {
-		subTokenStr, _ := t.parentToken.getSubTokenByExpireTimeListField(value.Field)
-		_, _ = t.subToken.remove(ctx, subTokenStr)
+	return t.removeParentTokenWithGrace(ctx, pToken, subTokenRemoveGrace)
+}
+
+// removeParentTokenWithGrace 删除parent token，grace为sub token保留的时间，0表示立即删除
+// 先读取parent token下的sub token和uid，生成lua脚本需要的key，读取之后数据发生变化时重试
+func (t *tokenServer) removeParentTokenWithGrace(ctx context.Context, pToken string, grace time.Duration) error {
+	for i := 0; i < removeParentTokenRetries; i++ {
+		removed, err := t.tryRemoveParentToken(ctx, pToken, grace)
+		if err != nil {
+			return err
+		}
+		if removed {
+			return nil
+		}
 	}
+	return fmt.Errorf("token.removeParentToken: parent token changed after %d retries", removeParentTokenRetries)
+}
 
-	uids, err := t.getUidsByParentToken(ctx, pToken)
+// tryRemoveParentToken 尝试删除parent token，读取之后数据发生变化返回false
+// 迁移过来的老sub token、多账号的uid key和parent token不在同一个slot，不能在脚本里处理，脚本执行成功后单独处理
+func (t *tokenServer) tryRemoveParentToken(ctx context.Context, pToken string, grace time.Duration) (bool, error) {
+	expireListRaw, uidsRaw, err := t.parentToken.getRawLists(ctx, pToken)
+	if errors.Is(err, redis.Nil) {
+		return false, fmt.Errorf("token.removeParentToken: parent token empty, err: %w", redis.Nil)
+	}
 	if err != nil {
-		return err
+		return false, fmt.Errorf("token.removeParentToken: get sub tokens failed, err:%w", err)
 	}
+	var expireList UserTokenExpires
+	if expireListRaw != "" {
+		if err = expireList.Unmarshal([]byte(expireListRaw)); err != nil {
+			return false, fmt.Errorf("token.removeParentToken: unmarshal sub tokens failed, err:%w", err)
+		}
+	}
+	var uids UidsStore
+	if uidsRaw != "" {
+		if err = uids.Unmarshal([]byte(uidsRaw)); err != nil {
+			return false, fmt.Errorf("token.removeParentToken: unmarshal uids failed, err:%w", err)
+		}
+	}
+
+	keys := []string{t.parentToken.getKey(pToken)}
+	var strayTokens []string
+	for _, value := range expireList {
+		subToken, err := t.parentToken.getSubTokenByExpireTimeListField(value.Field)
+		if err != nil {
+			continue
+		}
+		if t.config.layout.colocatedSubToken(subToken, pToken) {
+			keys = append(keys, t.subToken.getKey(subToken))
+		} else {
+			strayTokens = append(strayTokens, subToken)
+		}
+	}
+	subKeys := len(keys) - 1
+	var strayUids []int64
 	for _, uid := range uids {
-		_ = t.uidMapParentToken.removeParentToken(ctx, uid, pToken)
+		if t.config.layout.colocated(uid, pToken) {
+			keys = append(keys, t.uidMapParentToken.getKey(uid))
+		} else {
+			strayUids = append(strayUids, uid)
+		}
+	}
+
+	res, err := removeParentTokenScript.Run(ctx, t.redis.Client(), keys,
+		expireListRaw,
+		uidsRaw,
+		t.uidMapParentToken.getFieldKey(pToken),
+		int64(grace.Seconds()),
+		subKeys,
+	).Int64Slice()
+	if errors.Is(err, redis.Nil) {
+		return false, fmt.Errorf("token.removeParentToken: parent token empty, err: %w", err)
+	}
+	if err != nil {
+		return false, fmt.Errorf("token.removeParentToken: remove token failed, err:%w", err)
+	}
+	if res[0] == 0 {
+		return false, nil
+	}
+	for _, subToken := range strayTokens {
+		if err = t.expireSubTokenKey(ctx, subToken, grace); err != nil {
+			return false, fmt.Errorf("token.removeParentToken: remove sub token failed, err:%w", err)
+		}
+	}
+	for _, uid := range strayUids {
+		err = removeUserParentTokenScript.Run(ctx, t.redis.Client(), []string{
+			t.uidMapParentToken.getKey(uid),
+		}, t.uidMapParentToken.getFieldKey(pToken)).Err()
+		if err != nil {
+			return false, fmt.Errorf("token.removeParentToken: remove user token failed, err:%w", err)
+		}
 	}
+	return true, nil
+}
+
+// removeUserParentToken 删除用户的parent token以及下面所有的sub token；parent token已经过期时，只清理用户里的数据
+func (t *tokenServer) removeUserParentToken(ctx context.Context, uid int64, pToken string, grace time.Duration) error {
+	err := t.removeParentTokenWithGrace(ctx, pToken, grace)
+	if errors.Is(err, redis.Nil) {
+		err = removeUserParentTokenScript.Run(ctx, t.redis.Client(), []string{
+			t.uidMapParentToken.getKey(uid),
+		}, t.uidMapParentToken.getFieldKey(pToken)).Err()
+	}
+	return err
+}
 
-	return t.parentToken.remove(ctx, pToken)
+// removeParentTokenUid 多账号下从parent token里移除一个账号，同时吊销属于该账号的sub token
+// 移除的是最后一个账号时，删除整个parent token
+func (t *tokenServer) removeParentTokenUid(ctx context.Context, pToken string, uid int64) error {
+	remaining, err := removeParentTokenUidScript.Run(ctx, t.redis.Client(), []string{
+		t.parentToken.getKey(pToken),
+	}, uid, t.parentToken.getUserField(uid)).Int()
+	if err != nil {
+		return fmt.Errorf("tokenServer.removeParentTokenUid failed, err: %w", err)
+	}
+	switch remaining {
+	case -1:
+		return fmt.Errorf("tokenServer.removeParentTokenUid uid: %d, err: %w", uid, ErrUidNotInParentToken)
+	case 0:
+		return t.removeParentToken(ctx, pToken)
+	}
+
+	expireTimeList, err := t.parentToken.getExpireTimeList(ctx, pToken)
+	if err != nil {
+		return fmt.Errorf("tokenServer.removeParentTokenUid get sub tokens failed, err: %w", err)
+	}
+	for _, value := range expireTimeList {
+		subToken, err := t.parentToken.getSubTokenByExpireTimeListField(value.Field)
+		if err != nil {
+			continue
+		}
+		tokenUid, err := t.subToken.getUid(ctx, subToken)
+		if err != nil || tokenUid != uid {
+			continue
+		}
+		if err = t.removeSubToken(ctx, pToken, subToken, 0); err != nil {
+			return fmt.Errorf("tokenServer.removeParentTokenUid remove sub token failed, err: %w", err)
+		}
+	}
+	err = removeUserParentTokenScript.Run(ctx, t.redis.Client(), []string{
+		t.uidMapParentToken.getKey(uid),
+	}, t.uidMapParentToken.getFieldKey(pToken)).Err()
+	if err != nil {
+		return fmt.Errorf("tokenServer.removeParentTokenUid remove user token failed, err: %w", err)
+	}
+	return nil
+}
+
+// setTokenUid 多账号下切换sub token所属的账号，账号必须在parent token里登录过
+func (t *tokenServer) setTokenUid(ctx context.Context, token string, uid int64) error {
+	uids, err := t.getUidsByToken(ctx, token)
+	if err != nil {
+		return fmt.Errorf("tokenServer.setTokenUid failed, err: %w", err)
+	}
+	for _, value := range uids {
+		if value == uid {
+			return t.subToken.setUid(ctx, token, uid)
+		}
+	}
+	return fmt.Errorf("tokenServer.setTokenUid uid: %d, err: %w", uid, ErrUidNotInParentToken)
+}
+
+// getTokenUid 获得sub token所属的账号，没有指定账号时返回parent token里的第一个账号
+func (t *tokenServer) getTokenUid(ctx context.Context, token string) (uid int64, err error) {
+	uid, err = t.subToken.getUid(ctx, token)
+	if err != nil || uid != 0 {
+		return uid, err
+	}
+	uids, err := t.getUidsByToken(ctx, token)
+	if err != nil {
+		return 0, err
+	}
+	if len(uids) == 0 {
+		return 0, fmt.Errorf("tokenServer.getTokenUid uids empty, err: %w", redis.Nil)
+	}
+	return uids[0], nil
 }
 
 func (t *tokenServer) getUidsByParentToken(ctx context.Context, pToken string) (uids []int64, err error) {
@@ -0,0 +1,78 @@
+package ssostorage
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/ego-component/eoauth2/storage/tokenhash"
+)
+
+// tokenKey 新下发的token在redis里使用的key，开启token hash时为token的hash
+// Redis Cluster key布局下保留token的hash tag，hash后的token和parent token、用户仍然在同一个slot
+func (c *config) tokenKey(token string) string {
+	if c.hasher == nil {
+		return token
+	}
+	if index := strings.Index(token, "."); index > 0 {
+		return token[:index+1] + c.hasher.Key(token)
+	}
+	return c.hasher.Key(token)
+}
+
+// tokenLookups 客户端传入的token查询时使用的key，开启legacyLookup时会同时查询明文
+func (c *config) tokenLookups(token string) []string {
+	if c.hasher == nil {
+		return []string{token}
+	}
+	keys := make([]string, 0, 2)
+	for _, key := range c.hasher.Lookups(token) {
+		if key != token {
+			key = c.tokenKey(token)
+		}
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// tokenRefs 存储内部引用的token使用的key，已经是hash的直接使用
+func (c *config) tokenRefs(token string) []string {
+	if tokenhash.IsKey(token) {
+		return []string{token}
+	}
+	return c.tokenLookups(token)
+}
+
+// resolveToken 从多个候选key里找到存在的key，都不存在时返回第一个
+func (t *tokenServer) resolveToken(ctx context.Context, candidates []string, keyOf func(token string) string) (token string, exists bool, err error) {
+	for _, candidate := range candidates {
+		cnt, err := t.redis.Client().Exists(ctx, keyOf(candidate)).Result()
+		if err != nil {
+			return "", false, fmt.Errorf("tokenServer.resolveToken failed, err: %w", err)
+		}
+		if cnt == 1 {
+			return candidate, true, nil
+		}
+	}
+	return candidates[0], false, nil
+}
+
+// lookupParentToken 业务传入的parent token明文在redis里的key，只有一个候选key时不需要查询redis
+func (t *tokenServer) lookupParentToken(ctx context.Context, pToken string) (string, error) {
+	candidates := t.config.tokenLookups(pToken)
+	if len(candidates) == 1 {
+		return candidates[0], nil
+	}
+	pToken, _, err := t.resolveToken(ctx, candidates, t.parentToken.getKey)
+	return pToken, err
+}
+
+// lookupSubToken 业务传入的sub token明文在redis里的key，只有一个候选key时不需要查询redis
+func (t *tokenServer) lookupSubToken(ctx context.Context, subToken string) (string, error) {
+	candidates := t.config.tokenLookups(subToken)
+	if len(candidates) == 1 {
+		return candidates[0], nil
+	}
+	subToken, _, err := t.resolveToken(ctx, candidates, t.subToken.getKey)
+	return subToken, err
+}
@@ -6,7 +6,6 @@ import (
 	"fmt"
 	"strconv"
 	"strings"
-	"time"
 
 	"github.com/ego-component/eoauth2/server/model"
 	"github.com/ego-component/eredis"
@@ -48,6 +47,7 @@ type parentToken struct {
 	config              *config
 	redis               *eredis.Component
 	fieldCtime          string
+	fieldExpiresIn      string
 	fieldUids           string
 	fieldExpireTimeList string
 	fieldUser           string
@@ -59,6 +59,7 @@ func newParentToken(config *config, redis *eredis.Component) *parentToken {
 		config:              config,
 		redis:               redis,
 		fieldCtime:          "_ct",  // create time
+		fieldExpiresIn:      "_ex",  // 续期时长(s)
 		fieldUids:           "_u",   // uids list
 		fieldExpireTimeList: "_etl", // expire time List
 		fieldClient:         "_c:",  // ClientInfo 存储的sub token
@@ -68,7 +69,7 @@ func newParentToken(config *config, redis *eredis.Component) *parentToken {
 }
 
 func (p *parentToken) getKey(pToken string) string {
-	return fmt.Sprintf(p.config.parentTokenMapSubTokenKey, pToken)
+	return p.config.layout.parentTokenKey(pToken)
 }
 
 func (p *parentToken) getUserField(uid int64) string {
@@ -79,76 +80,6 @@ func (p *parentToken) getClientField(subToken string) string {
 	return p.fieldClient + subToken
 }
 
-func (p *parentToken) create(ctx context.Context, ssoData model.ParentToken) error {
-	// 如果没有开启多账号，那么就是单账号，直接set
-	if !p.config.enableMultipleAccounts {
-		uids := UidsStore{ssoData.Uid}
-		uids.Marshal()
-		err := p.redis.HMSet(ctx, p.getKey(ssoData.Token.Token), map[string]interface{}{
-			p.fieldCtime:                time.Now().Unix(),
-			p.fieldUids:                 uids.Marshal(),
-			p.getUserField(ssoData.Uid): ssoData.StoreData.Marshal(),
-		}, time.Duration(ssoData.Token.ExpiresIn)*time.Second)
-		if err != nil {
-			return fmt.Errorf("parentToken.create failed, err:%w", err)
-		}
-		return nil
-	}
-
-	uids, err := p.getUids(ctx, ssoData.Token.Token)
-	// 系统错误
-	if err != nil && !errors.Is(err, redis.Nil) {
-		return fmt.Errorf("parentToken.create get key empty, err: %w", err)
-	}
-
-	// 如果不存在，那么直接set，创建
-	if errors.Is(err, redis.Nil) {
-		uids = UidsStore{ssoData.Uid}
-		uids.Marshal()
-		err = p.redis.HMSet(ctx, p.getKey(ssoData.Token.Token), map[string]interface{}{
-			p.fieldCtime:                time.Now().Unix(),
-			p.fieldUids:                 uids.Marshal(),
-			p.getUserField(ssoData.Uid): ssoData.StoreData.Marshal(),
-		}, time.Duration(ssoData.Token.ExpiresIn)*time.Second)
-		if err != nil {
-			return fmt.Errorf("parentToken.create failed, err:%w", err)
-		}
-		return nil
-	}
-	// 如果存在，那么需要取出之前数据，重新写入新的uid信息
-	uids = append(uids, ssoData.Uid)
-	err = p.redis.HMSet(ctx, p.getKey(ssoData.Token.Token), map[string]interface{}{
-		p.fieldUids:                 uids.Marshal(),
-		p.getUserField(ssoData.Uid): ssoData.StoreData.Marshal(),
-	}, time.Duration(ssoData.Token.ExpiresIn)*time.Second)
-	if err != nil {
-		return fmt.Errorf("parentToken.create failed, err:%w", err)
-	}
-	return nil
-}
-
-func (p *parentToken) removeSubToken(ctx context.Context, pToken string, subToken string) error {
-	_ = p.redis.HDel(ctx, p.getKey(pToken), p.getClientField(subToken))
-	expireTimeList, err := p.getExpireTimeList(ctx, pToken)
-	if err != nil {
-		return err
-	}
-
-	newExpireTimeList := make(UserTokenExpires, 0)
-	// 删除不要的数据
-	for _, value := range expireTimeList {
-		if value.Field == p.getClientField(subToken) {
-			continue
-		}
-		newExpireTimeList = append(newExpireTimeList, value)
-	}
-	err = p.redis.HSet(ctx, p.getKey(pToken), p.fieldExpireTimeList, newExpireTimeList.Marshal())
-	if err != nil {
-		return fmt.Errorf("parentToken removeSubToken failed, error: %w", err)
-	}
-	return nil
-}
-
 // remove，退出的时候，必须del，避免有安全漏洞
 func (p *parentToken) remove(ctx context.Context, pToken string) error {
 	_, err := p.redis.Del(ctx, p.getKey(pToken))
@@ -158,59 +89,6 @@ func (p *parentToken) remove(ctx context.Context, pToken string) error {
 	return nil
 }
 
-func (p *parentToken) setToken(ctx context.Context, pToken string, token model.Token) error {
-	expireTimeList, err := p.getExpireTimeList(ctx, pToken)
-	if err != nil {
-		return err
-	}
-	// 因为authorize阶段创建了parent token，所以如果不存在parent token key是有问题的，需要报错
-	_, err = p.redis.HGet(ctx, p.getKey(pToken), p.fieldCtime)
-	if err != nil {
-		return fmt.Errorf("parentToken.createToken get key empty, err: %w", err)
-	}
-
-	nowTime := time.Now().Unix()
-	newExpireTimeList := make(UserTokenExpires, 0)
-	// 新数据添加到队列前面，这样方便后续清除数据，或者对数据做一些限制
-	newExpireTimeList = append(newExpireTimeList, UserTokenExpire{
-		Field:      p.getClientField(token.Token),
-		ExpireTime: nowTime + token.ExpiresIn,
-	})
-
-	// 删除过期的数据
-	hdelFields := make([]string, 0)
-	for _, value := range expireTimeList {
-		// 过期时间小于当前时间，那么需要删除
-		if value.ExpireTime <= nowTime {
-			hdelFields = append(hdelFields, value.Field)
-			continue
-		}
-		newExpireTimeList = append(newExpireTimeList, value)
-	}
-	if len(hdelFields) > 0 {
-		err = p.redis.HDel(ctx, p.getKey(pToken), hdelFields...)
-		if err != nil {
-			return fmt.Errorf("userToken setToken HDel expire data failed, error: %w", err)
-		}
-	}
-
-	err = p.redis.HSet(ctx, p.getKey(pToken), p.fieldExpireTimeList, newExpireTimeList.Marshal())
-	if err != nil {
-		return fmt.Errorf("userToken setToken HSet expire time failed, error: %w", err)
-	}
-
-	tokenJsonInfo, err := token.Marshal()
-	if err != nil {
-		return fmt.Errorf("parentToken.createToken json marshal failed, err: %w", err)
-	}
-
-	err = p.redis.HSet(ctx, p.getKey(pToken), p.getClientField(token.Token), tokenJsonInfo)
-	if err != nil {
-		return fmt.Errorf("parentToken.createToken hset failed, err:%w", err)
-	}
-	return nil
-}
-
 func (p *parentToken) getUids(ctx context.Context, pToken string) (uids UidsStore, err error) {
 	uidBytes, err := p.redis.Client().HGet(ctx, p.getKey(pToken), p.fieldUids).Bytes()
 	// 系统错误
@@ -227,6 +105,22 @@ func (p *parentToken) getUids(ctx context.Context, pToken string) (uids UidsStor
 	return
 }
 
+// getRawLists 获取未解码的 _etl、_u，parent token不存在返回 redis.Nil，字段不存在时为空字符串
+func (p *parentToken) getRawLists(ctx context.Context, pToken string) (expireList string, uids string, err error) {
+	values, err := p.redis.Client().HMGet(ctx, p.getKey(pToken), p.fieldCtime, p.fieldExpireTimeList, p.fieldUids).Result()
+	if err != nil {
+		err = fmt.Errorf("parentToken getRawLists failed, err: %w", err)
+		return
+	}
+	if values[0] == nil {
+		err = fmt.Errorf("parentToken getRawLists failed, err: %w", redis.Nil)
+		return
+	}
+	expireList, _ = values[1].(string)
+	uids, _ = values[2].(string)
+	return
+}
+
 // 获取过期时间，最新的在最前面。
 func (p *parentToken) getExpireTimeList(ctx context.Context, pToken string) (uidTokenInfo UserTokenExpires, err error) {
 	// 根据父节点token，获取用户信息
@@ -253,7 +147,8 @@ func (p *parentToken) getSubTokenByExpireTimeListField(field string) (subToken s
 	if !strings.HasPrefix(field, p.fieldClient) {
 		return "", fmt.Errorf("parentToken getSubTokenByExpireTimeListField failed,err: %w", fmt.Errorf("not field"))
 	}
-	arr := strings.Split(field, ":")
+	// token hash里包含 ":"，只按照第一个 ":" 拆分
+	arr := strings.SplitN(field, ":", 2)
 	if len(arr) != 2 {
 		return "", fmt.Errorf("parentToken getSubTokenByExpireTimeListField failed,err: %w", fmt.Errorf("length error"))
 	}
@@ -309,7 +204,7 @@ func (p *parentToken) processData(store *ParentTokenStore, key string, value str
 		}
 		store.Users[uid] = data
 	case strings.HasPrefix(key, p.fieldClient):
-		arr := strings.Split(key, ":")
+		arr := strings.SplitN(key, ":", 2)
 		if len(arr) != 2 {
 			return
 		}
@@ -2,11 +2,13 @@ package ssostorage
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"time"
 
+	"github.com/ego-component/eoauth2/server"
 	"github.com/ego-component/eoauth2/server/model"
 	"github.com/ego-component/eredis"
+	"github.com/go-redis/redis/v8"
 	"github.com/spf13/cast"
 )
 
@@ -18,6 +20,7 @@ type subToken struct {
 	fieldTokenInfo   string
 	fieldCtime       string
 	fieldAccessInfo  string
+	fieldUid         string
 }
 
 func newSubToken(config *config, redis *eredis.Component) *subToken {
@@ -28,30 +31,25 @@ func newSubToken(config *config, redis *eredis.Component) *subToken {
 		fieldClientId:    "_id",
 		fieldTokenInfo:   "_t",
 		fieldAccessInfo:  "_a",
+		fieldUid:         "_uid", // 多账号下token所属的账号
 		redis:            redis,
 	}
 }
 
 func (s *subToken) getKey(subToken string) string {
-	return fmt.Sprintf(s.config.subTokenMapParentTokenKey, subToken)
-}
-
-func (s *subToken) create(ctx context.Context, token model.SubToken, parentToken string, clientId string, accessData *AccessData) error {
-	err := s.redis.HMSet(ctx, s.getKey(token.Token.Token), map[string]interface{}{
-		s.fieldParentToken: parentToken,
-		s.fieldClientId:    clientId,
-		s.fieldCtime:       time.Now().Unix(),
-		s.fieldAccessInfo:  accessData.Marshal(),
-		s.fieldTokenInfo:   token.StoreData.Marshal(),
-	}, time.Duration(token.Token.ExpiresIn)*time.Second)
-	if err != nil {
-		return fmt.Errorf("subToken.create token failed, err:%w", err)
-	}
-	return nil
+	return s.config.layout.subTokenKey(subToken)
 }
 
 func (s *subToken) getAccess(ctx context.Context, token string) (storeData *AccessData, err error) {
 	infoBytes, err := s.redis.Client().HGet(ctx, s.getKey(token), s.fieldAccessInfo).Bytes()
+	if errors.Is(err, redis.Nil) {
+		err = fmt.Errorf("subToken getAccess failed, err: %w", server.ErrNotFound)
+		return
+	}
+	if err != nil {
+		err = fmt.Errorf("subToken getAccess failed, err: %w", err)
+		return
+	}
 	info := &AccessData{}
 	err = info.Unmarshal(infoBytes)
 	if err != nil {
@@ -61,9 +59,49 @@ func (s *subToken) getAccess(ctx context.Context, token string) (storeData *Acce
 	return info, nil
 }
 
-// remove 触发这个场景是refresh token操作，给30s时间，避免换token的时间差，prev token过早失效导致的业务问题
-func (s *subToken) remove(ctx context.Context, token string) (bool, error) {
-	return s.redis.Expire(ctx, s.getKey(token), 30*time.Second)
+// scan 遍历所有的sub token，Redis Cluster会遍历所有master节点
+func (s *subToken) scan(ctx context.Context, fn func(tokens []string) error) error {
+	return scanKeys(ctx, s.redis, s.config.layout.subTokenScanPattern(), func(keys []string) error {
+		tokens := make([]string, 0, len(keys))
+		for _, key := range keys {
+			tokens = append(tokens, s.config.layout.subTokenFromKey(key))
+		}
+		return fn(tokens)
+	})
+}
+
+// getClientId 获得sub token所属的客户端
+func (s *subToken) getClientId(ctx context.Context, token string) (clientId string, err error) {
+	clientId, err = s.redis.HGet(ctx, s.getKey(token), s.fieldClientId)
+	if err != nil {
+		err = fmt.Errorf("subToken.getClientId failed, %w", err)
+		return
+	}
+	return
+}
+
+// getUid 获得多账号下sub token所属的账号，没有指定账号返回0
+func (s *subToken) getUid(ctx context.Context, token string) (uid int64, err error) {
+	uid, err = s.redis.Client().HGet(ctx, s.getKey(token), s.fieldUid).Int64()
+	if errors.Is(err, redis.Nil) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("subToken.getUid failed, %w", err)
+	}
+	return uid, nil
+}
+
+// setUid 设置多账号下sub token所属的账号，sub token不存在返回 redis.Nil
+func (s *subToken) setUid(ctx context.Context, token string, uid int64) error {
+	updated, err := setSubTokenUidScript.Run(ctx, s.redis.Client(), []string{s.getKey(token)}, s.fieldUid, uid).Int()
+	if err != nil {
+		return fmt.Errorf("subToken.setUid failed, %w", err)
+	}
+	if updated == 0 {
+		return fmt.Errorf("subToken.setUid failed, %w", redis.Nil)
+	}
+	return nil
 }
 
 // 通过子系统token，获得父节点token
@@ -83,6 +121,7 @@ type SubTokenStore struct {
 	ClientId    string              `json:"clientId"`
 	TokenInfo   *model.SubTokenData `json:"tokenInfo"`
 	AccessInfo  *AccessData         `json:"accessInfo"`
+	Uid         int64               `json:"uid"` // 多账号下token所属的账号，0表示没有指定
 	TTL         int64               `json:"ttl"`
 }
 
@@ -98,6 +137,8 @@ func (p *subToken) processData(store *SubTokenStore, key string, value string) {
 		store.TokenInfo.Unmarshal([]byte(value))
 	case key == p.fieldAccessInfo:
 		store.AccessInfo.Unmarshal([]byte(value))
+	case key == p.fieldUid:
+		store.Uid = cast.ToInt64(value)
 	}
 }
 
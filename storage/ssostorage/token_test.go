@@ -0,0 +1,371 @@
+package ssostorage
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/ego-component/eoauth2/server"
+	"github.com/ego-component/eoauth2/server/model"
+	"github.com/go-redis/redis/v8"
+)
+
+// newAuthorize 生成登录的authorize信息
+func newAuthorize(t *testing.T, c *Component, uid int64, pToken model.Token) *server.AuthorizeData {
+	t.Helper()
+	client, err := c.GetStorage().GetClient(context.Background(), "storagetest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &server.AuthorizeData{
+		Client:               client,
+		Code:                 model.NewToken(0).Token,
+		ExpiresIn:            600,
+		ParentTokenExpiresIn: pToken.ExpiresIn,
+		RedirectUri:          client.GetRedirectUri(),
+		CreatedAt:            time.Now(),
+		SsoData: model.ParentToken{
+			Token:     pToken,
+			Uid:       uid,
+			StoreData: model.ParentTokenData{Ctime: time.Now().Unix(), Platform: "web"},
+		},
+	}
+}
+
+// login 登录并下发一个sub token
+func login(t *testing.T, c *Component, uid int64, pToken model.Token) *server.AccessData {
+	t.Helper()
+	loaded, err := c.GetStorage().LoadAuthorize(context.Background(), saveAuthorize(t, c, uid, pToken))
+	if err != nil {
+		t.Fatalf("LoadAuthorize failed, err: %v", err)
+	}
+	return issueToken(t, c, loaded, nil)
+}
+
+// saveAuthorize 保存authorize信息，返回code
+func saveAuthorize(t *testing.T, c *Component, uid int64, pToken model.Token) string {
+	t.Helper()
+	authorize := newAuthorize(t, c, uid, pToken)
+	if err := c.GetStorage().SaveAuthorize(context.Background(), authorize); err != nil {
+		t.Fatalf("SaveAuthorize failed, err: %v", err)
+	}
+	return authorize.Code
+}
+
+// issueToken 根据code或者之前的token下发新的sub token
+func issueToken(t *testing.T, c *Component, authorize *server.AuthorizeData, prev *server.AccessData) *server.AccessData {
+	t.Helper()
+	return issueTokenExpiresIn(t, c, authorize, prev, 3600)
+}
+
+// issueTokenExpiresIn 下发指定有效期的sub token
+func issueTokenExpiresIn(t *testing.T, c *Component, authorize *server.AuthorizeData, prev *server.AccessData, expiresIn int64) *server.AccessData {
+	t.Helper()
+	subToken := model.NewToken(expiresIn)
+	access := &server.AccessData{
+		AuthorizeData:  authorize,
+		AccessData:     prev,
+		AccessToken:    subToken.Token,
+		TokenExpiresIn: subToken.ExpiresIn,
+		CreatedAt:      time.Now(),
+		TokenData:      model.SubToken{Token: subToken},
+	}
+	if authorize != nil {
+		access.Client = authorize.Client
+		access.RedirectUri = authorize.RedirectUri
+	} else {
+		access.Client = prev.Client
+		access.RedirectUri = prev.RedirectUri
+	}
+	if err := c.GetStorage().SaveAccess(context.Background(), access); err != nil {
+		t.Fatalf("SaveAccess failed, err: %v", err)
+	}
+	return access
+}
+
+// storedParentToken access token在redis里对应的parent token
+func storedParentToken(t *testing.T, c *Component, access *server.AccessData) string {
+	t.Helper()
+	ctx := context.Background()
+	subToken, err := c.tokenServer.lookupSubToken(ctx, access.AccessToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pToken, err := c.tokenServer.getParentTokenByToken(ctx, subToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pToken
+}
+
+// userExpireTime uid下parent token的过期时间，不存在返回0
+func userExpireTime(t *testing.T, c *Component, uid int64, pToken string) int64 {
+	t.Helper()
+	list, err := c.tokenServer.uidMapParentToken.getExpireTimeList(context.Background(), uid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, value := range list {
+		if value.Field == c.tokenServer.uidMapParentToken.getFieldKey(pToken) {
+			return value.ExpireTime
+		}
+	}
+	return 0
+}
+
+func TestParentTokenSliding(t *testing.T) {
+	layouts := map[string][]Option{
+		"legacy":  nil,
+		"cluster": {WithClusterKeyLayout("oauth2")},
+	}
+	for name, options := range layouts {
+		options := options
+		t.Run(name, func(t *testing.T) {
+			c, mr := newTestComponent(t, append(options, WithParentTokenSliding(true), WithParentTokenMaxLifetime(150*time.Second))...)
+			ctx := context.Background()
+			first := login(t, c, 1, model.NewToken(100))
+			second := login(t, c, 1, model.NewToken(100))
+			pToken := storedParentToken(t, c, first)
+			otherToken := storedParentToken(t, c, second)
+			otherExpireTime := userExpireTime(t, c, 1, otherToken)
+
+			mr.FastForward(60 * time.Second)
+			before := time.Now().Unix()
+			issueToken(t, c, nil, first)
+			// 下发sub token时续期parent token和用户下的过期时间
+			if ttl := mr.TTL(c.config.layout.parentTokenKey(pToken)); ttl < 99*time.Second {
+				t.Errorf("parent token ttl after sliding renew, want ~100s, got: %v", ttl)
+			}
+			if expireTime := userExpireTime(t, c, 1, pToken); expireTime < before+100 {
+				t.Errorf("user expire time after sliding renew, want >= %d, got: %d", before+100, expireTime)
+			}
+			// 同一个用户的其他会话不受影响
+			if expireTime := userExpireTime(t, c, 1, otherToken); expireTime != otherExpireTime {
+				t.Errorf("other session expire time changed, want: %d, got: %d", otherExpireTime, expireTime)
+			}
+
+			// 续期不能超过最大会话时长
+			expireAt, err := c.tokenServer.renewParentToken(ctx, pToken, 1000)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if expireAt > before+150 {
+				t.Errorf("renew beyond max lifetime, want <= %d, got: %d", before+150, expireAt)
+			}
+			if expireTime := userExpireTime(t, c, 1, pToken); expireTime != expireAt {
+				t.Errorf("user expire time after renew, want: %d, got: %d", expireAt, expireTime)
+			}
+		})
+	}
+}
+
+// TestParentTokenSlidingMultipleAccounts Redis Cluster key布局下，多账号的uid key和parent token不在同一个slot，单独续期
+func TestParentTokenSlidingMultipleAccounts(t *testing.T) {
+	c, mr := newTestComponent(t, WithClusterKeyLayout("oauth2"), WithEnableMultipleAccounts(true), WithParentTokenSliding(true))
+	first := login(t, c, 1, model.NewToken(100))
+	pToken := storedParentToken(t, c, first)
+	second := login(t, c, 2, model.Token{Token: pToken, AuthAt: time.Now().Unix(), ExpiresIn: 100})
+	if storedParentToken(t, c, second) != pToken {
+		t.Fatalf("second account should reuse parent token")
+	}
+	if c.config.layout.colocated(2, pToken) {
+		t.Skip("uid 2 is colocated with the parent token")
+	}
+
+	mr.FastForward(60 * time.Second)
+	before := time.Now().Unix()
+	issueToken(t, c, nil, first)
+	for _, uid := range []int64{1, 2} {
+		if expireTime := userExpireTime(t, c, uid, pToken); expireTime < before+100 {
+			t.Errorf("uid %d expire time after sliding renew, want >= %d, got: %d", uid, before+100, expireTime)
+		}
+	}
+}
+
+// TestParentTokenMaxLifetimeMultipleAccounts 多账号复用已有的parent token登录，过期时间不能超过最大会话时长
+func TestParentTokenMaxLifetimeMultipleAccounts(t *testing.T) {
+	layouts := map[string][]Option{
+		"legacy":  nil,
+		"cluster": {WithClusterKeyLayout("oauth2")},
+	}
+	for name, options := range layouts {
+		options := options
+		t.Run(name, func(t *testing.T) {
+			c, mr := newTestComponent(t, append(options, WithEnableMultipleAccounts(true), WithParentTokenMaxLifetime(150*time.Second))...)
+			first := login(t, c, 1, model.NewToken(100))
+			pToken := storedParentToken(t, c, first)
+			pKey := c.config.layout.parentTokenKey(pToken)
+			// 会话已经登录了120s，还剩30s过期
+			mr.HSet(pKey, "_ct", strconv.FormatInt(time.Now().Unix()-120, 10))
+			mr.SetTTL(pKey, 30*time.Second)
+			// cluster布局下使用和parent token不在同一个slot的uid
+			uid := int64(2)
+			for name == "cluster" && c.config.layout.colocated(uid, pToken) {
+				uid++
+			}
+
+			before := time.Now().Unix()
+			login(t, c, uid, model.Token{Token: pToken, AuthAt: time.Now().Unix(), ExpiresIn: 100})
+			if ttl := mr.TTL(pKey); ttl > 31*time.Second || ttl < 28*time.Second {
+				t.Errorf("parent token ttl after re-login, want ~30s capped by max lifetime, got: %v", ttl)
+			}
+			if expireTime := userExpireTime(t, c, uid, pToken); expireTime > before+31 {
+				t.Errorf("uid %d expire time after re-login, want <= %d, got: %d", uid, before+31, expireTime)
+			}
+		})
+	}
+}
+
+// TestRemoveParentTokenMultipleAccounts 退出登录时同一个slot的key在脚本里处理，其他slot的uid key单独处理
+func TestRemoveParentTokenMultipleAccounts(t *testing.T) {
+	c, mr := newTestComponent(t, WithClusterKeyLayout("oauth2"), WithEnableMultipleAccounts(true))
+	ctx := context.Background()
+	first := login(t, c, 1, model.NewToken(100))
+	pToken := storedParentToken(t, c, first)
+	// 找一个和parent token不在同一个slot的uid
+	uid := int64(2)
+	for c.config.layout.colocated(uid, pToken) {
+		uid++
+	}
+	second := login(t, c, uid, model.Token{Token: pToken, AuthAt: time.Now().Unix(), ExpiresIn: 100})
+
+	if err := c.tokenServer.removeParentToken(ctx, pToken); err != nil {
+		t.Fatal(err)
+	}
+	if mr.Exists(c.config.layout.parentTokenKey(pToken)) {
+		t.Errorf("parent token should be removed")
+	}
+	for _, id := range []int64{1, uid} {
+		if expireTime := userExpireTime(t, c, id, pToken); expireTime != 0 {
+			t.Errorf("uid %d should not have parent token, got expire time: %d", id, expireTime)
+		}
+	}
+	for _, access := range []string{first.AccessToken, second.AccessToken} {
+		subToken, err := c.tokenServer.lookupSubToken(ctx, access)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ttl := mr.TTL(c.config.layout.subTokenKey(subToken)); ttl <= 0 || ttl > subTokenRemoveGrace {
+			t.Errorf("sub token ttl after remove, want <= %v, got: %v", subTokenRemoveGrace, ttl)
+		}
+	}
+	if err := c.tokenServer.removeParentToken(ctx, pToken); !errors.Is(err, redis.Nil) {
+		t.Errorf("remove again, want redis.Nil, got: %v", err)
+	}
+}
+
+func TestSessionPolicyEvict(t *testing.T) {
+	c, mr := newTestComponent(t, WithSessionPolicy(SessionPolicy{MaxSessions: 2, MaxSessionsPerPlatform: map[string]int{"web": 1}}))
+	first := login(t, c, 1, model.NewToken(100))
+	firstToken := storedParentToken(t, c, first)
+	second := login(t, c, 1, model.NewToken(100))
+	secondToken := storedParentToken(t, c, second)
+
+	// web只能有一个会话，踢掉最早登录的会话
+	if mr.Exists(c.config.layout.parentTokenKey(firstToken)) {
+		t.Errorf("evicted parent token should be removed")
+	}
+	if expireTime := userExpireTime(t, c, 1, firstToken); expireTime != 0 {
+		t.Errorf("evicted session should be removed from user, got expire time: %d", expireTime)
+	}
+	if expireTime := userExpireTime(t, c, 1, secondToken); expireTime == 0 {
+		t.Errorf("new session should be saved")
+	}
+}
+
+func TestSessionPolicyReject(t *testing.T) {
+	c, mr := newTestComponent(t, WithSessionPolicy(SessionPolicy{MaxSessions: 1, Action: SessionLimitReject}))
+	first := login(t, c, 1, model.NewToken(100))
+	firstToken := storedParentToken(t, c, first)
+
+	authorize := newAuthorize(t, c, 1, model.NewToken(100))
+	err := c.GetStorage().SaveAuthorize(context.Background(), authorize)
+	if !errors.Is(err, server.ErrSessionLimitExceeded) {
+		t.Fatalf("SaveAuthorize over limit, want ErrSessionLimitExceeded, got: %v", err)
+	}
+	// 拒绝登录时不会留下code和新的会话
+	if mr.Exists(c.config.layout.authorizeKey(authorize.Code)) {
+		t.Errorf("rejected login should not save code")
+	}
+	if mr.Exists(c.config.layout.parentTokenKey(authorize.SsoData.Token.Token)) {
+		t.Errorf("rejected login should not save parent token")
+	}
+	list, err := c.tokenServer.uidMapParentToken.getExpireTimeList(context.Background(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 1 || list[0].Field != c.tokenServer.uidMapParentToken.getFieldKey(firstToken) {
+		t.Errorf("user sessions after reject, want only the first session, got: %+v", list)
+	}
+
+	// 已有会话的账号再次登录不是新的会话
+	login(t, c, 1, model.Token{Token: firstToken, AuthAt: time.Now().Unix(), ExpiresIn: 100})
+}
+
+// TestSessionPolicyRejectBuild 通过server登录超过会话个数限制，Build返回的错误可以用 errors.As 和 errors.Is 判断
+func TestSessionPolicyRejectBuild(t *testing.T) {
+	c, _ := newTestComponent(t, WithSessionPolicy(SessionPolicy{MaxSessions: 1, Action: SessionLimitReject}))
+	srv := server.DefaultContainer().Build(server.WithStorage(c.GetStorage()))
+	authorize := func() error {
+		ar := srv.HandleAuthorizeRequest(context.Background(), server.AuthorizeRequestParam{
+			ClientId:     "storagetest",
+			RedirectUri:  "http://localhost/callback",
+			ResponseType: string(server.CODE),
+		})
+		if ar.IsError() {
+			t.Fatalf("authorize request failed, err: %v", ar.GetError())
+		}
+		return ar.Build(server.WithAuthorizeRequestAuthorized(true), server.WithAuthorizeSsoUid(1), server.WithAuthorizeSsoPlatform("web"))
+	}
+	if err := authorize(); err != nil {
+		t.Fatalf("first login failed, err: %v", err)
+	}
+
+	err := authorize()
+	var oauthErr *server.Error
+	if !errors.As(err, &oauthErr) {
+		t.Fatalf("rejected login, want *server.Error, got: %v", err)
+	}
+	if oauthErr.Code != server.E_ACCESS_DENIED {
+		t.Errorf("error code, want: %s, got: %s", server.E_ACCESS_DENIED, oauthErr.Code)
+	}
+	if !errors.Is(err, server.ErrSessionLimitExceeded) {
+		t.Errorf("rejected login, want ErrSessionLimitExceeded, got: %v", err)
+	}
+}
+
+// TestCreateParentTokenUidOutOfRange lua脚本里不能精确表示的uid拒绝登录
+func TestCreateParentTokenUidOutOfRange(t *testing.T) {
+	c, _ := newTestComponent(t)
+	for _, uid := range []int64{maxLuaInt + 1, -maxLuaInt - 1} {
+		err := c.GetStorage().SaveAuthorize(context.Background(), newAuthorize(t, c, uid, model.NewToken(100)))
+		if !errors.Is(err, ErrUidOutOfRange) {
+			t.Errorf("uid %d, want ErrUidOutOfRange, got: %v", uid, err)
+		}
+	}
+	login(t, c, maxLuaInt, model.NewToken(100))
+}
+
+// TestSetTokenUidExpired sub token过期后切换账号，不能重新创建没有过期时间的key
+func TestSetTokenUidExpired(t *testing.T) {
+	c, mr := newTestComponent(t, WithEnableMultipleAccounts(true))
+	ctx := context.Background()
+	access := login(t, c, 1, model.NewToken(100))
+	subToken, err := c.tokenServer.lookupSubToken(ctx, access.AccessToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = c.tokenServer.subToken.setUid(ctx, subToken, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	mr.Del(c.config.layout.subTokenKey(subToken))
+	if err = c.tokenServer.subToken.setUid(ctx, subToken, 1); !errors.Is(err, redis.Nil) {
+		t.Errorf("setUid on expired sub token, want redis.Nil, got: %v", err)
+	}
+	if mr.Exists(c.config.layout.subTokenKey(subToken)) {
+		t.Errorf("setUid should not re-create expired sub token")
+	}
+}
@@ -5,7 +5,6 @@ import (
 	"errors"
 	"fmt"
 	"strings"
-	"time"
 
 	"github.com/ego-component/eoauth2/server/model"
 	"github.com/ego-component/eredis"
@@ -33,119 +32,13 @@ func newUidMapParentToken(config *config, redis *eredis.Component) *userToken {
 }
 
 func (u *userToken) getKey(uid int64) string {
-	return fmt.Sprintf(u.config.uidMapParentTokenKey, uid)
+	return u.config.layout.uidKey(uid)
 }
 
 func (u *userToken) getFieldKey(parentToken string) string {
 	return u.fieldClient + parentToken
 }
 
-// 并发操作redis情况不考虑，因为一个用户使用多个终端，并发登录极其少见
-// 1 先取出这个key里面的数据
-//   expireTimeList:            [{"clientType1|parentToken":"expire的时间戳"}]
-//	 expireTime:                最大过期时间
-func (u *userToken) setToken(ctx context.Context, uid int64, pToken model.ParentToken) error {
-	fieldKey := u.getFieldKey(pToken.Token.Token)
-	var flagCreate bool
-	expireTime, err := u.getExpireTime(ctx, uid)
-	if err != nil && !errors.Is(err, redis.Nil) {
-		return err
-	}
-
-	// 如果不存在，那么需要创建
-	if errors.Is(err, redis.Nil) {
-		flagCreate = true
-		err = nil
-	}
-
-	expireTimeList, err := u.getExpireTimeList(ctx, uid)
-	if err != nil {
-		return err
-	}
-
-	nowTime := time.Now().Unix()
-	newExpireTimeList := make(UserTokenExpires, 0)
-	// 新数据添加到队列前面，这样方便后续清除数据，或者对数据做一些限制
-	newExpireTimeList = append(newExpireTimeList, UserTokenExpire{
-		Field:      fieldKey,
-		Platform:   pToken.StoreData.Platform,
-		ExpireTime: nowTime + pToken.Token.ExpiresIn,
-	})
-
-	// 删除过期的数据
-	hdelFields := make([]string, 0)
-	for _, value := range expireTimeList {
-		// 过期时间小于当前时间，那么需要删除
-		if value.ExpireTime <= nowTime {
-			hdelFields = append(hdelFields, value.Field)
-			continue
-		}
-		newExpireTimeList = append(newExpireTimeList, value)
-	}
-	if len(hdelFields) > 0 {
-		err = u.redis.HDel(ctx, u.getKey(uid), hdelFields...)
-		if err != nil {
-			return fmt.Errorf("userToken setToken HDel expire data failed, error: %w", err)
-		}
-	}
-
-	err = u.redis.HSet(ctx, u.getKey(uid), u.fieldExpireTimeList, newExpireTimeList.Marshal())
-	if err != nil {
-		return fmt.Errorf("userToken setToken HSet expire time failed, error: %w", err)
-	}
-
-	// 将parent token信息存入
-	pTokenByte, err := pToken.Token.Marshal()
-	if err != nil {
-		return fmt.Errorf("userToken.createToken failed, err: %w", err)
-	}
-
-	err = u.redis.HSet(ctx, u.getKey(uid), u.getFieldKey(pToken.Token.Token), pTokenByte)
-	if err != nil {
-		return fmt.Errorf("userToken setToken HSet token info failed, error: %w", err)
-	}
-
-	// 如果之前没数据，那么expireTime为0，所以会写入
-	// 新的token大于，之前的过期时间，所以需要续期
-	if pToken.Token.ExpiresIn > expireTime {
-		err = u.redis.Client().Expire(ctx, u.getKey(uid), time.Duration(pToken.Token.ExpiresIn)*time.Second).Err()
-		if err != nil {
-			return fmt.Errorf("userToken setToken expire error %w", err)
-		}
-	}
-
-	if flagCreate {
-		err = u.redis.HSet(ctx, u.getKey(uid), u.fieldCtime, time.Now().Unix())
-		if err != nil {
-			return fmt.Errorf("userToken setToken HSet create time failed, error: %w", err)
-		}
-	}
-
-	return nil
-}
-
-func (u *userToken) removeParentToken(ctx context.Context, uid int64, parentToken string) error {
-	_ = u.redis.HDel(ctx, u.getKey(uid), u.getFieldKey(parentToken))
-	expireTimeList, err := u.getExpireTimeList(ctx, uid)
-	if err != nil {
-		return err
-	}
-
-	newExpireTimeList := make(UserTokenExpires, 0)
-	// 删除不要的数据
-	for _, value := range expireTimeList {
-		if value.Field == u.getFieldKey(parentToken) {
-			continue
-		}
-		newExpireTimeList = append(newExpireTimeList, value)
-	}
-	err = u.redis.HSet(ctx, u.getKey(uid), u.fieldExpireTimeList, newExpireTimeList.Marshal())
-	if err != nil {
-		return fmt.Errorf("parentToken removeSubToken failed, error: %w", err)
-	}
-	return nil
-}
-
 // 获取过期时间，最新的在最前面。
 func (u *userToken) getExpireTimeList(ctx context.Context, uid int64) (userInfo UserTokenExpires, err error) {
 	// 根据父节点token，获取用户信息
@@ -168,18 +61,6 @@ func (u *userToken) getExpireTimeList(ctx context.Context, uid int64) (userInfo
 	return
 }
 
-// 获取过期时间，快过期的在最前面。
-func (u *userToken) getExpireTime(ctx context.Context, uid int64) (output int64, err error) {
-	// 根据父节点token，获取用户信息
-	expireTime, err := u.redis.Client().TTL(ctx, u.getKey(uid)).Result()
-	if err != nil {
-		err = fmt.Errorf("userToken getExpireTime failed, err: %w", err)
-		return
-	}
-	output = expireTime.Milliseconds() / 1000
-	return
-}
-
 func (p *userToken) getAll(ctx context.Context, uid int64) (output *UserStore, err error) {
 	allInfo, err := p.redis.Client().HGetAll(ctx, p.getKey(uid)).Result()
 	if err != nil {
@@ -218,7 +99,7 @@ func (p *userToken) processData(store *UserStore, key string, value string) {
 	case key == p.fieldExpireTimeList:
 		store.ExpireTimeList.Unmarshal([]byte(value))
 	case strings.HasPrefix(key, p.fieldClient):
-		arr := strings.Split(key, ":")
+		arr := strings.SplitN(key, ":", 2)
 		if len(arr) != 2 {
 			return
 		}
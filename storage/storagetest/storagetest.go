@@ -0,0 +1,369 @@
+// Package storagetest server.Storage 的一致性测试，自定义的存储实现可以通过 Run 验证和内置存储的行为一致
+package storagetest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ego-component/eoauth2/server"
+	"github.com/ego-component/eoauth2/server/model"
+)
+
+// Harness 被测试的存储
+type Harness struct {
+	// Storage 被测试的存储，每个用例都会创建新的存储
+	Storage server.Storage
+	// Client 已经在存储里注册的客户端
+	Client server.Client
+	// RefreshWithAccessToken 存储没有单独的refresh token，使用access token刷新，例如ssostorage使用sub token刷新
+	RefreshWithAccessToken bool
+	// RemoveGrace RemoveAccess、RemoveParentToken之后token在一段时间内仍然有效，需要通过Advance让token失效
+	RemoveGrace bool
+	// Advance 让存储的时间前进d，依赖TTL过期的存储需要设置，例如miniredis的FastForward，可以为nil
+	Advance func(d time.Duration)
+	// RemoveParentToken 删除parent token，为nil表示存储不支持单点登录，跳过单点登录的用例
+	RemoveParentToken func(ctx context.Context, pToken string) error
+}
+
+// Run 运行所有用例，newHarness 为每个用例创建新的存储
+func Run(t *testing.T, newHarness func(t *testing.T) *Harness) {
+	cases := []struct {
+		name string
+		fn   func(t *testing.T, h *Harness)
+	}{
+		{"NotFound", testNotFound},
+		{"Authorize", testAuthorize},
+		{"Access", testAccess},
+		{"Expiry", testExpiry},
+		{"RefreshChain", testRefreshChain},
+		{"Concurrency", testConcurrency},
+		{"ParentToken", testParentToken},
+	}
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			c.fn(t, newHarness(t))
+		})
+	}
+}
+
+// testNotFound 不存在的数据返回ErrNotFound，删除不存在的数据不返回错误
+func testNotFound(t *testing.T, h *Harness) {
+	ctx := context.Background()
+	if _, err := h.Storage.GetClient(ctx, "storagetest-missing"); !errors.Is(err, server.ErrNotFound) {
+		t.Errorf("GetClient missing client, want ErrNotFound, got: %v", err)
+	}
+	if _, err := h.Storage.LoadAuthorize(ctx, "storagetest-missing"); !errors.Is(err, server.ErrNotFound) {
+		t.Errorf("LoadAuthorize missing code, want ErrNotFound, got: %v", err)
+	}
+	if _, err := h.Storage.LoadAccess(ctx, "storagetest-missing"); !errors.Is(err, server.ErrNotFound) {
+		t.Errorf("LoadAccess missing token, want ErrNotFound, got: %v", err)
+	}
+	if _, err := h.Storage.LoadRefresh(ctx, "storagetest-missing"); !errors.Is(err, server.ErrNotFound) {
+		t.Errorf("LoadRefresh missing token, want ErrNotFound, got: %v", err)
+	}
+	if err := h.Storage.RemoveAuthorize(ctx, "storagetest-missing"); err != nil {
+		t.Errorf("RemoveAuthorize missing code, want nil, got: %v", err)
+	}
+	if err := h.Storage.RemoveAccess(ctx, "storagetest-missing"); err != nil {
+		t.Errorf("RemoveAccess missing token, want nil, got: %v", err)
+	}
+	if err := h.Storage.RemoveRefresh(ctx, "storagetest-missing"); err != nil {
+		t.Errorf("RemoveRefresh missing token, want nil, got: %v", err)
+	}
+}
+
+// testAuthorize 保存、读取、删除code
+func testAuthorize(t *testing.T, h *Harness) {
+	ctx := context.Background()
+	data := newAuthorize(h, model.NewToken(3600))
+	if err := h.Storage.SaveAuthorize(ctx, data); err != nil {
+		t.Fatalf("SaveAuthorize failed, err: %v", err)
+	}
+	loaded, err := h.Storage.LoadAuthorize(ctx, data.Code)
+	if err != nil {
+		t.Fatalf("LoadAuthorize failed, err: %v", err)
+	}
+	if loaded.Client == nil || loaded.Client.GetId() != h.Client.GetId() {
+		t.Errorf("LoadAuthorize client mismatch, got: %v", loaded.Client)
+	}
+	if loaded.RedirectUri != data.RedirectUri || loaded.Scope != data.Scope || loaded.State != data.State {
+		t.Errorf("LoadAuthorize data mismatch, want: %+v, got: %+v", data, loaded)
+	}
+	if loaded.IsExpired() {
+		t.Errorf("LoadAuthorize returned expired code")
+	}
+	if err = h.Storage.RemoveAuthorize(ctx, data.Code); err != nil {
+		t.Fatalf("RemoveAuthorize failed, err: %v", err)
+	}
+	if _, err = h.Storage.LoadAuthorize(ctx, data.Code); err == nil {
+		t.Errorf("LoadAuthorize after RemoveAuthorize, want error")
+	}
+}
+
+// testAccess 保存、读取、删除access token
+func testAccess(t *testing.T, h *Harness) {
+	ctx := context.Background()
+	access, _ := issue(t, h, model.NewToken(3600))
+	loaded, err := h.Storage.LoadAccess(ctx, access.AccessToken)
+	if err != nil {
+		t.Fatalf("LoadAccess failed, err: %v", err)
+	}
+	if loaded.Client == nil || loaded.Client.GetId() != h.Client.GetId() {
+		t.Errorf("LoadAccess client mismatch, got: %v", loaded.Client)
+	}
+	if loaded.Scope != access.Scope || loaded.TokenExpiresIn != access.TokenExpiresIn {
+		t.Errorf("LoadAccess data mismatch, want: %+v, got: %+v", access, loaded)
+	}
+	if loaded.IsExpired() {
+		t.Errorf("LoadAccess returned expired token")
+	}
+	if err = h.Storage.RemoveAccess(ctx, loaded.AccessToken); err != nil {
+		t.Fatalf("RemoveAccess failed, err: %v", err)
+	}
+	h.settle(t)
+	if _, err = h.Storage.LoadAccess(ctx, access.AccessToken); err == nil {
+		t.Errorf("LoadAccess after RemoveAccess, want error")
+	}
+}
+
+// testExpiry 过期的code、token不能被使用：存储返回错误，或者返回的数据已经过期，由server拒绝
+func testExpiry(t *testing.T, h *Harness) {
+	ctx := context.Background()
+	createdAt := time.Now().Add(-10 * time.Second)
+	data := newAuthorize(h, model.NewToken(3600))
+	data.ExpiresIn = 1
+	data.CreatedAt = createdAt
+	if err := h.Storage.SaveAuthorize(ctx, data); err != nil {
+		t.Fatalf("SaveAuthorize failed, err: %v", err)
+	}
+	authorize := newAuthorize(h, data.SsoData.Token)
+	if err := h.Storage.SaveAuthorize(ctx, authorize); err != nil {
+		t.Fatalf("SaveAuthorize failed, err: %v", err)
+	}
+	loadedAuthorize, err := h.Storage.LoadAuthorize(ctx, authorize.Code)
+	if err != nil {
+		t.Fatalf("LoadAuthorize failed, err: %v", err)
+	}
+	access := newAccess(h, loadedAuthorize, nil)
+	access.TokenExpiresIn = 1
+	access.TokenData.Token.ExpiresIn = 1
+	access.CreatedAt = createdAt
+	if err = h.Storage.SaveAccess(ctx, access); err != nil {
+		t.Fatalf("SaveAccess failed, err: %v", err)
+	}
+	if h.Advance != nil {
+		h.Advance(2 * time.Second)
+	}
+
+	if loaded, err := h.Storage.LoadAuthorize(ctx, data.Code); err == nil && !loaded.IsExpired() {
+		t.Errorf("LoadAuthorize returned expired code as valid")
+	}
+	if loaded, err := h.Storage.LoadAccess(ctx, access.AccessToken); err == nil && !loaded.IsExpired() {
+		t.Errorf("LoadAccess returned expired token as valid")
+	}
+}
+
+// testRefreshChain 连续刷新token，每次刷新后老的token、refresh token失效，新的token可以继续刷新
+func testRefreshChain(t *testing.T, h *Harness) {
+	ctx := context.Background()
+	current, _ := issue(t, h, model.NewToken(3600))
+	for i := 0; i < 3; i++ {
+		prev, err := h.Storage.LoadRefresh(ctx, h.refreshToken(current))
+		if err != nil {
+			t.Fatalf("LoadRefresh %d failed, err: %v", i, err)
+		}
+		if prev.Client == nil || prev.Client.GetId() != h.Client.GetId() {
+			t.Fatalf("LoadRefresh %d client mismatch, got: %v", i, prev.Client)
+		}
+		next := newAccess(h, nil, prev)
+		if err = h.Storage.SaveAccess(ctx, next); err != nil {
+			t.Fatalf("SaveAccess %d failed, err: %v", i, err)
+		}
+		// 和server一样，刷新后删除老的refresh token、access token
+		if prev.RefreshToken != "" {
+			if err = h.Storage.RemoveRefresh(ctx, prev.RefreshToken); err != nil {
+				t.Fatalf("RemoveRefresh %d failed, err: %v", i, err)
+			}
+		}
+		if err = h.Storage.RemoveAccess(ctx, prev.AccessToken); err != nil {
+			t.Fatalf("RemoveAccess %d failed, err: %v", i, err)
+		}
+		h.settle(t)
+		if _, err = h.Storage.LoadRefresh(ctx, h.refreshToken(current)); err == nil {
+			t.Errorf("LoadRefresh %d with used refresh token, want error", i)
+		}
+		if _, err = h.Storage.LoadAccess(ctx, current.AccessToken); err == nil {
+			t.Errorf("LoadAccess %d with refreshed token, want error", i)
+		}
+		if _, err = h.Storage.LoadAccess(ctx, next.AccessToken); err != nil {
+			t.Fatalf("LoadAccess %d with new token failed, err: %v", i, err)
+		}
+		current = next
+	}
+}
+
+// testConcurrency 并发的登录、读取token
+func testConcurrency(t *testing.T, h *Harness) {
+	const workers = 8
+	const rounds = 5
+	ctx := context.Background()
+	var wg sync.WaitGroup
+	errs := make(chan error, workers*rounds)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < rounds; j++ {
+				access, _, err := tryIssue(h, model.NewToken(3600))
+				if err != nil {
+					errs <- err
+					continue
+				}
+				if _, err = h.Storage.LoadAccess(ctx, access.AccessToken); err != nil {
+					errs <- fmt.Errorf("LoadAccess failed, err: %w", err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
+// testParentToken 单点登录下，多个子系统共用parent token，删除parent token后所有的sub token失效
+func testParentToken(t *testing.T, h *Harness) {
+	if h.RemoveParentToken == nil {
+		t.Skip("storage does not support sso parent token")
+	}
+	ctx := context.Background()
+	first, pToken := issue(t, h, model.NewToken(3600))
+	second, _ := issue(t, h, pToken)
+	for _, access := range []*server.AccessData{first, second} {
+		if _, err := h.Storage.LoadAccess(ctx, access.AccessToken); err != nil {
+			t.Fatalf("LoadAccess failed, err: %v", err)
+		}
+	}
+
+	// 删除parent token之前兑换的code，删除之后不能再下发sub token
+	pending := newAuthorize(h, pToken)
+	if err := h.Storage.SaveAuthorize(ctx, pending); err != nil {
+		t.Fatalf("SaveAuthorize failed, err: %v", err)
+	}
+	loaded, err := h.Storage.LoadAuthorize(ctx, pending.Code)
+	if err != nil {
+		t.Fatalf("LoadAuthorize failed, err: %v", err)
+	}
+	if loaded.SsoData.Token.Token == "" {
+		t.Errorf("LoadAuthorize lost parent token")
+	}
+
+	if err = h.RemoveParentToken(ctx, pToken.Token); err != nil {
+		t.Fatalf("RemoveParentToken failed, err: %v", err)
+	}
+	h.settle(t)
+	for _, access := range []*server.AccessData{first, second} {
+		if _, err = h.Storage.LoadAccess(ctx, access.AccessToken); err == nil {
+			t.Errorf("LoadAccess after RemoveParentToken, want error")
+		}
+	}
+	if err = h.Storage.SaveAccess(ctx, newAccess(h, loaded, nil)); err == nil {
+		t.Errorf("SaveAccess with removed parent token, want error")
+	}
+}
+
+// settle 等待删除的token失效
+func (h *Harness) settle(t *testing.T) {
+	if !h.RemoveGrace {
+		return
+	}
+	if h.Advance == nil {
+		t.Fatal("RemoveGrace requires Advance")
+	}
+	h.Advance(time.Minute)
+}
+
+func (h *Harness) refreshToken(access *server.AccessData) string {
+	if h.RefreshWithAccessToken {
+		return access.AccessToken
+	}
+	return access.RefreshToken
+}
+
+// issue 和server一样，通过code下发token，返回token以及存储使用的parent token
+// 存储可能在SaveAuthorize里修改parent token，例如Redis Cluster key布局会加上hash tag，server会把修改后的parent token写入cookie
+func issue(t *testing.T, h *Harness, pToken model.Token) (*server.AccessData, model.Token) {
+	t.Helper()
+	access, pToken, err := tryIssue(h, pToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return access, pToken
+}
+
+func tryIssue(h *Harness, pToken model.Token) (*server.AccessData, model.Token, error) {
+	ctx := context.Background()
+	data := newAuthorize(h, pToken)
+	if err := h.Storage.SaveAuthorize(ctx, data); err != nil {
+		return nil, pToken, fmt.Errorf("SaveAuthorize failed, err: %w", err)
+	}
+	loaded, err := h.Storage.LoadAuthorize(ctx, data.Code)
+	if err != nil {
+		return nil, pToken, fmt.Errorf("LoadAuthorize failed, err: %w", err)
+	}
+	access := newAccess(h, loaded, nil)
+	if err = h.Storage.SaveAccess(ctx, access); err != nil {
+		return nil, pToken, fmt.Errorf("SaveAccess failed, err: %w", err)
+	}
+	if err = h.Storage.RemoveAuthorize(ctx, loaded.Code); err != nil {
+		return nil, pToken, fmt.Errorf("RemoveAuthorize failed, err: %w", err)
+	}
+	return access, data.SsoData.Token, nil
+}
+
+func newAuthorize(h *Harness, pToken model.Token) *server.AuthorizeData {
+	return &server.AuthorizeData{
+		Client:               h.Client,
+		Code:                 model.NewToken(0).Token,
+		ExpiresIn:            600,
+		ParentTokenExpiresIn: pToken.ExpiresIn,
+		Scope:                "openid",
+		RedirectUri:          h.Client.GetRedirectUri(),
+		State:                "storagetest",
+		CreatedAt:            time.Now(),
+		SsoData: model.ParentToken{
+			Token: pToken,
+			Uid:   1,
+			StoreData: model.ParentTokenData{
+				Ctime:    time.Now().Unix(),
+				Platform: "web",
+			},
+		},
+	}
+}
+
+func newAccess(h *Harness, authorize *server.AuthorizeData, prev *server.AccessData) *server.AccessData {
+	subToken := model.NewToken(3600)
+	access := &server.AccessData{
+		Client:                h.Client,
+		AuthorizeData:         authorize,
+		AccessData:            prev,
+		AccessToken:           subToken.Token,
+		TokenExpiresIn:        subToken.ExpiresIn,
+		RefreshTokenExpiresIn: 7200,
+		Scope:                 "openid",
+		RedirectUri:           h.Client.GetRedirectUri(),
+		CreatedAt:             time.Now(),
+		TokenData:             model.SubToken{Token: subToken},
+	}
+	if !h.RefreshWithAccessToken {
+		access.RefreshToken = model.NewToken(0).Token
+	}
+	return access
+}
@@ -0,0 +1,66 @@
+// Package tokenhash 使用HMAC-SHA256计算token的hash，存储里只保存hash，明文token只出现在返回给客户端的响应里
+package tokenhash
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+)
+
+// Prefix token hash的前缀，用于区分老的明文token
+const Prefix = "hmac:"
+
+// Hasher 计算token的hash，nil表示不开启hash，存储明文token
+type Hasher struct {
+	secret       []byte
+	legacyLookup bool
+}
+
+// New 创建Hasher，secret为服务端密钥，secret为空时返回nil
+// legacyLookup 为true时，查询token会同时查询hash和明文，用于开启hash之前下发的token迁移期
+func New(secret []byte, legacyLookup bool) *Hasher {
+	if len(secret) == 0 {
+		return nil
+	}
+	return &Hasher{
+		secret:       secret,
+		legacyLookup: legacyLookup,
+	}
+}
+
+// Key 计算存储使用的key，没有开启hash时返回明文token
+func (h *Hasher) Key(token string) string {
+	if h == nil || token == "" {
+		return token
+	}
+	mac := hmac.New(sha256.New, h.secret)
+	_, _ = mac.Write([]byte(token))
+	return Prefix + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Lookups 客户端传入的token查询时使用的key，开启legacyLookup时会同时查询明文
+// 传入的值已经是hash时不会按照明文查询，避免存储泄露的hash被当作token使用
+func (h *Hasher) Lookups(token string) []string {
+	if h == nil {
+		return []string{token}
+	}
+	if h.legacyLookup && !IsKey(token) {
+		return []string{h.Key(token), token}
+	}
+	return []string{h.Key(token)}
+}
+
+// Refs 存储内部引用的token使用的key，例如从存储里读出来的previous token
+// 已经是hash的直接使用，否则和 Lookups 一样
+func (h *Hasher) Refs(token string) []string {
+	if IsKey(token) {
+		return []string{token}
+	}
+	return h.Lookups(token)
+}
+
+// IsKey 是否为token hash，明文token是base64url编码，不会包含 ":"
+func IsKey(token string) bool {
+	return strings.Contains(token, Prefix)
+}